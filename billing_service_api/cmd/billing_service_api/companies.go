@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/fiscal"
+	"github.com/lucasbpereira/billing_service_api/internal/handlers"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+)
+
+// runCreateCompanyCommand implements `billing_service_api create-company
+// -name "ACME" -cnpj 12345678000195 -uf SP`. Flags not given are taken from
+// the COMPANY_* variables, so an existing single company setup becomes the
+// first company with no flags at all. The created company is printed as JSON;
+// its id is what create-user and import take as -company.
+func runCreateCompanyCommand(args []string) {
+	defaults := fiscal.LoadCompany()
+
+	flags := flag.NewFlagSet("create-company", flag.ExitOnError)
+	name := flags.String("name", defaults.Name, "legal name")
+	tradeName := flags.String("trade-name", defaults.TradeName, "trade name")
+	cnpj := flags.String("cnpj", defaults.CNPJ, "CNPJ")
+	ie := flags.String("ie", defaults.IE, "state registration")
+	uf := flags.String("uf", defaults.UF, "state")
+	cityCode := flags.String("city-code", defaults.CityCode, "IBGE city code")
+	zipCode := flags.String("zip", defaults.ZipCode, "CEP")
+	street := flags.String("street", defaults.Street, "street")
+	number := flags.String("number", defaults.Number, "street number")
+	complement := flags.String("complement", defaults.Complement, "address complement")
+	district := flags.String("district", defaults.District, "district")
+	phone := flags.String("phone", defaults.Phone, "phone")
+	email := flags.String("email", defaults.Email, "email")
+	series := flags.String("series", defaults.Series, "invoice series")
+	industrial := flags.Bool("industrial", defaults.IndustrialAct, "whether the company is industrial")
+	flags.Parse(args)
+
+	company := models.Company{
+		Name:       *name,
+		TradeName:  *tradeName,
+		CNPJ:       fiscal.OnlyDigits(*cnpj),
+		IE:         fiscal.OnlyDigits(*ie),
+		UF:         *uf,
+		CityCode:   *cityCode,
+		ZipCode:    fiscal.OnlyDigits(*zipCode),
+		Street:     *street,
+		Number:     *number,
+		Complement: *complement,
+		District:   *district,
+		Phone:      fiscal.OnlyDigits(*phone),
+		Email:      *email,
+		Series:     *series,
+		Industrial: *industrial,
+	}
+	if err := validator.New().Struct(company); err != nil {
+		log.Fatal("Empresa inválida:", err)
+	}
+
+	db.Connect()
+
+	created, err := handlers.CreateCompanyAccount(company)
+	if err != nil {
+		log.Fatal("Erro ao criar empresa:", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(created)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/handlers"
+	"github.com/lucasbpereira/billing_service_api/internal/importer"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient"
+)
+
+// runImportCommand implements `billing_service_api import -company <id> -file
+// orders.csv`, which imports the invoices of that company.
+// Progress goes to stderr and the JSON report to stdout; the exit status is 1
+// when the report has errors.
+func runImportCommand(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	company := flags.String("company", "", "id of the company the invoices belong to")
+	file := flags.String("file", "", "CSV or JSONL file to import")
+	format := flags.String("format", "", "csv or jsonl (detected from the file name when empty)")
+	mode := flags.String("mode", string(importer.ModeAllOrNothing), "dry-run, all-or-nothing or partial")
+	flags.Parse(args)
+
+	if *file == "" || *company == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	importMode, err := importer.ParseMode(*mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	importFormat, err := importer.DetectFormat(*format, *file, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatal("Erro ao ler arquivo de importação:", err)
+	}
+
+	db.Connect()
+	// Events of the imported invoices are published by the server's relay.
+	if bus := connectEventBus(); bus != nil {
+		defer bus.Close()
+	}
+
+	var report *importer.Report
+	err = db.WithCompany(*company, func(conn *db.Conn) error {
+		ctx := stockclient.WithCompany(context.Background(), conn.CompanyID)
+		report, err = handlers.RunImport(ctx, conn, data, importFormat, importMode, func(phase string, processed, total int) {
+			fmt.Fprintf(os.Stderr, "\r%s %d/%d", phase, processed, total)
+		})
+		return err
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.Fatal("Erro na importação:", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	if len(report.Errors) > 0 {
+		os.Exit(1)
+	}
+}
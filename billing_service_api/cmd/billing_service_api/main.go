@@ -1,29 +1,176 @@
 package main
 
 import (
+	"context"
 	"log"
-	"github.com/gofiber/fiber/v2/middleware/cors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/lucasbpereira/billing_service_api/db"
 	"github.com/lucasbpereira/billing_service_api/internal/handlers"
+	"github.com/lucasbpereira/billing_service_api/internal/middleware"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/ratelimit"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/scheduler"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient/servicetoken"
 )
 
+// shutdownTimeout is how long the requests in flight get to finish on
+// shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImportCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "create-company" {
+		runCreateCompanyCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "create-user" {
+		runCreateUserCommand(os.Args[2:])
+		return
+	}
 
 	db.Connect()
+	authConfig, err := auth.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Erro na configuração de autenticação:", err)
+	}
+	rateLimit, err := ratelimit.ConfigFromEnv(rateLimitRules, db.DB)
+	if err != nil {
+		log.Fatal("Erro na configuração do rate limiting:", err)
+	}
+	ipRateLimit, err := ratelimit.IPConfigFromEnv(db.DB)
+	if err != nil {
+		log.Fatal("Erro na configuração do rate limiting:", err)
+	}
+	if _, err := servicetoken.SecretFromEnv(); err != nil {
+		log.Fatal("Erro na configuração de autenticação entre serviços:", err)
+	}
+	handlers.SetStockClient(stockclient.New(stockclient.ConfigFromEnv()))
+	handlers.SetAuthConfig(authConfig)
+	handlers.SetAPIKeyCacheTTL(auth.KeyCacheTTLFromEnv())
+	if err := handlers.StartEventStream(); err != nil {
+		log.Fatal("Erro ao escutar notificações do banco:", err)
+	}
+	if bus := connectEventBus(); bus != nil {
+		if err := handlers.SubscribeStockEvents(bus); err != nil {
+			log.Fatal("Erro ao assinar eventos do estoque:", err)
+		}
+	}
 
-	app := fiber.New()
+	tickets := auth.NewTickets(db.System)
+
+	app := fiber.New(fiber.Config{
+		BodyLimit: 32 * 1024 * 1024, // importação em lote de notas
+	})
 
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "http://localhost:4200", // URL do seu Angular
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
+		AllowOrigins:     "http://localhost:4200", // URL do seu Angular
+		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, If-Match, If-None-Match, Idempotency-Key, Last-Event-ID, X-Company-ID",
+		ExposeHeaders:    "ETag, Location, Idempotent-Replayed, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After",
 		AllowCredentials: true,
 	}))
 
-	app.Post("/invoice", handlers.CreateInvoice)
-	app.Get("/invoices/open", handlers.GetOpenInvoices)
-	app.Put("/invoices/:code/close", handlers.UpdateInvoiceStatus)
+	// A limit per IP address comes first, so made up tokens and API keys are
+	// limited before they are checked. Authentication follows, so requests
+	// are rate limited and idempotent responses kept per user or API key, then
+	// their company scopes the database for the rest.
+	if ipRateLimit != nil {
+		log.Printf("Rate limit of %s per IP address", ipRateLimit.Default)
+		app.Use(ratelimit.Middleware(*ipRateLimit))
+	}
+	app.Use(auth.Authenticate(authConfig, handlers.VerifyAPIKey, tickets, publicPaths))
+	if rateLimit != nil {
+		log.Printf("Rate limit of %s per client", rateLimit.Default)
+		app.Use(ratelimit.Middleware(*rateLimit))
+	}
+	app.Use(tenant.Middleware(db.DB, nil))
+	app.Use(middleware.Idempotency())
+
+	registerRoutes(app, tickets)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go scheduler.Every(ctx, "recurring-invoices",
+		scheduler.IntervalFromEnv("RECURRING_INVOICES_INTERVAL", time.Minute),
+		handlers.GenerateRecurringInvoices)
+	go scheduler.Every(ctx, "outbox-relay",
+		scheduler.IntervalFromEnv("OUTBOX_RELAY_INTERVAL", 5*time.Second),
+		handlers.RelayOutbox)
+	go scheduler.Every(ctx, "email-deliveries",
+		scheduler.IntervalFromEnv("EMAIL_RETRY_INTERVAL", 30*time.Second),
+		handlers.DeliverPendingEmails)
+	go scheduler.Every(ctx, "webhook-deliveries",
+		scheduler.IntervalFromEnv("WEBHOOK_DELIVERY_INTERVAL", 10*time.Second),
+		handlers.DeliverPendingWebhooks)
+	go scheduler.Every(ctx, "stream-events",
+		time.Hour, handlers.PurgeStreamEvents)
+	go scheduler.Every(ctx, "stream-tickets",
+		time.Hour, tickets.Purge)
+	go scheduler.Every(ctx, "refresh-tokens",
+		time.Hour, handlers.PurgeRefreshTokens)
+	go scheduler.Every(ctx, "idempotency-keys",
+		time.Hour, middleware.PurgeExpiredIdempotencyKeys)
+	go scheduler.Every(ctx, "api-key-usage",
+		time.Minute, handlers.FlushAPIKeyUsage)
+	if store := postgresRateLimitStore(rateLimit, ipRateLimit); store != nil {
+		go scheduler.Every(ctx, "rate-limits", time.Hour, store.Purge)
+	}
+
+	// SIGINT or SIGTERM stops the jobs and lets the requests in flight
+	// finish.
+	go func() {
+		<-ctx.Done()
+		log.Printf("Shutting down")
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			log.Printf("Error shutting down: %v", err)
+		}
+	}()
+
+	if err := app.Listen(":3001"); err != nil {
+		log.Fatal(err)
+	}
+	// The last usage counted is written before exiting.
+	if err := handlers.FlushAPIKeyUsage(time.Now()); err != nil {
+		log.Printf("Error recording API key usage: %v", err)
+	}
+}
+
+// connectEventBus sets the broker chosen by EVENT_BROKER for the handlers, or
+// returns nil when none is configured.
+func connectEventBus() events.Bus {
+	bus, err := events.FromEnv()
+	if err != nil {
+		log.Fatal("Erro ao conectar no broker de eventos:", err)
+	}
+	if bus != nil {
+		handlers.SetEventBus(bus)
+	}
+	return bus
+}
 
-	log.Fatal(app.Listen(":3001"))
+// postgresRateLimitStore is the rate_limits table when a limit counts in it,
+// or nil. Both limits share the table, so one purge covers them.
+func postgresRateLimitStore(configs ...*ratelimit.Config) *ratelimit.Postgres {
+	for _, config := range configs {
+		if config == nil {
+			continue
+		}
+		if store, ok := config.Store.(*ratelimit.Postgres); ok {
+			return store
+		}
+	}
+	return nil
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lucasbpereira/billing_service_api/internal/handlers"
+	"github.com/lucasbpereira/billing_service_api/internal/openapi"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/ratelimit"
+)
+
+// publicPaths take no access token; every other route needs a signed in
+// user, of at least the role it names, or an API key with the scope it names.
+// Routes that name no scope are for users only.
+var publicPaths = auth.Paths("/auth/login", "/auth/refresh", "/auth/logout", "/openapi.json", "/docs")
+
+// rateLimitRules limit signing in apart from the rest, per IP address, so
+// passwords are not guessed at the pace of the default limit.
+// RATE_LIMIT_ROUTES overrides them.
+var rateLimitRules = []ratelimit.Rule{
+	{Method: "POST", Path: "/auth/login", Limit: ratelimit.Limit{Requests: 10, Window: time.Minute}},
+	{Method: "POST", Path: "/auth/refresh", Limit: ratelimit.Limit{Requests: 30, Window: time.Minute}},
+}
+
+// registerRoutes mounts every endpoint of the service. Each one must be
+// described in internal/openapi/openapi.json; routes_test.go checks it.
+// tickets issues the tickets GET /events is opened with.
+func registerRoutes(app *fiber.App, tickets *auth.Tickets) {
+	user := auth.RequireRole(auth.RoleViewer)
+	admin := auth.RequireRole(auth.RoleAdmin)
+
+	readInvoices := auth.Allow(auth.RoleViewer, auth.ScopeInvoicesRead)
+	writeInvoices := auth.Allow(auth.RoleOperator, auth.ScopeInvoicesWrite)
+	closeInvoices := auth.Allow(auth.RoleFiscal, auth.ScopeInvoicesWrite)
+	readCustomers := auth.Allow(auth.RoleViewer, auth.ScopeCustomersRead)
+	writeCustomers := auth.Allow(auth.RoleOperator, auth.ScopeCustomersWrite)
+	readOrders := auth.Allow(auth.RoleViewer, auth.ScopeOrdersRead)
+	writeOrders := auth.Allow(auth.RoleOperator, auth.ScopeOrdersWrite)
+	readReports := auth.Allow(auth.RoleViewer, auth.ScopeReportsRead)
+	exportFiscal := auth.Allow(auth.RoleFiscal, auth.ScopeReportsRead)
+
+	app.Post("/auth/login", handlers.Login)
+	app.Post("/auth/refresh", handlers.RefreshToken)
+	app.Post("/auth/logout", handlers.Logout)
+	app.Get("/auth/me", user, handlers.GetCurrentUser)
+	app.Put("/auth/password", user, handlers.ChangePassword)
+
+	app.Get("/company", user, handlers.GetCurrentCompany)
+
+	app.Post("/users", admin, handlers.CreateUser)
+	app.Get("/users", admin, handlers.GetUsers)
+	app.Get("/users/:id", admin, handlers.GetUserById)
+	app.Put("/users/:id", admin, handlers.UpdateUser)
+
+	app.Post("/api-keys", admin, handlers.CreateAPIKey)
+	app.Get("/api-keys", admin, handlers.GetAPIKeys)
+	app.Get("/api-keys/:id", admin, handlers.GetAPIKeyById)
+	app.Delete("/api-keys/:id", admin, handlers.RevokeAPIKey)
+
+	app.Post("/invoice", writeInvoices, handlers.CreateInvoice)
+	app.Get("/invoices/open", readInvoices, handlers.GetOpenInvoices)
+	app.Put("/invoices/:code/close", closeInvoices, handlers.UpdateInvoiceStatus)
+	app.Put("/invoices/:code/cancel", closeInvoices, handlers.CancelInvoice)
+	app.Delete("/invoices/:code", closeInvoices, handlers.CancelInvoice)
+	app.Post("/invoices/:code/corrections", closeInvoices, handlers.CorrectInvoice)
+	app.Post("/invoices/import", writeInvoices, handlers.ImportInvoices)
+	app.Get("/invoices/import/:id", readInvoices, handlers.GetImportJob)
+	app.Get("/invoices/events/verify", readInvoices, handlers.VerifyInvoiceEvents)
+	app.Get("/invoices/:code", readInvoices, handlers.GetInvoiceByCode)
+	app.Get("/invoices/:code/history", readInvoices, handlers.GetInvoiceHistory)
+	app.Get("/invoices/:code/xml", readInvoices, handlers.GetInvoiceXML)
+	app.Get("/invoices/:code/danfe", readInvoices, handlers.GetInvoiceDANFE)
+	app.Post("/invoices/:code/emails", writeInvoices, handlers.SendInvoiceEmail)
+	app.Get("/invoices/:code/emails", readInvoices, handlers.GetInvoiceEmails)
+	app.Post("/emails/:id/resend", writeInvoices, handlers.ResendEmail)
+
+	app.Post("/customers", writeCustomers, handlers.CreateCustomer)
+	app.Get("/customers", readCustomers, handlers.GetCustomers)
+	app.Get("/customers/:id", readCustomers, handlers.GetCustomerById)
+
+	app.Post("/quotes", writeOrders, handlers.CreateQuote)
+	app.Get("/quotes", readOrders, handlers.GetQuotes)
+	app.Get("/quotes/:id", readOrders, handlers.GetQuoteById)
+	app.Put("/quotes/:id/status", writeOrders, handlers.UpdateQuoteStatus)
+	app.Post("/quotes/:id/order", writeOrders, handlers.ConvertQuoteToOrder)
+
+	app.Post("/orders", writeOrders, handlers.CreateOrder)
+	app.Get("/orders", readOrders, handlers.GetOrders)
+	app.Get("/orders/:id", readOrders, handlers.GetOrderById)
+	app.Put("/orders/:id/status", writeOrders, handlers.UpdateOrderStatus)
+	app.Post("/orders/:id/invoice", writeInvoices, handlers.InvoiceOrder)
+
+	app.Post("/invoice-templates", writeInvoices, handlers.CreateInvoiceTemplate)
+	app.Get("/invoice-templates", readInvoices, handlers.GetInvoiceTemplates)
+	app.Get("/invoice-templates/:id", readInvoices, handlers.GetInvoiceTemplateById)
+	app.Delete("/invoice-templates/:id", writeInvoices, handlers.DeactivateInvoiceTemplate)
+	app.Get("/invoice-templates/:id/occurrences", readInvoices, handlers.GetInvoiceTemplateOccurrences)
+
+	app.Get("/sped/efd", exportFiscal, handlers.ExportEFD)
+
+	app.Get("/reports/revenue", readReports, handlers.GetRevenueReport)
+	app.Get("/reports/top-products", readReports, handlers.GetTopProductsReport)
+	app.Get("/reports/customers", readReports, handlers.GetCustomerRevenueReport)
+	app.Get("/reports/average-ticket", readReports, handlers.GetAverageTicketReport)
+	app.Get("/reports/taxes", readReports, handlers.GetTaxReport)
+
+	app.Post("/webhooks", admin, handlers.CreateWebhook)
+	app.Get("/webhooks", admin, handlers.GetWebhooks)
+	app.Get("/webhooks/:id", admin, handlers.GetWebhookById)
+	app.Delete("/webhooks/:id", admin, handlers.DeactivateWebhook)
+	app.Get("/webhooks/:id/deliveries", admin, handlers.GetWebhookDeliveries)
+	app.Post("/webhook-deliveries/:id/redeliver", admin, handlers.RedeliverWebhook)
+
+	app.Get("/events", readInvoices, handlers.StreamEvents)
+	app.Post("/events/tickets", readInvoices, tickets.Handler("/events"))
+
+	app.Get("/metrics/stock-client", user, handlers.GetStockClientMetrics)
+
+	app.Get("/openapi.json", openapi.Handler)
+	app.Get("/docs", openapi.DocsHandler)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lucasbpereira/billing_service_api/internal/openapi"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+)
+
+var pathParam = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+func specOperations(t *testing.T) map[string]bool {
+	t.Helper()
+
+	var document struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openapi.Document, &document); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+
+	operations := make(map[string]bool)
+	for path, item := range document.Paths {
+		for method := range item {
+			operations[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	return operations
+}
+
+func registeredOperations() map[string]bool {
+	app := fiber.New()
+	registerRoutes(app, auth.NewTickets(nil))
+
+	operations := make(map[string]bool)
+	for _, route := range app.GetRoutes(true) {
+		// Fiber adds HEAD to every GET route.
+		if route.Method == fiber.MethodHead {
+			continue
+		}
+		operations[route.Method+" "+pathParam.ReplaceAllString(route.Path, "{$1}")] = true
+	}
+	return operations
+}
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	spec := specOperations(t)
+	for operation := range registeredOperations() {
+		if !spec[operation] {
+			t.Errorf("route %s is missing from internal/openapi/openapi.json", operation)
+		}
+	}
+}
+
+func TestEveryDocumentedOperationIsRouted(t *testing.T) {
+	routes := registeredOperations()
+	for operation := range specOperations(t) {
+		if !routes[operation] {
+			t.Errorf("openapi.json describes %s, which is not registered", operation)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/handlers"
+)
+
+// runCreateUserCommand implements `billing_service_api create-user -company
+// <id> -email admin@example.com -role admin`, which creates the first admin
+// of a company. The
+// password is read from stdin unless -password is given, so it stays out of
+// the shell history. The created user is printed as JSON.
+func runCreateUserCommand(args []string) {
+	flags := flag.NewFlagSet("create-user", flag.ExitOnError)
+	company := flags.String("company", "", "id of the company the user belongs to")
+	name := flags.String("name", "", "user name (defaults to the email)")
+	email := flags.String("email", "", "email used to sign in")
+	role := flags.String("role", "viewer", "viewer, operator, fiscal or admin")
+	password := flags.String("password", "", "password (read from stdin when empty)")
+	flags.Parse(args)
+
+	if *email == "" || *company == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *name == "" {
+		*name = *email
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			log.Fatal("Erro ao ler a senha:", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	request := handlers.CreateUserRequest{Name: *name, Email: *email, Password: *password, Role: *role}
+	if err := validator.New().Struct(request); err != nil {
+		log.Fatal("Usuário inválido:", err)
+	}
+
+	db.Connect()
+
+	user, err := handlers.CreateUserAccount(*company, request)
+	if err != nil {
+		log.Fatal("Erro ao criar usuário:", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(user)
+}
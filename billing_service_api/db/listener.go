@@ -0,0 +1,19 @@
+package db
+
+import (
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NewListener opens a connection of its own for LISTEN, since notifications
+// are delivered to the session that listens and pooled connections are
+// shared. It reconnects by itself; Connect must have been called.
+func NewListener() *pq.Listener {
+	return pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Error on the notification listener: %v", err)
+		}
+	})
+}
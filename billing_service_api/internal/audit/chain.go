@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Entry is the part of an audit record covered by its hash. Before and After
+// are the JSON snapshots exactly as stored; PrevHash is the hash of the record
+// written just before it, or empty for the first one.
+type Entry struct {
+	InvoiceCode string
+	Actor       string
+	Action      string
+	Reason      string
+	Before      string
+	After       string
+	CreatedAt   time.Time
+	PrevHash    string
+}
+
+// Hash returns the hex SHA-256 of the entry. Every field is length prefixed so
+// moving text from one field to the next changes the hash.
+func Hash(e Entry) string {
+	fields := []string{
+		e.InvoiceCode,
+		e.Actor,
+		e.Action,
+		e.Reason,
+		e.Before,
+		e.After,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.PrevHash,
+	}
+
+	var b strings.Builder
+	for _, field := range fields {
+		fmt.Fprintf(&b, "%d:%s;", len(field), field)
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHash(t *testing.T) {
+	base := Entry{
+		InvoiceCode: "NF-1",
+		Actor:       "fiscal@example.com",
+		Action:      "FECHADA",
+		Before:      `{"status":"FECHANDO"}`,
+		After:       `{"status":"FECHADA"}`,
+		CreatedAt:   time.Date(2025, 1, 1, 12, 0, 0, 123456000, time.UTC),
+		PrevHash:    "abc",
+	}
+	hash := Hash(base)
+	if len(hash) != 64 {
+		t.Fatalf("Hash() = %q, want 64 hex digits", hash)
+	}
+	if Hash(base) != hash {
+		t.Error("Hash() differs for the same entry")
+	}
+
+	sameInstant := base
+	sameInstant.CreatedAt = base.CreatedAt.In(time.FixedZone("BRT", -3*60*60))
+	if Hash(sameInstant) != hash {
+		t.Error("Hash() depends on the zone of CreatedAt")
+	}
+
+	tests := []struct {
+		name   string
+		change func(e *Entry)
+	}{
+		{"text moved from actor to action", func(e *Entry) { e.Actor, e.Action = "fiscal@example.comF", "ECHADA" }},
+		{"text moved from before to after", func(e *Entry) { e.Before, e.After = `{"status":"FECHANDO"}{`, `"status":"FECHADA"}` }},
+		{"text moved from reason to before", func(e *Entry) { e.Reason, e.Before = `{`, `"status":"FECHANDO"}` }},
+		{"edited after", func(e *Entry) { e.After = `{"status":"CANCELADA"}` }},
+		{"other previous entry", func(e *Entry) { e.PrevHash = "abd" }},
+		{"other time", func(e *Entry) { e.CreatedAt = e.CreatedAt.Add(time.Microsecond) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := base
+			tt.change(&entry)
+			if Hash(entry) == hash {
+				t.Errorf("Hash() did not change")
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package danfe
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lucasbpereira/billing_service_api/internal/nfe"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	margin       = 30.0
+	lineHeight   = 11.0
+	itemsPerPage = 40
+)
+
+// PDF renders a simplified DANFE (Documento Auxiliar da NF-e) for the
+// invoice: issuer, recipient, access key, items and totals, with the items
+// continuing on further A4 pages when needed.
+func PDF(doc *nfe.Document) ([]byte, error) {
+	var pages []string
+
+	items := doc.Items
+	for page := 0; page == 0 || len(items) > 0; page++ {
+		count := itemsPerPage
+		if count > len(items) {
+			count = len(items)
+		}
+		pages = append(pages, renderPage(doc, items[:count], page+1, pageCount(len(doc.Items)), len(items) == count))
+		items = items[count:]
+	}
+
+	return writePDF(pages)
+}
+
+func pageCount(items int) int {
+	if items == 0 {
+		return 1
+	}
+	return (items + itemsPerPage - 1) / itemsPerPage
+}
+
+func renderPage(doc *nfe.Document, items []nfe.Item, page, pages int, last bool) string {
+	c := &canvas{y: pageHeight - margin}
+	company := doc.Company
+	r := doc.Recipient
+
+	c.box(margin, pageHeight-margin-70, pageWidth-2*margin, 70)
+	c.text(margin+6, c.y-14, 12, true, "DANFE")
+	c.text(margin+6, c.y-26, 7, false, "Documento Auxiliar da Nota Fiscal Eletrônica")
+	c.text(margin+6, c.y-40, 9, true, company.Name)
+	c.text(margin+6, c.y-51, 7, false, fmt.Sprintf("%s, %s - %s - %s - CEP %s", company.Street, company.Number, company.District, company.UF, company.ZipCode))
+	c.text(margin+6, c.y-62, 7, false, fmt.Sprintf("CNPJ %s   IE %s", formatCNPJ(company.CNPJ), company.IE))
+	c.text(380, c.y-14, 9, true, fmt.Sprintf("NF-e Nº %09d", doc.Number))
+	c.text(380, c.y-26, 8, false, fmt.Sprintf("Série %s   Folha %d/%d", doc.Series, page, pages))
+	c.text(380, c.y-38, 8, false, "Emissão "+doc.IssuedAt.Format("02/01/2006 15:04"))
+	c.text(380, c.y-50, 8, false, "0 - Entrada   1 - Saída: 1")
+	c.y -= 80
+
+	c.box(margin, c.y-24, pageWidth-2*margin, 24)
+	c.text(margin+6, c.y-9, 6, false, "CHAVE DE ACESSO")
+	c.text(margin+6, c.y-19, 9, true, formatKey(doc.AccessKey))
+	c.y -= 32
+
+	c.box(margin, c.y-36, pageWidth-2*margin, 36)
+	c.text(margin+6, c.y-9, 6, false, "DESTINATÁRIO / REMETENTE")
+	c.text(margin+6, c.y-20, 8, true, r.Name)
+	c.text(380, c.y-20, 8, false, "CNPJ/CPF "+formatDocument(r.Document))
+	c.text(margin+6, c.y-31, 7, false, fmt.Sprintf("%s, %s %s - %s - %s", r.Street, r.Number, r.Complement, r.District, r.UF))
+	c.text(380, c.y-31, 7, false, "IE "+r.IE)
+	c.y -= 44
+
+	columns := []struct {
+		title string
+		x     float64
+	}{
+		{"CÓDIGO", margin + 2}, {"DESCRIÇÃO", 110}, {"NCM", 260}, {"CFOP", 300}, {"UN", 330},
+		{"QTD", 355}, {"V.UNIT", 390}, {"V.TOTAL", 435}, {"BC ICMS", 480}, {"V.ICMS", 520}, {"ALÍQ", 555},
+	}
+	c.text(margin+2, c.y-9, 6, false, "DADOS DOS PRODUTOS / SERVIÇOS")
+	c.y -= 14
+	for _, col := range columns {
+		c.text(col.x, c.y-8, 6, true, col.title)
+	}
+	c.line(margin, c.y-11, pageWidth-margin, c.y-11)
+	c.y -= 12
+
+	for _, item := range items {
+		c.y -= lineHeight
+		values := []string{
+			truncate(item.ProductID, 16), truncate(item.Description, 32), item.NCM, item.CFOP, item.Unit,
+			strconv.Itoa(item.Quantity), money(item.UnitPrice), money(item.Value), money(item.ICMSBase),
+			money(item.ICMSValue), money(item.ICMSRate),
+		}
+		for i, col := range columns {
+			c.text(col.x, c.y, 6, false, values[i])
+		}
+	}
+
+	if last {
+		c.y -= 24
+		c.box(margin, c.y-26, pageWidth-2*margin, 26)
+		c.text(margin+6, c.y-9, 6, false, "CÁLCULO DO IMPOSTO")
+		c.text(margin+6, c.y-20, 8, false, "Base de cálculo do ICMS "+money(doc.ICMSBase))
+		c.text(200, c.y-20, 8, false, "Valor do ICMS "+money(doc.ICMSValue))
+		c.text(400, c.y-20, 9, true, "Valor total da nota "+money(doc.Total))
+	}
+
+	return c.String()
+}
+
+type canvas struct {
+	b bytes.Buffer
+	y float64
+}
+
+func (c *canvas) text(x, y, size float64, bold bool, value string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&c.b, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(value))
+}
+
+func (c *canvas) box(x, y, w, h float64) {
+	fmt.Fprintf(&c.b, "0.5 w %.2f %.2f %.2f %.2f re S\n", x, y, w, h)
+}
+
+func (c *canvas) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&c.b, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+func (c *canvas) String() string {
+	return c.b.String()
+}
+
+// writePDF assembles the page content streams into a PDF 1.4 file using the
+// standard Helvetica fonts, so no font has to be embedded.
+func writePDF(pages []string) ([]byte, error) {
+	var out bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	encoder := encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder())
+	for i, content := range pages {
+		stream, err := encoder.String(content)
+		if err != nil {
+			return nil, err
+		}
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes(), nil
+}
+
+func escape(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "(", `\(`)
+	return strings.ReplaceAll(value, ")", `\)`)
+}
+
+func truncate(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) <= max {
+		return value
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+func money(value float64) string {
+	return strings.Replace(strconv.FormatFloat(value, 'f', 2, 64), ".", ",", 1)
+}
+
+func formatKey(key string) string {
+	var groups []string
+	for i := 0; i < len(key); i += 4 {
+		end := i + 4
+		if end > len(key) {
+			end = len(key)
+		}
+		groups = append(groups, key[i:end])
+	}
+	return strings.Join(groups, " ")
+}
+
+func formatCNPJ(cnpj string) string {
+	if len(cnpj) != 14 {
+		return cnpj
+	}
+	return fmt.Sprintf("%s.%s.%s/%s-%s", cnpj[0:2], cnpj[2:5], cnpj[5:8], cnpj[8:12], cnpj[12:])
+}
+
+func formatDocument(document string) string {
+	if len(document) == 11 {
+		return fmt.Sprintf("%s.%s.%s-%s", document[0:3], document[3:6], document[6:9], document[9:])
+	}
+	return formatCNPJ(document)
+}
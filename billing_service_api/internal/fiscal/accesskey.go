@@ -0,0 +1,62 @@
+package fiscal
+
+import (
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+var ufCodes = map[string]string{
+	"RO": "11", "AC": "12", "AM": "13", "RR": "14", "PA": "15", "AP": "16", "TO": "17",
+	"MA": "21", "PI": "22", "CE": "23", "RN": "24", "PB": "25", "PE": "26", "AL": "27", "SE": "28", "BA": "29",
+	"MG": "31", "ES": "32", "RJ": "33", "SP": "35",
+	"PR": "41", "SC": "42", "RS": "43",
+	"MS": "50", "MT": "51", "GO": "52", "DF": "53",
+}
+
+// UFCode returns the IBGE code of a state, or "" when it is unknown.
+func UFCode(uf string) string {
+	return ufCodes[uf]
+}
+
+// AccessKey builds the 44 digit NF-e key (chave de acesso) of a model 55
+// invoice: cUF, AAMM, CNPJ, model, series, number, emission type, cNF and the
+// modulo 11 check digit. cNF is derived from seed so the key is stable.
+func AccessKey(company Company, issuedAt time.Time, series string, number int64, seed string) (string, error) {
+	uf := UFCode(company.UF)
+	if uf == "" {
+		return "", fmt.Errorf("unknown company UF %q", company.UF)
+	}
+	if len(company.CNPJ) != 14 {
+		return "", fmt.Errorf("company CNPJ must have 14 digits")
+	}
+
+	var seriesNumber int
+	if _, err := fmt.Sscanf(series, "%d", &seriesNumber); err != nil {
+		return "", fmt.Errorf("invalid invoice series %q", series)
+	}
+
+	code := crc32.ChecksumIEEE([]byte(seed)) % 100000000
+	if int64(code) == number%100000000 {
+		code = (code + 1) % 100000000
+	}
+
+	key := fmt.Sprintf("%s%s%s55%03d%09d1%08d", uf, issuedAt.Format("0601"), company.CNPJ, seriesNumber, number, code)
+	return key + checkDigit(key), nil
+}
+
+func checkDigit(key string) string {
+	sum, weight := 0, 2
+	for i := len(key) - 1; i >= 0; i-- {
+		sum += int(key[i]-'0') * weight
+		weight++
+		if weight > 9 {
+			weight = 2
+		}
+	}
+	digit := 11 - sum%11
+	if digit >= 10 {
+		digit = 0
+	}
+	return fmt.Sprint(digit)
+}
@@ -0,0 +1,129 @@
+package fiscal
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Company is the issuer of the invoices of a company. LoadCompany reads one
+// from the environment, which create-company uses for the flags not given.
+type Company struct {
+	Name          string
+	TradeName     string
+	CNPJ          string
+	IE            string
+	UF            string
+	CityCode      string
+	ZipCode       string
+	Street        string
+	Number        string
+	Complement    string
+	District      string
+	Phone         string
+	Email         string
+	Series        string
+	IndustrialAct bool
+}
+
+// Accountant is the contabilista reported in record 0100 of the SPED file.
+type Accountant struct {
+	Name     string
+	CPF      string
+	CRC      string
+	CNPJ     string
+	ZipCode  string
+	Street   string
+	Number   string
+	District string
+	Phone    string
+	Email    string
+	CityCode string
+}
+
+// Taxes holds the ICMS rates applied when invoice lines are priced.
+type Taxes struct {
+	InternalRate      float64
+	InterstateRate    float64
+	InternalCFOP      string
+	InterstateCFOP    string
+	CSTICMS           string
+	ICMSDueDay        int
+	ICMSRevenueCode   string
+	SpedLayoutVersion string
+}
+
+func LoadCompany() Company {
+	return Company{
+		Name:          os.Getenv("COMPANY_NAME"),
+		TradeName:     os.Getenv("COMPANY_TRADE_NAME"),
+		CNPJ:          OnlyDigits(os.Getenv("COMPANY_CNPJ")),
+		IE:            OnlyDigits(os.Getenv("COMPANY_IE")),
+		UF:            strings.ToUpper(os.Getenv("COMPANY_UF")),
+		CityCode:      os.Getenv("COMPANY_CITY_CODE"),
+		ZipCode:       OnlyDigits(os.Getenv("COMPANY_ZIP")),
+		Street:        os.Getenv("COMPANY_STREET"),
+		Number:        os.Getenv("COMPANY_NUMBER"),
+		Complement:    os.Getenv("COMPANY_COMPLEMENT"),
+		District:      os.Getenv("COMPANY_DISTRICT"),
+		Phone:         OnlyDigits(os.Getenv("COMPANY_PHONE")),
+		Email:         os.Getenv("COMPANY_EMAIL"),
+		Series:        envOr("INVOICE_SERIES", "1"),
+		IndustrialAct: os.Getenv("COMPANY_INDUSTRIAL") == "true",
+	}
+}
+
+func LoadAccountant() Accountant {
+	return Accountant{
+		Name:     os.Getenv("ACCOUNTANT_NAME"),
+		CPF:      OnlyDigits(os.Getenv("ACCOUNTANT_CPF")),
+		CRC:      os.Getenv("ACCOUNTANT_CRC"),
+		CNPJ:     OnlyDigits(os.Getenv("ACCOUNTANT_CNPJ")),
+		ZipCode:  OnlyDigits(os.Getenv("ACCOUNTANT_ZIP")),
+		Street:   os.Getenv("ACCOUNTANT_STREET"),
+		Number:   os.Getenv("ACCOUNTANT_NUMBER"),
+		District: os.Getenv("ACCOUNTANT_DISTRICT"),
+		Phone:    OnlyDigits(os.Getenv("ACCOUNTANT_PHONE")),
+		Email:    os.Getenv("ACCOUNTANT_EMAIL"),
+		CityCode: os.Getenv("ACCOUNTANT_CITY_CODE"),
+	}
+}
+
+func LoadTaxes() Taxes {
+	return Taxes{
+		InternalRate:      envFloat("ICMS_INTERNAL_RATE", 18),
+		InterstateRate:    envFloat("ICMS_INTERSTATE_RATE", 12),
+		InternalCFOP:      envOr("CFOP_INTERNAL", "5102"),
+		InterstateCFOP:    envOr("CFOP_INTERSTATE", "6102"),
+		CSTICMS:           envOr("CST_ICMS", "000"),
+		ICMSDueDay:        int(envFloat("ICMS_DUE_DAY", 10)),
+		ICMSRevenueCode:   os.Getenv("ICMS_REVENUE_CODE"),
+		SpedLayoutVersion: envOr("SPED_LAYOUT_VERSION", "019"),
+	}
+}
+
+// OnlyDigits strips punctuation from documents, phones and zip codes.
+func OnlyDigits(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
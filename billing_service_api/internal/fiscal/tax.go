@@ -0,0 +1,39 @@
+package fiscal
+
+import (
+	"math"
+	"strings"
+)
+
+// LineTax is the ICMS treatment of one invoice line.
+type LineTax struct {
+	CFOP      string
+	CSTICMS   string
+	Base      float64
+	Rate      float64
+	ICMSValue float64
+}
+
+// ComputeLineTax applies the internal rate and CFOP when the customer is in
+// the issuer's state, or has no state on record, and the interstate ones
+// otherwise.
+func ComputeLineTax(taxes Taxes, companyUF, customerUF string, value float64) LineTax {
+	tax := LineTax{
+		CFOP:    taxes.InternalCFOP,
+		CSTICMS: taxes.CSTICMS,
+		Base:    Round2(value),
+		Rate:    taxes.InternalRate,
+	}
+
+	if customerUF != "" && !strings.EqualFold(customerUF, companyUF) {
+		tax.CFOP = taxes.InterstateCFOP
+		tax.Rate = taxes.InterstateRate
+	}
+
+	tax.ICMSValue = Round2(tax.Base * tax.Rate / 100)
+	return tax
+}
+
+func Round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
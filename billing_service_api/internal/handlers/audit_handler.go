@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/billing_service_api/internal/audit"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+// Actors recorded for changes that do not come from an HTTP request.
+const (
+	ActorScheduler = "scheduler"
+	ActorImport    = "import"
+)
+
+type ChainBreak struct {
+	EventID     int64  `json:"event_id"`
+	InvoiceCode string `json:"invoice_code"`
+	Reason      string `json:"reason"`
+}
+
+// requestActor identifies who made the request: the email of the signed in
+// user, or the prefix of the API key.
+func requestActor(c *fiber.Ctx) string {
+	if user := auth.CurrentUser(c); user != nil {
+		if user.IsAPIKey() {
+			return "api-key:" + user.KeyPrefix
+		}
+		return user.Email
+	}
+	return "anonymous"
+}
+
+// recordInvoiceEvent appends an entry to the audit log inside tx. Each company
+// has its own chain, and the advisory lock serializes the writers of a company
+// so each entry links to the one committed before it.
+// before and after are marshalled as invoice snapshots; nil stores NULL.
+func recordInvoiceEvent(tx *sqlx.Tx, code, actor string, action models.AcaoNota, reason string, before, after interface{}) error {
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('invoice_events'), hashtext(current_company_id()::text))"); err != nil {
+		return fmt.Errorf("error locking audit log: %v", err)
+	}
+
+	beforeJSON, err := snapshotJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := snapshotJSON(after)
+	if err != nil {
+		return err
+	}
+
+	var prevHash string
+	err = tx.Get(&prevHash, "SELECT COALESCE((SELECT hash FROM invoice_events ORDER BY id DESC LIMIT 1), '')")
+	if err != nil {
+		return fmt.Errorf("error reading audit log: %v", err)
+	}
+
+	entry := audit.Entry{
+		InvoiceCode: code,
+		Actor:       actor,
+		Action:      string(action),
+		Reason:      reason,
+		Before:      stringOrEmpty(beforeJSON),
+		After:       stringOrEmpty(afterJSON),
+		CreatedAt:   time.Now().UTC().Truncate(time.Microsecond),
+		PrevHash:    prevHash,
+	}
+
+	_, err = tx.Exec(`INSERT INTO invoice_events (invoice_code, actor, action, reason, before, after, prev_hash, hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		entry.InvoiceCode, entry.Actor, entry.Action, entry.Reason, beforeJSON, afterJSON, entry.PrevHash, audit.Hash(entry), entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error writing audit log: %v", err)
+	}
+
+	return nil
+}
+
+func GetInvoiceHistory(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	code := c.Params("code")
+
+	var exists bool
+	if err := conn.Get(&exists, "SELECT EXISTS (SELECT 1 FROM invoices WHERE code = $1)", code); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting invoice"})
+	}
+	if !exists {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+
+	events := []models.InvoiceEvent{}
+	if err := conn.Select(&events, "SELECT * FROM invoice_events WHERE invoice_code = $1 ORDER BY id", code); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting invoice history"})
+	}
+
+	return c.JSON(events)
+}
+
+// VerifyInvoiceEvents walks the audit log of the company in order, recomputing every
+// hash and checking each link to the previous entry. Any edited, deleted or
+// reordered entry shows up as a break.
+func VerifyInvoiceEvents(c *fiber.Ctx) error {
+	rows, err := tenant.DB(c).Queryx("SELECT * FROM invoice_events ORDER BY id")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error reading audit log"})
+	}
+	defer rows.Close()
+
+	breaks := []ChainBreak{}
+	checked := 0
+	prevHash := ""
+	for rows.Next() {
+		var event models.InvoiceEvent
+		if err := rows.StructScan(&event); err != nil {
+			log.Printf("Error scanning audit log: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Error reading audit log"})
+		}
+		checked++
+
+		if event.PrevHash != prevHash {
+			breaks = append(breaks, ChainBreak{EventID: event.ID, InvoiceCode: event.InvoiceCode, Reason: "previous hash does not match the preceding entry"})
+		}
+
+		createdAt, err := time.Parse(time.RFC3339Nano, event.CreatedAt)
+		if err != nil {
+			breaks = append(breaks, ChainBreak{EventID: event.ID, InvoiceCode: event.InvoiceCode, Reason: "invalid timestamp"})
+		} else {
+			expected := audit.Hash(audit.Entry{
+				InvoiceCode: event.InvoiceCode,
+				Actor:       event.Actor,
+				Action:      string(event.Action),
+				Reason:      event.Reason,
+				Before:      rawOrEmpty(event.Before),
+				After:       rawOrEmpty(event.After),
+				CreatedAt:   createdAt,
+				PrevHash:    event.PrevHash,
+			})
+			if expected != event.Hash {
+				breaks = append(breaks, ChainBreak{EventID: event.ID, InvoiceCode: event.InvoiceCode, Reason: "entry content does not match its hash"})
+			}
+		}
+
+		prevHash = event.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error reading audit log"})
+	}
+
+	return c.JSON(fiber.Map{
+		"valid":   len(breaks) == 0,
+		"checked": checked,
+		"breaks":  breaks,
+	})
+}
+
+func snapshotJSON(value interface{}) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding audit snapshot: %v", err)
+	}
+	text := string(data)
+	return &text, nil
+}
+
+func stringOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func rawOrEmpty(value *json.RawMessage) string {
+	if value == nil {
+		return ""
+	}
+	return string(*value)
+}
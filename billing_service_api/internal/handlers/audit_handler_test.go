@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+type chainVerification struct {
+	Valid   bool         `json:"valid"`
+	Checked int          `json:"checked"`
+	Breaks  []ChainBreak `json:"breaks"`
+}
+
+// writeTestEvents appends three entries to the audit log of companyID and
+// returns their IDs.
+func writeTestEvents(t *testing.T, companyID string) []int64 {
+	t.Helper()
+
+	var ids []int64
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		tx, err := conn.Beginx()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		steps := []models.StatusNota{models.StatusAberto, models.StatusFechando, models.StatusFechado}
+		for i, action := range []models.AcaoNota{models.AcaoCriada, models.AcaoFechamentoSolicitado, models.AcaoFechada} {
+			var before interface{}
+			if i > 0 {
+				before = fiber.Map{"status": steps[i-1]}
+			}
+			if err := recordInvoiceEvent(tx, "NF-1", "fiscal@example.com", action, "", before, fiber.Map{"status": steps[i]}); err != nil {
+				return err
+			}
+		}
+		if err := tx.Select(&ids, "SELECT id FROM invoice_events ORDER BY id"); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		t.Fatalf("writing audit log: %v", err)
+	}
+	return ids
+}
+
+// tamperEvents runs query on the audit log of companyID as its owner could,
+// with the append-only trigger off inside the transaction only.
+func tamperEvents(t *testing.T, companyID, query string, args ...interface{}) {
+	t.Helper()
+
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		tx, err := conn.Beginx()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("ALTER TABLE invoice_events DISABLE TRIGGER trg_invoice_events_append_only"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("ALTER TABLE invoice_events ENABLE TRIGGER trg_invoice_events_append_only"); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		t.Fatalf("tampering with audit log: %v", err)
+	}
+}
+
+func verifyEvents(t *testing.T, companyID string) chainVerification {
+	t.Helper()
+
+	app := fiber.New()
+	app.Use(tenant.Middleware(db.DB, func(*fiber.Ctx) bool { return true }))
+	app.Get("/invoices/events/verify", VerifyInvoiceEvents)
+
+	status, body := sendInvoiceRequest(t, app, companyID, "GET", "/invoices/events/verify", "")
+	if status != http.StatusOK {
+		t.Fatalf("verify = %d %s, want 200", status, body)
+	}
+	var result chainVerification
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestVerifyInvoiceEvents(t *testing.T) {
+	useTestDatabase(t)
+
+	t.Run("untouched", func(t *testing.T) {
+		companyID := createTestCompany(t)
+		writeTestEvents(t, companyID)
+
+		result := verifyEvents(t, companyID)
+		if !result.Valid || result.Checked != 3 || len(result.Breaks) != 0 {
+			t.Errorf("verify = %+v, want 3 valid entries", result)
+		}
+	})
+
+	t.Run("edited after", func(t *testing.T) {
+		companyID := createTestCompany(t)
+		ids := writeTestEvents(t, companyID)
+		tamperEvents(t, companyID, `UPDATE invoice_events SET after = '{"status":"CANCELADA"}' WHERE id = $1`, ids[1])
+
+		result := verifyEvents(t, companyID)
+		want := ChainBreak{EventID: ids[1], InvoiceCode: "NF-1", Reason: "entry content does not match its hash"}
+		if result.Valid || result.Checked != 3 || len(result.Breaks) != 1 || result.Breaks[0] != want {
+			t.Errorf("verify = %+v, want only %+v", result, want)
+		}
+	})
+
+	t.Run("edited before", func(t *testing.T) {
+		companyID := createTestCompany(t)
+		ids := writeTestEvents(t, companyID)
+		tamperEvents(t, companyID, `UPDATE invoice_events SET before = NULL WHERE id = $1`, ids[2])
+
+		result := verifyEvents(t, companyID)
+		want := ChainBreak{EventID: ids[2], InvoiceCode: "NF-1", Reason: "entry content does not match its hash"}
+		if result.Valid || len(result.Breaks) != 1 || result.Breaks[0] != want {
+			t.Errorf("verify = %+v, want only %+v", result, want)
+		}
+	})
+
+	t.Run("deleted middle entry", func(t *testing.T) {
+		companyID := createTestCompany(t)
+		ids := writeTestEvents(t, companyID)
+		tamperEvents(t, companyID, "DELETE FROM invoice_events WHERE id = $1", ids[1])
+
+		result := verifyEvents(t, companyID)
+		want := ChainBreak{EventID: ids[2], InvoiceCode: "NF-1", Reason: "previous hash does not match the preceding entry"}
+		if result.Valid || result.Checked != 2 || len(result.Breaks) != 1 || result.Breaks[0] != want {
+			t.Errorf("verify = %+v, want only %+v", result, want)
+		}
+	})
+}
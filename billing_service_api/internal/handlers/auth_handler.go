@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	authConfigMu sync.Mutex
+	authConfig   auth.Config
+)
+
+// SetAuthConfig sets how the tokens handed out by login and refresh are
+// signed.
+func SetAuthConfig(config auth.Config) {
+	authConfigMu.Lock()
+	defer authConfigMu.Unlock()
+
+	authConfig = config
+}
+
+func currentAuthConfig() auth.Config {
+	authConfigMu.Lock()
+	defer authConfigMu.Unlock()
+
+	return authConfig
+}
+
+// dummyPasswordHash is compared against when the email is unknown, so a login
+// takes as long whether the user exists or not.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("nota_fiscal"), bcrypt.DefaultCost)
+
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+// TokenResponse carries a new access token, sent as "Authorization: Bearer",
+// and the refresh token that gets the next one once it expires.
+type TokenResponse struct {
+	AccessToken  string      `json:"access_token"`
+	TokenType    string      `json:"token_type"`
+	ExpiresIn    int         `json:"expires_in"`
+	RefreshToken string      `json:"refresh_token"`
+	User         models.User `json:"user"`
+}
+
+func Login(c *fiber.Ctx) error {
+	request := new(LoginRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid login data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	// The company is not known before signing in: it is the one of the user.
+	var user models.User
+	err := db.System.Get(&user, "SELECT * FROM users WHERE email = $1", normalizeEmail(request.Email))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return c.Status(500).JSON(fiber.Map{"error": "Error signing in"})
+		}
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(request.Password))
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid email or password"})
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(request.Password)) != nil || !user.Active {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid email or password"})
+	}
+
+	response, err := issueTokens(db.System, user, time.Now())
+	if err != nil {
+		log.Printf("Error issuing tokens: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error signing in"})
+	}
+
+	return c.JSON(response)
+}
+
+// RefreshToken trades a refresh token for a new access and refresh token.
+// Each refresh token works once; presenting a revoked one means it leaked, so
+// every session of its user is revoked.
+func RefreshToken(c *fiber.Ctx) error {
+	request := new(RefreshRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid refresh data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	tx, err := db.System.Beginx()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	var token models.RefreshToken
+	err = tx.Get(&token, "SELECT * FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE", hashRefreshToken(request.RefreshToken))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+
+	if token.RevokedAt != nil {
+		log.Printf("Revoked refresh token %s presented again, revoking every session of user %s", token.ID, token.UserID)
+		if err := revokeRefreshTokens(tx, token.UserID, now); err != nil || tx.Commit() != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Error revoking sessions"})
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339Nano, token.ExpiresAt)
+	if err != nil || !now.Before(expiresAt) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Refresh token expired"})
+	}
+
+	var user models.User
+	if err := tx.Get(&user, "SELECT * FROM users WHERE id = $1", token.UserID); err != nil || !user.Active {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+
+	if _, err := tx.Exec("UPDATE refresh_tokens SET revoked_at = $1 WHERE id = $2", now, token.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error refreshing token"})
+	}
+
+	response, err := issueTokens(tx, user, now)
+	if err != nil {
+		log.Printf("Error issuing tokens: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error refreshing token"})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+
+	return c.JSON(response)
+}
+
+// Logout revokes a refresh token. The access token stays valid until it
+// expires.
+func Logout(c *fiber.Ctx) error {
+	request := new(RefreshRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid logout data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	_, err := db.DB.Exec("UPDATE refresh_tokens SET revoked_at = $1 WHERE token_hash = $2 AND revoked_at IS NULL",
+		time.Now(), hashRefreshToken(request.RefreshToken))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error signing out"})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func GetCurrentUser(c *fiber.Ctx) error {
+	user, err := findUser(tenant.DB(c), auth.CurrentUser(c).Subject)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	return c.JSON(user)
+}
+
+// ChangePassword sets a new password for the current user and revokes all of
+// their refresh tokens, so every session has to sign in again.
+func ChangePassword(c *fiber.Ctx) error {
+	request := new(ChangePasswordRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid password data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	user, err := findUser(tenant.DB(c), auth.CurrentUser(c).Subject)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(request.CurrentPassword)) != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Current password is incorrect"})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(request.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error hashing password"})
+	}
+
+	tx, err := tenant.DB(c).Beginx()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec("UPDATE users SET password_hash = $1, updated_at = $2 WHERE id = $3", string(hash), now, user.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error changing password"})
+	}
+	if err := revokeRefreshTokens(tx, user.ID, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error revoking sessions"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// issueTokens signs an access token for the user, scoped to their company,
+// and stores a new refresh token through q.
+func issueTokens(q sqlx.Execer, user models.User, now time.Time) (*TokenResponse, error) {
+	config := currentAuthConfig()
+
+	accessToken, expiresAt, err := config.IssueAccessToken(user.ID.String(), user.Email, user.CompanyID.String(), user.Role, now)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(secret)
+
+	_, err = q.Exec(`INSERT INTO refresh_tokens (id, user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		uuid.New(), user.ID, hashRefreshToken(refreshToken), now.Add(config.RefreshTTL), now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(expiresAt.Sub(now).Seconds()),
+		RefreshToken: refreshToken,
+		User:         user,
+	}, nil
+}
+
+func revokeRefreshTokens(q sqlx.Execer, userID uuid.UUID, now time.Time) error {
+	_, err := q.Exec("UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", now, userID)
+	return err
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// PurgeRefreshTokens is the scheduler job deleting the refresh tokens that
+// expired a day ago or more. Revoked tokens are kept until then, so reusing
+// one is still caught.
+func PurgeRefreshTokens(now time.Time) error {
+	_, err := db.DB.Exec("DELETE FROM refresh_tokens WHERE expires_at < $1", now.Add(-24*time.Hour))
+	return err
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/fiscal"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/webhooks"
+)
+
+// ErrCompanyExists is returned by CreateCompanyAccount for a CNPJ already
+// registered.
+var ErrCompanyExists = errors.New("company with this CNPJ already exists")
+
+// GetCurrentCompany returns the company of the signed in user, the issuer of
+// its invoices.
+func GetCurrentCompany(c *fiber.Ctx) error {
+	company, err := currentCompany(tenant.DB(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Company not found"})
+	}
+	return c.JSON(company)
+}
+
+// CreateCompanyAccount stores a validated company. It backs
+// `billing_service_api create-company`; companies are not created through
+// the API, since every user belongs to one.
+func CreateCompanyAccount(company models.Company) (*models.Company, error) {
+	company.CNPJ = fiscal.OnlyDigits(company.CNPJ)
+	company.UF = strings.ToUpper(company.UF)
+
+	var existingCount int
+	if err := db.System.QueryRow("SELECT COUNT(*) FROM companies WHERE cnpj = $1", company.CNPJ).Scan(&existingCount); err != nil {
+		return nil, err
+	}
+	if existingCount > 0 {
+		return nil, ErrCompanyExists
+	}
+
+	company.ID = uuid.New()
+	company.CreatedAt = time.Now().Format(time.RFC3339)
+
+	_, err := db.System.Exec(`INSERT INTO companies (id, name, trade_name, cnpj, ie, uf, city_code, zip_code, street, number,
+			complement, district, phone, email, series, industrial, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		company.ID, company.Name, company.TradeName, company.CNPJ, company.IE, company.UF, company.CityCode, company.ZipCode,
+		company.Street, company.Number, company.Complement, company.District, company.Phone, company.Email, company.Series,
+		company.Industrial, company.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &company, nil
+}
+
+// currentCompany loads the company q works for.
+func currentCompany(q sqlx.Queryer) (*models.Company, error) {
+	var company models.Company
+	if err := sqlx.Get(q, &company, "SELECT * FROM companies WHERE id = current_company_id()"); err != nil {
+		return nil, fmt.Errorf("error loading company: %v", err)
+	}
+	return &company, nil
+}
+
+// forEachCompany runs a scheduler job once for every company, on a
+// connection of that company, so the job only sees and changes its rows. A
+// company that fails does not stop the others.
+func forEachCompany(job func(conn *db.Conn) error) error {
+	return forEachCompanyID(func(companyID string) error {
+		return db.WithCompany(companyID, job)
+	}, "SELECT id FROM companies ORDER BY created_at")
+}
+
+// forEachCompanyID runs job once for every company that query, a SELECT of
+// company IDs run through db.System, returns, for jobs that take a connection
+// of the company only when they need one. A company that fails does not stop
+// the others.
+func forEachCompanyID(job func(companyID string) error, query string, args ...interface{}) error {
+	var companyIDs []string
+	if err := db.System.Select(&companyIDs, query, args...); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, companyID := range companyIDs {
+		if err := job(companyID); err != nil {
+			errs = append(errs, fmt.Errorf("company %s: %v", companyID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// companyTx runs each function it is given in a transaction of companyID, on
+// a connection taken for that transaction only.
+func companyTx(companyID string) webhooks.InTx {
+	return func(fn func(tx *sqlx.Tx) error) error {
+		return db.WithCompany(companyID, func(conn *db.Conn) error {
+			tx, err := conn.Beginx()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return tx.Commit()
+		})
+	}
+}
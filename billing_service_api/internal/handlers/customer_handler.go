@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+func CreateCustomer(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	customer := new(models.Customer)
+
+	if err := c.BodyParser(customer); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid customer data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(customer); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	if strings.TrimSpace(customer.Email) != "" {
+		if _, err := netmail.ParseAddressList(customer.Email); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON([]ErrorResponse{{FailedField: "Customer.Email", Tag: "email", Value: customer.Email}})
+		}
+	}
+
+	var existingCount int
+	err := conn.QueryRow(`SELECT COUNT(*) FROM customers WHERE document = $1`, customer.Document).Scan(&existingCount)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error checking customer existence"})
+	}
+
+	if existingCount > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Customer with this document already exists"})
+	}
+
+	customer.ID = uuid.New()
+	customer.CreatedAt = time.Now().Format(time.RFC3339)
+
+	customer.UF = strings.ToUpper(customer.UF)
+
+	query := `INSERT INTO customers (id, name, document, email, ie, uf, city_code, street, number, complement, district, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	_, err = conn.Exec(query, customer.ID, customer.Name, customer.Document, customer.Email, customer.IE, customer.UF,
+		customer.CityCode, customer.Street, customer.Number, customer.Complement, customer.District, customer.CreatedAt)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error creating customer"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(customer)
+}
+
+func GetCustomers(c *fiber.Ctx) error {
+	var customers []models.Customer
+	err := tenant.DB(c).Select(&customers, "SELECT * FROM customers ORDER BY name ASC")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting customers"})
+	}
+	return c.JSON(customers)
+}
+
+func GetCustomerById(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if id == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Customer id is required"})
+	}
+
+	var customer models.Customer
+	err := tenant.DB(c).Get(&customer, "SELECT * FROM customers WHERE id = $1", id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Customer not found"})
+	}
+
+	return c.JSON(customer)
+}
+
+func customerExists(q db.Querier, id uuid.UUID) (bool, error) {
+	var count int
+	err := q.QueryRow(`SELECT COUNT(*) FROM customers WHERE id = $1`, id).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient"
+)
+
+// useTestDatabase points db.DB and db.System at TEST_DATABASE_URL, a database
+// set up by init-scripts/init-billing-db.sql and reached as billing_user,
+// since row-level security does not bind superusers. The tests needing it
+// are skipped when it is not set.
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	conn, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	system, err := sqlx.Connect("postgres", url+separator+"options=-c%20app.all_companies%3Don")
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+
+	previousDB, previousSystem := db.DB, db.System
+	db.DB, db.System = conn, system
+	t.Cleanup(func() {
+		db.DB, db.System = previousDB, previousSystem
+		conn.Close()
+		system.Close()
+	})
+}
+
+// createTestCompany inserts a company able to issue NF-e and returns its ID.
+func createTestCompany(t *testing.T) string {
+	t.Helper()
+
+	var id string
+	cnpj := fmt.Sprintf("%014d", rand.Int63n(1e14))
+	err := db.System.Get(&id, `INSERT INTO companies (name, cnpj, uf, city_code)
+		VALUES ('Test company', $1, 'SP', '3550308') RETURNING id`, cnpj)
+	if err != nil {
+		t.Fatalf("creating company: %v", err)
+	}
+	return id
+}
+
+// createTestInvoice inserts an ABERTO invoice of companyID, with no stock
+// reservation, for a customer with an email address, and returns its code.
+func createTestInvoice(t *testing.T, companyID string) string {
+	t.Helper()
+
+	code := uuid.NewString()
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		var customerID string
+		err := conn.Get(&customerID, `INSERT INTO customers (name, document, email)
+			VALUES ('Test customer', $1, 'customer@example.com') RETURNING id`, fmt.Sprintf("%011d", rand.Int63n(1e11)))
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Exec("INSERT INTO invoices (code, customer_id, total_value) VALUES ($1, $2, 10)", code, customerID); err != nil {
+			return err
+		}
+		_, err = conn.Exec(`INSERT INTO invoice_products (invoice_code, product_id, amount, unit_price, total_value)
+			VALUES ($1, 'product-1', 2, 5, 10)`, code)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("creating invoice: %v", err)
+	}
+	return code
+}
+
+// useFakeStock points the stock client at a test server answering with
+// handler, and gives the previous client back when the test ends. The
+// client neither retries nor opens its breaker.
+func useFakeStock(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	stockClientMu.Lock()
+	previous := stockClient
+	stockClientMu.Unlock()
+
+	SetStockClient(stockclient.New(stockclient.Config{BaseURL: server.URL, Transport: stockclient.TransportHTTP, Timeout: time.Second}))
+	t.Cleanup(func() {
+		SetStockClient(previous)
+		server.Close()
+	})
+}
+
+// newInvoiceApp serves the invoice routes to requests naming their company
+// in tenant.Header, as other services do.
+func newInvoiceApp() *fiber.App {
+	app := fiber.New()
+	app.Use(tenant.Middleware(db.DB, func(*fiber.Ctx) bool { return true }))
+	app.Put("/invoices/:code/close", UpdateInvoiceStatus)
+	app.Put("/invoices/:code/cancel", CancelInvoice)
+	app.Post("/invoices/:code/corrections", CorrectInvoice)
+	app.Post("/invoices/:code/emails", SendInvoiceEmail)
+	return app
+}
+
+// sendInvoiceRequest sends a request for companyID and returns its status
+// and body. It can run in other goroutines than the test's, so a failed
+// request is reported with status 0.
+func sendInvoiceRequest(t *testing.T, app *fiber.App, companyID, method, path, body string, headers ...string) (int, string) {
+	t.Helper()
+
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	request.Header.Set(tenant.Header, companyID)
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Error(err)
+		return 0, ""
+	}
+	data, _ := io.ReadAll(response.Body)
+	return response.StatusCode, string(data)
+}
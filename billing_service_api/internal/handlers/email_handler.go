@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	netmail "net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/danfe"
+	"github.com/lucasbpereira/billing_service_api/internal/mail"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/billing_service_api/internal/nfe"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+// emailRetryBase is the wait after the first failed attempt; it doubles on
+// every further failure.
+const emailRetryBase = time.Minute
+
+type SendInvoiceEmailRequest struct {
+	Event      string   `json:"event" validate:"omitempty,oneof=issued cancelled corrected"`
+	Recipients []string `json:"recipients" validate:"omitempty,dive,email"`
+}
+
+// GetInvoiceXML downloads the NF-e XML of a closed invoice.
+func GetInvoiceXML(c *fiber.Ctx) error {
+	doc, err := loadInvoiceDocument(requestContext(c), tenant.DB(c), c.Params("code"), models.StatusFechado)
+	if err != nil {
+		return invoiceErrorResponse(c, err)
+	}
+
+	data, err := nfe.XML(doc)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Error generating XML", "details": err.Error()})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-nfe.xml"`, doc.AccessKey))
+	return c.Send(data)
+}
+
+// GetInvoiceDANFE downloads the DANFE of a closed invoice as PDF.
+func GetInvoiceDANFE(c *fiber.Ctx) error {
+	doc, err := loadInvoiceDocument(requestContext(c), tenant.DB(c), c.Params("code"), models.StatusFechado)
+	if err != nil {
+		return invoiceErrorResponse(c, err)
+	}
+
+	data, err := danfe.PDF(doc)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Error generating DANFE", "details": err.Error()})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-danfe.pdf"`, doc.AccessKey))
+	return c.Send(data)
+}
+
+// SendInvoiceEmail queues an email with the XML and DANFE of an invoice. The
+// event defaults to "issued" and the recipients to the customer's addresses.
+func SendInvoiceEmail(c *fiber.Ctx) error {
+	request := new(SendInvoiceEmailRequest)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid email data", "details": err.Error()})
+		}
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	if request.Event == "" {
+		request.Event = mail.EventIssued
+	}
+
+	delivery, err := enqueueInvoiceEmail(tenant.DB(c), c.Params("code"), request.Event, request.Recipients)
+	if err != nil {
+		return invoiceErrorResponse(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(delivery)
+}
+
+func GetInvoiceEmails(c *fiber.Ctx) error {
+	deliveries := []models.EmailDelivery{}
+	err := tenant.DB(c).Select(&deliveries, "SELECT * FROM email_deliveries WHERE invoice_code = $1 ORDER BY created_at DESC", c.Params("code"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting email deliveries"})
+	}
+
+	return c.JSON(deliveries)
+}
+
+// ResendEmail queues a new delivery with the same invoice, event and
+// recipients as an earlier one, whatever its status. The original entry is
+// kept in the log.
+func ResendEmail(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Email delivery not found"})
+	}
+
+	var original models.EmailDelivery
+	conn := tenant.DB(c)
+	if err := conn.Get(&original, "SELECT * FROM email_deliveries WHERE id = $1", id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Email delivery not found"})
+	}
+
+	delivery, err := enqueueInvoiceEmail(conn, original.InvoiceCode, original.Event, original.Recipients)
+	if err != nil {
+		return invoiceErrorResponse(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(delivery)
+}
+
+// DeliverPendingEmails is the scheduler job. Each due delivery is locked with
+// SKIP LOCKED, so several instances can run it side by side, and sent in its
+// own transaction, company by company.
+func DeliverPendingEmails(now time.Time) error {
+	config := mail.LoadConfig()
+	maxAttempts := emailMaxAttempts()
+
+	return forEachCompany(func(conn *db.Conn) error {
+		for {
+			done, err := deliverNextEmail(conn, config, now, maxAttempts)
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		}
+	})
+}
+
+func deliverNextEmail(conn *db.Conn, config mail.Config, now time.Time, maxAttempts int) (bool, error) {
+	tx, err := conn.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var delivery models.EmailDelivery
+	err = tx.Get(&delivery, `SELECT * FROM email_deliveries
+		WHERE status = $1 AND next_attempt_at <= $2
+		ORDER BY next_attempt_at
+		LIMIT 1 FOR UPDATE SKIP LOCKED`, models.EnvioPendente, now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil
+		}
+		return false, fmt.Errorf("error loading pending emails: %v", err)
+	}
+
+	sendErr := sendInvoiceEmail(conn, config, &delivery)
+	delivery.Attempts++
+
+	if sendErr == nil {
+		_, err = tx.Exec(`UPDATE email_deliveries SET status = $1, attempts = $2, subject = $3, last_error = NULL, sent_at = $4 WHERE id = $5`,
+			models.EnvioEnviado, delivery.Attempts, delivery.Subject, time.Now().Format(time.RFC3339), delivery.ID)
+	} else {
+		log.Printf("Error sending email %s for invoice %s (attempt %d): %v", delivery.ID, delivery.InvoiceCode, delivery.Attempts, sendErr)
+
+		status := models.EnvioPendente
+		if delivery.Attempts >= maxAttempts {
+			status = models.EnvioFalhou
+		}
+		backoff := emailRetryBase * time.Duration(math.Pow(2, float64(delivery.Attempts-1)))
+		_, err = tx.Exec(`UPDATE email_deliveries SET status = $1, attempts = $2, subject = $3, last_error = $4, next_attempt_at = $5 WHERE id = $6`,
+			status, delivery.Attempts, delivery.Subject, sendErr.Error(), now.Add(backoff).Format(time.RFC3339), delivery.ID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error updating email delivery %s: %v", delivery.ID, err)
+	}
+
+	return false, tx.Commit()
+}
+
+// sendInvoiceEmail renders the event template and sends it with the XML and
+// DANFE attached. The rendered subject is stored back on the delivery.
+func sendInvoiceEmail(conn *db.Conn, config mail.Config, delivery *models.EmailDelivery) error {
+	doc, err := loadInvoiceDocument(jobContext(conn), conn, delivery.InvoiceCode, emailEventStatus[delivery.Event])
+	if err != nil {
+		return err
+	}
+
+	xmlData, err := nfe.XML(doc)
+	if err != nil {
+		return err
+	}
+	pdfData, err := danfe.PDF(doc)
+	if err != nil {
+		return err
+	}
+
+	data := fiber.Map{
+		"CompanyName":  doc.Company.Name,
+		"CustomerName": doc.Recipient.Name,
+		"Number":       doc.Number,
+		"Series":       doc.Series,
+		"AccessKey":    doc.AccessKey,
+		"IssuedAt":     doc.IssuedAt.Format("02/01/2006"),
+		"Total":        strings.Replace(strconv.FormatFloat(doc.Total, 'f', 2, 64), ".", ",", 1),
+	}
+	if delivery.Event == mail.EventCorrected {
+		if data["Correction"], err = latestCorrection(conn, delivery.InvoiceCode); err != nil {
+			return err
+		}
+	}
+
+	subject, body, err := config.Render(delivery.Event, data)
+	if err != nil {
+		return err
+	}
+	delivery.Subject = subject
+
+	return config.Send(mail.Message{
+		To:      delivery.Recipients,
+		Subject: subject,
+		Body:    body,
+		Attachments: []mail.Attachment{
+			{Filename: doc.AccessKey + "-nfe.xml", ContentType: "application/xml", Data: xmlData},
+			{Filename: doc.AccessKey + "-danfe.pdf", ContentType: "application/pdf", Data: pdfData},
+		},
+	})
+}
+
+// emailEventStatus is the status an invoice must be in for the email of each
+// event: the NF-e is issued, and later corrected, once the invoice is
+// FECHADA, and the cancellation is sent once it is CANCELADA.
+var emailEventStatus = map[string]models.StatusNota{
+	mail.EventIssued:    models.StatusFechado,
+	mail.EventCorrected: models.StatusFechado,
+	mail.EventCancelled: models.StatusCancelada,
+}
+
+// checkEmailEvent refuses the email of event for an invoice that is not in
+// the status of the event, or that never had an NF-e issued, since every
+// email carries its XML and DANFE.
+func checkEmailEvent(invoice models.Invoice, event string) error {
+	status, ok := emailEventStatus[event]
+	if !ok {
+		return &invoiceError{Status: fiber.StatusUnprocessableEntity, Message: "Unknown email event " + event}
+	}
+	if invoice.Status != status {
+		return &invoiceError{Status: fiber.StatusConflict,
+			Message: fmt.Sprintf("The %s email needs a %s invoice, this one is %s", event, status, invoice.Status)}
+	}
+	if invoice.AccessKey == nil || invoice.ClosedAt == nil {
+		return &invoiceError{Status: fiber.StatusConflict, Message: "Invoice has not been issued yet"}
+	}
+	return nil
+}
+
+// enqueueInvoiceEmail records a PENDENTE delivery for the next scheduler run,
+// through q so it can join the transaction that closes the invoice. Without
+// explicit recipients the customer's addresses are used.
+func enqueueInvoiceEmail(q sqlx.Ext, code, event string, recipients []string) (*models.EmailDelivery, error) {
+	var invoice models.Invoice
+	if err := sqlx.Get(q, &invoice, "SELECT * FROM invoices WHERE code = $1", code); err != nil {
+		return nil, &invoiceError{Status: fiber.StatusNotFound, Message: "Invoice not found"}
+	}
+	if err := checkEmailEvent(invoice, event); err != nil {
+		return nil, err
+	}
+	if event == mail.EventCorrected {
+		if _, err := latestCorrection(q, code); err != nil {
+			return nil, &invoiceError{Status: fiber.StatusConflict, Message: "Invoice has no correction letter"}
+		}
+	}
+
+	if len(recipients) == 0 && invoice.CustomerID != nil {
+		var customer models.Customer
+		if err := sqlx.Get(q, &customer, "SELECT * FROM customers WHERE id = $1", invoice.CustomerID); err == nil {
+			recipients = customerEmails(customer.Email)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, &invoiceError{Status: fiber.StatusUnprocessableEntity, Message: "No email recipients for this invoice"}
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	delivery := models.EmailDelivery{
+		ID:            uuid.New(),
+		InvoiceCode:   code,
+		Event:         event,
+		Recipients:    recipients,
+		Status:        models.EnvioPendente,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+
+	_, err := q.Exec(`INSERT INTO email_deliveries (id, invoice_code, event, recipients, status, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		delivery.ID, delivery.InvoiceCode, delivery.Event, pq.Array(delivery.Recipients), delivery.Status, delivery.NextAttemptAt, delivery.CreatedAt)
+	if err != nil {
+		log.Printf("Error queueing email for invoice %s: %v", code, err)
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error queueing email"}
+	}
+
+	return &delivery, nil
+}
+
+// latestCorrection returns the text of the latest correction letter of an
+// invoice, which replaces the earlier ones.
+func latestCorrection(q sqlx.Queryer, code string) (string, error) {
+	var text string
+	err := sqlx.Get(q, &text, "SELECT reason FROM invoice_events WHERE invoice_code = $1 AND action = $2 ORDER BY id DESC LIMIT 1",
+		code, models.AcaoCorrigida)
+	return text, err
+}
+
+// loadInvoiceDocument gathers an issued invoice in status with its lines,
+// customer and product data into the document the XML and the DANFE are
+// rendered from. The company of q is the issuer.
+func loadInvoiceDocument(ctx context.Context, q db.Querier, code string, status models.StatusNota) (*nfe.Document, error) {
+	var invoice models.Invoice
+	if err := q.Get(&invoice, "SELECT * FROM invoices WHERE code = $1", code); err != nil {
+		return nil, &invoiceError{Status: fiber.StatusNotFound, Message: "Invoice not found"}
+	}
+	if invoice.AccessKey == nil || invoice.ClosedAt == nil {
+		return nil, &invoiceError{Status: fiber.StatusConflict, Message: "Invoice has not been issued yet"}
+	}
+	if invoice.Status != status {
+		return nil, &invoiceError{Status: fiber.StatusConflict, Message: fmt.Sprintf("Invoice is %s, not %s", invoice.Status, status)}
+	}
+	if invoice.CustomerID == nil {
+		return nil, &invoiceError{Status: fiber.StatusUnprocessableEntity, Message: "Invoice has no customer"}
+	}
+
+	var customer models.Customer
+	if err := q.Get(&customer, "SELECT * FROM customers WHERE id = $1", invoice.CustomerID); err != nil {
+		return nil, &invoiceError{Status: fiber.StatusUnprocessableEntity, Message: "Invoice customer not found"}
+	}
+
+	invoices := []models.Invoice{invoice}
+	if err := loadInvoiceProducts(q, invoices); err != nil {
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error fetching invoice products"}
+	}
+	company, err := currentCompany(q)
+	if err != nil {
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error loading company"}
+	}
+	lines := invoices[0].Products
+
+	issuedAt, err := time.Parse(time.RFC3339, *invoice.ClosedAt)
+	if err != nil {
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Invalid close date on invoice"}
+	}
+
+	doc := &nfe.Document{
+		Company: company.Issuer(),
+		Recipient: nfe.Recipient{
+			Name:       customer.Name,
+			Document:   customer.Document,
+			IE:         customer.IE,
+			Email:      strings.Join(customerEmails(customer.Email), ";"),
+			UF:         customer.UF,
+			CityCode:   customer.CityCode,
+			Street:     customer.Street,
+			Number:     customer.Number,
+			Complement: customer.Complement,
+			District:   customer.District,
+		},
+		Code:      invoice.Code,
+		Series:    invoice.Series,
+		Number:    invoice.Number,
+		AccessKey: *invoice.AccessKey,
+		IssuedAt:  issuedAt,
+		Total:     invoice.TotalValue,
+		ICMSValue: invoice.ICMSValue,
+	}
+
+	productIDs := make([]string, 0, len(lines))
+	for _, line := range lines {
+		productIDs = append(productIDs, line.ProductID)
+	}
+	products, missing, err := stockAPI().LookupProducts(ctx, productIDs)
+	if err == nil && len(missing) > 0 {
+		err = &UnknownProductsError{IDs: missing}
+	}
+	if err != nil {
+		return nil, &invoiceError{Status: fiber.StatusBadGateway, Message: "Error getting product data", Details: err.Error()}
+	}
+
+	for _, line := range lines {
+		product := products[line.ProductID]
+
+		doc.ICMSBase += line.ICMSBase
+		doc.Items = append(doc.Items, nfe.Item{
+			ProductID:   line.ProductID,
+			Description: product.Name,
+			NCM:         product.NCM,
+			Unit:        productUnit(product),
+			Quantity:    line.Amount,
+			UnitPrice:   line.UnitPrice,
+			Value:       line.TotalValue,
+			CFOP:        line.CFOP,
+			CSTICMS:     line.CSTICMS,
+			ICMSBase:    line.ICMSBase,
+			ICMSRate:    line.ICMSRate,
+			ICMSValue:   line.ICMSValue,
+		})
+	}
+
+	return doc, nil
+}
+
+// customerEmails splits the comma separated address list stored on the
+// customer.
+func customerEmails(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	addresses, err := netmail.ParseAddressList(value)
+	if err != nil {
+		return nil
+	}
+	emails := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		emails = append(emails, address.Address)
+	}
+	return emails
+}
+
+func emailMaxAttempts() int {
+	if value, err := strconv.Atoi(os.Getenv("EMAIL_MAX_ATTEMPTS")); err == nil && value > 0 {
+		return value
+	}
+	return 5
+}
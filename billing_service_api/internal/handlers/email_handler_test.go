@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/mail"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient"
+)
+
+func TestCheckEmailEvent(t *testing.T) {
+	accessKey, closedAt := "35250112345678000195550010000000011000000011", "2025-01-01T12:00:00Z"
+	issued := func(status models.StatusNota) models.Invoice {
+		return models.Invoice{Status: status, AccessKey: &accessKey, ClosedAt: &closedAt}
+	}
+
+	tests := []struct {
+		name    string
+		invoice models.Invoice
+		event   string
+		status  int
+	}{
+		{"issued email of a closed invoice", issued(models.StatusFechado), mail.EventIssued, 0},
+		{"corrected email of a closed invoice", issued(models.StatusFechado), mail.EventCorrected, 0},
+		{"cancelled email of a closed invoice", issued(models.StatusFechado), mail.EventCancelled, 409},
+		{"issued email of a cancelled invoice", issued(models.StatusCancelada), mail.EventIssued, 409},
+		{"corrected email of a cancelled invoice", issued(models.StatusCancelada), mail.EventCorrected, 409},
+		{"issued email of an open invoice", models.Invoice{Status: models.StatusAberto}, mail.EventIssued, 409},
+		{"cancelled email of an invoice never issued", models.Invoice{Status: models.StatusCancelada}, mail.EventCancelled, 409},
+		{"unknown event", issued(models.StatusFechado), "reminder", 422},
+	}
+	for _, test := range tests {
+		err := checkEmailEvent(test.invoice, test.event)
+		if test.status == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+			continue
+		}
+		var invoiceErr *invoiceError
+		if !errors.As(err, &invoiceErr) || invoiceErr.Status != test.status {
+			t.Errorf("%s: error %v, want status %d", test.name, err, test.status)
+		}
+	}
+}
+
+// TestEmailsOfAnIssuedInvoice closes an invoice, corrects it and cancels it
+// through the handlers, checking that each step queues its email and that
+// cancelling restores the stock deducted by the close.
+func TestEmailsOfAnIssuedInvoice(t *testing.T) {
+	useTestDatabase(t)
+
+	var mu sync.Mutex
+	var restored []string
+	useFakeStock(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/products/balance-update":
+			w.Write([]byte(`{"success":true}`))
+		case "/products/balance-restore":
+			var request stockclient.BalanceRestoreRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			mu.Lock()
+			restored = append(restored, request.OperationKey)
+			mu.Unlock()
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	companyID := createTestCompany(t)
+	code := createTestInvoice(t, companyID)
+	app := newInvoiceApp()
+
+	if status, body := sendInvoiceRequest(t, app, companyID, "PUT", "/invoices/"+code+"/close", ""); status != http.StatusOK {
+		t.Fatalf("close = %d %s, want 200", status, body)
+	}
+
+	correction := "O endereço do destinatário é Rua das Flores, 100."
+	status, body := sendInvoiceRequest(t, app, companyID, "POST", "/invoices/"+code+"/corrections", `{"text":"`+correction+`"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("correction = %d %s, want 201", status, body)
+	}
+	var event models.InvoiceEvent
+	if err := json.Unmarshal([]byte(body), &event); err != nil || event.Action != models.AcaoCorrigida || event.Reason != correction {
+		t.Errorf("correction answered %s, want the CORRIGIDA entry", body)
+	}
+
+	if status, body := sendInvoiceRequest(t, app, companyID, "PUT", "/invoices/"+code+"/cancel", ""); status != http.StatusOK {
+		t.Fatalf("cancel = %d %s, want 200", status, body)
+	}
+
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		var invoice models.Invoice
+		if err := conn.Get(&invoice, "SELECT * FROM invoices WHERE code = $1", code); err != nil {
+			return err
+		}
+		if err := checkEmailEvent(invoice, mail.EventCancelled); err != nil {
+			t.Errorf("cancelled email of the cancelled invoice refused: %v", err)
+		}
+
+		var deductID string
+		if err := conn.Get(&deductID, "SELECT id FROM outbox_messages WHERE invoice_code = $1 AND kind = $2", code, models.OutboxStockDeduct); err != nil {
+			return err
+		}
+		mu.Lock()
+		if len(restored) != 1 || restored[0] != deductID {
+			t.Errorf("restored operations %v, want [%s]", restored, deductID)
+		}
+		mu.Unlock()
+
+		var emails []string
+		if err := conn.Select(&emails, "SELECT event FROM email_deliveries WHERE invoice_code = $1", code); err != nil {
+			return err
+		}
+		sort.Strings(emails)
+		if got := strings.Join(emails, ","); got != "cancelled,corrected,issued" {
+			t.Errorf("queued emails %s, want cancelled,corrected,issued", got)
+		}
+
+		text, err := latestCorrection(conn, code)
+		if err != nil || text != correction {
+			t.Errorf("latestCorrection() = %q, %v, want %q", text, err, correction)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body := sendInvoiceRequest(t, app, companyID, "POST", "/invoices/"+code+"/emails", `{"event":"cancelled"}`); status != http.StatusAccepted {
+		t.Errorf("cancelled email = %d %s, want 202", status, body)
+	}
+	if status, body := sendInvoiceRequest(t, app, companyID, "POST", "/invoices/"+code+"/corrections", `{"text":"`+correction+`"}`); status != http.StatusConflict {
+		t.Errorf("correction of the cancelled invoice = %d %s, want 409", status, body)
+	}
+}
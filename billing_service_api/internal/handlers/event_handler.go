@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+)
+
+// eventSource names billing in the events it publishes, and eventGroup is the
+// consumer group of its subscriptions.
+const (
+	eventSource = "billing_service_api"
+	eventGroup  = "billing_service_api"
+)
+
+var (
+	eventBusMu sync.Mutex
+	eventBus   events.Bus
+)
+
+// SetEventBus makes billing publish domain events and close invoices through
+// the broker: invoice.closed replaces the stock deduction command, answered
+// by stock.deducted or stock.rejected, and invoice.cancelled the release.
+// Without a bus, stock is moved by calling the stock service.
+func SetEventBus(bus events.Bus) {
+	eventBusMu.Lock()
+	defer eventBusMu.Unlock()
+
+	eventBus = bus
+}
+
+func currentEventBus() events.Bus {
+	eventBusMu.Lock()
+	defer eventBusMu.Unlock()
+
+	return eventBus
+}
+
+// SubscribeStockEvents consumes the answers of the stock service to
+// invoice.closed.
+func SubscribeStockEvents(bus events.Bus) error {
+	if err := bus.Subscribe(events.StockDeducted, eventGroup, handleStockDeducted); err != nil {
+		return err
+	}
+	return bus.Subscribe(events.StockRejected, eventGroup, handleStockRejected)
+}
+
+type eventPayload struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// enqueueEvent writes the event to the outbox inside tx, so it is published
+// exactly when the state change it describes is committed. Without a bus
+// nothing is written and the ID is uuid.Nil.
+func enqueueEvent(tx *sqlx.Tx, code, eventType string, data interface{}) (uuid.UUID, error) {
+	if currentEventBus() == nil {
+		return uuid.Nil, nil
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return enqueueOutbox(tx, code, models.OutboxEvent, eventPayload{Type: eventType, Data: encoded})
+}
+
+// publishOutboxEvent publishes an event message. Its ID is the event ID, so a
+// redelivery after a crash is recognised by the broker and by consumers.
+func publishOutboxEvent(ctx context.Context, message models.OutboxMessage) error {
+	bus := currentEventBus()
+	if bus == nil {
+		return fmt.Errorf("no event broker configured")
+	}
+
+	var payload eventPayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		return err
+	}
+
+	occurredAt := message.CreatedAt
+	if created, err := time.Parse(time.RFC3339, message.CreatedAt); err == nil {
+		occurredAt = created.UTC().Format(time.RFC3339)
+	}
+
+	return bus.Publish(ctx, events.Event{
+		ID:         message.ID.String(),
+		Type:       payload.Type,
+		Source:     eventSource,
+		CompanyID:  message.CompanyID.String(),
+		OccurredAt: occurredAt,
+		Data:       payload.Data,
+	})
+}
+
+func invoiceEventData(invoice models.Invoice, products []models.InvoiceProduct) events.InvoiceData {
+	data := events.InvoiceData{
+		Code:       invoice.Code,
+		Status:     string(invoice.Status),
+		TotalValue: invoice.TotalValue,
+		AccessKey:  invoice.AccessKey,
+		Items:      make([]events.Item, 0, len(products)),
+	}
+	if invoice.CustomerID != nil {
+		id := invoice.CustomerID.String()
+		data.CustomerID = &id
+	}
+	if invoice.ReservationID != nil {
+		id := invoice.ReservationID.String()
+		data.ReservationID = &id
+	}
+	for _, product := range products {
+		data.Items = append(data.Items, events.Item{ProductID: product.ProductID, Quantity: product.Amount})
+	}
+	return data
+}
+
+func handleStockDeducted(ctx context.Context, event events.Event) error {
+	return settleInvoiceClose(event, func(tx *sqlx.Tx, result events.StockResultData) error {
+		return finishInvoiceClose(tx, result.InvoiceCode)
+	})
+}
+
+func handleStockRejected(ctx context.Context, event events.Event) error {
+	return settleInvoiceClose(event, func(tx *sqlx.Tx, result events.StockResultData) error {
+		return failInvoiceClose(tx, result.InvoiceCode, result.Reason)
+	})
+}
+
+// settleInvoiceClose applies the answer to the latest invoice.closed of the
+// invoice only, in the company of the event. Both steps do nothing unless the
+// invoice is FECHANDO, so a redelivered answer is harmless.
+func settleInvoiceClose(event events.Event, settle func(*sqlx.Tx, events.StockResultData) error) error {
+	var result events.StockResultData
+	if err := json.Unmarshal(event.Data, &result); err != nil {
+		log.Printf("Discarding malformed %s event %s: %v", event.Type, event.ID, err)
+		return nil
+	}
+	if event.CompanyID == "" {
+		log.Printf("Discarding %s event %s with no company", event.Type, event.ID)
+		return nil
+	}
+
+	return db.WithCompany(event.CompanyID, func(conn *db.Conn) error {
+		return settleInvoiceCloseTx(conn, event, result, settle)
+	})
+}
+
+func settleInvoiceCloseTx(conn *db.Conn, event events.Event, result events.StockResultData, settle func(*sqlx.Tx, events.StockResultData) error) error {
+	tx, err := conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var latest uuid.UUID
+	err = tx.Get(&latest, `SELECT id FROM outbox_messages
+		WHERE invoice_code = $1 AND kind = $2 AND payload->>'type' = $3
+		ORDER BY created_at DESC LIMIT 1`,
+		result.InvoiceCode, models.OutboxEvent, events.InvoiceClosed)
+	if err != nil {
+		log.Printf("Ignoring %s event %s for invoice %s: %v", event.Type, event.ID, result.InvoiceCode, err)
+		return nil
+	}
+	if latest.String() != result.EventID {
+		log.Printf("Ignoring %s event %s for an earlier close of invoice %s", event.Type, event.ID, result.InvoiceCode)
+		return nil
+	}
+
+	if err := settle(tx, result); err != nil {
+		return fmt.Errorf("error settling close of invoice %s: %v", result.InvoiceCode, err)
+	}
+	return tx.Commit()
+}
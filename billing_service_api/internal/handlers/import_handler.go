@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/importer"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+// ProgressFunc is told how many rows of the current phase have been handled.
+type ProgressFunc func(phase string, processed, total int)
+
+const (
+	ImportPhaseValidating = "VALIDANDO"
+	ImportPhaseWriting    = "GRAVANDO"
+)
+
+// ImportInvoices accepts a CSV or JSONL file, either as the multipart field
+// "file" or as the raw request body, and starts an import job in the
+// background. Query parameters: mode (dry-run, all-or-nothing, partial) and
+// format (csv, jsonl), which is otherwise detected from the file.
+func ImportInvoices(c *fiber.Ctx) error {
+	mode, err := importer.ParseMode(c.Query("mode"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var data []byte
+	filename := ""
+	if file, err := c.FormFile("file"); err == nil {
+		filename = file.Filename
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Error reading uploaded file"})
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(f); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Error reading uploaded file"})
+		}
+	} else {
+		data = append([]byte(nil), c.Body()...)
+	}
+
+	if len(data) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Import file is empty"})
+	}
+
+	format, err := importer.DetectFormat(c.Query("format"), filename, c.Get(fiber.HeaderContentType))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	job := models.ImportJob{
+		ID:        uuid.New(),
+		Status:    models.ImportPendente,
+		Mode:      string(mode),
+		Format:    string(format),
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+
+	_, err = tenant.DB(c).Exec(`INSERT INTO import_jobs (id, status, mode, format, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		job.ID, job.Status, job.Mode, job.Format, job.CreatedAt)
+	if err != nil {
+		log.Printf("Error creating import job: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error creating import job"})
+	}
+
+	go runImportJob(tenant.CompanyID(c), job.ID, data, format, mode)
+
+	c.Set(fiber.HeaderLocation, "/invoices/import/"+job.ID.String())
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+func GetImportJob(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Import job not found"})
+	}
+
+	var job models.ImportJob
+	if err := tenant.DB(c).Get(&job, "SELECT * FROM import_jobs WHERE id = $1", id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Import job not found"})
+	}
+
+	return c.JSON(job)
+}
+
+// runImportJob imports the file for companyID. The job row is updated through
+// db.System, outside the import transactions, so its progress shows while they
+// are still open.
+func runImportJob(companyID string, id uuid.UUID, data []byte, format importer.Format, mode importer.Mode) {
+	db.System.Exec("UPDATE import_jobs SET status = $1 WHERE id = $2", models.ImportProcessando, id)
+
+	lastUpdate := time.Time{}
+	progress := func(phase string, processed, total int) {
+		if processed > 0 && processed < total && time.Since(lastUpdate) < time.Second {
+			return
+		}
+		lastUpdate = time.Now()
+		_, err := db.System.Exec("UPDATE import_jobs SET phase = $1, processed_rows = $2, total_rows = $3 WHERE id = $4", phase, processed, total, id)
+		if err != nil {
+			log.Printf("Error updating import job %s progress: %v", id, err)
+		}
+	}
+
+	status := models.ImportConcluida
+	var report *importer.Report
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		var err error
+		report, err = RunImport(jobContext(conn), conn, data, format, mode, progress)
+		return err
+	})
+	if err != nil {
+		log.Printf("Import job %s failed: %v", id, err)
+		status = models.ImportFalhou
+		report = &importer.Report{Mode: mode, Errors: []importer.RowError{{Message: err.Error()}}}
+	}
+
+	encoded, _ := json.Marshal(report)
+	_, err = db.System.Exec(`UPDATE import_jobs SET status = $1, total_rows = $2, processed_rows = $2, invoices_created = $3,
+		error_count = $4, report = $5, finished_at = $6 WHERE id = $7`,
+		status, report.TotalRows, report.InvoicesCreated, len(report.Errors), encoded, time.Now().Format(time.RFC3339), id)
+	if err != nil {
+		log.Printf("Error saving import job %s report: %v", id, err)
+	}
+}
+
+// RunImport validates the whole file and then writes invoices according to
+// mode. Every product is checked against the stock service once, however many
+// rows reference it. It backs both the HTTP job and the CLI import command,
+// and writes the invoices for the company of conn.
+func RunImport(ctx context.Context, conn db.Scope, data []byte, format importer.Format, mode importer.Mode, progress ProgressFunc) (*importer.Report, error) {
+	if progress == nil {
+		progress = func(string, int, int) {}
+	}
+
+	rows, errs, err := importer.Parse(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, groupErrs := importer.GroupRows(rows)
+	errs = append(errs, groupErrs...)
+
+	report := &importer.Report{Mode: mode, TotalRows: len(rows) + len(errs)}
+	total := report.TotalRows
+
+	errs = append(errs, checkImportReferences(ctx, conn, groups, func(processed int) {
+		progress(ImportPhaseValidating, processed, total)
+	})...)
+	report.Errors = errs
+
+	invalid := make(map[string]bool)
+	for _, e := range errs {
+		invalid[e.InvoiceRef] = true
+	}
+
+	processed := 0
+	for _, group := range groups {
+		status := importer.InvoiceValida
+		if invalid[group.Ref] {
+			status = importer.InvoiceRejeitada
+		}
+		report.Invoices = append(report.Invoices, importer.InvoiceResult{InvoiceRef: group.Ref, Status: status, Lines: len(group.Rows)})
+	}
+
+	switch {
+	case mode == importer.ModeDryRun:
+		progress(ImportPhaseWriting, total, total)
+		return report, nil
+
+	case mode == importer.ModeAllOrNothing && len(errs) > 0:
+		for i := range report.Invoices {
+			if report.Invoices[i].Status == importer.InvoiceValida {
+				report.Invoices[i].Status = importer.InvoiceNaoGravada
+			}
+		}
+		progress(ImportPhaseWriting, total, total)
+		return report, nil
+
+	case mode == importer.ModeAllOrNothing:
+		tx, err := conn.Beginx()
+		if err != nil {
+			return nil, err
+		}
+		// Every invoice is discarded when one fails, so the stock reserved for
+		// those written so far is released.
+		var inserted []*models.Invoice
+		for i, group := range groups {
+			invoice, err := insertImportedInvoice(ctx, tx, group)
+			if err != nil {
+				tx.Rollback()
+				releaseReservations(ctx, inserted...)
+				for j := range report.Invoices {
+					report.Invoices[j].Status = importer.InvoiceNaoGravada
+					report.Invoices[j].InvoiceCode = ""
+				}
+				report.Invoices[i].Status = importer.InvoiceRejeitada
+				report.Errors = append(report.Errors, importer.RowError{Line: group.Rows[0].Line, InvoiceRef: group.Ref, Message: err.Error()})
+				progress(ImportPhaseWriting, total, total)
+				return report, nil
+			}
+			inserted = append(inserted, invoice)
+			report.Invoices[i].InvoiceCode = invoice.Code
+			processed += len(group.Rows)
+			progress(ImportPhaseWriting, processed, total)
+		}
+		if err := tx.Commit(); err != nil {
+			releaseReservations(ctx, inserted...)
+			return nil, fmt.Errorf("error committing import: %v", err)
+		}
+		for i := range report.Invoices {
+			report.Invoices[i].Status = importer.InvoiceCriada
+		}
+		report.InvoicesCreated = len(groups)
+
+	case mode == importer.ModePartial:
+		for i, group := range groups {
+			processed += len(group.Rows)
+			if report.Invoices[i].Status == importer.InvoiceRejeitada {
+				progress(ImportPhaseWriting, processed, total)
+				continue
+			}
+
+			tx, err := conn.Beginx()
+			if err != nil {
+				return nil, err
+			}
+			invoice, err := insertImportedInvoice(ctx, tx, group)
+			if err == nil {
+				if err = tx.Commit(); err != nil {
+					releaseReservations(ctx, invoice)
+				}
+			} else {
+				tx.Rollback()
+			}
+			if err != nil {
+				report.Invoices[i].Status = importer.InvoiceRejeitada
+				report.Errors = append(report.Errors, importer.RowError{Line: group.Rows[0].Line, InvoiceRef: group.Ref, Message: err.Error()})
+			} else {
+				report.Invoices[i].Status = importer.InvoiceCriada
+				report.Invoices[i].InvoiceCode = invoice.Code
+				report.InvoicesCreated++
+			}
+			progress(ImportPhaseWriting, processed, total)
+		}
+	}
+
+	progress(ImportPhaseWriting, total, total)
+	return report, nil
+}
+
+// checkImportReferences reports rows whose product is unknown to the stock
+// service or whose customer is not registered.
+func checkImportReferences(ctx context.Context, q db.Querier, groups []importer.Group, progress func(processed int)) []importer.RowError {
+	var errs []importer.RowError
+	processed := 0
+
+	// One lookup covers every product in the file.
+	var productIDs []string
+	for _, group := range groups {
+		for _, row := range group.Rows {
+			if row.ProductID != "" {
+				productIDs = append(productIDs, row.ProductID)
+			}
+		}
+	}
+	products := make(map[string]error)
+	_, missing, lookupErr := stockAPI().LookupProducts(ctx, productIDs)
+	if lookupErr != nil {
+		for _, id := range productIDs {
+			products[id] = lookupErr
+		}
+	}
+	for _, id := range missing {
+		products[id] = errors.New("product not found")
+	}
+	customers := make(map[uuid.UUID]bool)
+
+	for _, group := range groups {
+		if group.CustomerID != nil {
+			exists, checked := customers[*group.CustomerID]
+			if !checked {
+				exists, _ = customerExists(q, *group.CustomerID)
+				customers[*group.CustomerID] = exists
+			}
+			if !exists {
+				errs = append(errs, importer.RowError{Line: group.Rows[0].Line, InvoiceRef: group.Ref, Field: "customer_id", Message: "customer not found"})
+			}
+		}
+
+		for _, row := range group.Rows {
+			if row.ProductID == "" {
+				continue
+			}
+			if lookupErr := products[row.ProductID]; lookupErr != nil {
+				errs = append(errs, importer.RowError{Line: row.Line, InvoiceRef: row.InvoiceRef, Field: "product_id", Message: lookupErr.Error()})
+			}
+			processed++
+			progress(processed)
+		}
+	}
+
+	return errs
+}
+
+func insertImportedInvoice(ctx context.Context, tx *sqlx.Tx, group importer.Group) (*models.Invoice, error) {
+	products := make([]models.InvoiceProduct, 0, len(group.Rows))
+	for _, row := range group.Rows {
+		products = append(products, models.InvoiceProduct{ProductID: row.ProductID, Amount: row.Amount})
+	}
+	return insertInvoice(ctx, tx, ActorImport, group.CustomerID, nil, products)
+}
@@ -1,27 +1,29 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
-	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/lucasbpereira/billing_service_api/internal/fiscal"
+	"github.com/lucasbpereira/billing_service_api/internal/mail"
 	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/scheduler"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient"
 )
 
-type StockUpdateRequest struct {
-	ProductID string `json:"product_id"`
-	Quantity  int    `json:"quantity"`
-}
-
 type StockUpdateResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
@@ -33,30 +35,26 @@ type ErrorResponse struct {
 	Value       string `json:"value"`
 }
 
-type Product struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price"`
-	Balance     int       `json:"balance"`
+// validationErrorResponse answers with the field errors of a failed
+// validator.Struct call, falling back to a generic 500.
+func validationErrorResponse(c *fiber.Ctx, err error) error {
+	if validationErrors, ok := err.(validator.ValidationErrors); ok {
+		var errors []ErrorResponse
+		for _, err := range validationErrors {
+			var el ErrorResponse
+			el.FailedField = err.StructNamespace()
+			el.Tag = err.Tag()
+			el.Value = err.Param()
+			errors = append(errors, el)
+		}
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(errors)
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Validation failed"})
 }
 
 type CreateInvoiceRequest struct {
-	Products []models.InvoiceProduct `json:"products" validate:"required,min=1,dive"`
-}
-
-type APIClient struct {
-	baseURL    string
-	httpClient *http.Client
-}
-
-func NewAPIClient(baseURL string) *APIClient {
-	return &APIClient{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-	}
+	CustomerID *uuid.UUID              `json:"customer_id"`
+	Products   []models.InvoiceProduct `json:"products" validate:"required,min=1,dive"`
 }
 
 func CreateInvoice(c *fiber.Ctx) error {
@@ -71,21 +69,99 @@ func CreateInvoice(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "At least one product is required"})
 	}
 
-	code, err := generateInvoiceCode()
+	tx, err := tenant.DB(c).Beginx()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+
+	invoice, err := insertInvoice(requestContext(c), tx, requestActor(c), request.CustomerID, nil, request.Products)
+	if err != nil {
+		tx.Rollback()
+		return invoiceErrorResponse(c, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		releaseReservations(requestContext(c), invoice)
+		return c.Status(500).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+
+	setInvoiceETag(c, *invoice)
+	return c.Status(fiber.StatusCreated).JSON(invoice)
+}
+
+// invoiceError carries the HTTP status and message insertInvoice wants the
+// caller to answer with, so every entry point reports failures the same way.
+type invoiceError struct {
+	Status  int
+	Message string
+	Details interface{}
+}
+
+func (e *invoiceError) Error() string {
+	return e.Message
+}
+
+func invoiceErrorResponse(c *fiber.Ctx, err error) error {
+	ie, ok := err.(*invoiceError)
+	if !ok {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	if ie.Details == nil {
+		return c.Status(ie.Status).JSON(fiber.Map{"error": ie.Message})
+	}
+	if errs, ok := ie.Details.([]ErrorResponse); ok {
+		return c.Status(ie.Status).JSON(errs)
+	}
+	return c.Status(ie.Status).JSON(fiber.Map{"error": ie.Message, "details": ie.Details})
+}
+
+// insertInvoice prices the products, generates the invoice code and inserts an
+// ABERTO invoice with its lines inside tx, reserving their stock and recording
+// the creation by actor in the audit log. It is the single creation path used
+// by CreateInvoice and by sales order conversion. The company of tx issues the
+// invoice and numbers it. The reservation is released when insertInvoice
+// fails after making it; callers release it with releaseReservations when tx
+// does not commit.
+func insertInvoice(ctx context.Context, tx *sqlx.Tx, actor string, customerID *uuid.UUID, orderID *uuid.UUID, products []models.InvoiceProduct) (_ *models.Invoice, err error) {
+	company, err := currentCompany(tx)
+	if err != nil {
+		log.Printf("Error loading company: %v", err)
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error loading company"}
+	}
+
+	code, err := generateInvoiceCode(tx)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating invoice code"})
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error generating invoice code"}
 	}
 
-	totalValue, err := calculateInvoiceTotalValue(request.Products)
+	totalValue, err := calculateInvoiceTotalValue(ctx, products)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error calculating invoice total value"})
+		var unknown *UnknownProductsError
+		if errors.As(err, &unknown) {
+			return nil, &invoiceError{Status: fiber.StatusUnprocessableEntity, Message: "Unknown products", Details: unknown.IDs}
+		}
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error calculating invoice total value"}
+	}
+
+	icmsValue, err := applyInvoiceTaxes(tx, company.UF, customerID, products)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &invoiceError{Status: fiber.StatusUnprocessableEntity, Message: "Unknown customer"}
+	}
+	if err != nil {
+		log.Printf("Error calculating invoice taxes: %v", err)
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error calculating invoice taxes"}
 	}
 
 	invoice := models.Invoice{
 		ID:         uuid.New(),
 		Code:       code,
+		Series:     company.Series,
 		Status:     models.StatusAberto,
 		TotalValue: totalValue,
+		ICMSValue:  icmsValue,
+		CustomerID: customerID,
+		OrderID:    orderID,
+		Version:    1,
 		CreatedAt:  time.Now().Format(time.RFC3339),
 		UpdatedAt:  time.Now().Format(time.RFC3339),
 	}
@@ -101,37 +177,34 @@ func CreateInvoice(c *fiber.Ctx) error {
 				el.Value = err.Param()
 				errors = append(errors, el)
 			}
-			return c.Status(fiber.StatusUnprocessableEntity).JSON(errors)
+			return nil, &invoiceError{Status: fiber.StatusUnprocessableEntity, Message: "Validation failed", Details: errors}
 		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Validation failed"})
-	}
-
-	tx, err := db.DB.Beginx()
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Validation failed"}
 	}
 
-	query := `INSERT INTO invoices (id, code, status, total_value, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)`
-	_, err = tx.Exec(query, invoice.ID, invoice.Code, invoice.Status, invoice.TotalValue, invoice.CreatedAt, invoice.UpdatedAt)
+	query := `INSERT INTO invoices (id, code, series, status, total_value, icms_value, customer_id, order_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING number`
+	err = tx.QueryRow(query, invoice.ID, invoice.Code, invoice.Series, invoice.Status, invoice.TotalValue, invoice.ICMSValue,
+		invoice.CustomerID, invoice.OrderID, invoice.CreatedAt, invoice.UpdatedAt).Scan(&invoice.Number)
 	if err != nil {
-		tx.Rollback()
 		log.Printf("Error inserting invoice: %v", err)
-		return c.Status(500).JSON(fiber.Map{"error": "Error creating invoice", "details": err.Error()})
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error creating invoice", Details: err.Error()}
 	}
 
 	var invoiceProducts []models.InvoiceProduct
-	productQuery := `INSERT INTO invoice_products (invoice_code, product_id, amount, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
+	productQuery := `INSERT INTO invoice_products (invoice_code, product_id, amount, unit_price, total_value, cfop, cst_icms, icms_base, icms_rate, icms_value, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`
 
-	for _, product := range request.Products {
+	for _, product := range products {
 		product.InvoiceCode = invoice.Code
 		product.CreatedAt = time.Now().Format(time.RFC3339)
 
 		var productID uuid.UUID
-		err = tx.QueryRow(productQuery, product.InvoiceCode, product.ProductID, product.Amount, product.CreatedAt).Scan(&productID)
+		err = tx.QueryRow(productQuery, product.InvoiceCode, product.ProductID, product.Amount, product.UnitPrice, product.TotalValue,
+			product.CFOP, product.CSTICMS, product.ICMSBase, product.ICMSRate, product.ICMSValue, product.CreatedAt).Scan(&productID)
 		if err != nil {
-			tx.Rollback()
 			log.Printf("Error inserting invoice product: %v", err)
-			return c.Status(500).JSON(fiber.Map{"error": "Error creating invoice products", "details": err.Error()})
+			return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error creating invoice products", Details: err.Error()}
 		}
 
 		product.ID = productID
@@ -140,244 +213,563 @@ func CreateInvoice(c *fiber.Ctx) error {
 
 	invoice.Products = invoiceProducts
 
-	if err := tx.Commit(); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Error committing transaction"})
+	reservation, err := stockAPI().CreateReservation(ctx, stockclient.CreateReservationRequest{
+		Reference:  invoice.Code,
+		TTLSeconds: int(scheduler.IntervalFromEnv("STOCK_RESERVATION_TTL", 72*time.Hour).Seconds()),
+		Items:      stockItems(invoiceProducts),
+	})
+	if err != nil {
+		if errors.Is(err, stockclient.ErrInsufficientStock) {
+			return nil, &invoiceError{Status: fiber.StatusUnprocessableEntity, Message: "Insufficient stock for invoice", Details: err.Error()}
+		}
+		if isPermanent(err) {
+			return nil, &invoiceError{Status: fiber.StatusUnprocessableEntity, Message: "Stock reservation refused", Details: err.Error()}
+		}
+		return nil, &invoiceError{Status: fiber.StatusBadGateway, Message: "Error reserving stock", Details: err.Error()}
 	}
+	invoice.ReservationID = &reservation.ID
+	defer func() {
+		if err != nil {
+			releaseReservations(ctx, &invoice)
+		}
+	}()
 
-	return c.Status(fiber.StatusCreated).JSON(invoice)
+	if _, err := tx.Exec("UPDATE invoices SET reservation_id = $1 WHERE id = $2", invoice.ReservationID, invoice.ID); err != nil {
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error creating invoice", Details: err.Error()}
+	}
+
+	if err := recordInvoiceEvent(tx, invoice.Code, actor, models.AcaoCriada, "", nil, invoice); err != nil {
+		log.Printf("Error auditing invoice creation: %v", err)
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error recording invoice history"}
+	}
+
+	if _, err := enqueueEvent(tx, invoice.Code, events.InvoiceCreated, invoiceEventData(invoice, invoiceProducts)); err != nil {
+		log.Printf("Error queueing invoice.created event: %v", err)
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error queueing invoice event"}
+	}
+	if err := notifyInvoiceChange(tx, events.InvoiceCreated, invoiceEventData(invoice, invoiceProducts)); err != nil {
+		log.Printf("Error queueing invoice.created notifications: %v", err)
+		return nil, &invoiceError{Status: fiber.StatusInternalServerError, Message: "Error queueing invoice notifications"}
+	}
+
+	return &invoice, nil
+}
+
+// releaseReservations releases the stock reservations of invoices whose
+// transaction did not commit, so they don't hold stock until they expire.
+// A failed release is only logged: the stock service still releases the
+// reservation when it expires.
+func releaseReservations(ctx context.Context, invoices ...*models.Invoice) {
+	for _, invoice := range invoices {
+		if invoice == nil || invoice.ReservationID == nil {
+			continue
+		}
+		if err := stockAPI().ReleaseReservation(ctx, *invoice.ReservationID); err != nil {
+			log.Printf("Error releasing reservation %s of uncommitted invoice %s: %v", invoice.ReservationID, invoice.Code, err)
+		}
+	}
 }
 
 func GetOpenInvoices(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
 	var invoices []models.Invoice
 
-	err := db.DB.Select(&invoices, "SELECT * FROM invoices WHERE status = 'ABERTO' ORDER BY created_at DESC")
+	err := conn.Select(&invoices, "SELECT * FROM invoices WHERE status NOT IN ($1, $2) ORDER BY created_at DESC",
+		models.StatusFechado, models.StatusCancelada)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Error getting open invoices"})
 	}
 
-	for i := range invoices {
-		var invoiceProducts []models.InvoiceProduct
+	if err := loadInvoiceProducts(conn, invoices); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting invoice products"})
+	}
 
-		err = db.DB.Select(&invoiceProducts, "SELECT * FROM invoice_products WHERE invoice_code = $1", invoices[i].Code)
-		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": "Error getting invoice products"})
-		}
+	return c.JSON(invoices)
+}
 
-		invoices[i].Products = invoiceProducts
+// loadInvoiceProducts fills the lines of every invoice with a single query
+// over their codes, so reading a page of invoices costs two queries in total.
+func loadInvoiceProducts(q sqlx.Queryer, invoices []models.Invoice) error {
+	if len(invoices) == 0 {
+		return nil
 	}
 
-	return c.JSON(invoices)
+	codes := make([]string, 0, len(invoices))
+	for _, invoice := range invoices {
+		codes = append(codes, invoice.Code)
+	}
+
+	var lines []models.InvoiceProduct
+	err := sqlx.Select(q, &lines, "SELECT * FROM invoice_products WHERE invoice_code = ANY($1) ORDER BY created_at, id", pq.Array(codes))
+	if err != nil {
+		return err
+	}
+
+	linesByInvoice := make(map[string][]models.InvoiceProduct, len(invoices))
+	for _, line := range lines {
+		linesByInvoice[line.InvoiceCode] = append(linesByInvoice[line.InvoiceCode], line)
+	}
+	for i := range invoices {
+		invoices[i].Products = linesByInvoice[invoices[i].Code]
+	}
+
+	return nil
 }
 
+// UpdateInvoiceStatus starts closing an invoice. In one transaction the
+// invoice moves to FECHANDO and the stock deduction is written to the outbox;
+// the relay then finishes the close or fails it with FALHA_ESTOQUE. Delivery is
+// tried once right away, so the answer is 200 when stock is reachable and 202
+// while the close is still pending.
 func UpdateInvoiceStatus(c *fiber.Ctx) error {
 	code := c.Params("code")
 	if code == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invoice code is required"})
 	}
 
+	conn := tenant.DB(c)
+	tx, err := conn.Beginx()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+	defer tx.Rollback()
+
 	var invoice models.Invoice
-	err := db.DB.Get(&invoice, "SELECT * FROM invoices WHERE code = $1", code)
+	err = tx.Get(&invoice, "SELECT * FROM invoices WHERE code = $1", code)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invoice not found"})
 	}
 
-	if invoice.Status == models.StatusFechado {
+	if !matchesIfMatch(c, invoice.Version) {
+		return preconditionFailed(c, invoice)
+	}
+
+	switch invoice.Status {
+	case models.StatusFechado:
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invoice is already closed"})
+	case models.StatusFechando:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Invoice is already being closed"})
+	case models.StatusCancelada:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Invoice is cancelled"})
 	}
 
-	var invoiceProducts []models.InvoiceProduct
-	err = db.DB.Select(&invoiceProducts, "SELECT * FROM invoice_products WHERE invoice_code = $1", code)
-	if err != nil {
+	invoices := []models.Invoice{invoice}
+	if err := loadInvoiceProducts(tx, invoices); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching invoice products"})
 	}
+	invoiceProducts := invoices[0].Products
+
+	closing := invoice
+	closing.Status = models.StatusFechando
+	closing.UpdatedAt = time.Now().Format(time.RFC3339)
+	closing.Version = invoice.Version + 1
 
-	query := `UPDATE invoices SET status = $1, updated_at = $2 WHERE code = $3`
-	_, err = db.DB.Exec(query, models.StatusFechado, time.Now().Format(time.RFC3339), code)
+	// Only the request that still sees the version it read wins; a concurrent
+	// close makes this update match no row, so stock is deducted once.
+	query := `UPDATE invoices SET status = $1, updated_at = $2, version = version + 1
+		WHERE code = $3 AND status IN ($4, $5) AND version = $6`
+	result, err := tx.Exec(query, closing.Status, closing.UpdatedAt, code, models.StatusAberto, models.StatusFalhaEstoque, invoice.Version)
 	if err != nil {
 		log.Printf("Error updating invoice status: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error updating invoice status"})
 	}
-
-	apiClient := NewAPIClient("http://stock_service_api:3000")
-	err = apiClient.UpdateStockProducts(invoiceProducts, "/products/balance-update")
-	if err != nil {
-		_, rollbackErr := db.DB.Exec("UPDATE invoices SET status = $1, updated_at = $2 WHERE code = $3",
-			models.StatusAberto, time.Now().Format(time.RFC3339), code)
-		if rollbackErr != nil {
-			log.Printf("Error rolling back invoice status: %v", rollbackErr)
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		tx.Rollback()
+		return invoiceConflictResponse(c, code)
+	}
+
+	// Over the broker the stock service deducts on invoice.closed and answers
+	// with an event; otherwise the relay calls it with a deduction command.
+	var messageID uuid.UUID
+	if currentEventBus() != nil {
+		messageID, err = enqueueEvent(tx, code, events.InvoiceClosed, invoiceEventData(closing, invoiceProducts))
+	} else {
+		payload := stockDeductPayload{ReservationID: invoice.ReservationID}
+		for _, product := range invoiceProducts {
+			payload.Items = append(payload.Items, stockclient.Item{ProductID: product.ProductID, Quantity: product.Amount})
 		}
+		messageID, err = enqueueOutbox(tx, code, models.OutboxStockDeduct, payload)
+	}
+	if err != nil {
+		log.Printf("Error queueing stock deduction: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error queueing stock update"})
+	}
 
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error":   "Error updating stock, invoice reopened",
-			"details": err.Error(),
-		})
+	if err := recordInvoiceEvent(tx, code, requestActor(c), models.AcaoFechamentoSolicitado, "", invoice, closing); err != nil {
+		log.Printf("Error auditing invoice close: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording invoice history"})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+
+	if _, err := relayOutboxMessage(requestContext(c), conn, time.Now(), &messageID); err != nil {
+		log.Printf("Error relaying stock deduction for invoice %s: %v", code, err)
 	}
 
 	var updatedInvoice models.Invoice
-	err = db.DB.Get(&updatedInvoice, "SELECT * FROM invoices WHERE code = $1", code)
+	err = conn.Get(&updatedInvoice, "SELECT * FROM invoices WHERE code = $1", code)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching updated invoice"})
 	}
 
 	updatedInvoice.Products = invoiceProducts
+	setInvoiceETag(c, updatedInvoice)
 
-	return c.JSON(fiber.Map{
-		"message": "Invoice successfully closed and stock updated",
+	switch updatedInvoice.Status {
+	case models.StatusFechado:
+		return c.JSON(fiber.Map{
+			"message": "Invoice successfully closed and stock updated",
+			"invoice": updatedInvoice,
+		})
+	case models.StatusFalhaEstoque:
+		var lastError *string
+		conn.Get(&lastError, "SELECT last_error FROM outbox_messages WHERE id = $1", messageID)
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":   "Error updating stock, invoice not closed",
+			"details": lastError,
+			"invoice": updatedInvoice,
+		})
+	}
+
+	c.Set(fiber.HeaderLocation, "/invoices/"+code)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Invoice is being closed, stock update pending",
 		"invoice": updatedInvoice,
 	})
 }
 
-func generateInvoiceCode() (string, error) {
-	currentDate := time.Now().Format("20060102")
+// CancelInvoice cancels an open or a closed invoice, through the outbox in the
+// same transaction: the stock reservation of an open invoice is released, the
+// stock deducted by a closed one is restored and its customer is emailed.
+// Invoices being closed cannot be cancelled until the stock service answers.
+func CancelInvoice(c *fiber.Ctx) error {
+	code := c.Params("code")
 
-	var count int
-	query := `SELECT COUNT(*) FROM invoices WHERE code LIKE $1 || '%'`
-	err := db.DB.QueryRow(query, currentDate).Scan(&count)
+	conn := tenant.DB(c)
+	tx, err := conn.Beginx()
 	if err != nil {
-		return "", err
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
 	}
+	defer tx.Rollback()
 
-	nextNumber := count + 1
+	var invoice models.Invoice
+	if err := tx.Get(&invoice, "SELECT * FROM invoices WHERE code = $1", code); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invoice not found"})
+	}
 
-	log.Println(fmt.Sprintf("%s%d", currentDate, nextNumber))
-	return fmt.Sprintf("%s%d", currentDate, nextNumber), nil
-}
+	if !matchesIfMatch(c, invoice.Version) {
+		return preconditionFailed(c, invoice)
+	}
 
-func calculateInvoiceTotalValue(invoiceProducts []models.InvoiceProduct) (float64, error) {
-	totalValue := 0.0
-	apiClient := NewAPIClient("http://stock_service_api:3000")
+	switch invoice.Status {
+	case models.StatusCancelada:
+		setInvoiceETag(c, invoice)
+		return c.JSON(invoice)
+	case models.StatusFechando:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Invoices being closed cannot be cancelled"})
+	}
 
-	log.Printf("Calculating total value for %d products", len(invoiceProducts))
+	cancelled := invoice
+	cancelled.Status = models.StatusCancelada
+	cancelled.UpdatedAt = time.Now().Format(time.RFC3339)
+	cancelled.Version = invoice.Version + 1
 
-	for i, invoiceProduct := range invoiceProducts {
-		// DEBUG: Log para verificar o ProductID
-		log.Printf("Processing product %d: ProductID=%+v, Type=%T", i, invoiceProduct.ProductID, invoiceProduct.ProductID)
+	result, err := tx.Exec(`UPDATE invoices SET status = $1, updated_at = $2, version = version + 1
+		WHERE code = $3 AND status IN ($4, $5, $6) AND version = $7`,
+		cancelled.Status, cancelled.UpdatedAt, code, models.StatusAberto, models.StatusFalhaEstoque, models.StatusFechado, invoice.Version)
+	if err != nil {
+		log.Printf("Error cancelling invoice: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error cancelling invoice"})
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		tx.Rollback()
+		return invoiceConflictResponse(c, code)
+	}
+
+	// Over the broker the stock service releases the reservation on
+	// invoice.cancelled.
+	invoices := []models.Invoice{invoice}
+	if err := loadInvoiceProducts(tx, invoices); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching invoice products"})
+	}
+	cancelledProducts := invoices[0].Products
 
-		// Buscar produto na API
-		product, err := apiClient.GetProduct(invoiceProduct.ProductID)
+	var messageIDs []uuid.UUID
+	switch {
+	case invoice.Status == models.StatusFechado:
+		// The stock service restores the operation that closed the invoice,
+		// over either transport, and answers a repeated restore as done.
+		key, err := closingOperationKey(tx, code)
+		if err != nil {
+			log.Printf("Error finding the stock operation of invoice %s: %v", code, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error queueing stock restore"})
+		}
+		id, err := enqueueOutbox(tx, code, models.OutboxStockRestore, stockRestorePayload{OperationKey: key.String()})
+		if err != nil {
+			log.Printf("Error queueing stock restore: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error queueing stock restore"})
+		}
+		messageIDs = append(messageIDs, id)
+	case currentEventBus() != nil:
+		id, err := enqueueEvent(tx, code, events.InvoiceCancelled, invoiceEventData(cancelled, cancelledProducts))
+		if err != nil {
+			log.Printf("Error queueing invoice.cancelled event: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error queueing stock release"})
+		}
+		messageIDs = append(messageIDs, id)
+	case invoice.ReservationID != nil:
+		id, err := enqueueOutbox(tx, code, models.OutboxStockRelease, stockReleasePayload{ReservationID: *invoice.ReservationID})
 		if err != nil {
-			log.Printf("Error getting product %s from API: %v", invoiceProduct.ProductID, err)
-			return 0, fmt.Errorf("error getting product %s: %v", invoiceProduct.ProductID, err)
+			log.Printf("Error queueing reservation release: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error queueing stock release"})
 		}
-		log.Printf("Product retrieved: Name=%s, Price=%.2f", product.Name, product.Price)
+		messageIDs = append(messageIDs, id)
+	}
 
-		// Calcular subtotal
-		subtotal := float64(invoiceProduct.Amount) * product.Price
-		totalValue += subtotal
+	if err := recordInvoiceEvent(tx, code, requestActor(c), models.AcaoCancelada, "", invoice, cancelled); err != nil {
+		log.Printf("Error auditing invoice cancel: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording invoice history"})
+	}
+
+	if err := notifyInvoiceChange(tx, events.InvoiceCancelled, invoiceEventData(cancelled, cancelledProducts)); err != nil {
+		log.Printf("Error queueing invoice.cancelled notifications: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error queueing invoice notifications"})
+	}
+
+	if invoice.Status == models.StatusFechado {
+		if _, err := enqueueInvoiceEmail(tx, code, mail.EventCancelled, nil); err != nil {
+			log.Printf("Invoice %s cancelled without queueing the customer email: %v", code, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
 
-		log.Printf("Product %d: Amount=%d, Price=%.2f, Subtotal=%.2f", i, invoiceProduct.Amount, product.Price, subtotal)
+	for _, id := range messageIDs {
+		if _, err := relayOutboxMessage(requestContext(c), conn, time.Now(), &id); err != nil {
+			log.Printf("Error relaying stock update for cancelled invoice %s: %v", code, err)
+		}
 	}
 
-	log.Printf("Total invoice value: %.2f", totalValue)
-	return totalValue, nil
+	setInvoiceETag(c, cancelled)
+	return c.JSON(cancelled)
 }
 
-func (c *APIClient) UpdateStockProducts(products []models.InvoiceProduct, endpoint string) error {
-	var stockUpdates []StockUpdateRequest
-	for _, product := range products {
-		stockUpdates = append(stockUpdates, StockUpdateRequest{
-			ProductID: product.ProductID,
-			Quantity:  product.Amount,
-		})
+// CorrectInvoiceRequest is a correction letter (CC-e): a text fixing details
+// of an issued NF-e that neither change its values nor its parties.
+type CorrectInvoiceRequest struct {
+	Text string `json:"text" validate:"required,min=15,max=1000"`
+}
+
+// CorrectInvoice records a correction letter for a closed invoice in its audit
+// log and queues the corrected email to its customer in the same transaction.
+// A new letter replaces the earlier ones, so the email carries the latest.
+func CorrectInvoice(c *fiber.Ctx) error {
+	code := c.Params("code")
+
+	request := new(CorrectInvoiceRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid correction data", "details": err.Error()})
 	}
+	request.Text = strings.TrimSpace(request.Text)
 
-	jsonData, err := json.Marshal(stockUpdates)
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	tx, err := tenant.DB(c).Beginx()
 	if err != nil {
-		return fmt.Errorf("error marshaling stock data: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
 	}
+	defer tx.Rollback()
 
-	stockServiceURL := os.Getenv("STOCK_SERVICE_URL")
-	if stockServiceURL == "" {
-		stockServiceURL = "http://stock_service_api:3000"
+	var invoice models.Invoice
+	if err := tx.Get(&invoice, "SELECT * FROM invoices WHERE code = $1 FOR UPDATE", code); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invoice not found"})
+	}
+	if err := checkEmailEvent(invoice, mail.EventCorrected); err != nil {
+		return invoiceErrorResponse(c, err)
 	}
-	url := stockServiceURL + endpoint
 
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
+	if err := recordInvoiceEvent(tx, code, requestActor(c), models.AcaoCorrigida, request.Text, invoice, invoice); err != nil {
+		log.Printf("Error auditing invoice correction: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording invoice history"})
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	var event models.InvoiceEvent
+	if err := tx.Get(&event, "SELECT * FROM invoice_events WHERE invoice_code = $1 ORDER BY id DESC LIMIT 1", code); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording invoice history"})
+	}
 
-	// Usa o client do APIClient em vez de criar um novo
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("error calling stock service: %v", err)
+	if _, err := enqueueInvoiceEmail(tx, code, mail.EventCorrected, nil); err != nil {
+		log.Printf("Invoice %s corrected without queueing the customer email: %v", code, err)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading response body: %v", err)
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error committing transaction"})
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		var errorResp struct {
-			Error string `json:"error"`
-		}
-		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error != "" {
-			return fmt.Errorf("stock service returned error: %s - %s", resp.Status, errorResp.Error)
-		}
-		return fmt.Errorf("stock service returned error: %s - %s", resp.Status, string(body))
+	return c.Status(fiber.StatusCreated).JSON(event)
+}
+
+// GetInvoiceByCode returns an invoice with its lines and its version as ETag.
+// If-None-Match with the current ETag answers 304.
+func GetInvoiceByCode(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	var invoice models.Invoice
+	if err := conn.Get(&invoice, "SELECT * FROM invoices WHERE code = $1", c.Params("code")); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invoice not found"})
 	}
 
-	var response struct {
-		Success bool   `json:"success"`
-		Message string `json:"message"`
+	setInvoiceETag(c, invoice)
+	if c.Get(fiber.HeaderIfNoneMatch) == invoiceETag(invoice) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	invoices := []models.Invoice{invoice}
+	if err := loadInvoiceProducts(conn, invoices); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error fetching invoice products"})
 	}
 
-	if err := json.Unmarshal(body, &response); err != nil {
-		return fmt.Errorf("error decoding stock service response: %v", err)
+	return c.JSON(invoices[0])
+}
+
+func invoiceETag(invoice models.Invoice) string {
+	return fmt.Sprintf(`"%d"`, invoice.Version)
+}
+
+func setInvoiceETag(c *fiber.Ctx, invoice models.Invoice) {
+	c.Set(fiber.HeaderETag, invoiceETag(invoice))
+}
+
+// matchesIfMatch reports whether the If-Match header, when present, names the
+// given version. Weak tags and "*" are accepted.
+func matchesIfMatch(c *fiber.Ctx, version int) bool {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" || header == "*" {
+		return true
 	}
 
-	if !response.Success {
-		return fmt.Errorf("stock service reported failure: %s", response.Message)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if value, err := strconv.Atoi(strings.Trim(tag, `"`)); err == nil && value == version {
+			return true
+		}
 	}
+	return false
+}
 
-	log.Printf("Stock successfully updated for %d products", len(products))
-	return nil
+func preconditionFailed(c *fiber.Ctx, current models.Invoice) error {
+	setInvoiceETag(c, current)
+	return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{
+		"error":   "Invoice was modified by another request",
+		"version": current.Version,
+	})
 }
 
-func (c *APIClient) GetProduct(productID string) (*Product, error) {
-	stockServiceURL := os.Getenv("STOCK_SERVICE_URL")
-	if stockServiceURL == "" {
-		stockServiceURL = "http://stock_service_api:3000"
+// invoiceConflictResponse answers a conditional update that matched no row by
+// looking at what the invoice became in the meantime.
+func invoiceConflictResponse(c *fiber.Ctx, code string) error {
+	var current models.Invoice
+	if err := tenant.DB(c).Get(&current, "SELECT * FROM invoices WHERE code = $1", code); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invoice not found"})
 	}
-	url := fmt.Sprintf("%s/product/%s", stockServiceURL, productID)
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+	if c.Get(fiber.HeaderIfMatch) != "" {
+		return preconditionFailed(c, current)
+	}
+	switch current.Status {
+	case models.StatusFechado:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invoice is already closed"})
+	case models.StatusFechando:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Invoice is already being closed"})
+	case models.StatusCancelada:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Invoice is cancelled"})
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	setInvoiceETag(c, current)
+	return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Invoice was modified by another request, retry"})
+}
+
+// generateInvoiceCode counts through tx so several invoices created in the
+// same transaction get consecutive codes.
+func generateInvoiceCode(tx *sqlx.Tx) (string, error) {
+	currentDate := time.Now().Format("20060102")
 
-	resp, err := c.httpClient.Do(req)
+	var count int
+	query := `SELECT COUNT(*) FROM invoices WHERE code LIKE $1 || '%'`
+	err := tx.QueryRow(query, currentDate).Scan(&count)
 	if err != nil {
-		return nil, fmt.Errorf("error calling product service: %v", err)
+		return "", err
+	}
+
+	nextNumber := count + 1
+
+	log.Println(fmt.Sprintf("%s%d", currentDate, nextNumber))
+	return fmt.Sprintf("%s%d", currentDate, nextNumber), nil
+}
+
+// calculateInvoiceTotalValue prices every line with a single stock lookup.
+// Unknown products are reported together as an *UnknownProductsError.
+func calculateInvoiceTotalValue(ctx context.Context, invoiceProducts []models.InvoiceProduct) (float64, error) {
+	productIDs := make([]string, 0, len(invoiceProducts))
+	for _, invoiceProduct := range invoiceProducts {
+		productIDs = append(productIDs, invoiceProduct.ProductID)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	products, missing, err := stockAPI().LookupProducts(ctx, productIDs)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %v", err)
+		log.Printf("Error looking up %d products: %v", len(productIDs), err)
+		return 0, fmt.Errorf("error getting products: %v", err)
+	}
+	if len(missing) > 0 {
+		return 0, &UnknownProductsError{IDs: missing}
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		var errorResp struct {
-			Error string `json:"error"`
-		}
-		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error != "" {
-			return nil, fmt.Errorf("product service returned error: %s - %s", resp.Status, errorResp.Error)
+	totalValue := 0.0
+	for i, invoiceProduct := range invoiceProducts {
+		product := products[invoiceProduct.ProductID]
+
+		// Calcular subtotal
+		subtotal := fiscal.Round2(float64(invoiceProduct.Amount) * product.Price)
+		totalValue += subtotal
+
+		invoiceProducts[i].UnitPrice = product.Price
+		invoiceProducts[i].TotalValue = subtotal
+	}
+
+	log.Printf("Total invoice value for %d products: %.2f", len(invoiceProducts), totalValue)
+	return fiscal.Round2(totalValue), nil
+}
+
+// applyInvoiceTaxes fills the ICMS fields of priced lines according to the
+// states of the issuer, companyUF, and of the customer, and returns the
+// invoice ICMS total. A customer the company does not see, because it does
+// not exist or belongs to another company, fails with sql.ErrNoRows.
+func applyInvoiceTaxes(tx *sqlx.Tx, companyUF string, customerID *uuid.UUID, products []models.InvoiceProduct) (float64, error) {
+	customerUF := ""
+	if customerID != nil {
+		if err := tx.Get(&customerUF, "SELECT uf FROM customers WHERE id = $1", *customerID); err != nil {
+			return 0, fmt.Errorf("error loading customer %s: %w", *customerID, err)
 		}
-		return nil, fmt.Errorf("product service returned error: %s - %s", resp.Status, string(body))
 	}
 
-	var product Product
-	if err := json.Unmarshal(body, &product); err != nil {
-		return nil, fmt.Errorf("error decoding product service response: %v", err)
+	taxes := fiscal.LoadTaxes()
+
+	total := 0.0
+	for i := range products {
+		tax := fiscal.ComputeLineTax(taxes, companyUF, customerUF, products[i].TotalValue)
+		products[i].CFOP = tax.CFOP
+		products[i].CSTICMS = tax.CSTICMS
+		products[i].ICMSBase = tax.Base
+		products[i].ICMSRate = tax.Rate
+		products[i].ICMSValue = tax.ICMSValue
+		total += tax.ICMSValue
 	}
 
-	log.Printf("Product successfully retrieved: %s", productID)
-	return &product, nil
+	return fiscal.Round2(total), nil
 }
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+)
+
+// TestConcurrentClosesDeductOnce sends two closes of the same version. The
+// test holds the invoice row until both have read it and wait on their
+// update, so only the conditional update can tell them apart.
+func TestConcurrentClosesDeductOnce(t *testing.T) {
+	useTestDatabase(t)
+
+	var deductions atomic.Int32
+	useFakeStock(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/balance-update" {
+			http.NotFound(w, r)
+			return
+		}
+		deductions.Add(1)
+		w.Write([]byte(`{"success":true}`))
+	})
+
+	companyID := createTestCompany(t)
+	code := createTestInvoice(t, companyID)
+	app := newInvoiceApp()
+
+	conn, err := db.Company(context.Background(), companyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+	lock, err := conn.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Rollback()
+	if _, err := lock.Exec("SELECT 1 FROM invoices WHERE code = $1 FOR UPDATE", code); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make([]int, 2)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i], _ = sendInvoiceRequest(t, app, companyID, "PUT", "/invoices/"+code+"/close", "", "If-Match", `"1"`)
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var waiting int
+		err := db.DB.Get(&waiting, `SELECT COUNT(*) FROM pg_stat_activity
+			WHERE wait_event_type = 'Lock' AND query LIKE 'UPDATE invoices%'`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if waiting == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d closes waiting on the invoice row, want 2", waiting)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lock.Rollback()
+	wg.Wait()
+
+	sort.Ints(statuses)
+	if statuses[0] != http.StatusOK || (statuses[1] != http.StatusConflict && statuses[1] != http.StatusPreconditionFailed) {
+		t.Errorf("closes answered %v, want one 200 and one 409 or 412", statuses)
+	}
+
+	var messages int
+	err = db.WithCompany(companyID, func(conn *db.Conn) error {
+		return conn.Get(&messages, "SELECT COUNT(*) FROM outbox_messages WHERE invoice_code = $1 AND kind = $2", code, models.OutboxStockDeduct)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages != 1 || deductions.Load() != 1 {
+		t.Errorf("%d stock.deduct messages and %d deductions sent, want 1 and 1", messages, deductions.Load())
+	}
+}
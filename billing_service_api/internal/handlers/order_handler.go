@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+// InvoiceOrderRequest selects what to invoice from an order. An empty product
+// list invoices everything still pending on the order.
+type InvoiceOrderRequest struct {
+	Products []CommercialLine `json:"products" validate:"dive"`
+}
+
+func CreateOrder(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	var request CreateCommercialRequest
+
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid order data", "details": err.Error()})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	if exists, err := customerExists(conn, request.CustomerID); err != nil || !exists {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Customer not found"})
+	}
+
+	totalValue, err := estimateCommercialTotal(requestContext(c), request.Products)
+	if unknown, ok := err.(*UnknownProductsError); ok {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Unknown products", "details": unknown.IDs})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error calculating order total value", "details": err.Error()})
+	}
+
+	order, err := insertSalesOrder(conn, request.CustomerID, nil, models.StatusRascunho, request.ValidUntil, totalValue, request.Products)
+	if err != nil {
+		log.Printf("Error creating order: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error creating order", "details": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(order)
+}
+
+func GetOrders(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	if err := expireCommercialDocuments(conn, "sales_orders"); err != nil {
+		log.Printf("Error expiring orders: %v", err)
+	}
+
+	var orders []models.SalesOrder
+	query := "SELECT * FROM sales_orders ORDER BY created_at DESC"
+	args := []interface{}{}
+	if status := c.Query("status"); status != "" {
+		query = "SELECT * FROM sales_orders WHERE status = $1 ORDER BY created_at DESC"
+		args = append(args, status)
+	}
+
+	if err := conn.Select(&orders, query, args...); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting orders"})
+	}
+
+	if err := loadSalesOrderDetails(conn, orders); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting order products"})
+	}
+
+	return c.JSON(orders)
+}
+
+func GetOrderById(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	if err := expireCommercialDocuments(conn, "sales_orders"); err != nil {
+		log.Printf("Error expiring orders: %v", err)
+	}
+
+	order, err := findSalesOrder(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Order not found"})
+	}
+
+	return c.JSON(order)
+}
+
+func UpdateOrderStatus(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	var request UpdateCommercialStatusRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid status data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	if err := expireCommercialDocuments(conn, "sales_orders"); err != nil {
+		log.Printf("Error expiring orders: %v", err)
+	}
+
+	order, err := findSalesOrder(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Order not found"})
+	}
+
+	if !order.Status.CanTransition(request.Status) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Order cannot move from %s to %s", order.Status, request.Status),
+		})
+	}
+
+	order.UpdatedAt = time.Now().Format(time.RFC3339)
+	_, err = conn.Exec(`UPDATE sales_orders SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4`,
+		request.Status, order.UpdatedAt, order.ID, order.Status)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error updating order status"})
+	}
+	order.Status = request.Status
+
+	return c.JSON(order)
+}
+
+// InvoiceOrder converts an accepted order, fully or partially, into an ABERTO
+// invoice. The order lines are locked while the invoice is created so two
+// conversions can never invoice the same quantity twice.
+func InvoiceOrder(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	var request InvoiceOrderRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid invoice data", "details": err.Error()})
+		}
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	if err := expireCommercialDocuments(conn, "sales_orders"); err != nil {
+		log.Printf("Error expiring orders: %v", err)
+	}
+
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Order not found"})
+	}
+
+	tx, err := conn.Beginx()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+	defer tx.Rollback()
+
+	var order models.SalesOrder
+	if err := tx.Get(&order, "SELECT * FROM sales_orders WHERE id = $1 FOR UPDATE", id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Order not found"})
+	}
+
+	if order.Status != models.StatusAceito {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Only accepted orders can be invoiced"})
+	}
+
+	var lines []models.SalesOrderProduct
+	if err := tx.Select(&lines, "SELECT * FROM sales_order_products WHERE order_id = $1 ORDER BY created_at FOR UPDATE", order.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting order products"})
+	}
+
+	pending := make(map[string]int, len(lines))
+	for _, line := range lines {
+		pending[line.ProductID] += line.Amount - line.InvoicedAmount
+	}
+
+	requested := request.Products
+	if len(requested) == 0 {
+		for _, line := range lines {
+			if remaining := line.Amount - line.InvoicedAmount; remaining > 0 {
+				requested = append(requested, CommercialLine{ProductID: line.ProductID, Amount: remaining})
+			}
+		}
+		if len(requested) == 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Order is already fully invoiced"})
+		}
+	}
+
+	var invoiceProducts []models.InvoiceProduct
+	for _, item := range requested {
+		if item.Amount > pending[item.ProductID] {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": fmt.Sprintf("Requested amount for product %s exceeds pending amount %d", item.ProductID, pending[item.ProductID]),
+			})
+		}
+		pending[item.ProductID] -= item.Amount
+		invoiceProducts = append(invoiceProducts, models.InvoiceProduct{ProductID: item.ProductID, Amount: item.Amount})
+	}
+
+	// Distribute the invoiced quantity over the order lines in their original order.
+	for _, item := range requested {
+		left := item.Amount
+		for i := range lines {
+			if left == 0 {
+				break
+			}
+			if lines[i].ProductID != item.ProductID {
+				continue
+			}
+			take := lines[i].Amount - lines[i].InvoicedAmount
+			if take > left {
+				take = left
+			}
+			if take <= 0 {
+				continue
+			}
+			lines[i].InvoicedAmount += take
+			left -= take
+			if _, err := tx.Exec("UPDATE sales_order_products SET invoiced_amount = $1 WHERE id = $2", lines[i].InvoicedAmount, lines[i].ID); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Error updating order products"})
+			}
+		}
+	}
+
+	invoice, err := insertInvoice(requestContext(c), tx, requestActor(c), &order.CustomerID, &order.ID, invoiceProducts)
+	if err != nil {
+		return invoiceErrorResponse(c, err)
+	}
+
+	if _, err := tx.Exec("UPDATE sales_orders SET updated_at = $1 WHERE id = $2", time.Now().Format(time.RFC3339), order.ID); err != nil {
+		releaseReservations(requestContext(c), invoice)
+		return c.Status(500).JSON(fiber.Map{"error": "Error updating order"})
+	}
+
+	if err := tx.Commit(); err != nil {
+		releaseReservations(requestContext(c), invoice)
+		return c.Status(500).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(invoice)
+}
+
+func insertSalesOrder(q db.Scope, customerID uuid.UUID, quoteID *uuid.UUID, status models.StatusComercial, validUntil string, totalValue float64, lines []CommercialLine) (*models.SalesOrder, error) {
+	code, err := generateCommercialCode(q, "sales_orders", "PED")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	order := models.SalesOrder{
+		ID:         uuid.New(),
+		Code:       code,
+		CustomerID: customerID,
+		QuoteID:    quoteID,
+		Status:     status,
+		ValidUntil: validUntil,
+		TotalValue: totalValue,
+		Invoices:   []string{},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	tx, err := q.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO sales_orders (id, code, customer_id, quote_id, status, valid_until, total_value, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = tx.Exec(query, order.ID, order.Code, order.CustomerID, order.QuoteID, order.Status, order.ValidUntil, order.TotalValue, order.CreatedAt, order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	lineQuery := `INSERT INTO sales_order_products (order_id, product_id, amount, invoiced_amount, created_at) VALUES ($1, $2, $3, 0, $4) RETURNING id`
+	for _, line := range lines {
+		product := models.SalesOrderProduct{OrderID: order.ID, ProductID: line.ProductID, Amount: line.Amount, CreatedAt: now}
+		if err := tx.QueryRow(lineQuery, product.OrderID, product.ProductID, product.Amount, product.CreatedAt).Scan(&product.ID); err != nil {
+			return nil, err
+		}
+		order.Products = append(order.Products, product)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+func findSalesOrder(q db.Querier, id string) (*models.SalesOrder, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, err
+	}
+
+	var order models.SalesOrder
+	if err := q.Get(&order, "SELECT * FROM sales_orders WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+
+	orders := []models.SalesOrder{order}
+	if err := loadSalesOrderDetails(q, orders); err != nil {
+		return nil, err
+	}
+
+	return &orders[0], nil
+}
+
+// loadSalesOrderDetails fills in the lines of each order and the codes of the
+// invoices generated from it.
+func loadSalesOrderDetails(q db.Querier, orders []models.SalesOrder) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(orders))
+	index := make(map[uuid.UUID]int, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID.String()
+		index[order.ID] = i
+		orders[i].Invoices = []string{}
+	}
+
+	var products []models.SalesOrderProduct
+	err := q.Select(&products, "SELECT * FROM sales_order_products WHERE order_id = ANY($1::uuid[]) ORDER BY created_at", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		i := index[product.OrderID]
+		orders[i].Products = append(orders[i].Products, product)
+	}
+
+	var invoices []struct {
+		OrderID uuid.UUID `db:"order_id"`
+		Code    string    `db:"code"`
+	}
+	err = q.Select(&invoices, "SELECT order_id, code FROM invoices WHERE order_id = ANY($1::uuid[]) ORDER BY created_at", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	for _, invoice := range invoices {
+		i := index[invoice.OrderID]
+		orders[i].Invoices = append(orders[i].Invoices, invoice.Code)
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/fiscal"
+	"github.com/lucasbpereira/billing_service_api/internal/mail"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient"
+)
+
+// ActorSaga records the steps of invoice closing taken by the outbox relay.
+const ActorSaga = "saga"
+
+// outboxRetryBase is the wait after the first failed delivery; it doubles on
+// every further failure up to outboxRetryMax.
+const (
+	outboxRetryBase = 5 * time.Second
+	outboxRetryMax  = time.Hour
+)
+
+// stockDeductPayload confirms the invoice reservation when there is one and
+// deducts the items directly otherwise.
+type stockDeductPayload struct {
+	ReservationID *uuid.UUID         `json:"reservation_id,omitempty"`
+	Items         []stockclient.Item `json:"items"`
+}
+
+type stockRestorePayload struct {
+	OperationKey string `json:"operation_key"`
+}
+
+type stockReleasePayload struct {
+	ReservationID uuid.UUID `json:"reservation_id"`
+}
+
+// enqueueOutbox writes a message inside tx, so it exists exactly when the
+// state change that requires it is committed.
+func enqueueOutbox(tx *sqlx.Tx, code, kind string, payload interface{}) (uuid.UUID, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	id := uuid.New()
+	_, err = tx.Exec(`INSERT INTO outbox_messages (id, invoice_code, kind, payload, status, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)`,
+		id, code, kind, data, models.OutboxPendente, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("error writing outbox message: %v", err)
+	}
+
+	return id, nil
+}
+
+// RelayOutbox is the scheduler job. It delivers every due message, each in its
+// own transaction locked with SKIP LOCKED, and applies the next saga step in
+// that same transaction. A crash at any point leaves the message pending, and
+// the stock service deduplicates the redelivery by its ID. Each company's
+// messages are delivered on its own connection and in its name.
+func RelayOutbox(now time.Time) error {
+	return forEachCompany(func(conn *db.Conn) error {
+		for {
+			done, err := relayOutboxMessage(jobContext(conn), conn, now, nil)
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		}
+	})
+}
+
+// relayOutboxMessage delivers the oldest due message of conn, or only the
+// message id when given. It returns true when there was nothing to deliver.
+func relayOutboxMessage(ctx context.Context, conn db.Scope, now time.Time, id *uuid.UUID) (bool, error) {
+	tx, err := conn.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var message models.OutboxMessage
+	query := `SELECT * FROM outbox_messages
+		WHERE status = $1 AND next_attempt_at <= $2 AND ($3::uuid IS NULL OR id = $3)
+		ORDER BY next_attempt_at
+		LIMIT 1 FOR UPDATE SKIP LOCKED`
+	if err := tx.Get(&message, query, models.OutboxPendente, now, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil
+		}
+		return false, fmt.Errorf("error loading outbox: %v", err)
+	}
+
+	deliverErr := deliverOutboxMessage(ctx, message)
+	message.Attempts++
+
+	switch {
+	case deliverErr == nil:
+		err = completeOutboxMessage(tx, message)
+
+	case isPermanent(deliverErr):
+		// The stock service rejected the whole command, so nothing was deducted
+		// and failing the invoice is the only compensation needed.
+		log.Printf("Outbox message %s for invoice %s rejected: %v", message.ID, message.InvoiceCode, deliverErr)
+		err = failOutboxMessage(tx, message, deliverErr)
+		if err == nil && message.Kind == models.OutboxStockDeduct {
+			err = failInvoiceClose(tx, message.InvoiceCode, deliverErr.Error())
+		}
+
+	case message.Kind == models.OutboxStockDeduct && message.Attempts >= outboxMaxAttempts():
+		// Whether the deduction arrived is unknown: fail the invoice and queue
+		// a restore, which the stock service applies only if it did.
+		log.Printf("Outbox message %s for invoice %s gave up after %d attempts: %v", message.ID, message.InvoiceCode, message.Attempts, deliverErr)
+		err = failOutboxMessage(tx, message, deliverErr)
+		if err == nil {
+			err = failInvoiceClose(tx, message.InvoiceCode, deliverErr.Error())
+		}
+		if err == nil {
+			_, err = enqueueOutbox(tx, message.InvoiceCode, models.OutboxStockRestore, stockRestorePayload{OperationKey: message.ID.String()})
+		}
+
+	default:
+		// Restores, releases and events are retried until they succeed: giving
+		// up would leave stock held for an invoice that was never closed, or a
+		// close nobody answers.
+		log.Printf("Error delivering outbox message %s for invoice %s (attempt %d): %v", message.ID, message.InvoiceCode, message.Attempts, deliverErr)
+		err = retryOutboxMessage(tx, message, now, deliverErr)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error updating outbox message %s: %v", message.ID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
+
+func deliverOutboxMessage(ctx context.Context, message models.OutboxMessage) error {
+	switch message.Kind {
+	case models.OutboxStockDeduct:
+		var payload stockDeductPayload
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			return err
+		}
+		if payload.ReservationID != nil {
+			return stockAPI().ConfirmReservation(ctx, *payload.ReservationID, message.ID.String())
+		}
+		return stockAPI().UpdateBalance(ctx, payload.Items, message.ID.String())
+
+	case models.OutboxStockRestore:
+		var payload stockRestorePayload
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			return err
+		}
+		return stockAPI().RestoreBalance(ctx, payload.OperationKey)
+
+	case models.OutboxStockRelease:
+		var payload stockReleasePayload
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			return err
+		}
+		return stockAPI().ReleaseReservation(ctx, payload.ReservationID)
+
+	case models.OutboxEvent:
+		return publishOutboxEvent(ctx, message)
+	}
+
+	return fmt.Errorf("unknown outbox message kind %q", message.Kind)
+}
+
+func completeOutboxMessage(tx *sqlx.Tx, message models.OutboxMessage) error {
+	_, err := tx.Exec(`UPDATE outbox_messages SET status = $1, attempts = $2, last_error = NULL, delivered_at = $3 WHERE id = $4`,
+		models.OutboxEnviada, message.Attempts, time.Now().Format(time.RFC3339), message.ID)
+	if err != nil {
+		return err
+	}
+
+	if message.Kind == models.OutboxStockDeduct {
+		return finishInvoiceClose(tx, message.InvoiceCode)
+	}
+	return nil
+}
+
+func failOutboxMessage(tx *sqlx.Tx, message models.OutboxMessage, cause error) error {
+	_, err := tx.Exec(`UPDATE outbox_messages SET status = $1, attempts = $2, last_error = $3 WHERE id = $4`,
+		models.OutboxFalhou, message.Attempts, cause.Error(), message.ID)
+	return err
+}
+
+func retryOutboxMessage(tx *sqlx.Tx, message models.OutboxMessage, now time.Time, cause error) error {
+	backoff := outboxRetryBase << uint(message.Attempts-1)
+	if backoff <= 0 || backoff > outboxRetryMax {
+		backoff = outboxRetryMax
+	}
+
+	_, err := tx.Exec(`UPDATE outbox_messages SET attempts = $1, last_error = $2, next_attempt_at = $3 WHERE id = $4`,
+		message.Attempts, cause.Error(), now.Add(backoff).Format(time.RFC3339), message.ID)
+	return err
+}
+
+// finishInvoiceClose moves a FECHANDO invoice to FECHADA once its stock was
+// deducted: it gets its access key and its customer email is queued.
+func finishInvoiceClose(tx *sqlx.Tx, code string) error {
+	var invoice models.Invoice
+	if err := tx.Get(&invoice, "SELECT * FROM invoices WHERE code = $1 FOR UPDATE", code); err != nil {
+		return err
+	}
+	if invoice.Status != models.StatusFechando {
+		return nil
+	}
+
+	closedAt := time.Now()
+	closed := invoice
+	closed.Status = models.StatusFechado
+	closed.UpdatedAt = closedAt.Format(time.RFC3339)
+	closed.ClosedAt = &closed.UpdatedAt
+	closed.Version = invoice.Version + 1
+	company, err := currentCompany(tx)
+	if err != nil {
+		return err
+	}
+	if key, err := fiscal.AccessKey(company.Issuer(), closedAt, invoice.Series, invoice.Number, invoice.ID.String()); err == nil {
+		closed.AccessKey = &key
+	} else {
+		log.Printf("Invoice %s closed without access key: %v", code, err)
+	}
+
+	_, err = tx.Exec(`UPDATE invoices SET status = $1, updated_at = $2, closed_at = $2, access_key = $3, version = version + 1
+		WHERE code = $4`,
+		closed.Status, closed.UpdatedAt, closed.AccessKey, code)
+	if err != nil {
+		return err
+	}
+
+	if err := recordInvoiceEvent(tx, code, ActorSaga, models.AcaoFechada, "", invoice, closed); err != nil {
+		return err
+	}
+
+	invoices := []models.Invoice{closed}
+	if err := loadInvoiceProducts(tx, invoices); err != nil {
+		return err
+	}
+	if err := notifyInvoiceChange(tx, events.InvoiceClosed, invoiceEventData(closed, invoices[0].Products)); err != nil {
+		return err
+	}
+
+	if _, err := enqueueInvoiceEmail(tx, code, mail.EventIssued, nil); err != nil {
+		log.Printf("Invoice %s closed without queueing the customer email: %v", code, err)
+	}
+
+	return nil
+}
+
+// failInvoiceClose moves a FECHANDO invoice to FALHA_ESTOQUE. It can be closed
+// again once stock is available.
+func failInvoiceClose(tx *sqlx.Tx, code, reason string) error {
+	var invoice models.Invoice
+	if err := tx.Get(&invoice, "SELECT * FROM invoices WHERE code = $1 FOR UPDATE", code); err != nil {
+		return err
+	}
+	if invoice.Status != models.StatusFechando {
+		return nil
+	}
+
+	failed := invoice
+	failed.Status = models.StatusFalhaEstoque
+	failed.UpdatedAt = time.Now().Format(time.RFC3339)
+	failed.Version = invoice.Version + 1
+
+	_, err := tx.Exec("UPDATE invoices SET status = $1, updated_at = $2, version = version + 1 WHERE code = $3",
+		failed.Status, failed.UpdatedAt, code)
+	if err != nil {
+		return err
+	}
+
+	return recordInvoiceEvent(tx, code, ActorSaga, models.AcaoFalhaEstoque, reason, invoice, failed)
+}
+
+// closingOperationKey returns the key of the stock operation that closed the
+// invoice: the ID of its latest deduction, or of its latest invoice.closed
+// event when closed over the broker.
+func closingOperationKey(tx *sqlx.Tx, code string) (uuid.UUID, error) {
+	var key uuid.UUID
+	err := tx.Get(&key, `SELECT id FROM outbox_messages
+		WHERE invoice_code = $1 AND (kind = $2 OR (kind = $3 AND payload->>'type' = $4))
+		ORDER BY created_at DESC LIMIT 1`,
+		code, models.OutboxStockDeduct, models.OutboxEvent, events.InvoiceClosed)
+	return key, err
+}
+
+func isPermanent(err error) bool {
+	var stockErr *stockclient.Error
+	return errors.As(err, &stockErr) && stockErr.Permanent()
+}
+
+func outboxMaxAttempts() int {
+	if value, err := strconv.Atoi(os.Getenv("OUTBOX_MAX_ATTEMPTS")); err == nil && value > 0 {
+		return value
+	}
+	return 8
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+)
+
+// closeState is an invoice with its outbox messages, oldest first.
+func closeState(t *testing.T, companyID, code string) (models.Invoice, []models.OutboxMessage) {
+	t.Helper()
+
+	var invoice models.Invoice
+	var messages []models.OutboxMessage
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		if err := conn.Get(&invoice, "SELECT * FROM invoices WHERE code = $1", code); err != nil {
+			return err
+		}
+		return conn.Select(&messages, "SELECT * FROM outbox_messages WHERE invoice_code = $1 ORDER BY created_at, kind", code)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return invoice, messages
+}
+
+// wallClock drops the zone of a timestamp column, which stores the wall
+// clock the relay wrote.
+func wallClock(t *testing.T, timestamp string) string {
+	t.Helper()
+
+	parsed, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", timestamp, err)
+	}
+	return parsed.Format("2006-01-02T15:04:05")
+}
+
+// TestRelayOutcomesOfAClose closes an invoice against a stock service that
+// answers each branch of relayOutboxMessage.
+func TestRelayOutcomesOfAClose(t *testing.T) {
+	useTestDatabase(t)
+
+	tests := []struct {
+		name        string
+		stockStatus int
+		maxAttempts string
+		wantStatus  int
+		wantInvoice models.StatusNota
+		wantMessage models.StatusOutbox
+		wantRestore bool
+	}{
+		{"deducted", http.StatusOK, "", http.StatusOK, models.StatusFechado, models.OutboxEnviada, false},
+		{"rejected", http.StatusUnprocessableEntity, "", http.StatusUnprocessableEntity, models.StatusFalhaEstoque, models.OutboxFalhou, false},
+		{"given up", http.StatusServiceUnavailable, "1", http.StatusUnprocessableEntity, models.StatusFalhaEstoque, models.OutboxFalhou, true},
+		{"retried", http.StatusServiceUnavailable, "", http.StatusAccepted, models.StatusFechando, models.OutboxPendente, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OUTBOX_MAX_ATTEMPTS", tt.maxAttempts)
+			useFakeStock(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.stockStatus)
+				if tt.stockStatus == http.StatusOK {
+					w.Write([]byte(`{"success":true}`))
+				} else {
+					w.Write([]byte(`{"error":"Insufficient stock for product product-1"}`))
+				}
+			})
+
+			companyID := createTestCompany(t)
+			code := createTestInvoice(t, companyID)
+			if status, body := sendInvoiceRequest(t, newInvoiceApp(), companyID, "PUT", "/invoices/"+code+"/close", ""); status != tt.wantStatus {
+				t.Fatalf("close = %d %s, want %d", status, body, tt.wantStatus)
+			}
+
+			invoice, messages := closeState(t, companyID, code)
+			if invoice.Status != tt.wantInvoice {
+				t.Errorf("invoice status = %s, want %s", invoice.Status, tt.wantInvoice)
+			}
+			if (invoice.AccessKey != nil) != (tt.wantInvoice == models.StatusFechado) {
+				t.Errorf("access key = %v on a %s invoice", invoice.AccessKey, invoice.Status)
+			}
+
+			deduct := messages[0]
+			if deduct.Kind != models.OutboxStockDeduct || deduct.Status != tt.wantMessage || deduct.Attempts != 1 {
+				t.Errorf("deduction %s is %s after %d attempts, want %s after 1", deduct.Kind, deduct.Status, deduct.Attempts, tt.wantMessage)
+			}
+
+			var restores []models.OutboxMessage
+			for _, message := range messages {
+				if message.Kind == models.OutboxStockRestore {
+					restores = append(restores, message)
+				}
+			}
+			if !tt.wantRestore {
+				if len(restores) != 0 {
+					t.Errorf("%d restores queued, want none", len(restores))
+				}
+				return
+			}
+			if len(restores) != 1 {
+				t.Fatalf("%d restores queued, want 1", len(restores))
+			}
+			var payload stockRestorePayload
+			json.Unmarshal(restores[0].Payload, &payload)
+			if restores[0].Status != models.OutboxPendente || payload.OperationKey != deduct.ID.String() {
+				t.Errorf("restore %s of operation %s, want a pending restore of %s", restores[0].Status, payload.OperationKey, deduct.ID)
+			}
+		})
+	}
+}
+
+// TestRelayBacksOffUntilDeducted retries a deduction the stock service could
+// not take, doubling the wait, and closes the invoice once it is taken.
+func TestRelayBacksOffUntilDeducted(t *testing.T) {
+	useTestDatabase(t)
+
+	var stockStatus atomic.Int32
+	stockStatus.Store(http.StatusServiceUnavailable)
+	useFakeStock(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(stockStatus.Load()))
+		w.Write([]byte(`{"success":true}`))
+	})
+
+	companyID := createTestCompany(t)
+	code := createTestInvoice(t, companyID)
+
+	before := time.Now().Truncate(time.Second)
+	if status, body := sendInvoiceRequest(t, newInvoiceApp(), companyID, "PUT", "/invoices/"+code+"/close", ""); status != http.StatusAccepted {
+		t.Fatalf("close = %d %s, want 202", status, body)
+	}
+	after := time.Now()
+
+	_, messages := closeState(t, companyID, code)
+	deduct := messages[0]
+	first := wallClock(t, deduct.NextAttemptAt)
+	if first < before.Add(outboxRetryBase).Format("2006-01-02T15:04:05") || first > after.Add(outboxRetryBase).Format("2006-01-02T15:04:05") {
+		t.Errorf("first retry at %s, want %s after the close", first, outboxRetryBase)
+	}
+
+	relay := func(now time.Time) {
+		t.Helper()
+
+		err := db.WithCompany(companyID, func(conn *db.Conn) error {
+			_, err := relayOutboxMessage(context.Background(), conn, now, &deduct.ID)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now().Add(time.Minute)
+	relay(now)
+	invoice, messages := closeState(t, companyID, code)
+	if invoice.Status != models.StatusFechando || messages[0].Attempts != 2 {
+		t.Errorf("after a second failure the invoice is %s and the deduction has %d attempts, want FECHANDO and 2", invoice.Status, messages[0].Attempts)
+	}
+	if next, want := wallClock(t, messages[0].NextAttemptAt), now.Add(2*outboxRetryBase).Format("2006-01-02T15:04:05"); next != want {
+		t.Errorf("second retry at %s, want %s", next, want)
+	}
+
+	stockStatus.Store(http.StatusOK)
+	relay(now.Add(time.Hour))
+	invoice, messages = closeState(t, companyID, code)
+	if invoice.Status != models.StatusFechado || messages[0].Status != models.OutboxEnviada {
+		t.Errorf("after the deduction the invoice is %s and the message %s, want FECHADA and ENVIADA", invoice.Status, messages[0].Status)
+	}
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+type CommercialLine struct {
+	ProductID string `json:"product_id" validate:"required"`
+	Amount    int    `json:"amount" validate:"required,min=1"`
+}
+
+type CreateCommercialRequest struct {
+	CustomerID uuid.UUID        `json:"customer_id" validate:"required"`
+	ValidUntil string           `json:"valid_until" validate:"required,datetime=2006-01-02"`
+	Products   []CommercialLine `json:"products" validate:"required,min=1,dive"`
+}
+
+type UpdateCommercialStatusRequest struct {
+	Status models.StatusComercial `json:"status" validate:"required,oneof=RASCUNHO ENVIADO ACEITO EXPIRADO"`
+}
+
+func CreateQuote(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	var request CreateCommercialRequest
+
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid quote data", "details": err.Error()})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	if exists, err := customerExists(conn, request.CustomerID); err != nil || !exists {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Customer not found"})
+	}
+
+	code, err := generateCommercialCode(conn, "quotes", "ORC")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating quote code"})
+	}
+
+	totalValue, err := estimateCommercialTotal(requestContext(c), request.Products)
+	if unknown, ok := err.(*UnknownProductsError); ok {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Unknown products", "details": unknown.IDs})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error calculating quote total value", "details": err.Error()})
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	quote := models.Quote{
+		ID:         uuid.New(),
+		Code:       code,
+		CustomerID: request.CustomerID,
+		Status:     models.StatusRascunho,
+		ValidUntil: request.ValidUntil,
+		TotalValue: totalValue,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	tx, err := conn.Beginx()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+
+	query := `INSERT INTO quotes (id, code, customer_id, status, valid_until, total_value, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = tx.Exec(query, quote.ID, quote.Code, quote.CustomerID, quote.Status, quote.ValidUntil, quote.TotalValue, quote.CreatedAt, quote.UpdatedAt)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error inserting quote: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error creating quote", "details": err.Error()})
+	}
+
+	lineQuery := `INSERT INTO quote_products (quote_id, product_id, amount, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
+	for _, line := range request.Products {
+		product := models.QuoteProduct{QuoteID: quote.ID, ProductID: line.ProductID, Amount: line.Amount, CreatedAt: now}
+		if err := tx.QueryRow(lineQuery, product.QuoteID, product.ProductID, product.Amount, product.CreatedAt).Scan(&product.ID); err != nil {
+			tx.Rollback()
+			log.Printf("Error inserting quote product: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Error creating quote products", "details": err.Error()})
+		}
+		quote.Products = append(quote.Products, product)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(quote)
+}
+
+func GetQuotes(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	if err := expireCommercialDocuments(conn, "quotes"); err != nil {
+		log.Printf("Error expiring quotes: %v", err)
+	}
+
+	var quotes []models.Quote
+	query := "SELECT * FROM quotes ORDER BY created_at DESC"
+	args := []interface{}{}
+	if status := c.Query("status"); status != "" {
+		query = "SELECT * FROM quotes WHERE status = $1 ORDER BY created_at DESC"
+		args = append(args, status)
+	}
+
+	if err := conn.Select(&quotes, query, args...); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting quotes"})
+	}
+
+	if err := loadQuoteProducts(conn, quotes); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting quote products"})
+	}
+
+	return c.JSON(quotes)
+}
+
+func GetQuoteById(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	if err := expireCommercialDocuments(conn, "quotes"); err != nil {
+		log.Printf("Error expiring quotes: %v", err)
+	}
+
+	quote, err := findQuote(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Quote not found"})
+	}
+
+	return c.JSON(quote)
+}
+
+func UpdateQuoteStatus(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	var request UpdateCommercialStatusRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid status data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	if err := expireCommercialDocuments(conn, "quotes"); err != nil {
+		log.Printf("Error expiring quotes: %v", err)
+	}
+
+	quote, err := findQuote(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Quote not found"})
+	}
+
+	if !quote.Status.CanTransition(request.Status) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("Quote cannot move from %s to %s", quote.Status, request.Status),
+		})
+	}
+
+	quote.UpdatedAt = time.Now().Format(time.RFC3339)
+	_, err = conn.Exec(`UPDATE quotes SET status = $1, updated_at = $2 WHERE id = $3 AND status = $4`,
+		request.Status, quote.UpdatedAt, quote.ID, quote.Status)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error updating quote status"})
+	}
+	quote.Status = request.Status
+
+	return c.JSON(quote)
+}
+
+// ConvertQuoteToOrder turns an accepted quote into an accepted sales order
+// with the same customer, validity and lines. A quote yields at most one order.
+func ConvertQuoteToOrder(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	if err := expireCommercialDocuments(conn, "quotes"); err != nil {
+		log.Printf("Error expiring quotes: %v", err)
+	}
+
+	quote, err := findQuote(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Quote not found"})
+	}
+
+	if quote.Status != models.StatusAceito {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Only accepted quotes can become orders"})
+	}
+
+	var existing int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sales_orders WHERE quote_id = $1`, quote.ID).Scan(&existing); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error checking existing orders"})
+	}
+	if existing > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Quote already converted into an order"})
+	}
+
+	lines := make([]CommercialLine, 0, len(quote.Products))
+	for _, product := range quote.Products {
+		lines = append(lines, CommercialLine{ProductID: product.ProductID, Amount: product.Amount})
+	}
+
+	order, err := insertSalesOrder(conn, quote.CustomerID, &quote.ID, models.StatusAceito, quote.ValidUntil, quote.TotalValue, lines)
+	if err != nil {
+		log.Printf("Error converting quote %s: %v", quote.Code, err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error creating order", "details": err.Error()})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(order)
+}
+
+func findQuote(q db.Querier, id string) (*models.Quote, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, err
+	}
+
+	var quote models.Quote
+	if err := q.Get(&quote, "SELECT * FROM quotes WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+
+	quotes := []models.Quote{quote}
+	if err := loadQuoteProducts(q, quotes); err != nil {
+		return nil, err
+	}
+
+	return &quotes[0], nil
+}
+
+func loadQuoteProducts(q db.Querier, quotes []models.Quote) error {
+	if len(quotes) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(quotes))
+	index := make(map[uuid.UUID]int, len(quotes))
+	for i, quote := range quotes {
+		ids[i] = quote.ID.String()
+		index[quote.ID] = i
+	}
+
+	var products []models.QuoteProduct
+	err := q.Select(&products, "SELECT * FROM quote_products WHERE quote_id = ANY($1::uuid[]) ORDER BY created_at", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		i := index[product.QuoteID]
+		quotes[i].Products = append(quotes[i].Products, product)
+	}
+
+	return nil
+}
+
+// generateCommercialCode numbers quotes and orders per day, like invoice codes,
+// with a prefix so the three kinds of document never share a code.
+func generateCommercialCode(q db.Querier, table, prefix string) (string, error) {
+	currentDate := time.Now().Format("20060102")
+
+	var count int
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE code LIKE $1 || '%%'`, table)
+	if err := q.QueryRow(query, prefix+currentDate).Scan(&count); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%s%d", prefix, currentDate, count+1), nil
+}
+
+// expireCommercialDocuments moves drafts and sent documents whose validity
+// date has passed to EXPIRADO. Reads call it so statuses are never stale.
+func expireCommercialDocuments(q db.Querier, table string) error {
+	query := fmt.Sprintf(`UPDATE %s SET status = $1, updated_at = $2
+		WHERE status IN ($3, $4) AND valid_until < CURRENT_DATE`, table)
+	_, err := q.Exec(query, models.StatusExpirado, time.Now().Format(time.RFC3339),
+		models.StatusRascunho, models.StatusEnviado)
+	return err
+}
+
+func estimateCommercialTotal(ctx context.Context, lines []CommercialLine) (float64, error) {
+	products := make([]models.InvoiceProduct, 0, len(lines))
+	for _, line := range lines {
+		products = append(products, models.InvoiceProduct{ProductID: line.ProductID, Amount: line.Amount})
+	}
+	return calculateInvoiceTotalValue(ctx, products)
+}
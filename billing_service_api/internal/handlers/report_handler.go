@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+// Every report covers closed invoices, dated by closed_at, in the inclusive
+// [start, end] range (default: the current month). Add format=csv, or send
+// Accept: text/csv, to download CSV instead of JSON.
+
+type RevenueRow struct {
+	Period       string  `json:"period" db:"period"`
+	InvoiceCount int     `json:"invoice_count" db:"invoice_count"`
+	Revenue      float64 `json:"revenue" db:"revenue"`
+}
+
+type ProductSalesRow struct {
+	ProductID    string  `json:"product_id" db:"product_id"`
+	Quantity     int     `json:"quantity" db:"quantity"`
+	Revenue      float64 `json:"revenue" db:"revenue"`
+	InvoiceCount int     `json:"invoice_count" db:"invoice_count"`
+}
+
+type CustomerRevenueRow struct {
+	CustomerID   *string `json:"customer_id" db:"customer_id"`
+	CustomerName *string `json:"customer_name" db:"customer_name"`
+	InvoiceCount int     `json:"invoice_count" db:"invoice_count"`
+	Revenue      float64 `json:"revenue" db:"revenue"`
+}
+
+type AverageTicketRow struct {
+	InvoiceCount  int     `json:"invoice_count" db:"invoice_count"`
+	Revenue       float64 `json:"revenue" db:"revenue"`
+	AverageTicket float64 `json:"average_ticket" db:"average_ticket"`
+}
+
+type TaxTotalRow struct {
+	Tax   string  `json:"tax" db:"tax"`
+	CFOP  string  `json:"cfop" db:"cfop"`
+	Base  float64 `json:"base" db:"base"`
+	Value float64 `json:"value" db:"value"`
+}
+
+// GetRevenueReport groups revenue and invoice counts by day, week or month
+// (?granularity=, default day).
+func GetRevenueReport(c *fiber.Ctx) error {
+	start, end, err := reportRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	granularity := c.Query("granularity", "day")
+	if granularity != "day" && granularity != "week" && granularity != "month" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "granularity must be day, week or month"})
+	}
+
+	rows := []RevenueRow{}
+	query := `SELECT to_char(date_trunc($1, closed_at), 'YYYY-MM-DD') AS period,
+			COUNT(*) AS invoice_count, COALESCE(SUM(total_value), 0) AS revenue
+		FROM invoices
+		WHERE status = $2 AND closed_at >= $3 AND closed_at < $4
+		GROUP BY 1 ORDER BY 1`
+	if err := tenant.DB(c).Select(&rows, query, granularity, models.StatusFechado, start, end); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error building revenue report"})
+	}
+
+	return respondReport(c, "revenue", rows)
+}
+
+// GetTopProductsReport ranks products by quantity or value (?by=, default
+// quantity) and returns the first ?limit= rows (default 10).
+func GetTopProductsReport(c *fiber.Ctx) error {
+	start, end, err := reportRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	order := "quantity"
+	switch c.Query("by", "quantity") {
+	case "quantity":
+	case "value":
+		order = "revenue"
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "by must be quantity or value"})
+	}
+
+	limit := c.QueryInt("limit", 10)
+	if limit < 1 || limit > 1000 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be between 1 and 1000"})
+	}
+
+	rows := []ProductSalesRow{}
+	query := fmt.Sprintf(`SELECT ip.product_id, SUM(ip.amount) AS quantity, COALESCE(SUM(ip.total_value), 0) AS revenue,
+			COUNT(DISTINCT ip.invoice_code) AS invoice_count
+		FROM invoice_products ip
+		JOIN invoices i ON i.code = ip.invoice_code
+		WHERE i.status = $1 AND i.closed_at >= $2 AND i.closed_at < $3
+		GROUP BY ip.product_id
+		ORDER BY %s DESC, ip.product_id
+		LIMIT $4`, order)
+	if err := tenant.DB(c).Select(&rows, query, models.StatusFechado, start, end, limit); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error building products report"})
+	}
+
+	return respondReport(c, "top-products", rows)
+}
+
+func GetCustomerRevenueReport(c *fiber.Ctx) error {
+	start, end, err := reportRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	rows := []CustomerRevenueRow{}
+	query := `SELECT i.customer_id::text AS customer_id, cu.name AS customer_name,
+			COUNT(*) AS invoice_count, COALESCE(SUM(i.total_value), 0) AS revenue
+		FROM invoices i
+		LEFT JOIN customers cu ON cu.id = i.customer_id
+		WHERE i.status = $1 AND i.closed_at >= $2 AND i.closed_at < $3
+		GROUP BY i.customer_id, cu.name
+		ORDER BY revenue DESC`
+	if err := tenant.DB(c).Select(&rows, query, models.StatusFechado, start, end); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error building customers report"})
+	}
+
+	return respondReport(c, "customers", rows)
+}
+
+func GetAverageTicketReport(c *fiber.Ctx) error {
+	start, end, err := reportRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var row AverageTicketRow
+	query := `SELECT COUNT(*) AS invoice_count, COALESCE(SUM(total_value), 0) AS revenue,
+			COALESCE(ROUND(AVG(total_value), 2), 0) AS average_ticket
+		FROM invoices
+		WHERE status = $1 AND closed_at >= $2 AND closed_at < $3`
+	if err := tenant.DB(c).Get(&row, query, models.StatusFechado, start, end); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error building average ticket report"})
+	}
+
+	return respondReport(c, "average-ticket", []AverageTicketRow{row})
+}
+
+// GetTaxReport totals each tax by CFOP. Only ICMS is computed today; new taxes
+// are added as further branches of the UNION.
+func GetTaxReport(c *fiber.Ctx) error {
+	start, end, err := reportRange(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	rows := []TaxTotalRow{}
+	query := `SELECT 'ICMS' AS tax, ip.cfop, COALESCE(SUM(ip.icms_base), 0) AS base, COALESCE(SUM(ip.icms_value), 0) AS value
+		FROM invoice_products ip
+		JOIN invoices i ON i.code = ip.invoice_code
+		WHERE i.status = $1 AND i.closed_at >= $2 AND i.closed_at < $3
+		GROUP BY ip.cfop
+		ORDER BY tax, cfop`
+	if err := tenant.DB(c).Select(&rows, query, models.StatusFechado, start, end); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error building tax report"})
+	}
+
+	return respondReport(c, "taxes", rows)
+}
+
+// reportRange returns [start, end) with end moved to the day after the
+// requested, inclusive, end date.
+func reportRange(c *fiber.Ctx) (time.Time, time.Time, error) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, -1)
+
+	if value := c.Query("start"); value != "" {
+		parsed, err := models.ParseDate(value)
+		if err != nil {
+			return start, end, fmt.Errorf("start must be YYYY-MM-DD")
+		}
+		start = parsed
+	}
+	if value := c.Query("end"); value != "" {
+		parsed, err := models.ParseDate(value)
+		if err != nil {
+			return start, end, fmt.Errorf("end must be YYYY-MM-DD")
+		}
+		end = parsed
+	}
+	if end.Before(start) {
+		return start, end, fmt.Errorf("end must not be before start")
+	}
+
+	return start, end.AddDate(0, 0, 1), nil
+}
+
+// respondReport writes rows as JSON, or as CSV with the json tags as header
+// when format=csv or the client accepts text/csv.
+func respondReport(c *fiber.Ctx, name string, rows interface{}) error {
+	format := c.Query("format")
+	if format == "" && strings.Contains(c.Get(fiber.HeaderAccept), "text/csv") {
+		format = "csv"
+	}
+	if format != "csv" {
+		return c.JSON(rows)
+	}
+
+	value := reflect.ValueOf(rows)
+	rowType := value.Type().Elem()
+
+	var b strings.Builder
+	writer := csv.NewWriter(&b)
+
+	header := make([]string, rowType.NumField())
+	for i := range header {
+		header[i] = strings.Split(rowType.Field(i).Tag.Get("json"), ",")[0]
+	}
+	writer.Write(header)
+
+	for i := 0; i < value.Len(); i++ {
+		row := value.Index(i)
+		record := make([]string, row.NumField())
+		for j := range record {
+			record[j] = csvValue(row.Field(j))
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+	return c.SendString(b.String())
+}
+
+func csvValue(field reflect.Value) string {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return ""
+		}
+		field = field.Elem()
+	}
+	if field.Kind() == reflect.Float64 {
+		return fmt.Sprintf("%.2f", field.Float())
+	}
+	return fmt.Sprint(field.Interface())
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+	"github.com/lucasbpereira/billing_service_api/internal/fiscal"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/billing_service_api/internal/sped"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient"
+)
+
+// ExportEFD generates the EFD ICMS/IPI file of one month from the invoices
+// closed in it. Query parameters: start and end (YYYY-MM-DD) and items=true to
+// add C170 records.
+func ExportEFD(c *fiber.Ctx) error {
+	start, err := models.ParseDate(c.Query("start"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "start must be YYYY-MM-DD"})
+	}
+	end, err := models.ParseDate(c.Query("end"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "end must be YYYY-MM-DD"})
+	}
+
+	conn := tenant.DB(c)
+	company, err := currentCompany(conn)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error loading company"})
+	}
+
+	var invoices []models.Invoice
+	err = conn.Select(&invoices, `SELECT * FROM invoices WHERE status = $1 AND closed_at >= $2 AND closed_at < $3 ORDER BY number`,
+		models.StatusFechado, start, end.AddDate(0, 0, 1))
+	if err != nil {
+		log.Printf("Error loading invoices for EFD: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting closed invoices"})
+	}
+
+	var incomplete []string
+	codes := make([]string, 0, len(invoices))
+	customerIDs := make([]string, 0, len(invoices))
+	for _, invoice := range invoices {
+		if invoice.CustomerID == nil || invoice.AccessKey == nil {
+			incomplete = append(incomplete, invoice.Code)
+			continue
+		}
+		codes = append(codes, invoice.Code)
+		customerIDs = append(customerIDs, invoice.CustomerID.String())
+	}
+	if len(incomplete) > 0 {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":    "Closed invoices without customer or access key cannot be reported",
+			"invoices": incomplete,
+		})
+	}
+
+	var customers []models.Customer
+	if err := conn.Select(&customers, "SELECT * FROM customers WHERE id = ANY($1::uuid[]) ORDER BY name", pq.Array(customerIDs)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting customers"})
+	}
+
+	var lines []models.InvoiceProduct
+	if err := conn.Select(&lines, "SELECT * FROM invoice_products WHERE invoice_code = ANY($1) ORDER BY created_at, id", pq.Array(codes)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting invoice products"})
+	}
+	linesByInvoice := make(map[string][]models.InvoiceProduct)
+	for _, line := range lines {
+		linesByInvoice[line.InvoiceCode] = append(linesByInvoice[line.InvoiceCode], line)
+	}
+
+	includeItems := c.QueryBool("items", false)
+	products := make(map[string]*stockclient.Product)
+	if includeItems {
+		productIDs := make([]string, 0, len(lines))
+		for _, line := range lines {
+			productIDs = append(productIDs, line.ProductID)
+		}
+		found, missing, err := stockAPI().LookupProducts(requestContext(c), productIDs)
+		if err == nil && len(missing) > 0 {
+			err = &UnknownProductsError{IDs: missing}
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Error getting product data", "details": err.Error()})
+		}
+		products = found
+	}
+
+	input := sped.Input{
+		Company:      company.Issuer(),
+		Accountant:   fiscal.LoadAccountant(),
+		Taxes:        fiscal.LoadTaxes(),
+		Start:        start,
+		End:          end,
+		IncludeItems: includeItems,
+	}
+
+	for _, customer := range customers {
+		input.Participants = append(input.Participants, sped.Participant{
+			Code:       customer.ID.String(),
+			Name:       customer.Name,
+			Document:   customer.Document,
+			IE:         customer.IE,
+			CityCode:   customer.CityCode,
+			Street:     customer.Street,
+			Number:     customer.Number,
+			Complement: customer.Complement,
+			District:   customer.District,
+		})
+	}
+
+	seenItems := make(map[string]bool)
+	for _, line := range lines {
+		product, ok := products[line.ProductID]
+		if !ok || seenItems[line.ProductID] {
+			continue
+		}
+		seenItems[line.ProductID] = true
+		input.Items = append(input.Items, sped.Item{
+			Code:        line.ProductID,
+			Description: product.Name,
+			Unit:        productUnit(product),
+			NCM:         product.NCM,
+		})
+	}
+
+	for _, invoice := range invoices {
+		issuedAt, err := time.Parse(time.RFC3339, *invoice.ClosedAt)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("Invalid close date on invoice %s", invoice.Code)})
+		}
+
+		doc := sped.Document{
+			ParticipantCode: invoice.CustomerID.String(),
+			Series:          invoice.Series,
+			Number:          invoice.Number,
+			AccessKey:       *invoice.AccessKey,
+			IssuedAt:        issuedAt,
+			Value:           invoice.TotalValue,
+			ICMSValue:       invoice.ICMSValue,
+		}
+		for _, line := range linesByInvoice[invoice.Code] {
+			doc.ICMSBase += line.ICMSBase
+			doc.Lines = append(doc.Lines, sped.Line{
+				ItemCode:  line.ProductID,
+				Quantity:  float64(line.Amount),
+				Unit:      productUnit(products[line.ProductID]),
+				Value:     line.TotalValue,
+				CSTICMS:   line.CSTICMS,
+				CFOP:      line.CFOP,
+				ICMSBase:  line.ICMSBase,
+				ICMSRate:  line.ICMSRate,
+				ICMSValue: line.ICMSValue,
+			})
+		}
+		input.Documents = append(input.Documents, doc)
+	}
+
+	file, err := sped.Generate(input)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Error generating EFD file", "details": err.Error()})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/plain; charset=ISO-8859-1")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="EFD_%s_%s.txt"`, input.Company.CNPJ, start.Format("200601")))
+	return c.Send(file)
+}
+
+func productUnit(product *stockclient.Product) string {
+	if product == nil || product.Unit == "" {
+		return "UN"
+	}
+	return product.Unit
+}
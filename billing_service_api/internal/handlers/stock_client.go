@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient"
+)
+
+var (
+	stockClientMu sync.Mutex
+	stockClient   *stockclient.Client
+)
+
+// SetStockClient replaces the client every handler and job uses to reach the
+// stock service. The breaker and metrics live in the client, so it is shared.
+func SetStockClient(client *stockclient.Client) {
+	stockClientMu.Lock()
+	defer stockClientMu.Unlock()
+
+	stockClient = client
+}
+
+// stockAPI returns the shared client, built from the environment when main
+// did not set one, as in the import command.
+func stockAPI() *stockclient.Client {
+	stockClientMu.Lock()
+	defer stockClientMu.Unlock()
+
+	if stockClient == nil {
+		stockClient = stockclient.New(stockclient.ConfigFromEnv())
+	}
+	return stockClient
+}
+
+// requestContext is the context of the stock calls made by a request, on
+// behalf of its company.
+func requestContext(c *fiber.Ctx) context.Context {
+	return stockclient.WithCompany(c.UserContext(), tenant.CompanyID(c))
+}
+
+// jobContext is the context of the stock calls made by a job running for
+// the company of conn.
+func jobContext(conn *db.Conn) context.Context {
+	return stockclient.WithCompany(context.Background(), conn.CompanyID)
+}
+
+func stockItems(products []models.InvoiceProduct) []stockclient.Item {
+	items := make([]stockclient.Item, 0, len(products))
+	for _, product := range products {
+		items = append(items, stockclient.Item{ProductID: product.ProductID, Quantity: product.Amount})
+	}
+	return items
+}
+
+// UnknownProductsError lists the requested products the stock service does
+// not have.
+type UnknownProductsError struct {
+	IDs []string
+}
+
+func (e *UnknownProductsError) Error() string {
+	return fmt.Sprintf("unknown products: %s", strings.Join(e.IDs, ", "))
+}
+
+// GetStockClientMetrics reports the outcomes of calls to the stock service and
+// the state of the circuit breaker.
+func GetStockClientMetrics(c *fiber.Ctx) error {
+	return c.JSON(stockAPI().Metrics())
+}
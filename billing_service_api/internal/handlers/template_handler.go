@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+func CreateInvoiceTemplate(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	template := new(models.InvoiceTemplate)
+
+	if err := c.BodyParser(template); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid template data", "details": err.Error()})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(template); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	start, err := models.ParseDate(template.StartDate)
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "start_date must be YYYY-MM-DD"})
+	}
+	if template.EndDate != nil {
+		end, err := models.ParseDate(*template.EndDate)
+		if err != nil || end.Before(start) {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "end_date must be YYYY-MM-DD and not before start_date"})
+		}
+	}
+
+	if exists, err := customerExists(conn, template.CustomerID); err != nil || !exists {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Customer not found"})
+	}
+
+	if template.Interval < 1 {
+		template.Interval = 1
+	}
+	if template.Frequency == models.FrequenciaMensal && template.DayOfMonth == 0 {
+		template.DayOfMonth = start.Day()
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	template.ID = uuid.New()
+	template.Active = true
+	template.CreatedAt = now
+	template.UpdatedAt = now
+
+	tx, err := conn.Beginx()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+
+	query := `INSERT INTO invoice_templates (id, name, customer_id, payment_terms, frequency, interval_count, day_of_month, weekday, start_date, end_date, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+	_, err = tx.Exec(query, template.ID, template.Name, template.CustomerID, template.PaymentTerms, template.Frequency,
+		template.Interval, template.DayOfMonth, template.Weekday, template.StartDate, template.EndDate, template.Active,
+		template.CreatedAt, template.UpdatedAt)
+	if err != nil {
+		tx.Rollback()
+		log.Printf("Error inserting invoice template: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error creating template", "details": err.Error()})
+	}
+
+	lineQuery := `INSERT INTO invoice_template_products (template_id, product_id, amount, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
+	for i := range template.Products {
+		product := &template.Products[i]
+		product.TemplateID = template.ID
+		product.CreatedAt = now
+		if err := tx.QueryRow(lineQuery, product.TemplateID, product.ProductID, product.Amount, product.CreatedAt).Scan(&product.ID); err != nil {
+			tx.Rollback()
+			log.Printf("Error inserting invoice template product: %v", err)
+			return c.Status(500).JSON(fiber.Map{"error": "Error creating template products", "details": err.Error()})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(template)
+}
+
+func GetInvoiceTemplates(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	var templates []models.InvoiceTemplate
+	if err := conn.Select(&templates, "SELECT * FROM invoice_templates ORDER BY name ASC"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting templates"})
+	}
+
+	if err := loadTemplateProducts(conn, templates); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting template products"})
+	}
+
+	return c.JSON(templates)
+}
+
+func GetInvoiceTemplateById(c *fiber.Ctx) error {
+	template, err := findInvoiceTemplate(tenant.DB(c), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Template not found"})
+	}
+
+	return c.JSON(template)
+}
+
+// DeactivateInvoiceTemplate stops future occurrences. Invoices already
+// generated are kept.
+func DeactivateInvoiceTemplate(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	template, err := findInvoiceTemplate(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Template not found"})
+	}
+
+	template.Active = false
+	template.UpdatedAt = time.Now().Format(time.RFC3339)
+	_, err = conn.Exec("UPDATE invoice_templates SET active = FALSE, updated_at = $1 WHERE id = $2", template.UpdatedAt, template.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error deactivating template"})
+	}
+
+	return c.JSON(template)
+}
+
+// GetInvoiceTemplateOccurrences lists the invoices already generated from a
+// template and the next dates it is due (?upcoming=N, default 5).
+func GetInvoiceTemplateOccurrences(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	template, err := findInvoiceTemplate(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Template not found"})
+	}
+
+	limit := c.QueryInt("upcoming", 5)
+	if limit < 0 || limit > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "upcoming must be between 0 and 100"})
+	}
+
+	generated := []models.InvoiceOccurrence{}
+	err = conn.Select(&generated, "SELECT * FROM invoice_occurrences WHERE template_id = $1 ORDER BY scheduled_for DESC", template.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting occurrences"})
+	}
+
+	upcoming := []string{}
+	if template.Active {
+		from, err := nextPendingDate(conn, template.ID, time.Now())
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Error getting occurrences"})
+		}
+		for _, date := range template.Next(from, limit) {
+			upcoming = append(upcoming, date.Format("2006-01-02"))
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"template_id": template.ID,
+		"generated":   generated,
+		"upcoming":    upcoming,
+	})
+}
+
+// GenerateRecurringInvoices is the scheduler job. For every active template it
+// issues one invoice per occurrence due up to now that has not been generated
+// yet, oldest first, so missed runs are caught up after a restart.
+func GenerateRecurringInvoices(now time.Time) error {
+	return forEachCompany(func(conn *db.Conn) error {
+		return generateRecurringInvoices(conn, now)
+	})
+}
+
+func generateRecurringInvoices(conn *db.Conn, now time.Time) error {
+	var templates []models.InvoiceTemplate
+	if err := conn.Select(&templates, "SELECT * FROM invoice_templates WHERE active = TRUE"); err != nil {
+		return fmt.Errorf("error loading templates: %v", err)
+	}
+
+	if err := loadTemplateProducts(conn, templates); err != nil {
+		return fmt.Errorf("error loading template products: %v", err)
+	}
+
+	for i := range templates {
+		template := &templates[i]
+
+		from, err := nextPendingDate(conn, template.ID, time.Time{})
+		if err != nil {
+			return fmt.Errorf("error loading occurrences of template %s: %v", template.ID, err)
+		}
+
+		for _, date := range template.Occurrences(from, now) {
+			code, err := generateOccurrence(conn, template, date)
+			if err != nil {
+				// Later periods wait for this one so invoices stay in order.
+				log.Printf("Error generating invoice for template %s on %s: %v", template.ID, date.Format("2006-01-02"), err)
+				break
+			}
+			if code != "" {
+				log.Printf("Invoice %s generated from template %s for %s", code, template.ID, date.Format("2006-01-02"))
+			}
+		}
+	}
+
+	return nil
+}
+
+// generateOccurrence claims the (template, date) slot and creates its invoice
+// in the same transaction. It returns an empty code when another run already
+// generated that period.
+func generateOccurrence(conn *db.Conn, template *models.InvoiceTemplate, date time.Time) (string, error) {
+	tx, err := conn.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var occurrenceID uuid.UUID
+	err = tx.QueryRow(`INSERT INTO invoice_occurrences (template_id, scheduled_for, invoice_code)
+		VALUES ($1, $2, '') ON CONFLICT (template_id, scheduled_for) DO NOTHING RETURNING id`,
+		template.ID, date.Format("2006-01-02")).Scan(&occurrenceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	products := make([]models.InvoiceProduct, 0, len(template.Products))
+	for _, line := range template.Products {
+		products = append(products, models.InvoiceProduct{ProductID: line.ProductID, Amount: line.Amount})
+	}
+
+	invoice, err := insertInvoice(jobContext(conn), tx, ActorScheduler, &template.CustomerID, nil, products)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec("UPDATE invoice_occurrences SET invoice_code = $1 WHERE id = $2", invoice.Code, occurrenceID); err != nil {
+		releaseReservations(jobContext(conn), invoice)
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		releaseReservations(jobContext(conn), invoice)
+		return "", err
+	}
+
+	return invoice.Code, nil
+}
+
+// nextPendingDate returns the day after the last generated occurrence, or
+// fallback when the template has never been generated.
+func nextPendingDate(q db.Querier, templateID uuid.UUID, fallback time.Time) (time.Time, error) {
+	var last *string
+	err := q.Get(&last, "SELECT MAX(scheduled_for)::text FROM invoice_occurrences WHERE template_id = $1", templateID)
+	if err != nil || last == nil {
+		return fallback, err
+	}
+
+	date, err := models.ParseDate(*last)
+	if err != nil {
+		return fallback, err
+	}
+
+	next := date.AddDate(0, 0, 1)
+	if next.Before(fallback) {
+		return fallback, nil
+	}
+	return next, nil
+}
+
+func findInvoiceTemplate(q db.Querier, id string) (*models.InvoiceTemplate, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, err
+	}
+
+	var template models.InvoiceTemplate
+	if err := q.Get(&template, "SELECT * FROM invoice_templates WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+
+	templates := []models.InvoiceTemplate{template}
+	if err := loadTemplateProducts(q, templates); err != nil {
+		return nil, err
+	}
+
+	return &templates[0], nil
+}
+
+func loadTemplateProducts(q db.Querier, templates []models.InvoiceTemplate) error {
+	if len(templates) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(templates))
+	index := make(map[uuid.UUID]int, len(templates))
+	for i, template := range templates {
+		ids[i] = template.ID.String()
+		index[template.ID] = i
+	}
+
+	var products []models.InvoiceTemplateProduct
+	err := q.Select(&products, "SELECT * FROM invoice_template_products WHERE template_id = ANY($1::uuid[]) ORDER BY created_at", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+
+	for _, product := range products {
+		i := index[product.TemplateID]
+		templates[i].Products = append(templates[i].Products, product)
+	}
+
+	return nil
+}
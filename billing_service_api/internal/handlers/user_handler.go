@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUserExists is returned by CreateUserAccount for an email already taken.
+var ErrUserExists = errors.New("user with this email already exists")
+
+// CreateUserRequest is also what `billing_service_api create-user` takes.
+// bcrypt only uses the first 72 bytes of a password.
+type CreateUserRequest struct {
+	Name     string `json:"name" validate:"required"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+	Role     string `json:"role" validate:"required,oneof=viewer operator fiscal admin"`
+}
+
+// UpdateUserRequest changes only the fields given.
+type UpdateUserRequest struct {
+	Name     *string `json:"name" validate:"omitempty,min=1"`
+	Password *string `json:"password" validate:"omitempty,min=8,max=72"`
+	Role     *string `json:"role" validate:"omitempty,oneof=viewer operator fiscal admin"`
+	Active   *bool   `json:"active"`
+}
+
+func CreateUser(c *fiber.Ctx) error {
+	request := new(CreateUserRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	user, err := CreateUserAccount(tenant.CompanyID(c), *request)
+	if err != nil {
+		if errors.Is(err, ErrUserExists) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User with this email already exists"})
+		}
+		log.Printf("Error creating user: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Error creating user"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(user)
+}
+
+// CreateUserAccount stores a validated CreateUserRequest as a user of
+// companyID. Emails are unique across companies, since signing in finds the
+// company by the email.
+func CreateUserAccount(companyID string, request CreateUserRequest) (*models.User, error) {
+	company, err := uuid.Parse(companyID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid company id: %v", err)
+	}
+	email := normalizeEmail(request.Email)
+
+	var existingCount int
+	if err := db.System.QueryRow("SELECT COUNT(*) FROM users WHERE email = $1", email).Scan(&existingCount); err != nil {
+		return nil, err
+	}
+	if existingCount > 0 {
+		return nil, ErrUserExists
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	user := models.User{
+		ID:           uuid.New(),
+		CompanyID:    company,
+		Name:         request.Name,
+		Email:        email,
+		PasswordHash: string(hash),
+		Role:         auth.Role(request.Role),
+		Active:       true,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	_, err = db.System.Exec(`INSERT INTO users (id, company_id, name, email, password_hash, role, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		user.ID, user.CompanyID, user.Name, user.Email, user.PasswordHash, user.Role, user.Active, user.CreatedAt, user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func GetUsers(c *fiber.Ctx) error {
+	users := []models.User{}
+	if err := tenant.DB(c).Select(&users, "SELECT * FROM users ORDER BY name ASC"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting users"})
+	}
+	return c.JSON(users)
+}
+
+func GetUserById(c *fiber.Ctx) error {
+	user, err := findUser(tenant.DB(c), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	return c.JSON(user)
+}
+
+// UpdateUser changes the name, password, role or active flag of a user.
+// Anything but the name signs the user out of every session, though access
+// tokens already issued last until they expire. Admins can't demote or
+// deactivate themselves, so there is always one left.
+func UpdateUser(c *fiber.Ctx) error {
+	request := new(UpdateUserRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	user, err := findUser(tenant.DB(c), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if user.ID.String() == auth.CurrentUser(c).Subject &&
+		((request.Role != nil && auth.Role(*request.Role) != auth.RoleAdmin) || (request.Active != nil && !*request.Active)) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "You can't remove your own admin access"})
+	}
+
+	revoke := false
+	if request.Name != nil {
+		user.Name = *request.Name
+	}
+	if request.Password != nil {
+		hash, err := bcrypt.GenerateFromPassword([]byte(*request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Error hashing password"})
+		}
+		user.PasswordHash = string(hash)
+		revoke = true
+	}
+	if request.Role != nil && auth.Role(*request.Role) != user.Role {
+		user.Role = auth.Role(*request.Role)
+		revoke = true
+	}
+	if request.Active != nil && *request.Active != user.Active {
+		user.Active = *request.Active
+		revoke = true
+	}
+
+	tx, err := tenant.DB(c).Beginx()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	user.UpdatedAt = now.Format(time.RFC3339)
+	_, err = tx.Exec("UPDATE users SET name = $1, password_hash = $2, role = $3, active = $4, updated_at = $5 WHERE id = $6",
+		user.Name, user.PasswordHash, user.Role, user.Active, user.UpdatedAt, user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error updating user"})
+	}
+	if revoke {
+		if err := revokeRefreshTokens(tx, user.ID, now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Error revoking sessions"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+
+	return c.JSON(user)
+}
+
+func findUser(q db.Querier, id string) (*models.User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := q.Get(&user, "SELECT * FROM users WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
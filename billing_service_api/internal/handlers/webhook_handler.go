@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/webhooks"
+)
+
+// CreateWebhookRequest subscribes url to invoice.created, invoice.closed,
+// sent once the invoice is FECHADA, and invoice.cancelled.
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" validate:"required,http_url"`
+	EventTypes []string `json:"event_types" validate:"required,min=1,dive,oneof=invoice.created invoice.closed invoice.cancelled"`
+}
+
+// CreateWebhook registers a subscription and answers with its secret, which
+// is not shown again. Its URL must resolve to a public address.
+func CreateWebhook(c *fiber.Ctx) error {
+	request := new(CreateWebhookRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid webhook data", "details": err.Error()})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	if err := webhooks.CheckURL(c.UserContext(), request.URL); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Invalid webhook URL", "details": err.Error()})
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating webhook secret"})
+	}
+
+	subscription := models.WebhookSubscription{
+		ID:         uuid.New(),
+		URL:        request.URL,
+		EventTypes: request.EventTypes,
+		Secret:     secret,
+		Active:     true,
+		CreatedAt:  time.Now().Format(time.RFC3339),
+	}
+
+	_, err = tenant.DB(c).Exec(`INSERT INTO webhook_subscriptions (id, url, event_types, secret, active, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		subscription.ID, subscription.URL, subscription.EventTypes, subscription.Secret, subscription.Active, subscription.CreatedAt)
+	if err != nil {
+		log.Printf("Error creating webhook: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error creating webhook"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(subscription)
+}
+
+func GetWebhooks(c *fiber.Ctx) error {
+	subscriptions := []models.WebhookSubscription{}
+	if err := tenant.DB(c).Select(&subscriptions, "SELECT * FROM webhook_subscriptions ORDER BY created_at DESC"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting webhooks"})
+	}
+
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	return c.JSON(subscriptions)
+}
+
+func GetWebhookById(c *fiber.Ctx) error {
+	subscription, err := findWebhook(tenant.DB(c), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Webhook not found"})
+	}
+
+	subscription.Secret = ""
+	return c.JSON(subscription)
+}
+
+// DeactivateWebhook stops new deliveries to a subscription. Its delivery log
+// is kept.
+func DeactivateWebhook(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	subscription, err := findWebhook(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Webhook not found"})
+	}
+
+	if _, err := conn.Exec("UPDATE webhook_subscriptions SET active = FALSE WHERE id = $1", subscription.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error deactivating webhook"})
+	}
+
+	subscription.Active = false
+	subscription.Secret = ""
+	return c.JSON(subscription)
+}
+
+func GetWebhookDeliveries(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	subscription, err := findWebhook(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Webhook not found"})
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	err = conn.Select(&deliveries, "SELECT * FROM webhook_deliveries WHERE subscription_id = $1 ORDER BY created_at DESC", subscription.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting webhook deliveries"})
+	}
+
+	return c.JSON(deliveries)
+}
+
+// RedeliverWebhook queues a new delivery of the same event to the same
+// subscription as an earlier one, whatever its status. The original entry is
+// kept in the log.
+func RedeliverWebhook(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Webhook delivery not found"})
+	}
+
+	var original models.WebhookDelivery
+	if err := conn.Get(&original, "SELECT * FROM webhook_deliveries WHERE id = $1", id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Webhook delivery not found"})
+	}
+
+	subscription, err := findWebhook(conn, original.SubscriptionID.String())
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Webhook not found"})
+	}
+	if !subscription.Active {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Webhook is not active"})
+	}
+
+	delivery, err := insertWebhookDelivery(conn, subscription.ID, original.EventID, original.EventType, original.Payload)
+	if err != nil {
+		log.Printf("Error queueing webhook redelivery: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error queueing webhook delivery"})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(delivery)
+}
+
+// DeliverPendingWebhooks is the scheduler job. Each due delivery is claimed
+// with SKIP LOCKED and sent outside any transaction, company by company, so a
+// slow subscriber holds no connection while it answers.
+func DeliverPendingWebhooks(now time.Time) error {
+	client := webhooks.NewClient()
+	maxAttempts := webhooks.MaxAttempts()
+
+	return forEachCompanyID(func(companyID string) error {
+		return webhooks.DeliverDue(context.Background(), companyTx(companyID), client, now, maxAttempts)
+	}, "SELECT DISTINCT company_id FROM webhook_deliveries WHERE status = $1 AND next_attempt_at <= $2", models.EnvioPendente, now)
+}
+
+// enqueueWebhooks queues a delivery of the event for every active
+// subscription to its type, through q so it joins the transaction of the
+// change it reports.
+func enqueueWebhooks(q sqlx.Ext, event events.Event) error {
+	var subscriptions []uuid.UUID
+	err := sqlx.Select(q, &subscriptions, "SELECT id FROM webhook_subscriptions WHERE active AND $1 = ANY(event_types)", event.Type)
+	if err != nil || len(subscriptions) == 0 {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if _, err := insertWebhookDelivery(q, subscription, event.ID, event.Type, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertWebhookDelivery(q sqlx.Execer, subscriptionID uuid.UUID, eventID, eventType string, payload []byte) (*models.WebhookDelivery, error) {
+	now := time.Now().Format(time.RFC3339)
+	delivery := models.WebhookDelivery{
+		ID:             uuid.New(),
+		SubscriptionID: subscriptionID,
+		EventID:        eventID,
+		EventType:      eventType,
+		Payload:        payload,
+		Status:         models.EnvioPendente,
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+	}
+
+	_, err := q.Exec(`INSERT INTO webhook_deliveries (id, subscription_id, event_id, event_type, payload, status, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		delivery.ID, delivery.SubscriptionID, delivery.EventID, delivery.EventType, []byte(delivery.Payload), delivery.Status, delivery.NextAttemptAt, delivery.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func findWebhook(q db.Querier, id string) (*models.WebhookSubscription, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, err
+	}
+
+	var subscription models.WebhookSubscription
+	if err := q.Get(&subscription, "SELECT * FROM webhook_subscriptions WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
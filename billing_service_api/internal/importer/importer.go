@@ -0,0 +1,252 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+type Mode string
+
+const (
+	// ModeDryRun only validates and reports, nothing is written.
+	ModeDryRun Mode = "dry-run"
+	// ModeAllOrNothing commits every invoice in a single transaction, or none
+	// of them when any row is invalid.
+	ModeAllOrNothing Mode = "all-or-nothing"
+	// ModePartial commits every valid invoice and rejects the invalid ones.
+	ModePartial Mode = "partial"
+)
+
+const (
+	InvoiceCriada     = "CRIADA"
+	InvoiceValida     = "VALIDA"
+	InvoiceRejeitada  = "REJEITADA"
+	InvoiceNaoGravada = "NAO_GRAVADA"
+)
+
+// Row is one invoice line of the import file. Rows sharing InvoiceRef become
+// a single invoice.
+type Row struct {
+	Line       int    `json:"line"`
+	InvoiceRef string `json:"invoice_ref"`
+	CustomerID string `json:"customer_id,omitempty"`
+	ProductID  string `json:"product_id"`
+	Amount     int    `json:"amount"`
+}
+
+type RowError struct {
+	Line       int    `json:"line"`
+	InvoiceRef string `json:"invoice_ref,omitempty"`
+	Field      string `json:"field,omitempty"`
+	Message    string `json:"message"`
+}
+
+type Group struct {
+	Ref        string
+	CustomerID *uuid.UUID
+	Rows       []Row
+}
+
+type InvoiceResult struct {
+	InvoiceRef  string `json:"invoice_ref"`
+	Status      string `json:"status"`
+	InvoiceCode string `json:"invoice_code,omitempty"`
+	Lines       int    `json:"lines"`
+}
+
+type Report struct {
+	Mode            Mode            `json:"mode"`
+	TotalRows       int             `json:"total_rows"`
+	InvoicesCreated int             `json:"invoices_created"`
+	Invoices        []InvoiceResult `json:"invoices"`
+	Errors          []RowError      `json:"errors"`
+}
+
+func ParseMode(value string) (Mode, error) {
+	switch Mode(value) {
+	case "":
+		return ModeAllOrNothing, nil
+	case ModeDryRun, ModeAllOrNothing, ModePartial:
+		return Mode(value), nil
+	}
+	return "", fmt.Errorf("unknown import mode %q", value)
+}
+
+// DetectFormat picks the format from an explicit value, then from the file
+// name extension, then from the content type. CSV is the default.
+func DetectFormat(explicit, filename, contentType string) (Format, error) {
+	switch strings.ToLower(explicit) {
+	case "csv":
+		return FormatCSV, nil
+	case "jsonl", "ndjson":
+		return FormatJSONL, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown import format %q", explicit)
+	}
+
+	name := strings.ToLower(filename)
+	if strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".ndjson") || strings.Contains(contentType, "ndjson") || strings.Contains(contentType, "jsonl") {
+		return FormatJSONL, nil
+	}
+	return FormatCSV, nil
+}
+
+// Parse reads every row of the file. Rows that cannot be read are reported as
+// RowErrors; only I/O failures abort parsing.
+func Parse(data []byte, format Format) ([]Row, []RowError, error) {
+	if format == FormatJSONL {
+		return parseJSONL(data)
+	}
+	return parseCSV(data)
+}
+
+func parseCSV(data []byte) ([]Row, []RowError, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading CSV header: %v", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"invoice_ref", "product_id", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("CSV header is missing column %q", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []Row
+	var errs []RowError
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, RowError{Line: line, Message: err.Error()})
+			continue
+		}
+
+		row := Row{
+			Line:       line,
+			InvoiceRef: field(record, "invoice_ref"),
+			CustomerID: field(record, "customer_id"),
+			ProductID:  field(record, "product_id"),
+		}
+
+		amount, err := strconv.Atoi(field(record, "amount"))
+		if err != nil {
+			errs = append(errs, RowError{Line: line, InvoiceRef: row.InvoiceRef, Field: "amount", Message: "amount must be an integer"})
+			continue
+		}
+		row.Amount = amount
+
+		rows = append(rows, row)
+	}
+
+	return rows, errs, nil
+}
+
+func parseJSONL(data []byte) ([]Row, []RowError, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []Row
+	var errs []RowError
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var row Row
+		if err := json.Unmarshal([]byte(text), &row); err != nil {
+			errs = append(errs, RowError{Line: line, Message: fmt.Sprintf("invalid JSON: %v", err)})
+			continue
+		}
+		row.Line = line
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading JSONL: %v", err)
+	}
+
+	return rows, errs, nil
+}
+
+// GroupRows validates each row on its own and groups the rows into invoices,
+// keeping the order in which references first appear in the file.
+func GroupRows(rows []Row) ([]Group, []RowError) {
+	var groups []Group
+	var errs []RowError
+	index := make(map[string]int)
+
+	for _, row := range rows {
+		if row.InvoiceRef == "" {
+			errs = append(errs, RowError{Line: row.Line, Field: "invoice_ref", Message: "invoice_ref is required"})
+			continue
+		}
+		if row.ProductID == "" {
+			errs = append(errs, RowError{Line: row.Line, InvoiceRef: row.InvoiceRef, Field: "product_id", Message: "product_id is required"})
+		}
+		if row.Amount < 1 {
+			errs = append(errs, RowError{Line: row.Line, InvoiceRef: row.InvoiceRef, Field: "amount", Message: "amount must be at least 1"})
+		}
+
+		i, ok := index[row.InvoiceRef]
+		if !ok {
+			i = len(groups)
+			index[row.InvoiceRef] = i
+			groups = append(groups, Group{Ref: row.InvoiceRef})
+		}
+		group := &groups[i]
+
+		if row.CustomerID != "" {
+			customerID, err := uuid.Parse(row.CustomerID)
+			switch {
+			case err != nil:
+				errs = append(errs, RowError{Line: row.Line, InvoiceRef: row.InvoiceRef, Field: "customer_id", Message: "customer_id must be a UUID"})
+			case group.CustomerID == nil:
+				group.CustomerID = &customerID
+			case *group.CustomerID != customerID:
+				errs = append(errs, RowError{Line: row.Line, InvoiceRef: row.InvoiceRef, Field: "customer_id", Message: "rows of the same invoice must share the customer"})
+			}
+		}
+
+		group.Rows = append(group.Rows, row)
+	}
+
+	return groups, errs
+}
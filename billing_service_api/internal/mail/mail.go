@@ -0,0 +1,161 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Events with a template of their own in templates/<event>.tmpl.
+const (
+	EventIssued    = "issued"
+	EventCancelled = "cancelled"
+	EventCorrected = "corrected"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+type Config struct {
+	Host         string
+	Port         string
+	Username     string
+	Password     string
+	From         string
+	TemplatesDir string
+}
+
+// LoadConfig reads the SMTP settings. With no SMTP_USERNAME the connection is
+// unauthenticated, which is what local catchers such as MailHog expect.
+func LoadConfig() Config {
+	config := Config{
+		Host:         os.Getenv("SMTP_HOST"),
+		Port:         os.Getenv("SMTP_PORT"),
+		Username:     os.Getenv("SMTP_USERNAME"),
+		Password:     os.Getenv("SMTP_PASSWORD"),
+		From:         os.Getenv("SMTP_FROM"),
+		TemplatesDir: os.Getenv("EMAIL_TEMPLATES_DIR"),
+	}
+	if config.Host == "" {
+		config.Host = "localhost"
+	}
+	if config.Port == "" {
+		config.Port = "1025"
+	}
+	if config.From == "" {
+		config.From = "nfe@localhost"
+	}
+	return config
+}
+
+func IsEvent(event string) bool {
+	return event == EventIssued || event == EventCancelled || event == EventCorrected
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+type Message struct {
+	To          []string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Render executes the "subject" and "body" templates of an event. Templates
+// in EMAIL_TEMPLATES_DIR take precedence over the embedded defaults.
+func (c Config) Render(event string, data interface{}) (string, string, error) {
+	if !IsEvent(event) {
+		return "", "", fmt.Errorf("unknown email event %q", event)
+	}
+
+	name := event + ".tmpl"
+	var tmpl *template.Template
+	var err error
+	if c.TemplatesDir != "" {
+		if _, statErr := os.Stat(filepath.Join(c.TemplatesDir, name)); statErr == nil {
+			tmpl, err = template.ParseFiles(filepath.Join(c.TemplatesDir, name))
+		}
+	}
+	if tmpl == nil && err == nil {
+		tmpl, err = template.ParseFS(defaultTemplates, "templates/"+name)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("error parsing %s template: %v", event, err)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
+
+// Send delivers the message over SMTP as multipart/mixed with a text body and
+// base64 encoded attachments.
+func (c Config) Send(msg Message) error {
+	if len(msg.To) == 0 {
+		return fmt.Errorf("message has no recipients")
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	fmt.Fprintf(&body, "From: %s\r\n", c.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(msg.To, ", "))
+	fmt.Fprintf(&body, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&body, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&body, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	text, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	if err != nil {
+		return err
+	}
+	text.Write([]byte(msg.Body))
+
+	for _, attachment := range msg.Attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf("%s; name=%q", attachment.ContentType, attachment.Filename)},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", attachment.Filename)},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	writer.Close()
+
+	var auth smtp.Auth
+	if c.Username != "" {
+		auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+
+	return smtp.SendMail(c.Host+":"+c.Port, auth, c.From, msg.To, body.Bytes())
+}
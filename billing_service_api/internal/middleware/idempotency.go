@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/billing_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/scheduler"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// replayedHeaders are the response headers stored with the body and sent
+// again on replay.
+var replayedHeaders = []string{fiber.HeaderContentType, fiber.HeaderETag, fiber.HeaderLocation}
+
+// Idempotency makes POST, PUT, PATCH and DELETE requests carrying an
+// Idempotency-Key header safe to retry. The first request runs normally and
+// its response is stored; retries with the same key and payload get that
+// response back, while a different payload under the same key gets 422.
+// Server errors are not stored, so the request can be retried for real. Keys
+// belong to the user or API key making the request, within its company, so
+// two clients picking the same key don't see each other's responses; the
+// calls of other services share one set per company. Requests with no
+// company are not deduplicated.
+func Idempotency() fiber.Handler {
+	ttl := scheduler.IntervalFromEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" || !isMutating(c.Method()) || tenant.CompanyID(c) == "" {
+			return c.Next()
+		}
+		if len(key) > 255 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Idempotency-Key must be at most 255 characters"})
+		}
+
+		conn := tenant.DB(c)
+		principal := requestPrincipal(c)
+		fingerprint := requestFingerprint(c)
+		now := time.Now()
+
+		claimed, err := claimKey(conn, principal, key, fingerprint, now, ttl)
+		if err != nil {
+			log.Printf("Error storing idempotency key: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error checking idempotency key"})
+		}
+
+		if !claimed {
+			var stored models.IdempotencyKey
+			if err := conn.Get(&stored, "SELECT * FROM idempotency_keys WHERE principal = $1 AND key = $2", principal, key); err != nil {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Request with this Idempotency-Key is being processed, retry later"})
+			}
+			if stored.Fingerprint != fingerprint {
+				return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Idempotency-Key was already used with a different request"})
+			}
+			if stored.Status != models.IdempotenciaConcluida {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Request with this Idempotency-Key is being processed, retry later"})
+			}
+			return replay(c, stored)
+		}
+
+		if err := c.Next(); err != nil {
+			releaseKey(conn, principal, key)
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if status >= fiber.StatusInternalServerError {
+			releaseKey(conn, principal, key)
+			return nil
+		}
+
+		headers := make(map[string]string)
+		for _, name := range replayedHeaders {
+			if value := c.GetRespHeader(name); value != "" {
+				headers[name] = value
+			}
+		}
+		encodedHeaders, _ := json.Marshal(headers)
+
+		_, err = conn.Exec(`UPDATE idempotency_keys SET status = $1, response_status = $2, response_headers = $3, response_body = $4
+			WHERE principal = $5 AND key = $6`,
+			models.IdempotenciaConcluida, status, encodedHeaders, c.Response().Body(), principal, key)
+		if err != nil {
+			log.Printf("Error saving response for idempotency key %s: %v", key, err)
+			releaseKey(conn, principal, key)
+		}
+
+		return nil
+	}
+}
+
+// PurgeExpiredIdempotencyKeys is the scheduler job that drops expired keys.
+func PurgeExpiredIdempotencyKeys(now time.Time) error {
+	_, err := db.System.Exec("DELETE FROM idempotency_keys WHERE expires_at <= $1", now)
+	return err
+}
+
+// claimKey inserts the key as PROCESSANDO. An expired key left behind by the
+// purge job is replaced. It returns false when the key is already in use.
+func claimKey(q db.Querier, principal, key, fingerprint string, now time.Time, ttl time.Duration) (bool, error) {
+	result, err := q.Exec(`INSERT INTO idempotency_keys (principal, key, fingerprint, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (company_id, principal, key) DO UPDATE SET fingerprint = EXCLUDED.fingerprint, status = EXCLUDED.status,
+			response_status = 0, response_headers = '{}', response_body = NULL,
+			created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= $5`,
+		principal, key, fingerprint, models.IdempotenciaProcessando, now, now.Add(ttl))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func releaseKey(q db.Querier, principal, key string) {
+	if _, err := q.Exec("DELETE FROM idempotency_keys WHERE principal = $1 AND key = $2 AND status = $3",
+		principal, key, models.IdempotenciaProcessando); err != nil {
+		log.Printf("Error releasing idempotency key %s: %v", key, err)
+	}
+}
+
+func replay(c *fiber.Ctx, stored models.IdempotencyKey) error {
+	var headers map[string]string
+	if err := json.Unmarshal(stored.ResponseHeaders, &headers); err == nil {
+		for name, value := range headers {
+			c.Set(name, value)
+		}
+	}
+	c.Set("Idempotent-Replayed", "true")
+	return c.Status(stored.ResponseStatus).Send(stored.ResponseBody)
+}
+
+// requestPrincipal names who owns the keys of the request: the user or API
+// key signed in, or "" for the calls of other services.
+func requestPrincipal(c *fiber.Ctx) string {
+	if user := auth.CurrentUser(c); user != nil {
+		return user.Subject
+	}
+	return ""
+}
+
+// requestFingerprint hashes what makes two requests the same operation:
+// method, path with query string and body.
+func requestFingerprint(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method()))
+	hash.Write([]byte{0})
+	hash.Write([]byte(c.OriginalURL()))
+	hash.Write([]byte{0})
+	hash.Write(c.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/billing_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+// useTestDatabase points db.DB and db.System at TEST_DATABASE_URL, a database
+// set up by init-scripts/init-billing-db.sql and reached as billing_user,
+// since row-level security does not bind superusers. The tests needing it
+// are skipped when it is not set.
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	conn, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	system, err := sqlx.Connect("postgres", url+separator+"options=-c%20app.all_companies%3Don")
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+
+	previousDB, previousSystem := db.DB, db.System
+	db.DB, db.System = conn, system
+	t.Cleanup(func() {
+		db.DB, db.System = previousDB, previousSystem
+		conn.Close()
+		system.Close()
+	})
+}
+
+// createTestCompany inserts a company and returns its ID.
+func createTestCompany(t *testing.T) string {
+	t.Helper()
+
+	var id string
+	cnpj := fmt.Sprintf("%014d", rand.Int63n(1e14))
+	if err := db.System.Get(&id, "INSERT INTO companies (name, cnpj) VALUES ('Test company', $1) RETURNING id", cnpj); err != nil {
+		t.Fatalf("creating company: %v", err)
+	}
+	return id
+}
+
+func TestIdempotencyKeysBelongToTheirUser(t *testing.T) {
+	useTestDatabase(t)
+
+	config := auth.Config{Secret: []byte(strings.Repeat("s", 32)), Issuer: "nota_fiscal", AccessTTL: time.Hour}
+	companyID := createTestCompany(t)
+
+	var calls atomic.Int32
+	app := fiber.New()
+	app.Use(auth.Authenticate(config, nil, nil, func(*fiber.Ctx) bool { return false }))
+	app.Use(tenant.Middleware(db.DB, nil))
+	app.Use(Idempotency())
+	app.Post("/invoices", func(c *fiber.Ctx) error {
+		call := calls.Add(1)
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"user": auth.CurrentUser(c).Subject, "call": call})
+	})
+
+	key := uuid.NewString()
+	post := func(userID string) (string, string) {
+		t.Helper()
+
+		token, _, err := config.IssueAccessToken(userID, userID+"@example.com", companyID, auth.RoleOperator, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := httptest.NewRequest(fiber.MethodPost, "/invoices", strings.NewReader(`{"amount":10}`))
+		request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		request.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		request.Header.Set(IdempotencyKeyHeader, key)
+
+		response, err := app.Test(request, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		if response.StatusCode != fiber.StatusCreated {
+			t.Fatalf("POST as %s = %d %s, want 201", userID, response.StatusCode, body)
+		}
+		return string(body), response.Header.Get("Idempotent-Replayed")
+	}
+
+	first, second := uuid.NewString(), uuid.NewString()
+	firstBody, _ := post(first)
+	secondBody, replayed := post(second)
+	if replayed != "" || secondBody == firstBody {
+		t.Errorf("another user of the company got the response of the first one: %s", secondBody)
+	}
+
+	retryBody, replayed := post(first)
+	if replayed != "true" || retryBody != firstBody {
+		t.Errorf("retry of the first user = %s (replayed %q), want %s replayed", retryBody, replayed, firstBody)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("handler ran %d times, want 2", calls.Load())
+	}
+}
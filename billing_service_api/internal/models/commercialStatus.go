@@ -0,0 +1,27 @@
+package models
+
+// StatusComercial is the negotiation status shared by quotes and sales orders.
+type StatusComercial string
+
+const (
+	StatusRascunho StatusComercial = "RASCUNHO"
+	StatusEnviado  StatusComercial = "ENVIADO"
+	StatusAceito   StatusComercial = "ACEITO"
+	StatusExpirado StatusComercial = "EXPIRADO"
+)
+
+var commercialTransitions = map[StatusComercial][]StatusComercial{
+	StatusRascunho: {StatusEnviado, StatusExpirado},
+	StatusEnviado:  {StatusAceito, StatusExpirado},
+}
+
+// CanTransition reports whether a quote or order may move from one status to
+// the other. ACEITO and EXPIRADO are final.
+func (s StatusComercial) CanTransition(to StatusComercial) bool {
+	for _, next := range commercialTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/billing_service_api/internal/fiscal"
+)
+
+// Company issues invoices. Every other record belongs to one company and is
+// seen only by its users and jobs.
+type Company struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	Name       string    `json:"name" db:"name" validate:"required"`
+	TradeName  string    `json:"trade_name" db:"trade_name"`
+	CNPJ       string    `json:"cnpj" db:"cnpj" validate:"required,len=14,numeric"`
+	IE         string    `json:"ie" db:"ie" validate:"omitempty,numeric"`
+	UF         string    `json:"uf" db:"uf" validate:"omitempty,len=2,alpha"`
+	CityCode   string    `json:"city_code" db:"city_code" validate:"omitempty,len=7,numeric"`
+	ZipCode    string    `json:"zip_code" db:"zip_code" validate:"omitempty,len=8,numeric"`
+	Street     string    `json:"street" db:"street"`
+	Number     string    `json:"number" db:"number"`
+	Complement string    `json:"complement" db:"complement"`
+	District   string    `json:"district" db:"district"`
+	Phone      string    `json:"phone" db:"phone"`
+	Email      string    `json:"email" db:"email"`
+	Series     string    `json:"series" db:"series" validate:"required,max=3,numeric"`
+	Industrial bool      `json:"industrial" db:"industrial"`
+	CreatedAt  string    `json:"created_at,omitempty" db:"created_at"`
+}
+
+// Issuer is the company as the issuer of NF-e and SPED files.
+func (c Company) Issuer() fiscal.Company {
+	return fiscal.Company{
+		Name:          c.Name,
+		TradeName:     c.TradeName,
+		CNPJ:          c.CNPJ,
+		IE:            c.IE,
+		UF:            c.UF,
+		CityCode:      c.CityCode,
+		ZipCode:       c.ZipCode,
+		Street:        c.Street,
+		Number:        c.Number,
+		Complement:    c.Complement,
+		District:      c.District,
+		Phone:         c.Phone,
+		Email:         c.Email,
+		Series:        c.Series,
+		IndustrialAct: c.Industrial,
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+type Customer struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	CompanyID  uuid.UUID `json:"company_id" db:"company_id"`
+	Name       string    `json:"name" db:"name" validate:"required"`
+	Document   string    `json:"document" db:"document" validate:"required,min=11,max=14,numeric"`
+	Email      string    `json:"email" db:"email"` // comma separated list of addresses
+	IE         string    `json:"ie" db:"ie" validate:"omitempty,numeric"`
+	UF         string    `json:"uf" db:"uf" validate:"omitempty,len=2,alpha"`
+	CityCode   string    `json:"city_code" db:"city_code" validate:"omitempty,len=7,numeric"`
+	Street     string    `json:"street" db:"street"`
+	Number     string    `json:"number" db:"number"`
+	Complement string    `json:"complement" db:"complement"`
+	District   string    `json:"district" db:"district"`
+	CreatedAt  string    `json:"created_at,omitempty" db:"created_at"`
+}
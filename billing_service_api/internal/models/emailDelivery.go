@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type StatusEnvio string
+
+const (
+	EnvioPendente StatusEnvio = "PENDENTE"
+	EnvioEnviado  StatusEnvio = "ENVIADO"
+	EnvioFalhou   StatusEnvio = "FALHOU"
+)
+
+// EmailDelivery is one entry of the email log. Failed sends stay PENDENTE with
+// a later NextAttemptAt until they run out of attempts and become FALHOU.
+type EmailDelivery struct {
+	ID            uuid.UUID      `json:"id" db:"id"`
+	CompanyID     uuid.UUID      `json:"company_id" db:"company_id"`
+	InvoiceCode   string         `json:"invoice_code" db:"invoice_code"`
+	Event         string         `json:"event" db:"event"`
+	Recipients    pq.StringArray `json:"recipients" db:"recipients"`
+	Subject       string         `json:"subject" db:"subject"`
+	Status        StatusEnvio    `json:"status" db:"status"`
+	Attempts      int            `json:"attempts" db:"attempts"`
+	LastError     *string        `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt string         `json:"next_attempt_at" db:"next_attempt_at"`
+	SentAt        *string        `json:"sent_at,omitempty" db:"sent_at"`
+	CreatedAt     string         `json:"created_at,omitempty" db:"created_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type StatusIdempotencia string
+
+const (
+	IdempotenciaProcessando StatusIdempotencia = "PROCESSANDO"
+	IdempotenciaConcluida   StatusIdempotencia = "CONCLUIDA"
+)
+
+// IdempotencyKey stores the first response given to a request carrying an
+// Idempotency-Key header so retries can be answered with it.
+type IdempotencyKey struct {
+	CompanyID       uuid.UUID          `json:"company_id" db:"company_id"`
+	Principal       string             `json:"principal" db:"principal"`
+	Key             string             `json:"key" db:"key"`
+	Fingerprint     string             `json:"fingerprint" db:"fingerprint"`
+	Status          StatusIdempotencia `json:"status" db:"status"`
+	ResponseStatus  int                `json:"response_status" db:"response_status"`
+	ResponseHeaders json.RawMessage    `json:"response_headers" db:"response_headers"`
+	ResponseBody    []byte             `json:"response_body" db:"response_body"`
+	CreatedAt       string             `json:"created_at" db:"created_at"`
+	ExpiresAt       string             `json:"expires_at" db:"expires_at"`
+}
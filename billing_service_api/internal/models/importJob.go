@@ -0,0 +1,34 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type StatusImportacao string
+
+const (
+	ImportPendente    StatusImportacao = "PENDENTE"
+	ImportProcessando StatusImportacao = "PROCESSANDO"
+	ImportConcluida   StatusImportacao = "CONCLUIDA"
+	ImportFalhou      StatusImportacao = "FALHOU"
+)
+
+// ImportJob tracks a bulk invoice import. ProcessedRows grows while the job
+// runs; Report holds the final validation report once it finishes.
+type ImportJob struct {
+	ID              uuid.UUID        `json:"id" db:"id"`
+	CompanyID       uuid.UUID        `json:"company_id" db:"company_id"`
+	Status          StatusImportacao `json:"status" db:"status"`
+	Mode            string           `json:"mode" db:"mode"`
+	Format          string           `json:"format" db:"format"`
+	Phase           string           `json:"phase" db:"phase"`
+	TotalRows       int              `json:"total_rows" db:"total_rows"`
+	ProcessedRows   int              `json:"processed_rows" db:"processed_rows"`
+	InvoicesCreated int              `json:"invoices_created" db:"invoices_created"`
+	ErrorCount      int              `json:"error_count" db:"error_count"`
+	Report          *json.RawMessage `json:"report,omitempty" db:"report"`
+	CreatedAt       string           `json:"created_at,omitempty" db:"created_at"`
+	FinishedAt      *string          `json:"finished_at,omitempty" db:"finished_at"`
+}
@@ -6,17 +6,33 @@ import (
 
 type StatusNota string
 
+// An invoice being closed stays FECHANDO until the stock service confirms the
+// deduction (FECHADA) or rejects it (FALHA_ESTOQUE, which can be closed again).
+// Invoices that were never closed can be CANCELADA, releasing their stock.
 const (
-	StatusAberto  StatusNota = "ABERTO"
-	StatusFechado StatusNota = "FECHADA"
+	StatusAberto       StatusNota = "ABERTO"
+	StatusFechando     StatusNota = "FECHANDO"
+	StatusFechado      StatusNota = "FECHADA"
+	StatusFalhaEstoque StatusNota = "FALHA_ESTOQUE"
+	StatusCancelada    StatusNota = "CANCELADA"
 )
 
 type Invoice struct {
-	ID         uuid.UUID        `json:"id" db:"id"`
-	Code       string           `json:"code" db:"code"`
-	Status     StatusNota       `json:"status" db:"status"`
-	TotalValue float64          `json:"totalValue" db:"total_value"`
-	Products   []InvoiceProduct `json:"products"`
-	CreatedAt  string           `json:"created_at,omitempty" db:"created_at"`
-	UpdatedAt  string           `json:"updated_at,omitempty" db:"updated_at"`
-}
\ No newline at end of file
+	ID            uuid.UUID        `json:"id" db:"id"`
+	CompanyID     uuid.UUID        `json:"company_id" db:"company_id"`
+	Code          string           `json:"code" db:"code"`
+	Number        int64            `json:"number" db:"number"`
+	Series        string           `json:"series" db:"series"`
+	AccessKey     *string          `json:"access_key,omitempty" db:"access_key"`
+	Status        StatusNota       `json:"status" db:"status"`
+	TotalValue    float64          `json:"totalValue" db:"total_value"`
+	ICMSValue     float64          `json:"icms_value" db:"icms_value"`
+	CustomerID    *uuid.UUID       `json:"customer_id,omitempty" db:"customer_id"`
+	OrderID       *uuid.UUID       `json:"order_id,omitempty" db:"order_id"`
+	ReservationID *uuid.UUID       `json:"reservation_id,omitempty" db:"reservation_id"`
+	Products      []InvoiceProduct `json:"products"`
+	CreatedAt     string           `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt     string           `json:"updated_at,omitempty" db:"updated_at"`
+	ClosedAt      *string          `json:"closed_at,omitempty" db:"closed_at"`
+	Version       int              `json:"version" db:"version"`
+}
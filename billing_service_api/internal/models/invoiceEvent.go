@@ -0,0 +1,34 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type AcaoNota string
+
+const (
+	AcaoCriada               AcaoNota = "CRIADA"
+	AcaoFechamentoSolicitado AcaoNota = "FECHAMENTO_SOLICITADO"
+	AcaoFechada              AcaoNota = "FECHADA"
+	AcaoFalhaEstoque         AcaoNota = "FALHA_ESTOQUE"
+	AcaoCancelada            AcaoNota = "CANCELADA"
+	AcaoCorrigida            AcaoNota = "CORRIGIDA"
+)
+
+// InvoiceEvent is one append-only entry of the invoice audit log. Hash covers
+// the entry and PrevHash, chaining every entry to the one written before it.
+type InvoiceEvent struct {
+	ID          int64            `json:"id" db:"id"`
+	CompanyID   uuid.UUID        `json:"company_id" db:"company_id"`
+	InvoiceCode string           `json:"invoice_code" db:"invoice_code"`
+	Actor       string           `json:"actor" db:"actor"`
+	Action      AcaoNota         `json:"action" db:"action"`
+	Reason      string           `json:"reason,omitempty" db:"reason"`
+	Before      *json.RawMessage `json:"before" db:"before"`
+	After       *json.RawMessage `json:"after" db:"after"`
+	PrevHash    string           `json:"prev_hash" db:"prev_hash"`
+	Hash        string           `json:"hash" db:"hash"`
+	CreatedAt   string           `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+// InvoiceOccurrence records the invoice generated for one period of a
+// template. (template_id, scheduled_for) is unique, which makes generation
+// idempotent across scheduler runs and instances.
+type InvoiceOccurrence struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	CompanyID    uuid.UUID `json:"company_id" db:"company_id"`
+	TemplateID   uuid.UUID `json:"template_id" db:"template_id"`
+	ScheduledFor string    `json:"scheduled_for" db:"scheduled_for"`
+	InvoiceCode  string    `json:"invoice_code" db:"invoice_code"`
+	CreatedAt    string    `json:"created_at,omitempty" db:"created_at"`
+}
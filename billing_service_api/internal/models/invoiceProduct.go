@@ -6,8 +6,16 @@ import (
 
 type InvoiceProduct struct {
 	ID          uuid.UUID `json:"id" db:"id"`
+	CompanyID   uuid.UUID `json:"company_id" db:"company_id"`
 	InvoiceCode string    `json:"invoice_code" db:"invoice_code"`
 	ProductID   string    `json:"product_id" db:"product_id"`
 	Amount      int       `json:"amount" db:"amount"`
+	UnitPrice   float64   `json:"unit_price" db:"unit_price"`
+	TotalValue  float64   `json:"total_value" db:"total_value"`
+	CFOP        string    `json:"cfop" db:"cfop"`
+	CSTICMS     string    `json:"cst_icms" db:"cst_icms"`
+	ICMSBase    float64   `json:"icms_base" db:"icms_base"`
+	ICMSRate    float64   `json:"icms_rate" db:"icms_rate"`
+	ICMSValue   float64   `json:"icms_value" db:"icms_value"`
 	CreatedAt   string    `json:"created_at,omitempty" db:"created_at"`
 }
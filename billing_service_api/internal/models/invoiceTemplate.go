@@ -0,0 +1,123 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Frequencia string
+
+const (
+	FrequenciaDiaria  Frequencia = "DIARIA"
+	FrequenciaSemanal Frequencia = "SEMANAL"
+	FrequenciaMensal  Frequencia = "MENSAL"
+)
+
+// InvoiceTemplate describes an invoice that is issued again on every
+// occurrence of its recurrence rule, between StartDate and EndDate.
+// Weekday, 0 for Sunday, is required by weekly templates and refused by the
+// others.
+type InvoiceTemplate struct {
+	ID           uuid.UUID                `json:"id" db:"id"`
+	CompanyID    uuid.UUID                `json:"company_id" db:"company_id"`
+	Name         string                   `json:"name" db:"name" validate:"required"`
+	CustomerID   uuid.UUID                `json:"customer_id" db:"customer_id" validate:"required"`
+	PaymentTerms string                   `json:"payment_terms" db:"payment_terms"`
+	Frequency    Frequencia               `json:"frequency" db:"frequency" validate:"required,oneof=DIARIA SEMANAL MENSAL"`
+	Interval     int                      `json:"interval" db:"interval_count" validate:"min=0"`
+	DayOfMonth   int                      `json:"day_of_month" db:"day_of_month" validate:"omitempty,min=1,max=31"`
+	Weekday      *int                     `json:"weekday" db:"weekday" validate:"required_if=Frequency SEMANAL,excluded_unless=Frequency SEMANAL,omitempty,min=0,max=6"`
+	StartDate    string                   `json:"start_date" db:"start_date" validate:"required"`
+	EndDate      *string                  `json:"end_date,omitempty" db:"end_date"`
+	Active       bool                     `json:"active" db:"active"`
+	Products     []InvoiceTemplateProduct `json:"products" validate:"required,min=1,dive"`
+	CreatedAt    string                   `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt    string                   `json:"updated_at,omitempty" db:"updated_at"`
+}
+
+// Occurrences returns the dates, at day precision, on which the template is
+// due within [from, to], both inclusive.
+func (t *InvoiceTemplate) Occurrences(from, to time.Time) []time.Time {
+	start, err := ParseDate(t.StartDate)
+	if err != nil {
+		return nil
+	}
+	from, to = truncateDay(from), truncateDay(to)
+	if from.Before(start) {
+		from = start
+	}
+	if t.EndDate != nil {
+		if end, err := ParseDate(*t.EndDate); err == nil && end.Before(to) {
+			to = end
+		}
+	}
+
+	interval := t.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	var dates []time.Time
+	switch t.Frequency {
+	case FrequenciaDiaria:
+		for d := start; !d.After(to); d = d.AddDate(0, 0, interval) {
+			if !d.Before(from) {
+				dates = append(dates, d)
+			}
+		}
+	case FrequenciaSemanal:
+		if t.Weekday == nil {
+			return nil
+		}
+		d := start.AddDate(0, 0, (*t.Weekday-int(start.Weekday())+7)%7)
+		for ; !d.After(to); d = d.AddDate(0, 0, 7*interval) {
+			if !d.Before(from) {
+				dates = append(dates, d)
+			}
+		}
+	case FrequenciaMensal:
+		for m := 0; ; m += interval {
+			first := time.Date(start.Year(), start.Month()+time.Month(m), 1, 0, 0, 0, 0, time.UTC)
+			if first.After(to) {
+				break
+			}
+			day := t.DayOfMonth
+			if last := first.AddDate(0, 1, -1).Day(); day > last {
+				day = last
+			}
+			d := first.AddDate(0, 0, day-1)
+			if !d.Before(from) && !d.Before(start) && !d.After(to) {
+				dates = append(dates, d)
+			}
+		}
+	}
+
+	return dates
+}
+
+// Next returns up to n occurrences on or after from, looking at most ten
+// years ahead.
+func (t *InvoiceTemplate) Next(from time.Time, n int) []time.Time {
+	dates := t.Occurrences(from, from.AddDate(10, 0, 0))
+	if len(dates) > n {
+		dates = dates[:n]
+	}
+	return dates
+}
+
+// ParseDate reads a DATE column or a YYYY-MM-DD request field as a UTC day.
+func ParseDate(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return truncateDay(t), nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
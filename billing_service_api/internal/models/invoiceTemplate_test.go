@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+)
+
+func TestInvoiceTemplateWeekdayValidation(t *testing.T) {
+	weekday := func(day int) *int { return &day }
+
+	tests := []struct {
+		name      string
+		frequency Frequencia
+		weekday   *int
+		valid     bool
+	}{
+		{"weekly on Sunday", FrequenciaSemanal, weekday(0), true},
+		{"weekly on Saturday", FrequenciaSemanal, weekday(6), true},
+		{"weekly without weekday", FrequenciaSemanal, nil, false},
+		{"weekly on day 7", FrequenciaSemanal, weekday(7), false},
+		{"monthly without weekday", FrequenciaMensal, nil, true},
+		{"monthly with weekday", FrequenciaMensal, weekday(0), false},
+		{"daily with weekday", FrequenciaDiaria, weekday(3), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := InvoiceTemplate{
+				Name:       "Monthly fee",
+				CustomerID: uuid.New(),
+				Frequency:  tt.frequency,
+				Weekday:    tt.weekday,
+				StartDate:  "2025-01-01",
+				Products:   []InvoiceTemplateProduct{{ProductID: "product", Amount: 1}},
+			}
+			err := validator.New().Struct(template)
+			if (err == nil) != tt.valid {
+				t.Errorf("Struct() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestInvoiceTemplateWeeklyOccurrences(t *testing.T) {
+	wednesday := 3
+	template := InvoiceTemplate{Frequency: FrequenciaSemanal, Interval: 1, Weekday: &wednesday, StartDate: "2025-01-01"}
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	dates := template.Occurrences(from, from.AddDate(0, 0, 14))
+	want := []string{"2025-01-01", "2025-01-08", "2025-01-15"}
+	if len(dates) != len(want) {
+		t.Fatalf("Occurrences() = %v, want %v", dates, want)
+	}
+	for i, date := range dates {
+		if date.Format("2006-01-02") != want[i] {
+			t.Errorf("occurrence %d = %s, want %s", i, date.Format("2006-01-02"), want[i])
+		}
+	}
+}
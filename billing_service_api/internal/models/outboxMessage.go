@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type StatusOutbox string
+
+const (
+	OutboxPendente StatusOutbox = "PENDENTE"
+	OutboxEnviada  StatusOutbox = "ENVIADA"
+	OutboxFalhou   StatusOutbox = "FALHOU"
+)
+
+// Kinds of outbox message. The ID of a stock deduction is also the operation
+// key the stock service deduplicates on, and the key a restore compensates. A
+// release frees the reservation of a cancelled invoice. An event is published
+// to the broker with the message ID as its event ID.
+const (
+	OutboxStockDeduct  = "stock.deduct"
+	OutboxStockRestore = "stock.restore"
+	OutboxStockRelease = "stock.release"
+	OutboxEvent        = "event"
+)
+
+// OutboxMessage is a command written in the same transaction as the state
+// change that requires it and delivered later by the relay.
+type OutboxMessage struct {
+	ID            uuid.UUID       `json:"id" db:"id"`
+	CompanyID     uuid.UUID       `json:"company_id" db:"company_id"`
+	InvoiceCode   string          `json:"invoice_code" db:"invoice_code"`
+	Kind          string          `json:"kind" db:"kind"`
+	Payload       json.RawMessage `json:"payload" db:"payload"`
+	Status        StatusOutbox    `json:"status" db:"status"`
+	Attempts      int             `json:"attempts" db:"attempts"`
+	LastError     *string         `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt string          `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt     string          `json:"created_at" db:"created_at"`
+	DeliveredAt   *string         `json:"delivered_at,omitempty" db:"delivered_at"`
+}
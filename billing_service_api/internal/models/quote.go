@@ -0,0 +1,18 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+type Quote struct {
+	ID         uuid.UUID       `json:"id" db:"id"`
+	CompanyID  uuid.UUID       `json:"company_id" db:"company_id"`
+	Code       string          `json:"code" db:"code"`
+	CustomerID uuid.UUID       `json:"customer_id" db:"customer_id"`
+	Status     StatusComercial `json:"status" db:"status"`
+	ValidUntil string          `json:"valid_until" db:"valid_until"`
+	TotalValue float64         `json:"totalValue" db:"total_value"`
+	Products   []QuoteProduct  `json:"products"`
+	CreatedAt  string          `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt  string          `json:"updated_at,omitempty" db:"updated_at"`
+}
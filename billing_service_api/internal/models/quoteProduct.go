@@ -0,0 +1,14 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+type QuoteProduct struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	CompanyID uuid.UUID `json:"company_id" db:"company_id"`
+	QuoteID   uuid.UUID `json:"quote_id" db:"quote_id"`
+	ProductID string    `json:"product_id" db:"product_id" validate:"required"`
+	Amount    int       `json:"amount" db:"amount" validate:"required,min=1"`
+	CreatedAt string    `json:"created_at,omitempty" db:"created_at"`
+}
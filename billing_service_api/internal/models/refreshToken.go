@@ -0,0 +1,14 @@
+package models
+
+import "github.com/google/uuid"
+
+// RefreshToken is stored as the SHA-256 of the token handed out. Each one is
+// used once: refreshing revokes it and issues the next.
+type RefreshToken struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	TokenHash string    `json:"-" db:"token_hash"`
+	ExpiresAt string    `json:"expires_at" db:"expires_at"`
+	RevokedAt *string   `json:"revoked_at,omitempty" db:"revoked_at"`
+	CreatedAt string    `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+type SalesOrder struct {
+	ID         uuid.UUID           `json:"id" db:"id"`
+	CompanyID  uuid.UUID           `json:"company_id" db:"company_id"`
+	Code       string              `json:"code" db:"code"`
+	CustomerID uuid.UUID           `json:"customer_id" db:"customer_id"`
+	QuoteID    *uuid.UUID          `json:"quote_id,omitempty" db:"quote_id"`
+	Status     StatusComercial     `json:"status" db:"status"`
+	ValidUntil string              `json:"valid_until" db:"valid_until"`
+	TotalValue float64             `json:"totalValue" db:"total_value"`
+	Products   []SalesOrderProduct `json:"products"`
+	Invoices   []string            `json:"invoices"`
+	CreatedAt  string              `json:"created_at,omitempty" db:"created_at"`
+	UpdatedAt  string              `json:"updated_at,omitempty" db:"updated_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+type SalesOrderProduct struct {
+	ID             uuid.UUID `json:"id" db:"id"`
+	CompanyID      uuid.UUID `json:"company_id" db:"company_id"`
+	OrderID        uuid.UUID `json:"order_id" db:"order_id"`
+	ProductID      string    `json:"product_id" db:"product_id" validate:"required"`
+	Amount         int       `json:"amount" db:"amount" validate:"required,min=1"`
+	InvoicedAmount int       `json:"invoiced_amount" db:"invoiced_amount"`
+	CreatedAt      string    `json:"created_at,omitempty" db:"created_at"`
+}
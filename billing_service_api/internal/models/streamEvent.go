@@ -0,0 +1,19 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// StreamEvent is one event of GET /events. Subject is the invoice code it is
+// about, and Payload the event envelope also sent to webhooks.
+type StreamEvent struct {
+	ID        int64           `json:"id" db:"id"`
+	Position  int64           `json:"position" db:"position"`
+	CompanyID uuid.UUID       `json:"company_id" db:"company_id"`
+	EventType string          `json:"event_type" db:"event_type"`
+	Subject   string          `json:"subject" db:"subject"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt string          `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+)
+
+// User signs in with Email and a password stored as a bcrypt hash. Inactive
+// users can't sign in or refresh their tokens.
+type User struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	CompanyID    uuid.UUID `json:"company_id" db:"company_id"`
+	Name         string    `json:"name" db:"name"`
+	Email        string    `json:"email" db:"email"`
+	PasswordHash string    `json:"-" db:"password_hash"`
+	Role         auth.Role `json:"role" db:"role"`
+	Active       bool      `json:"active" db:"active"`
+	CreatedAt    string    `json:"created_at" db:"created_at"`
+	UpdatedAt    string    `json:"updated_at" db:"updated_at"`
+}
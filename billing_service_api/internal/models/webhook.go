@@ -0,0 +1,38 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// WebhookSubscription receives the events of EventTypes at URL, signed with
+// Secret. The secret is only returned when the subscription is created.
+type WebhookSubscription struct {
+	ID         uuid.UUID      `json:"id" db:"id"`
+	CompanyID  uuid.UUID      `json:"company_id" db:"company_id"`
+	URL        string         `json:"url" db:"url"`
+	EventTypes pq.StringArray `json:"event_types" db:"event_types"`
+	Secret     string         `json:"secret,omitempty" db:"secret"`
+	Active     bool           `json:"active" db:"active"`
+	CreatedAt  string         `json:"created_at" db:"created_at"`
+}
+
+// WebhookDelivery is one entry of the webhook log. Like emails, failed sends
+// stay PENDENTE with a later NextAttemptAt until they run out of attempts.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id" db:"id"`
+	CompanyID      uuid.UUID       `json:"company_id" db:"company_id"`
+	SubscriptionID uuid.UUID       `json:"subscription_id" db:"subscription_id"`
+	EventID        string          `json:"event_id" db:"event_id"`
+	EventType      string          `json:"event_type" db:"event_type"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         StatusEnvio     `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	ResponseStatus *int            `json:"response_status,omitempty" db:"response_status"`
+	LastError      *string         `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt  string          `json:"next_attempt_at" db:"next_attempt_at"`
+	DeliveredAt    *string         `json:"delivered_at,omitempty" db:"delivered_at"`
+	CreatedAt      string          `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,266 @@
+package nfe
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lucasbpereira/billing_service_api/internal/fiscal"
+)
+
+// Item is an invoice line together with the product data the documents print.
+type Item struct {
+	ProductID   string
+	Description string
+	NCM         string
+	Unit        string
+	Quantity    int
+	UnitPrice   float64
+	Value       float64
+	CFOP        string
+	CSTICMS     string
+	ICMSBase    float64
+	ICMSRate    float64
+	ICMSValue   float64
+}
+
+type Recipient struct {
+	Name       string
+	Document   string
+	IE         string
+	Email      string
+	UF         string
+	CityCode   string
+	Street     string
+	Number     string
+	Complement string
+	District   string
+}
+
+// Document gathers everything needed to render the XML and the DANFE of a
+// closed invoice.
+type Document struct {
+	Company   fiscal.Company
+	Recipient Recipient
+	Code      string
+	Series    string
+	Number    int64
+	AccessKey string
+	IssuedAt  time.Time
+	Total     float64
+	ICMSBase  float64
+	ICMSValue float64
+	Items     []Item
+}
+
+type nfeXML struct {
+	XMLName xml.Name  `xml:"NFe"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	InfNFe  infNFeXML `xml:"infNFe"`
+}
+
+type infNFeXML struct {
+	ID     string    `xml:"Id,attr"`
+	Versao string    `xml:"versao,attr"`
+	Ide    ideXML    `xml:"ide"`
+	Emit   emitXML   `xml:"emit"`
+	Dest   destXML   `xml:"dest"`
+	Det    []detXML  `xml:"det"`
+	Total  totalXML  `xml:"total"`
+	Transp transpXML `xml:"transp"`
+	Pag    pagXML    `xml:"pag"`
+}
+
+type ideXML struct {
+	CUF      string `xml:"cUF"`
+	CNF      string `xml:"cNF"`
+	NatOp    string `xml:"natOp"`
+	Mod      string `xml:"mod"`
+	Serie    string `xml:"serie"`
+	NNF      string `xml:"nNF"`
+	DhEmi    string `xml:"dhEmi"`
+	TpNF     string `xml:"tpNF"`
+	IdDest   string `xml:"idDest"`
+	CMunFG   string `xml:"cMunFG"`
+	TpImp    string `xml:"tpImp"`
+	TpEmis   string `xml:"tpEmis"`
+	CDV      string `xml:"cDV"`
+	TpAmb    string `xml:"tpAmb"`
+	FinNFe   string `xml:"finNFe"`
+	IndFinal string `xml:"indFinal"`
+	IndPres  string `xml:"indPres"`
+	ProcEmi  string `xml:"procEmi"`
+	VerProc  string `xml:"verProc"`
+}
+
+type enderXML struct {
+	XLgr    string `xml:"xLgr"`
+	Nro     string `xml:"nro"`
+	XCpl    string `xml:"xCpl,omitempty"`
+	XBairro string `xml:"xBairro"`
+	CMun    string `xml:"cMun"`
+	UF      string `xml:"UF"`
+	CEP     string `xml:"CEP,omitempty"`
+	CPais   string `xml:"cPais"`
+	XPais   string `xml:"xPais"`
+}
+
+type emitXML struct {
+	CNPJ      string   `xml:"CNPJ"`
+	XNome     string   `xml:"xNome"`
+	XFant     string   `xml:"xFant,omitempty"`
+	EnderEmit enderXML `xml:"enderEmit"`
+	IE        string   `xml:"IE"`
+	CRT       string   `xml:"CRT"`
+}
+
+type destXML struct {
+	CNPJ      string   `xml:"CNPJ,omitempty"`
+	CPF       string   `xml:"CPF,omitempty"`
+	XNome     string   `xml:"xNome"`
+	EnderDest enderXML `xml:"enderDest"`
+	IndIEDest string   `xml:"indIEDest"`
+	IE        string   `xml:"IE,omitempty"`
+	Email     string   `xml:"email,omitempty"`
+}
+
+type detXML struct {
+	NItem   int        `xml:"nItem,attr"`
+	Prod    prodXML    `xml:"prod"`
+	Imposto impostoXML `xml:"imposto"`
+}
+
+type prodXML struct {
+	CProd    string `xml:"cProd"`
+	CEAN     string `xml:"cEAN"`
+	XProd    string `xml:"xProd"`
+	NCM      string `xml:"NCM"`
+	CFOP     string `xml:"CFOP"`
+	UCom     string `xml:"uCom"`
+	QCom     string `xml:"qCom"`
+	VUnCom   string `xml:"vUnCom"`
+	VProd    string `xml:"vProd"`
+	CEANTrib string `xml:"cEANTrib"`
+	UTrib    string `xml:"uTrib"`
+	QTrib    string `xml:"qTrib"`
+	VUnTrib  string `xml:"vUnTrib"`
+	IndTot   string `xml:"indTot"`
+}
+
+type impostoXML struct {
+	ICMS icmsXML `xml:"ICMS>ICMS00"`
+}
+
+type icmsXML struct {
+	Orig  string `xml:"orig"`
+	CST   string `xml:"CST"`
+	ModBC string `xml:"modBC"`
+	VBC   string `xml:"vBC"`
+	PICMS string `xml:"pICMS"`
+	VICMS string `xml:"vICMS"`
+}
+
+type totalXML struct {
+	VBC   string `xml:"ICMSTot>vBC"`
+	VICMS string `xml:"ICMSTot>vICMS"`
+	VProd string `xml:"ICMSTot>vProd"`
+	VNF   string `xml:"ICMSTot>vNF"`
+}
+
+type transpXML struct {
+	ModFrete string `xml:"modFrete"`
+}
+
+type pagXML struct {
+	TPag string `xml:"detPag>tPag"`
+	VPag string `xml:"detPag>vPag"`
+}
+
+// XML renders the invoice in the NF-e 4.00 layout. The document is not signed
+// and carries no authorisation protocol.
+func XML(doc *Document) ([]byte, error) {
+	if len(doc.AccessKey) != 44 {
+		return nil, fmt.Errorf("invoice %s has no access key", doc.Code)
+	}
+
+	c := doc.Company
+	r := doc.Recipient
+
+	idDest := "1"
+	if r.UF != "" && r.UF != c.UF {
+		idDest = "2"
+	}
+
+	dest := destXML{
+		XNome: r.Name,
+		EnderDest: enderXML{XLgr: r.Street, Nro: r.Number, XCpl: r.Complement, XBairro: r.District,
+			CMun: r.CityCode, UF: r.UF, CPais: "1058", XPais: "BRASIL"},
+		IndIEDest: "9",
+		Email:     r.Email,
+	}
+	if len(r.Document) == 14 {
+		dest.CNPJ = r.Document
+	} else {
+		dest.CPF = r.Document
+	}
+	if r.IE != "" {
+		dest.IndIEDest = "1"
+		dest.IE = r.IE
+	}
+
+	document := nfeXML{
+		Xmlns: "http://www.portalfiscal.inf.br/nfe",
+		InfNFe: infNFeXML{
+			ID:     "NFe" + doc.AccessKey,
+			Versao: "4.00",
+			Ide: ideXML{
+				CUF: doc.AccessKey[0:2], CNF: doc.AccessKey[35:43], NatOp: "VENDA DE MERCADORIA", Mod: "55",
+				Serie: doc.Series, NNF: strconv.FormatInt(doc.Number, 10), DhEmi: doc.IssuedAt.Format(time.RFC3339),
+				TpNF: "1", IdDest: idDest, CMunFG: c.CityCode, TpImp: "1", TpEmis: "1", CDV: doc.AccessKey[43:],
+				TpAmb: "2", FinNFe: "1", IndFinal: "0", IndPres: "9", ProcEmi: "0", VerProc: "billing_service_api",
+			},
+			Emit: emitXML{
+				CNPJ: c.CNPJ, XNome: c.Name, XFant: c.TradeName,
+				EnderEmit: enderXML{XLgr: c.Street, Nro: c.Number, XCpl: c.Complement, XBairro: c.District,
+					CMun: c.CityCode, UF: c.UF, CEP: c.ZipCode, CPais: "1058", XPais: "BRASIL"},
+				IE: c.IE, CRT: "3",
+			},
+			Dest:   dest,
+			Total:  totalXML{VBC: money(doc.ICMSBase), VICMS: money(doc.ICMSValue), VProd: money(doc.Total), VNF: money(doc.Total)},
+			Transp: transpXML{ModFrete: "9"},
+			Pag:    pagXML{TPag: "90", VPag: money(0)},
+		},
+	}
+
+	for i, item := range doc.Items {
+		origin, cst := "0", "00"
+		if len(item.CSTICMS) == 3 {
+			origin, cst = item.CSTICMS[:1], item.CSTICMS[1:]
+		}
+		quantity := strconv.FormatFloat(float64(item.Quantity), 'f', 4, 64)
+		unitPrice := strconv.FormatFloat(item.UnitPrice, 'f', 10, 64)
+		document.InfNFe.Det = append(document.InfNFe.Det, detXML{
+			NItem: i + 1,
+			Prod: prodXML{
+				CProd: item.ProductID, CEAN: "SEM GTIN", XProd: item.Description, NCM: item.NCM, CFOP: item.CFOP,
+				UCom: item.Unit, QCom: quantity, VUnCom: unitPrice, VProd: money(item.Value),
+				CEANTrib: "SEM GTIN", UTrib: item.Unit, QTrib: quantity, VUnTrib: unitPrice, IndTot: "1",
+			},
+			Imposto: impostoXML{ICMS: icmsXML{
+				Orig: origin, CST: cst, ModBC: "3",
+				VBC: money(item.ICMSBase), PICMS: money(item.ICMSRate), VICMS: money(item.ICMSValue),
+			}},
+		})
+	}
+
+	body, err := xml.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+func money(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
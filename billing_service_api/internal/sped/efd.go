@@ -0,0 +1,388 @@
+package sped
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucasbpereira/billing_service_api/internal/fiscal"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Participant is a customer reported in record 0150.
+type Participant struct {
+	Code       string
+	Name       string
+	Document   string
+	IE         string
+	CityCode   string
+	Street     string
+	Number     string
+	Complement string
+	District   string
+}
+
+// Item is a product reported in record 0200.
+type Item struct {
+	Code        string
+	Description string
+	Unit        string
+	NCM         string
+}
+
+type Line struct {
+	ItemCode  string
+	Quantity  float64
+	Unit      string
+	Value     float64
+	CSTICMS   string
+	CFOP      string
+	ICMSBase  float64
+	ICMSRate  float64
+	ICMSValue float64
+}
+
+// Document is one closed invoice, reported in C100 and summarised in C190.
+type Document struct {
+	ParticipantCode string
+	Series          string
+	Number          int64
+	AccessKey       string
+	IssuedAt        time.Time
+	Value           float64
+	ICMSBase        float64
+	ICMSValue       float64
+	Lines           []Line
+}
+
+type Input struct {
+	Company      fiscal.Company
+	Accountant   fiscal.Accountant
+	Taxes        fiscal.Taxes
+	Start        time.Time
+	End          time.Time
+	Participants []Participant
+	Items        []Item
+	Documents    []Document
+	// IncludeItems adds C170 (and the 0190/0200 records they reference).
+	// The Guia Prático forbids C170 for NF-e of own issue, so only enable it
+	// when the state requires the items.
+	IncludeItems bool
+}
+
+// Validate lists the configuration the PVA requires before a file can be
+// generated.
+func (in *Input) Validate() error {
+	var missing []string
+	check := func(name, value string) {
+		if strings.TrimSpace(value) == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	check("COMPANY_NAME", in.Company.Name)
+	check("COMPANY_CNPJ", in.Company.CNPJ)
+	check("COMPANY_IE", in.Company.IE)
+	check("COMPANY_UF", in.Company.UF)
+	check("COMPANY_CITY_CODE", in.Company.CityCode)
+	check("COMPANY_ZIP", in.Company.ZipCode)
+	check("COMPANY_STREET", in.Company.Street)
+	check("COMPANY_DISTRICT", in.Company.District)
+	check("ACCOUNTANT_NAME", in.Accountant.Name)
+	check("ACCOUNTANT_CPF", in.Accountant.CPF)
+	check("ACCOUNTANT_CRC", in.Accountant.CRC)
+	check("ACCOUNTANT_CITY_CODE", in.Accountant.CityCode)
+
+	for _, p := range in.Participants {
+		if p.CityCode == "" || p.Street == "" {
+			missing = append(missing, fmt.Sprintf("city_code/street of customer %s", p.Name))
+		}
+	}
+
+	debits := 0.0
+	for _, doc := range in.Documents {
+		debits += doc.ICMSValue
+	}
+	if debits > 0 {
+		check("ICMS_REVENUE_CODE", in.Taxes.ICMSRevenueCode)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing fiscal configuration: %s", strings.Join(missing, ", "))
+	}
+
+	if in.Start.Day() != 1 || in.End.Month() != in.Start.Month() || in.End.Year() != in.Start.Year() || in.End.Before(in.Start) {
+		return fmt.Errorf("the EFD period must start on the first day of a month and end within that month")
+	}
+
+	return nil
+}
+
+// Generate writes the EFD ICMS/IPI file for the period in ISO-8859-1, with
+// blocks 0, B, C, D, E, G, H, K, 1 and 9 in the order the PVA expects.
+func Generate(in Input) ([]byte, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+
+	w := newWriter()
+	writeBlock0(w, &in)
+	writeEmptyBlock(w, "B")
+	writeBlockC(w, &in)
+	writeEmptyBlock(w, "D")
+	writeBlockE(w, &in)
+	writeEmptyBlock(w, "G")
+	writeEmptyBlock(w, "H")
+	writeEmptyBlock(w, "K")
+	writeBlock1(w)
+	writeBlock9(w)
+
+	encoder := encoding.ReplaceUnsupported(charmap.ISO8859_1.NewEncoder())
+	return encoder.Bytes([]byte(w.String()))
+}
+
+func writeBlock0(w *writer, in *Input) {
+	c := in.Company
+	a := in.Accountant
+
+	activity := "1"
+	if c.IndustrialAct {
+		activity = "0"
+	}
+
+	tradeName := c.TradeName
+	if tradeName == "" {
+		tradeName = c.Name
+	}
+
+	cnpj, cpf := "", ""
+	if len(c.CNPJ) == 14 {
+		cnpj = c.CNPJ
+	} else {
+		cpf = c.CNPJ
+	}
+
+	w.add("0000", in.Taxes.SpedLayoutVersion, "0", date(in.Start), date(in.End), c.Name, cnpj, cpf, c.UF, c.IE, c.CityCode, "", "", "A", activity)
+	w.add("0001", "0")
+	w.add("0005", tradeName, c.ZipCode, c.Street, c.Number, c.Complement, c.District, c.Phone, "", c.Email)
+	w.add("0100", a.Name, a.CPF, a.CRC, a.CNPJ, a.ZipCode, a.Street, a.Number, "", a.District, a.Phone, "", a.Email, a.CityCode)
+
+	for _, p := range in.Participants {
+		cnpj, cpf := "", ""
+		if len(p.Document) == 14 {
+			cnpj = p.Document
+		} else {
+			cpf = p.Document
+		}
+		w.add("0150", p.Code, p.Name, "1058", cnpj, cpf, p.IE, p.CityCode, "", p.Street, p.Number, p.Complement, p.District)
+	}
+
+	if in.IncludeItems {
+		units := map[string]bool{}
+		var unitList []string
+		for _, item := range in.Items {
+			if !units[item.Unit] {
+				units[item.Unit] = true
+				unitList = append(unitList, item.Unit)
+			}
+		}
+		sort.Strings(unitList)
+		for _, unit := range unitList {
+			w.add("0190", unit, unitDescription(unit))
+		}
+		for _, item := range in.Items {
+			w.add("0200", item.Code, item.Description, "", "", item.Unit, "00", item.NCM, "", "", "", "", "")
+		}
+	}
+
+	w.closeBlock("0990", "0")
+}
+
+func writeBlockC(w *writer, in *Input) {
+	if len(in.Documents) == 0 {
+		w.add("C001", "1")
+		w.closeBlock("C990", "C")
+		return
+	}
+
+	w.add("C001", "0")
+	for _, doc := range in.Documents {
+		merchandise := 0.0
+		for _, line := range doc.Lines {
+			merchandise += line.Value
+		}
+
+		w.add("C100", "1", "0", doc.ParticipantCode, "55", "00", doc.Series, strconv.FormatInt(doc.Number, 10), doc.AccessKey,
+			date(doc.IssuedAt), date(doc.IssuedAt), money(doc.Value), "0", money(0), money(0), money(merchandise), "9",
+			money(0), money(0), money(0), money(doc.ICMSBase), money(doc.ICMSValue), money(0), money(0), money(0),
+			money(0), money(0), money(0), money(0))
+
+		if in.IncludeItems {
+			for i, line := range doc.Lines {
+				w.add("C170", strconv.Itoa(i+1), line.ItemCode, "", decimal(line.Quantity, 5), line.Unit, money(line.Value), money(0), "0",
+					line.CSTICMS, line.CFOP, "", money(line.ICMSBase), money(line.ICMSRate), money(line.ICMSValue), money(0), money(0), money(0),
+					"", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "")
+			}
+		}
+
+		for _, summary := range summarise(doc.Lines) {
+			w.add("C190", summary.CSTICMS, summary.CFOP, money(summary.ICMSRate), money(summary.Value), money(summary.ICMSBase),
+				money(summary.ICMSValue), money(0), money(0), money(0), money(0), "")
+		}
+	}
+	w.closeBlock("C990", "C")
+}
+
+// summarise groups lines by CST, CFOP and rate, as C190 requires.
+func summarise(lines []Line) []Line {
+	var keys []string
+	groups := map[string]*Line{}
+	for _, line := range lines {
+		key := fmt.Sprintf("%s|%s|%.2f", line.CSTICMS, line.CFOP, line.ICMSRate)
+		group, ok := groups[key]
+		if !ok {
+			group = &Line{CSTICMS: line.CSTICMS, CFOP: line.CFOP, ICMSRate: line.ICMSRate}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		group.Value += line.Value
+		group.ICMSBase += line.ICMSBase
+		group.ICMSValue += line.ICMSValue
+	}
+
+	sort.Strings(keys)
+	summaries := make([]Line, 0, len(keys))
+	for _, key := range keys {
+		summaries = append(summaries, *groups[key])
+	}
+	return summaries
+}
+
+func writeBlockE(w *writer, in *Input) {
+	debits := 0.0
+	for _, doc := range in.Documents {
+		for _, summary := range summarise(doc.Lines) {
+			debits += fiscal.Round2(summary.ICMSValue)
+		}
+	}
+	debits = fiscal.Round2(debits)
+
+	w.add("E001", "0")
+	w.add("E100", date(in.Start), date(in.End))
+	w.add("E110", money(debits), money(0), money(0), money(0), money(0), money(0), money(0), money(0), money(0),
+		money(debits), money(0), money(debits), money(0), money(0))
+
+	if debits > 0 {
+		due := time.Date(in.Start.Year(), in.Start.Month()+1, in.Taxes.ICMSDueDay, 0, 0, 0, 0, time.UTC)
+		w.add("E116", "000", money(debits), date(due), in.Taxes.ICMSRevenueCode, "", "", "", "", in.Start.Format("012006"))
+	}
+
+	w.closeBlock("E990", "E")
+}
+
+func writeBlock1(w *writer) {
+	w.add("1001", "0")
+	w.add("1010", "N", "N", "N", "N", "N", "N", "N", "N", "N", "N", "N", "N", "N")
+	w.closeBlock("1990", "1")
+}
+
+func writeEmptyBlock(w *writer, block string) {
+	w.add(block+"001", "1")
+	w.closeBlock(block+"990", block)
+}
+
+// writeBlock9 writes one 9900 per record type, counting the block 9 records
+// themselves, followed by the block and file totals.
+func writeBlock9(w *writer) {
+	w.add("9001", "0")
+
+	registers := append([]string{}, w.order...)
+	registers = append(registers, "9900", "9990", "9999")
+	for _, reg := range registers {
+		count := w.counts[reg]
+		switch reg {
+		case "9900":
+			count = len(registers)
+		case "9990", "9999":
+			count = 1
+		}
+		w.add("9900", reg, strconv.Itoa(count))
+	}
+
+	w.add("9990", strconv.Itoa(w.blockLines["9"]+2))
+	w.add("9999", strconv.Itoa(len(w.lines)+1))
+}
+
+type writer struct {
+	lines      []string
+	counts     map[string]int
+	order      []string
+	blockLines map[string]int
+}
+
+func newWriter() *writer {
+	return &writer{counts: map[string]int{}, blockLines: map[string]int{}}
+}
+
+func (w *writer) add(reg string, fields ...string) {
+	if _, seen := w.counts[reg]; !seen && reg != "9900" {
+		w.order = append(w.order, reg)
+	}
+	w.counts[reg]++
+	w.blockLines[reg[:1]]++
+
+	clean := make([]string, 0, len(fields)+1)
+	clean = append(clean, reg)
+	for _, field := range fields {
+		clean = append(clean, sanitize(field))
+	}
+	w.lines = append(w.lines, "|"+strings.Join(clean, "|")+"|")
+}
+
+// closeBlock writes the block's closing record, whose only field is the
+// number of lines in the block including itself.
+func (w *writer) closeBlock(reg, block string) {
+	w.add(reg, strconv.Itoa(w.blockLines[block]+1))
+}
+
+func (w *writer) String() string {
+	return strings.Join(w.lines, "\r\n") + "\r\n"
+}
+
+func sanitize(value string) string {
+	value = strings.ReplaceAll(value, "|", " ")
+	value = strings.ReplaceAll(value, "\r", " ")
+	value = strings.ReplaceAll(value, "\n", " ")
+	return strings.TrimSpace(value)
+}
+
+func date(t time.Time) string {
+	return t.Format("02012006")
+}
+
+func money(value float64) string {
+	return decimal(value, 2)
+}
+
+func decimal(value float64, places int) string {
+	return strings.Replace(strconv.FormatFloat(value, 'f', places, 64), ".", ",", 1)
+}
+
+func unitDescription(unit string) string {
+	switch strings.ToUpper(unit) {
+	case "UN":
+		return "Unidade"
+	case "KG":
+		return "Quilograma"
+	case "CX":
+		return "Caixa"
+	case "L", "LT":
+		return "Litro"
+	case "M":
+		return "Metro"
+	}
+	return unit
+}
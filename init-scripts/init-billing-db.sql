@@ -4,8 +4,31 @@ CREATE DATABASE billing_db;
 -- Conectar ao database billing_db
 \c billing_db;
 
+DROP TABLE IF EXISTS rate_limits CASCADE;
+DROP TABLE IF EXISTS stream_tickets CASCADE;
+DROP TABLE IF EXISTS api_keys CASCADE;
+DROP TABLE IF EXISTS refresh_tokens CASCADE;
+DROP TABLE IF EXISTS users CASCADE;
+DROP TABLE IF EXISTS stream_events CASCADE;
+DROP TABLE IF EXISTS webhook_deliveries CASCADE;
+DROP TABLE IF EXISTS webhook_subscriptions CASCADE;
+DROP TABLE IF EXISTS outbox_messages CASCADE;
+DROP TABLE IF EXISTS idempotency_keys CASCADE;
+DROP TABLE IF EXISTS invoice_events CASCADE;
+DROP TABLE IF EXISTS email_deliveries CASCADE;
+DROP TABLE IF EXISTS import_jobs CASCADE;
+DROP TABLE IF EXISTS invoice_occurrences CASCADE;
+DROP TABLE IF EXISTS invoice_template_products CASCADE;
+DROP TABLE IF EXISTS invoice_templates CASCADE;
 DROP TABLE IF EXISTS invoice_products CASCADE;
 DROP TABLE IF EXISTS invoices CASCADE;
+DROP TABLE IF EXISTS sales_order_products CASCADE;
+DROP TABLE IF EXISTS sales_orders CASCADE;
+DROP TABLE IF EXISTS quote_products CASCADE;
+DROP TABLE IF EXISTS quotes CASCADE;
+DROP TABLE IF EXISTS customers CASCADE;
+DROP TABLE IF EXISTS invoice_numbers CASCADE;
+DROP TABLE IF EXISTS companies CASCADE;
 
 -- Opcional: deletar a extensão e recriar
 DROP EXTENSION IF EXISTS "uuid-ossp";
@@ -19,33 +42,507 @@ CREATE USER billing_user WITH PASSWORD 'billing_password';
 GRANT ALL PRIVILEGES ON DATABASE billing_db TO billing_user;
 GRANT ALL ON SCHEMA public TO billing_user;
 
--- Criar tabelas
+-- Empresas emitentes. Cada conexão da API trabalha para uma empresa, definida
+-- em app.company_id; as políticas de RLS no fim do script escondem e recusam
+-- as linhas das outras. app.all_companies libera todas, para os jobs.
+CREATE TABLE companies (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    trade_name VARCHAR(255) NOT NULL DEFAULT '',
+    cnpj VARCHAR(14) NOT NULL UNIQUE,
+    ie VARCHAR(14) NOT NULL DEFAULT '',
+    uf CHAR(2) NOT NULL DEFAULT '',
+    city_code VARCHAR(7) NOT NULL DEFAULT '', -- código IBGE do município
+    zip_code VARCHAR(8) NOT NULL DEFAULT '',
+    street VARCHAR(255) NOT NULL DEFAULT '',
+    number VARCHAR(20) NOT NULL DEFAULT '',
+    complement VARCHAR(100) NOT NULL DEFAULT '',
+    district VARCHAR(100) NOT NULL DEFAULT '',
+    phone VARCHAR(20) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    series VARCHAR(3) NOT NULL DEFAULT '1', -- série das NF-e emitidas
+    industrial BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE OR REPLACE FUNCTION current_company_id() RETURNS UUID AS $$
+    SELECT NULLIF(current_setting('app.company_id', true), '')::uuid;
+$$ LANGUAGE sql STABLE;
+
+CREATE TABLE customers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    name VARCHAR(255) NOT NULL,
+    document VARCHAR(14) NOT NULL, -- CPF ou CNPJ, apenas dígitos
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    ie VARCHAR(14) NOT NULL DEFAULT '',
+    uf CHAR(2) NOT NULL DEFAULT '',
+    city_code VARCHAR(7) NOT NULL DEFAULT '', -- código IBGE do município
+    street VARCHAR(255) NOT NULL DEFAULT '',
+    number VARCHAR(20) NOT NULL DEFAULT '',
+    complement VARCHAR(100) NOT NULL DEFAULT '',
+    district VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uq_customers_document UNIQUE (company_id, document)
+);
+
+-- Orçamentos
+CREATE TABLE quotes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    code VARCHAR(100) NOT NULL,
+    customer_id UUID NOT NULL REFERENCES customers(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'RASCUNHO',
+    valid_until DATE NOT NULL,
+    total_value DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uq_quotes_code UNIQUE (company_id, code)
+);
+
+CREATE TABLE quote_products (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    quote_id UUID NOT NULL REFERENCES quotes(id) ON DELETE CASCADE,
+    product_id VARCHAR(100) NOT NULL,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Pedidos de venda
+CREATE TABLE sales_orders (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    code VARCHAR(100) NOT NULL,
+    customer_id UUID NOT NULL REFERENCES customers(id),
+    quote_id UUID UNIQUE REFERENCES quotes(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'RASCUNHO',
+    valid_until DATE NOT NULL,
+    total_value DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uq_sales_orders_code UNIQUE (company_id, code)
+);
+
+CREATE TABLE sales_order_products (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    order_id UUID NOT NULL REFERENCES sales_orders(id) ON DELETE CASCADE,
+    product_id VARCHAR(100) NOT NULL,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    invoiced_amount INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT chk_invoiced_amount CHECK (invoiced_amount BETWEEN 0 AND amount)
+);
+
 CREATE TABLE invoices (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    code VARCHAR(100) NOT NULL UNIQUE,
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    code VARCHAR(100) NOT NULL,
+    number BIGINT NOT NULL, -- NUM_DOC da NF-e, sequencial por empresa
+    series VARCHAR(3) NOT NULL DEFAULT '1',
+    access_key CHAR(44), -- chave de acesso, gerada no fechamento
     status VARCHAR(20) NOT NULL DEFAULT 'ABERTO',
     total_value DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    icms_value DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    customer_id UUID REFERENCES customers(id),
+    order_id UUID REFERENCES sales_orders(id), -- pedido de origem, quando houver
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    closed_at TIMESTAMP,
+    version INTEGER NOT NULL DEFAULT 1, -- incrementada a cada alteração (ETag)
+    reservation_id UUID, -- reserva de estoque feita na criação
+
+    CONSTRAINT uq_invoices_code UNIQUE (company_id, code),
+    CONSTRAINT uq_invoices_number UNIQUE (company_id, number)
+);
+
+-- Último número de nota de cada empresa. A linha fica travada até o commit,
+-- então duas notas da mesma empresa nunca recebem o mesmo número.
+CREATE TABLE invoice_numbers (
+    company_id UUID PRIMARY KEY REFERENCES companies(id),
+    last_number BIGINT NOT NULL
 );
 
+CREATE OR REPLACE FUNCTION invoices_assign_number() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO invoice_numbers (company_id, last_number) VALUES (NEW.company_id, 1)
+    ON CONFLICT (company_id) DO UPDATE SET last_number = invoice_numbers.last_number + 1
+    RETURNING last_number INTO NEW.number;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_invoices_assign_number
+    BEFORE INSERT ON invoices
+    FOR EACH ROW EXECUTE FUNCTION invoices_assign_number();
+
+CREATE INDEX idx_invoices_order_id ON invoices(order_id);
+-- Relatórios e SPED filtram notas fechadas por data de fechamento
+CREATE INDEX idx_invoices_status_closed_at ON invoices(status, closed_at);
+
 CREATE TABLE invoice_products (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
     invoice_code VARCHAR(100) NOT NULL, -- ✅ Mudado para invoice_code
     product_id VARCHAR(100) NOT NULL,
     amount INTEGER NOT NULL,
+    unit_price DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    total_value DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    cfop CHAR(4) NOT NULL DEFAULT '',
+    cst_icms CHAR(3) NOT NULL DEFAULT '',
+    icms_base DECIMAL(10,2) NOT NULL DEFAULT 0.00,
+    icms_rate DECIMAL(5,2) NOT NULL DEFAULT 0.00,
+    icms_value DECIMAL(10,2) NOT NULL DEFAULT 0.00,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     
-    -- Foreign key agora referencia o code da invoice
+    -- Foreign key agora referencia o code da invoice, único por empresa
     CONSTRAINT fk_invoice
-        FOREIGN KEY (invoice_code) 
-        REFERENCES invoices(code)
+        FOREIGN KEY (company_id, invoice_code) 
+        REFERENCES invoices(company_id, code)
         ON DELETE CASCADE
 );
+
+-- Leitura das linhas de várias notas de uma vez (invoice_code = ANY(...))
+CREATE INDEX idx_invoice_products_invoice_code ON invoice_products(company_id, invoice_code);
+
+-- Modelos de nota recorrente
+CREATE TABLE invoice_templates (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    name VARCHAR(255) NOT NULL,
+    customer_id UUID NOT NULL REFERENCES customers(id),
+    payment_terms VARCHAR(255) NOT NULL DEFAULT '',
+    frequency VARCHAR(20) NOT NULL, -- DIARIA, SEMANAL, MENSAL
+    interval_count INTEGER NOT NULL DEFAULT 1 CHECK (interval_count > 0),
+    day_of_month INTEGER NOT NULL DEFAULT 0 CHECK (day_of_month BETWEEN 0 AND 31),
+    weekday INTEGER CHECK (weekday BETWEEN 0 AND 6), -- só nos modelos SEMANAL
+    start_date DATE NOT NULL,
+    end_date DATE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE invoice_template_products (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    template_id UUID NOT NULL REFERENCES invoice_templates(id) ON DELETE CASCADE,
+    product_id VARCHAR(100) NOT NULL,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Uma linha por período gerado; a chave única torna o agendador idempotente
+CREATE TABLE invoice_occurrences (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    template_id UUID NOT NULL REFERENCES invoice_templates(id) ON DELETE CASCADE,
+    scheduled_for DATE NOT NULL,
+    invoice_code VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uq_invoice_occurrence UNIQUE (template_id, scheduled_for)
+);
+
+-- Importações em lote de notas
+CREATE TABLE import_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDENTE',
+    mode VARCHAR(20) NOT NULL,
+    format VARCHAR(10) NOT NULL,
+    phase VARCHAR(20) NOT NULL DEFAULT '',
+    total_rows INTEGER NOT NULL DEFAULT 0,
+    processed_rows INTEGER NOT NULL DEFAULT 0,
+    invoices_created INTEGER NOT NULL DEFAULT 0,
+    error_count INTEGER NOT NULL DEFAULT 0,
+    report JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+-- Log de envio de e-mails das notas
+CREATE TABLE email_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    invoice_code VARCHAR(100) NOT NULL,
+    event VARCHAR(20) NOT NULL,
+    recipients TEXT[] NOT NULL,
+    subject VARCHAR(255) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDENTE',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (company_id, invoice_code) REFERENCES invoices(company_id, code)
+);
+
+CREATE INDEX idx_email_deliveries_invoice_code ON email_deliveries(company_id, invoice_code);
+CREATE INDEX idx_email_deliveries_pending ON email_deliveries(next_attempt_at) WHERE status = 'PENDENTE';
+
+-- Trilha de auditoria das notas, encadeada por hash. before/after são JSON
+-- (não JSONB) para que o texto gravado seja exatamente o que foi hasheado.
+CREATE TABLE invoice_events (
+    id BIGSERIAL PRIMARY KEY,
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    invoice_code VARCHAR(100) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    before JSON,
+    after JSON,
+    prev_hash VARCHAR(64) NOT NULL DEFAULT '',
+    hash VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+-- Cada empresa tem a sua trilha: o elo é a entrada anterior da mesma empresa
+CREATE INDEX idx_invoice_events_invoice_code ON invoice_events(company_id, invoice_code);
+
+-- A trilha só aceita inserções
+CREATE OR REPLACE FUNCTION invoice_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'invoice_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_invoice_events_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON invoice_events
+    FOR EACH STATEMENT EXECUTE FUNCTION invoice_events_append_only();
+
+-- Respostas guardadas para requisições com Idempotency-Key
+CREATE TABLE idempotency_keys (
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    principal VARCHAR(64) NOT NULL DEFAULT '', -- usuário ou API key dona da chave; vazio para os outros serviços
+    key VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PROCESSANDO',
+    response_status INTEGER NOT NULL DEFAULT 0,
+    response_headers JSONB NOT NULL DEFAULT '{}',
+    response_body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+
+    PRIMARY KEY (company_id, principal, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+
+-- Outbox: comandos ao estoque gravados na mesma transação da mudança de status
+CREATE TABLE outbox_messages (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    invoice_code VARCHAR(100) NOT NULL,
+    kind VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDENTE',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP,
+
+    FOREIGN KEY (company_id, invoice_code) REFERENCES invoices(company_id, code)
+);
+
+CREATE INDEX idx_outbox_messages_pending ON outbox_messages(next_attempt_at) WHERE status = 'PENDENTE';
+CREATE INDEX idx_outbox_messages_invoice_code ON outbox_messages(company_id, invoice_code);
+
+-- Assinaturas de webhook: eventos enviados por POST assinados com HMAC-SHA256
+CREATE TABLE webhook_subscriptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    url TEXT NOT NULL,
+    event_types TEXT[] NOT NULL,
+    secret VARCHAR(64) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Log de entregas de webhook, reenviadas com backoff exponencial
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id),
+    event_id VARCHAR(64) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDENTE',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_deliveries_pending ON webhook_deliveries(next_attempt_at) WHERE status = 'PENDENTE';
+CREATE INDEX idx_webhook_deliveries_subscription_id ON webhook_deliveries(subscription_id);
+
+-- Eventos do stream GET /events (SSE). position é o Last-Event-ID dos
+-- clientes: o trigger a atribui no commit, sob um lock da empresa, para seguir
+-- a ordem dos commits de cada empresa sem serializar as escritas das outras.
+-- Cada evento avisa as instâncias da API por NOTIFY, entregue no commit
+CREATE TABLE stream_events (
+    id BIGSERIAL PRIMARY KEY,
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    position BIGINT UNIQUE, -- nula até o commit
+    event_type VARCHAR(50) NOT NULL,
+    subject VARCHAR(100) NOT NULL, -- código da nota
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_stream_events_created_at ON stream_events(created_at);
+CREATE INDEX idx_stream_events_company_position ON stream_events(company_id, position);
+
+CREATE SEQUENCE stream_events_position_seq OWNED BY stream_events.position;
+
+-- Roda no commit (trigger adiado): o lock da empresa vale só até o fim do
+-- commit, e uma transação da mesma empresa que o espera recebe posições
+-- maiores e só fica visível depois desta
+CREATE OR REPLACE FUNCTION stream_events_position() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('stream_events'), hashtext(NEW.company_id::text));
+    UPDATE stream_events SET position = nextval('stream_events_position_seq') WHERE id = NEW.id;
+    PERFORM pg_notify('stream_events', NEW.id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER trg_stream_events_position
+    AFTER INSERT ON stream_events
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION stream_events_position();
+
+-- Usuários: senha com bcrypt e papel viewer, operator, fiscal ou admin. O
+-- e-mail é único entre todas as empresas, pois o login vem antes da empresa
+CREATE TABLE users (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    password_hash VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('viewer', 'operator', 'fiscal', 'admin')),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Refresh tokens guardados como SHA-256; cada um é usado uma única vez
+CREATE TABLE refresh_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id),
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+
+-- Chaves de API das integrações, guardadas como SHA-256, com escopos no lugar
+-- do papel de um usuário. O uso é somado a request_count periodicamente
+CREATE TABLE api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id UUID NOT NULL DEFAULT current_company_id() REFERENCES companies(id),
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(20) NOT NULL UNIQUE,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    request_count BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tickets de uso único com que o EventSource abre GET /events, já que não
+-- envia o header Authorization. Guardados como SHA-256, valem 30 segundos e
+-- são apagados ao serem usados. Lidos antes de se saber a empresa
+CREATE TABLE stream_tickets (
+    ticket_hash VARCHAR(64) PRIMARY KEY,
+    company_id UUID NOT NULL REFERENCES companies(id),
+    path VARCHAR(100) NOT NULL,
+    subject VARCHAR(64) NOT NULL, -- usuário ou chave de API
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    role VARCHAR(20) NOT NULL DEFAULT '',
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    key_prefix VARCHAR(20) NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_stream_tickets_expires_at ON stream_tickets(expires_at);
+
+-- Contadores do rate limiting com RATE_LIMIT_STORE=postgres, um por cliente,
+-- regra e janela, compartilhados entre as réplicas. Não pertencem a uma empresa
+CREATE TABLE rate_limits (
+    key TEXT NOT NULL,
+    window_start TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX idx_rate_limits_expires_at ON rate_limits(expires_at);
+
+-- Row-level security: cada tabela de empresa só mostra e aceita as linhas de
+-- current_company_id(). FORCE aplica as políticas também ao billing_user, dono
+-- das tabelas. Sem app.company_id nenhuma linha aparece.
+DO $$
+DECLARE
+    t TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY[
+        'customers', 'quotes', 'quote_products', 'sales_orders', 'sales_order_products',
+        'invoices', 'invoice_products', 'invoice_templates', 'invoice_template_products',
+        'invoice_occurrences', 'import_jobs', 'email_deliveries', 'invoice_events',
+        'idempotency_keys', 'outbox_messages', 'webhook_subscriptions', 'webhook_deliveries',
+        'stream_events', 'users', 'api_keys'
+    ] LOOP
+        EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+        EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+        EXECUTE format($p$CREATE POLICY company_isolation ON %I
+            USING (company_id = current_company_id() OR current_setting('app.all_companies', true) = 'on')
+            WITH CHECK (company_id = current_company_id() OR current_setting('app.all_companies', true) = 'on')$p$, t);
+    END LOOP;
+END;
+$$;
+
 -- Conceder privilégios nas tabelas
 GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO billing_user;
 GRANT ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA public TO billing_user;
 
 -- Alterar owner das tabelas para o usuário
+ALTER TABLE companies OWNER TO billing_user;
+ALTER TABLE invoice_numbers OWNER TO billing_user;
 ALTER TABLE invoices OWNER TO billing_user;
 ALTER TABLE invoice_products OWNER TO billing_user;
+ALTER TABLE customers OWNER TO billing_user;
+ALTER TABLE quotes OWNER TO billing_user;
+ALTER TABLE quote_products OWNER TO billing_user;
+ALTER TABLE sales_orders OWNER TO billing_user;
+ALTER TABLE sales_order_products OWNER TO billing_user;
+ALTER TABLE invoice_templates OWNER TO billing_user;
+ALTER TABLE invoice_template_products OWNER TO billing_user;
+ALTER TABLE invoice_occurrences OWNER TO billing_user;
+ALTER TABLE import_jobs OWNER TO billing_user;
+ALTER TABLE email_deliveries OWNER TO billing_user;
+ALTER TABLE invoice_events OWNER TO billing_user;
+ALTER TABLE idempotency_keys OWNER TO billing_user;
+ALTER TABLE outbox_messages OWNER TO billing_user;
+ALTER TABLE webhook_subscriptions OWNER TO billing_user;
+ALTER TABLE webhook_deliveries OWNER TO billing_user;
+ALTER TABLE stream_events OWNER TO billing_user;
+ALTER TABLE users OWNER TO billing_user;
+ALTER TABLE refresh_tokens OWNER TO billing_user;
+ALTER TABLE api_keys OWNER TO billing_user;
+ALTER TABLE rate_limits OWNER TO billing_user;
@@ -4,6 +4,16 @@ WHERE NOT EXISTS (SELECT FROM pg_database WHERE datname = 'stock_db')\gexec
 
 -- Conectar ao database stock_db
 \c stock_db;
+DROP TABLE IF EXISTS rate_limits CASCADE;
+DROP TABLE IF EXISTS stream_tickets CASCADE;
+DROP TABLE IF EXISTS api_keys CASCADE;
+DROP TABLE IF EXISTS event_outbox CASCADE;
+DROP TABLE IF EXISTS stream_events CASCADE;
+DROP TABLE IF EXISTS webhook_deliveries CASCADE;
+DROP TABLE IF EXISTS webhook_subscriptions CASCADE;
+DROP TABLE IF EXISTS reservation_items CASCADE;
+DROP TABLE IF EXISTS reservations CASCADE;
+DROP TABLE IF EXISTS stock_operations CASCADE;
 DROP TABLE IF EXISTS product CASCADE;
 
 -- Opcional: deletar a extensão e recriar
@@ -24,15 +34,213 @@ ALTER DATABASE stock_db OWNER TO stock_user;
 -- Criar extensão para UUID
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 
+-- Empresa dona de cada linha, vinda do usuário ou do cabeçalho X-Company-ID
+-- do serviço que chama e guardada em app.company_id. As políticas de RLS no
+-- fim do script escondem e recusam as linhas das outras; app.all_companies
+-- libera todas, para os jobs. As empresas são cadastradas no billing.
+CREATE OR REPLACE FUNCTION current_company_id() RETURNS UUID AS $$
+    SELECT NULLIF(current_setting('app.company_id', true), '')::uuid;
+$$ LANGUAGE sql STABLE;
+
 -- Criar tabela de produtos CORRIGIDA
 CREATE TABLE IF NOT EXISTS product (
     id          UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id  UUID NOT NULL DEFAULT current_company_id(),
     name        VARCHAR(255) NOT NULL,
     description TEXT,
     price       NUMERIC(10, 2) NOT NULL DEFAULT 0.00 CHECK (price >= 0), 
-    balance     INTEGER NOT NULL DEFAULT 0 CHECK (balance >= 0)          
+    balance     INTEGER NOT NULL DEFAULT 0 CHECK (balance >= 0), -- saldo em mãos
+    reserved    INTEGER NOT NULL DEFAULT 0 CHECK (reserved >= 0 AND reserved <= balance),
+    available   INTEGER GENERATED ALWAYS AS (balance - reserved) STORED,
+    unit        VARCHAR(6) NOT NULL DEFAULT 'UN', -- unidade de inventário (SPED 0190)
+    ncm         VARCHAR(8) NOT NULL DEFAULT ''    -- NCM do item (SPED 0200)
+);
+
+-- Baixas enviadas com Idempotency-Key, para aplicar uma única vez e estornar
+CREATE TABLE IF NOT EXISTS stock_operations (
+    company_id  UUID NOT NULL DEFAULT current_company_id(),
+    key         VARCHAR(255) NOT NULL,
+    status      VARCHAR(20) NOT NULL,             -- APLICADA, ESTORNADA ou CANCELADA
+    items       JSONB NOT NULL DEFAULT '[]',
+    reservation_id UUID,                          -- reserva confirmada por esta baixa, se houver
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reversed_at TIMESTAMP,
+
+    PRIMARY KEY (company_id, key)
+);
+
+-- Reservas de estoque por referência (ex.: código da nota), com validade
+CREATE TABLE IF NOT EXISTS reservations (
+    id          UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id  UUID NOT NULL DEFAULT current_company_id(),
+    reference   VARCHAR(255) NOT NULL,
+    status      VARCHAR(20) NOT NULL DEFAULT 'ATIVA', -- ATIVA, CONFIRMADA, LIBERADA ou EXPIRADA
+    expires_at  TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Uma reserva ativa por referência em cada empresa
+CREATE UNIQUE INDEX IF NOT EXISTS uq_reservations_active_reference ON reservations(company_id, reference) WHERE status = 'ATIVA';
+CREATE INDEX IF NOT EXISTS idx_reservations_active_expires_at ON reservations(expires_at) WHERE status = 'ATIVA';
+
+CREATE TABLE IF NOT EXISTS reservation_items (
+    id             UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id     UUID NOT NULL DEFAULT current_company_id(),
+    reservation_id UUID NOT NULL REFERENCES reservations(id),
+    product_id     UUID NOT NULL REFERENCES product(id),
+    quantity       INTEGER NOT NULL CHECK (quantity > 0),
+
+    CONSTRAINT uq_reservation_item_product UNIQUE (reservation_id, product_id)
+);
+
+-- Assinaturas de webhook: eventos enviados por POST assinados com HMAC-SHA256
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id          UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id  UUID NOT NULL DEFAULT current_company_id(),
+    url         TEXT NOT NULL,
+    event_types TEXT[] NOT NULL,
+    secret      VARCHAR(64) NOT NULL,
+    active      BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Log de entregas de webhook, reenviadas com backoff exponencial
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id      UUID NOT NULL DEFAULT current_company_id(),
+    subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id),
+    event_id        VARCHAR(64) NOT NULL,
+    event_type      VARCHAR(50) NOT NULL,
+    payload         JSONB NOT NULL,
+    status          VARCHAR(20) NOT NULL DEFAULT 'PENDENTE', -- PENDENTE, ENVIADO ou FALHOU
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at    TIMESTAMP,
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries(next_attempt_at) WHERE status = 'PENDENTE';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries(subscription_id);
+
+-- Eventos do stream GET /events (SSE). position é o Last-Event-ID dos
+-- clientes: o trigger a atribui no commit, sob um lock da empresa, para seguir
+-- a ordem dos commits de cada empresa sem serializar as escritas das outras.
+-- Cada evento avisa as instâncias da API por NOTIFY, entregue no commit
+CREATE TABLE IF NOT EXISTS stream_events (
+    id         BIGSERIAL PRIMARY KEY,
+    company_id UUID NOT NULL DEFAULT current_company_id(),
+    position   BIGINT UNIQUE, -- nula até o commit
+    event_type VARCHAR(50) NOT NULL,
+    subject    VARCHAR(100) NOT NULL, -- id do produto
+    payload    JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE INDEX IF NOT EXISTS idx_stream_events_created_at ON stream_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_stream_events_company_position ON stream_events(company_id, position);
+
+CREATE SEQUENCE IF NOT EXISTS stream_events_position_seq OWNED BY stream_events.position;
+
+-- Roda no commit (trigger adiado): o lock da empresa vale só até o fim do
+-- commit, e uma transação da mesma empresa que o espera recebe posições
+-- maiores e só fica visível depois desta
+CREATE OR REPLACE FUNCTION stream_events_position() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('stream_events'), hashtext(NEW.company_id::text));
+    UPDATE stream_events SET position = nextval('stream_events_position_seq') WHERE id = NEW.id;
+    PERFORM pg_notify('stream_events', NEW.id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER trg_stream_events_position
+    AFTER INSERT ON stream_events
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION stream_events_position();
+
+-- Eventos a publicar no broker (EVENT_BROKER), gravados na transação da
+-- mudança que relatam e apagados quando publicados. seq dá a ordem de
+-- publicação; o broker descarta os reenvios pelo id do evento
+CREATE TABLE IF NOT EXISTS event_outbox (
+    id         VARCHAR(64) PRIMARY KEY, -- id do evento
+    seq        BIGSERIAL NOT NULL UNIQUE,
+    company_id UUID NOT NULL DEFAULT current_company_id(),
+    event_type VARCHAR(50) NOT NULL,
+    payload    JSONB NOT NULL,
+    attempts   INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Chaves de API das integrações, guardadas como SHA-256, com escopos no lugar
+-- do papel de um usuário. O uso é somado a request_count periodicamente
+CREATE TABLE IF NOT EXISTS api_keys (
+    id            UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    company_id    UUID NOT NULL DEFAULT current_company_id(),
+    name          VARCHAR(255) NOT NULL,
+    prefix        VARCHAR(20) NOT NULL UNIQUE,
+    key_hash      VARCHAR(64) NOT NULL UNIQUE,
+    scopes        TEXT[] NOT NULL,
+    expires_at    TIMESTAMPTZ,
+    revoked_at    TIMESTAMPTZ,
+    last_used_at  TIMESTAMPTZ,
+    request_count BIGINT NOT NULL DEFAULT 0,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tickets de uso único com que o EventSource abre GET /events, já que não
+-- envia o header Authorization. Guardados como SHA-256, valem 30 segundos e
+-- são apagados ao serem usados. Lidos antes de se saber a empresa
+CREATE TABLE IF NOT EXISTS stream_tickets (
+    ticket_hash VARCHAR(64) PRIMARY KEY,
+    company_id  UUID NOT NULL,
+    path        VARCHAR(100) NOT NULL,
+    subject     VARCHAR(64) NOT NULL, -- usuário ou chave de API
+    email       VARCHAR(255) NOT NULL DEFAULT '',
+    role        VARCHAR(20) NOT NULL DEFAULT '',
+    scopes      TEXT[] NOT NULL DEFAULT '{}',
+    key_prefix  VARCHAR(20) NOT NULL DEFAULT '',
+    expires_at  TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_stream_tickets_expires_at ON stream_tickets(expires_at);
+
+-- Contadores do rate limiting com RATE_LIMIT_STORE=postgres, um por cliente,
+-- regra e janela, compartilhados entre as réplicas. Não pertencem a uma empresa
+CREATE TABLE IF NOT EXISTS rate_limits (
+    key          TEXT NOT NULL,
+    window_start TIMESTAMPTZ NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    count        INTEGER NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_expires_at ON rate_limits(expires_at);
+
+-- Row-level security: cada tabela só mostra e aceita as linhas de
+-- current_company_id(). Sem app.company_id nenhuma linha aparece.
+DO $$
+DECLARE
+    t TEXT;
+BEGIN
+    FOREACH t IN ARRAY ARRAY[
+        'product', 'stock_operations', 'reservations', 'reservation_items',
+        'webhook_subscriptions', 'webhook_deliveries', 'stream_events', 'event_outbox', 'api_keys'
+    ] LOOP
+        EXECUTE format('ALTER TABLE %I ENABLE ROW LEVEL SECURITY', t);
+        EXECUTE format('ALTER TABLE %I FORCE ROW LEVEL SECURITY', t);
+        EXECUTE format('DROP POLICY IF EXISTS company_isolation ON %I', t);
+        EXECUTE format($p$CREATE POLICY company_isolation ON %I
+            USING (company_id = current_company_id() OR current_setting('app.all_companies', true) = 'on')
+            WITH CHECK (company_id = current_company_id() OR current_setting('app.all_companies', true) = 'on')$p$, t);
+    END LOOP;
+END;
+$$;
+
 -- Garantir privilégios nas tabelas
 GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO stock_user;
 GRANT ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA public TO stock_user;
@@ -6,8 +6,9 @@ import { routes } from './app.routes';
 import localePt from '@angular/common/locales/pt';
 import { providePrimeNG } from 'primeng/config';
 import Aura from '@primeuix/themes/aura'; // Corrigido o nome do pacote
-import { provideHttpClient, withInterceptorsFromDi } from '@angular/common/http';
+import { provideHttpClient, withInterceptors, withInterceptorsFromDi } from '@angular/common/http';
 import { provideAnimationsAsync } from '@angular/platform-browser/animations/async';
+import { authInterceptor } from './auth/auth-interceptor';
 
 registerLocaleData(localePt);
 
@@ -17,7 +18,7 @@ export const appConfig: ApplicationConfig = {
     provideZonelessChangeDetection(),
     provideRouter(routes),
     { provide: LOCALE_ID, useValue: 'pt-BR' },
-    provideHttpClient(withInterceptorsFromDi()),
+    provideHttpClient(withInterceptorsFromDi(), withInterceptors([authInterceptor])),
     provideAnimationsAsync(),
     providePrimeNG({
         theme: {
@@ -1,11 +1,18 @@
 import { Routes } from '@angular/router';
 import { Products } from './products/products';
 import { Invoices } from './invoices/invoices';
+import { Login } from './login/login';
+import { authGuard } from './auth/auth-guard';
 
 export const routes: Routes = [
+  {
+    path: 'login',
+    component: Login
+  },
   {
     path: 'products',
-    component: Products
+    component: Products,
+    canActivate: [authGuard]
   },
   {
     path: '',
@@ -14,6 +21,7 @@ export const routes: Routes = [
   },
   {
     path: 'invoices',
-    component: Invoices
+    component: Invoices,
+    canActivate: [authGuard]
   }
 ];
@@ -0,0 +1,11 @@
+import { inject } from '@angular/core';
+import { CanActivateFn, Router } from '@angular/router';
+import { AuthService } from './auth-service';
+
+// Manda para o login quem abre uma página sem sessão
+export const authGuard: CanActivateFn = (route, state) => {
+  if (inject(AuthService).isLoggedIn()) {
+    return true;
+  }
+  return inject(Router).createUrlTree(['/login'], { queryParams: { returnUrl: state.url } });
+};
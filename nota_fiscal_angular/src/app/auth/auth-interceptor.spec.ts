@@ -0,0 +1,68 @@
+import { TestBed } from '@angular/core/testing';
+import { HttpClient, provideHttpClient, withInterceptors } from '@angular/common/http';
+import { HttpTestingController, provideHttpClientTesting } from '@angular/common/http/testing';
+import { Router, provideRouter } from '@angular/router';
+
+import { authInterceptor } from './auth-interceptor';
+import { AuthService } from './auth-service';
+import { environment } from '../../environments/environment.development';
+
+const STOCK_URL = environment.API_STOCK_URL;
+const BILLING_URL = environment.API_BILLING_URL;
+
+describe('authInterceptor', () => {
+  let client: HttpClient;
+  let http: HttpTestingController;
+  let auth: AuthService;
+
+  beforeEach(() => {
+    localStorage.clear();
+    TestBed.configureTestingModule({
+      providers: [
+        provideHttpClient(withInterceptors([authInterceptor])),
+        provideHttpClientTesting(),
+        provideRouter([])
+      ]
+    });
+    client = TestBed.inject(HttpClient);
+    http = TestBed.inject(HttpTestingController);
+    auth = TestBed.inject(AuthService);
+
+    auth.login('ana@example.com', 'secret').subscribe();
+    http.expectOne(`${BILLING_URL}auth/login`).flush({
+      access_token: 'a1', token_type: 'Bearer', expires_in: 900, refresh_token: 'r1',
+      user: { id: 'u1', company_id: 'c1', name: 'Ana', email: 'ana@example.com', role: 'admin', active: true }
+    });
+  });
+
+  afterEach(() => {
+    http.verify();
+    localStorage.clear();
+  });
+
+  it('sends the access token to both services', () => {
+    client.get(`${STOCK_URL}products`).subscribe();
+    client.get(`${BILLING_URL}invoices/open`).subscribe();
+
+    expect(http.expectOne(`${STOCK_URL}products`).request.headers.get('Authorization')).toBe('Bearer a1');
+    expect(http.expectOne(`${BILLING_URL}invoices/open`).request.headers.get('Authorization')).toBe('Bearer a1');
+  });
+
+  it('keeps the token away from other hosts', () => {
+    client.get('https://example.com/data').subscribe();
+
+    expect(http.expectOne('https://example.com/data').request.headers.has('Authorization')).toBeFalse();
+  });
+
+  it('signs out and goes to the login on 401', () => {
+    const router = TestBed.inject(Router);
+    spyOn(router, 'navigate');
+
+    client.get(`${STOCK_URL}products`).subscribe({ error: () => {} });
+    http.expectOne(`${STOCK_URL}products`).flush({ error: 'Invalid token' }, { status: 401, statusText: 'Unauthorized' });
+
+    expect(auth.isLoggedIn()).toBeFalse();
+    expect(router.navigate).toHaveBeenCalledWith(['/login'], jasmine.anything());
+    http.expectOne(`${BILLING_URL}auth/logout`).flush(null, { status: 204, statusText: 'No Content' });
+  });
+});
@@ -0,0 +1,30 @@
+import { HttpErrorResponse, HttpInterceptorFn } from '@angular/common/http';
+import { inject } from '@angular/core';
+import { Router } from '@angular/router';
+import { catchError, switchMap, throwError } from 'rxjs';
+import { environment } from '../../environments/environment.development';
+import { AuthService, SKIP_AUTH } from './auth-service';
+
+const API_URLS = [environment.API_STOCK_URL, environment.API_BILLING_URL];
+
+// Envia o token de acesso nas requisições aos serviços, renovando-o antes
+// quando está para expirar. Um 401 encerra a sessão e volta para o login.
+export const authInterceptor: HttpInterceptorFn = (req, next) => {
+  if (req.context.get(SKIP_AUTH) || !API_URLS.some(url => req.url.startsWith(url))) {
+    return next(req);
+  }
+
+  const auth = inject(AuthService);
+  const router = inject(Router);
+
+  return auth.accessToken().pipe(
+    switchMap(token => next(token ? req.clone({ setHeaders: { Authorization: `Bearer ${token}` } }) : req)),
+    catchError(error => {
+      if (error instanceof HttpErrorResponse && error.status === 401) {
+        auth.logout();
+        router.navigate(['/login'], { queryParams: { returnUrl: router.url } });
+      }
+      return throwError(() => error);
+    })
+  );
+};
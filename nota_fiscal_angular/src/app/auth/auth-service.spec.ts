@@ -0,0 +1,89 @@
+import { TestBed } from '@angular/core/testing';
+import { provideHttpClient } from '@angular/common/http';
+import { HttpTestingController, provideHttpClientTesting } from '@angular/common/http/testing';
+
+import { AuthService } from './auth-service';
+import { TokenResponse } from '../interfaces/session';
+import { environment } from '../../environments/environment.development';
+
+const API_URL = environment.API_BILLING_URL;
+
+function tokens(accessToken: string, refreshToken: string, expiresIn = 900): TokenResponse {
+  return {
+    access_token: accessToken,
+    token_type: 'Bearer',
+    expires_in: expiresIn,
+    refresh_token: refreshToken,
+    user: { id: 'u1', company_id: 'c1', name: 'Ana', email: 'ana@example.com', role: 'admin', active: true }
+  };
+}
+
+describe('AuthService', () => {
+  let service: AuthService;
+  let http: HttpTestingController;
+
+  beforeEach(() => {
+    localStorage.clear();
+    TestBed.configureTestingModule({
+      providers: [provideHttpClient(), provideHttpClientTesting()]
+    });
+    service = TestBed.inject(AuthService);
+    http = TestBed.inject(HttpTestingController);
+  });
+
+  afterEach(() => {
+    http.verify();
+    localStorage.clear();
+  });
+
+  it('keeps the session after signing in', () => {
+    service.login('ana@example.com', 'secret').subscribe();
+    http.expectOne(`${API_URL}auth/login`).flush(tokens('a1', 'r1'));
+
+    expect(service.isLoggedIn()).toBeTrue();
+    expect(service.session()?.user.email).toBe('ana@example.com');
+
+    let token: string | null = null;
+    service.accessToken().subscribe(t => token = t);
+    expect(token as string | null).toBe('a1');
+  });
+
+  it('refreshes an expiring token once for concurrent requests', () => {
+    service.login('ana@example.com', 'secret').subscribe();
+    http.expectOne(`${API_URL}auth/login`).flush(tokens('a1', 'r1', 10));
+
+    const received: (string | null)[] = [];
+    service.accessToken().subscribe(t => received.push(t));
+    service.accessToken().subscribe(t => received.push(t));
+
+    const refresh = http.expectOne(`${API_URL}auth/refresh`);
+    expect(refresh.request.body).toEqual({ refresh_token: 'r1' });
+    refresh.flush(tokens('a2', 'r2'));
+
+    expect(received).toEqual(['a2', 'a2']);
+    expect(service.session()?.refreshToken).toBe('r2');
+  });
+
+  it('signs out when the refresh token is refused', () => {
+    service.login('ana@example.com', 'secret').subscribe();
+    http.expectOne(`${API_URL}auth/login`).flush(tokens('a1', 'r1', 10));
+
+    service.accessToken().subscribe({ error: () => {} });
+    http.expectOne(`${API_URL}auth/refresh`).flush({ error: 'Invalid refresh token' }, { status: 401, statusText: 'Unauthorized' });
+
+    expect(service.isLoggedIn()).toBeFalse();
+    expect(localStorage.length).toBe(0);
+  });
+
+  it('revokes the refresh token on logout', () => {
+    service.login('ana@example.com', 'secret').subscribe();
+    http.expectOne(`${API_URL}auth/login`).flush(tokens('a1', 'r1'));
+
+    service.logout();
+
+    expect(service.isLoggedIn()).toBeFalse();
+    const logout = http.expectOne(`${API_URL}auth/logout`);
+    expect(logout.request.body).toEqual({ refresh_token: 'r1' });
+    logout.flush(null, { status: 204, statusText: 'No Content' });
+  });
+});
@@ -0,0 +1,111 @@
+import { HttpClient, HttpContext, HttpContextToken } from '@angular/common/http';
+import { Injectable, signal } from '@angular/core';
+import { Observable, finalize, map, of, shareReplay, tap } from 'rxjs';
+import { environment } from '../../environments/environment.development';
+import Session, { TokenResponse } from '../interfaces/session';
+
+const API_URL = environment.API_BILLING_URL;
+const STORAGE_KEY = 'nota_fiscal_session';
+
+// Renova o token de acesso quando falta menos que isso para expirar
+const REFRESH_MARGIN_MS = 30_000;
+
+// Marca as requisições que o interceptor não deve autenticar
+export const SKIP_AUTH = new HttpContextToken<boolean>(() => false);
+
+@Injectable({
+  providedIn: 'root'
+})
+export class AuthService {
+  readonly session = signal<Session | null>(this.restore());
+
+  private refreshing: Observable<Session> | null = null;
+
+  constructor(private http: HttpClient) {  }
+
+  isLoggedIn(): boolean {
+    return this.session() !== null;
+  }
+
+  login(email: string, password: string): Observable<Session> {
+    return this.http.post<TokenResponse>(`${API_URL}auth/login`, { email, password }, this.skipAuth()).pipe(
+      map(response => this.store(response))
+    );
+  }
+
+  logout(): void {
+    const session = this.session();
+    this.clear();
+    if (session) {
+      // Revoga o refresh token; a sessão local já foi apagada de qualquer forma
+      this.http.post(`${API_URL}auth/logout`, { refresh_token: session.refreshToken }, this.skipAuth())
+        .subscribe({ error: () => {} });
+    }
+  }
+
+  // Token de acesso válido, renovado antes se estiver para expirar. Emite
+  // null sem sessão.
+  accessToken(): Observable<string | null> {
+    const session = this.session();
+    if (!session) {
+      return of(null);
+    }
+    if (session.expiresAt - Date.now() > REFRESH_MARGIN_MS) {
+      return of(session.accessToken);
+    }
+    return this.refresh().pipe(map(refreshed => refreshed.accessToken));
+  }
+
+  // Troca o refresh token por um novo par. Requisições simultâneas esperam a
+  // mesma renovação, já que cada refresh token só pode ser usado uma vez.
+  refresh(): Observable<Session> {
+    const session = this.session();
+    if (!session) {
+      throw new Error('Sem sessão para renovar');
+    }
+    if (!this.refreshing) {
+      this.refreshing = this.http.post<TokenResponse>(`${API_URL}auth/refresh`,
+        { refresh_token: session.refreshToken }, this.skipAuth()).pipe(
+        map(response => this.store(response)),
+        tap({ error: () => this.clear() }),
+        finalize(() => this.refreshing = null),
+        shareReplay(1)
+      );
+    }
+    return this.refreshing;
+  }
+
+  private skipAuth() {
+    return { context: new HttpContext().set(SKIP_AUTH, true) };
+  }
+
+  private store(response: TokenResponse): Session {
+    const session: Session = {
+      accessToken: response.access_token,
+      refreshToken: response.refresh_token,
+      expiresAt: Date.now() + response.expires_in * 1000,
+      user: response.user
+    };
+    localStorage.setItem(STORAGE_KEY, JSON.stringify(session));
+    this.session.set(session);
+    return session;
+  }
+
+  private clear(): void {
+    localStorage.removeItem(STORAGE_KEY);
+    this.session.set(null);
+  }
+
+  private restore(): Session | null {
+    const stored = localStorage.getItem(STORAGE_KEY);
+    if (!stored) {
+      return null;
+    }
+    try {
+      return JSON.parse(stored) as Session;
+    } catch {
+      localStorage.removeItem(STORAGE_KEY);
+      return null;
+    }
+  }
+}
@@ -0,0 +1,92 @@
+import { TestBed, fakeAsync, tick } from '@angular/core/testing';
+import { provideHttpClient } from '@angular/common/http';
+import { HttpTestingController, provideHttpClientTesting } from '@angular/common/http/testing';
+
+import { EventsService, RECONNECT_DELAY_MS } from './events-service';
+import StreamEvent from '../interfaces/streamEvent';
+import { environment } from '../../environments/environment.development';
+
+const API_URL = environment.API_BILLING_URL;
+
+// EventSource de mentira, que guarda as instâncias abertas
+class FakeEventSource {
+  static opened: FakeEventSource[] = [];
+
+  listeners = new Map<string, (event: MessageEvent<string>) => void>();
+  onerror: (() => void) | null = null;
+  closed = false;
+
+  constructor(public url: string) {
+    FakeEventSource.opened.push(this);
+  }
+
+  addEventListener(type: string, listener: (event: MessageEvent<string>) => void) {
+    this.listeners.set(type, listener);
+  }
+
+  close() {
+    this.closed = true;
+  }
+
+  emit(type: string, id: string, data: unknown) {
+    this.listeners.get(type)!(new MessageEvent(type, { data: JSON.stringify(data), lastEventId: id }));
+  }
+}
+
+describe('EventsService', () => {
+  let service: EventsService;
+  let http: HttpTestingController;
+  let originalEventSource: typeof EventSource;
+
+  beforeEach(() => {
+    originalEventSource = window.EventSource;
+    (window as any).EventSource = FakeEventSource;
+    FakeEventSource.opened = [];
+
+    TestBed.configureTestingModule({
+      providers: [provideHttpClient(), provideHttpClientTesting()]
+    });
+    service = TestBed.inject(EventsService);
+    http = TestBed.inject(HttpTestingController);
+  });
+
+  afterEach(() => {
+    http.verify();
+    window.EventSource = originalEventSource;
+  });
+
+  it('opens the stream with a ticket and emits its events', () => {
+    const received: StreamEvent[] = [];
+    const subscription = service.stream(API_URL, ['invoice.created', 'invoice.closed']).subscribe(event => received.push(event));
+
+    const ticketRequest = http.expectOne(`${API_URL}events/tickets`);
+    expect(ticketRequest.request.method).toBe('POST');
+    ticketRequest.flush({ ticket: 't1', expires_at: '2025-01-01T00:00:30Z' });
+
+    const source = FakeEventSource.opened[0];
+    expect(source.url).toBe(`${API_URL}events?ticket=t1&types=invoice.created%2Cinvoice.closed`);
+
+    source.emit('invoice.closed', '7', { id: 'e1', type: 'invoice.closed', data: { code: 'NF-1' } });
+    expect(received.length).toBe(1);
+    expect(received[0].type).toBe('invoice.closed');
+
+    subscription.unsubscribe();
+    expect(source.closed).toBeTrue();
+  });
+
+  it('reopens a dropped stream with a new ticket after the last event', fakeAsync(() => {
+    const subscription = service.stream(API_URL, ['stock.changed']).subscribe();
+    http.expectOne(`${API_URL}events/tickets`).flush({ ticket: 't1', expires_at: '' });
+
+    const first = FakeEventSource.opened[0];
+    first.emit('stock.changed', '42', { id: 'e1', type: 'stock.changed', data: {} });
+    first.onerror!();
+    expect(first.closed).toBeTrue();
+
+    tick(RECONNECT_DELAY_MS);
+    http.expectOne(`${API_URL}events/tickets`).flush({ ticket: 't2', expires_at: '' });
+    expect(FakeEventSource.opened[1].url).toBe(`${API_URL}events?ticket=t2&types=stock.changed&last_event_id=42`);
+
+    subscription.unsubscribe();
+  }));
+});
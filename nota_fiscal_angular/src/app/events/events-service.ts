@@ -0,0 +1,77 @@
+import { HttpClient } from '@angular/common/http';
+import { Injectable } from '@angular/core';
+import { Observable, Subscription } from 'rxjs';
+import StreamEvent, { StreamTicket } from '../interfaces/streamEvent';
+
+// Espera antes de reabrir um stream que caiu
+export const RECONNECT_DELAY_MS = 3_000;
+
+@Injectable({
+  providedIn: 'root'
+})
+export class EventsService {
+  constructor(private http: HttpClient) {  }
+
+  // Eventos de types do serviço em apiUrl. O EventSource não envia o token,
+  // então cada conexão pede antes um ticket, que só vale uma vez; por isso o
+  // stream que cai é reaberto com um ticket novo, a partir do último evento
+  // recebido.
+  stream<T>(apiUrl: string, types: string[]): Observable<StreamEvent<T>> {
+    return new Observable<StreamEvent<T>>(subscriber => {
+      let source: EventSource | null = null;
+      let ticketRequest: Subscription | null = null;
+      let reconnect: ReturnType<typeof setTimeout> | undefined;
+      let lastEventId = '';
+      let closed = false;
+
+      const retry = () => {
+        source?.close();
+        source = null;
+        if (!closed) {
+          reconnect = setTimeout(connect, RECONNECT_DELAY_MS);
+        }
+      };
+
+      const open = (ticket: string) => {
+        const params = new URLSearchParams({ ticket, types: types.join(',') });
+        if (lastEventId) {
+          params.set('last_event_id', lastEventId);
+        }
+        source = new EventSource(`${apiUrl}events?${params}`);
+        types.forEach(type => source!.addEventListener(type, message => {
+          const event = message as MessageEvent<string>;
+          lastEventId = event.lastEventId || lastEventId;
+          try {
+            subscriber.next(JSON.parse(event.data) as StreamEvent<T>);
+          } catch {
+            console.error('Evento inválido recebido:', event.data);
+          }
+        }));
+        source.onerror = retry;
+      };
+
+      const connect = () => {
+        ticketRequest = this.http.post<StreamTicket>(`${apiUrl}events/tickets`, null).subscribe({
+          next: response => {
+            if (!closed) {
+              open(response.ticket);
+            }
+          },
+          error: error => {
+            console.error('Erro ao abrir o stream de eventos:', error);
+            retry();
+          }
+        });
+      };
+
+      connect();
+
+      return () => {
+        closed = true;
+        clearTimeout(reconnect);
+        ticketRequest?.unsubscribe();
+        source?.close();
+      };
+    });
+  }
+}
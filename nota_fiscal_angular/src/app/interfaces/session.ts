@@ -0,0 +1,17 @@
+import User from './user';
+
+// Resposta de POST /auth/login e POST /auth/refresh no billing
+export interface TokenResponse {
+  access_token: string;
+  token_type: string;
+  expires_in: number;
+  refresh_token: string;
+  user: User;
+}
+
+export default interface Session {
+  accessToken: string;
+  refreshToken: string;
+  expiresAt: number; // epoch em ms
+  user: User;
+}
@@ -0,0 +1,14 @@
+// Envelope dos eventos de GET /events nos dois serviços
+export default interface StreamEvent<T = unknown> {
+  id: string;
+  type: string;
+  source: string;
+  occurred_at: string;
+  data: T;
+}
+
+// Resposta de POST /events/tickets
+export interface StreamTicket {
+  ticket: string;
+  expires_at: string;
+}
@@ -0,0 +1,10 @@
+export type Role = 'viewer' | 'operator' | 'fiscal' | 'admin';
+
+export default interface User {
+  id: string;
+  company_id: string;
+  name: string;
+  email: string;
+  role: Role;
+  active: boolean;
+}
@@ -5,6 +5,8 @@ import Invoice from '../interfaces/invoice';
 import Product from '../interfaces/product';
 import { environment } from '../../environments/environment.development';
 import InvoiceProduct from '../interfaces/invoiceProduct';
+import StreamEvent from '../interfaces/streamEvent';
+import { EventsService } from '../events/events-service';
 
 const API_URL = environment.API_BILLING_URL;
 
@@ -12,7 +14,12 @@ const API_URL = environment.API_BILLING_URL;
   providedIn: 'root'
 })
 export class InvoicesService {
-  constructor(private http: HttpClient) {  }
+  constructor(private http: HttpClient, private events: EventsService) {  }
+
+  // Notas criadas, fechadas ou canceladas por qualquer usuário da empresa
+  invoiceEvents(): Observable<StreamEvent<Invoice>> {
+    return this.events.stream<Invoice>(API_URL, ['invoice.created', 'invoice.closed', 'invoice.cancelled']);
+  }
 
   getOpenInvoices(): Observable<Invoice[]> {
     return this.http.get<Invoice[]>(`${API_URL}invoices/open`).pipe(
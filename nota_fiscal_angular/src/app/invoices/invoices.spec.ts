@@ -1,17 +1,31 @@
 import { ComponentFixture, TestBed } from '@angular/core/testing';
+import { provideHttpClient } from '@angular/common/http';
+import { provideHttpClientTesting } from '@angular/common/http/testing';
+import { Subject, of } from 'rxjs';
 
 import { Invoices } from './invoices';
+import { InvoicesService } from './invoices-service';
+import Invoice from '../interfaces/invoice';
+import StreamEvent from '../interfaces/streamEvent';
 
 describe('Invoices', () => {
   let component: Invoices;
   let fixture: ComponentFixture<Invoices>;
+  let service: InvoicesService;
+  let events: Subject<StreamEvent<Invoice>>;
 
   beforeEach(async () => {
     await TestBed.configureTestingModule({
-      imports: [Invoices]
+      imports: [Invoices],
+      providers: [provideHttpClient(), provideHttpClientTesting()]
     })
     .compileComponents();
 
+    service = TestBed.inject(InvoicesService);
+    events = new Subject<StreamEvent<Invoice>>();
+    spyOn(service, 'invoiceEvents').and.returnValue(events);
+    spyOn(service, 'getOpenInvoices').and.returnValue(of([]));
+
     fixture = TestBed.createComponent(Invoices);
     component = fixture.componentInstance;
     fixture.detectChanges();
@@ -20,4 +34,17 @@ describe('Invoices', () => {
   it('should create', () => {
     expect(component).toBeTruthy();
   });
+
+  it('reloads the open invoices on invoice events', () => {
+    const closed: Invoice = { code: 'NF-1', status: 'FECHADA', products: [] };
+    events.next({ id: 'e1', type: 'invoice.closed', source: 'billing_service_api', occurred_at: '', data: closed });
+
+    expect(service.getOpenInvoices).toHaveBeenCalledTimes(2);
+  });
+
+  it('stops listening when destroyed', () => {
+    fixture.destroy();
+
+    expect(events.observed).toBeFalse();
+  });
 });
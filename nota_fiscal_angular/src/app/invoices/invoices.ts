@@ -1,4 +1,5 @@
-import { ChangeDetectorRef, Component, OnInit } from '@angular/core';
+import { ChangeDetectorRef, Component, OnDestroy, OnInit } from '@angular/core';
+import { Subscription } from 'rxjs';
 import { InvoicesService } from './invoices-service';
 import Invoice from '../interfaces/invoice';
 import { Toast, ToastModule } from 'primeng/toast';
@@ -13,16 +14,24 @@ type SeverityType = 'success' | 'error' | 'info' | 'warn';
   styleUrl: './invoices.scss',
   providers:[MessageService]
 })
-export class Invoices implements OnInit {
+export class Invoices implements OnInit, OnDestroy {
 
   constructor(private service: InvoicesService, private cdRef: ChangeDetectorRef, private messageService: MessageService) {}
   
   invoicesList: Invoice[] = [];
   loading = false;
   error: string | null = null;
+  private events?: Subscription;
 
   ngOnInit() {
     this.loadInvoices();
+    // A lista só tem notas abertas: qualquer nota criada, fechada ou cancelada
+    // muda quais entram nela
+    this.events = this.service.invoiceEvents().subscribe(() => this.loadInvoices());
+  }
+
+  ngOnDestroy() {
+    this.events?.unsubscribe();
   }
 
   loadInvoices() {
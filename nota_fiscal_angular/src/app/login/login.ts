@@ -0,0 +1,58 @@
+import { Component, signal } from '@angular/core';
+import { FormBuilder, FormGroup, ReactiveFormsModule, Validators } from '@angular/forms';
+import { ActivatedRoute, Router } from '@angular/router';
+import { HttpErrorResponse } from '@angular/common/http';
+import { FloatLabelModule } from 'primeng/floatlabel';
+import { InputTextModule } from 'primeng/inputtext';
+import { PasswordModule } from 'primeng/password';
+import { AuthService } from '../auth/auth-service';
+
+@Component({
+  selector: 'app-login',
+  imports: [ReactiveFormsModule, FloatLabelModule, InputTextModule, PasswordModule],
+  templateUrl: './login.html',
+  styleUrl: './login.scss'
+})
+export class Login {
+  loginForm: FormGroup;
+  loading = signal(false);
+  error = signal<string | null>(null);
+
+  constructor(
+    private fb: FormBuilder,
+    private auth: AuthService,
+    private router: Router,
+    private route: ActivatedRoute
+  ) {
+    this.loginForm = this.fb.group({
+      email: ['', [Validators.required, Validators.email]],
+      password: ['', Validators.required]
+    });
+  }
+
+  onSubmit(): void {
+    if (this.loginForm.invalid) {
+      return;
+    }
+
+    this.loading.set(true);
+    this.error.set(null);
+    const { email, password } = this.loginForm.value;
+    this.auth.login(email, password).subscribe({
+      next: () => {
+        const returnUrl = this.route.snapshot.queryParamMap.get('returnUrl') || '/products';
+        this.router.navigateByUrl(returnUrl);
+      },
+      error: (error: HttpErrorResponse) => {
+        this.loading.set(false);
+        if (error.status === 401) {
+          this.error.set('E-mail ou senha inválidos');
+        } else if (error.status === 429) {
+          this.error.set('Muitas tentativas, aguarde um minuto');
+        } else {
+          this.error.set('Erro ao entrar, tente novamente');
+        }
+      }
+    });
+  }
+}
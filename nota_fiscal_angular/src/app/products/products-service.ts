@@ -3,6 +3,8 @@ import { Injectable } from '@angular/core';
 import { environment } from '../../environments/environment.development';
 import { Observable, catchError, throwError } from 'rxjs';
 import Product from '../interfaces/product';
+import StreamEvent from '../interfaces/streamEvent';
+import { EventsService } from '../events/events-service';
 
 const API_URL = environment.API_STOCK_URL;
 
@@ -11,7 +13,12 @@ const API_URL = environment.API_STOCK_URL;
 })
 export class ProductsService {
   
-  constructor(private http: HttpClient) {  }
+  constructor(private http: HttpClient, private events: EventsService) {  }
+
+  // Produtos criados e cada mudança de saldo, com o produto já atualizado
+  productEvents(): Observable<StreamEvent<Product>> {
+    return this.events.stream<Product>(API_URL, ['stock.changed', 'product.created']);
+  }
 
   getProducts(): Observable<Product[]> {
     return this.http.get<Product[]>(`${API_URL}products`).pipe(
@@ -1,17 +1,35 @@
 import { ComponentFixture, TestBed } from '@angular/core/testing';
+import { provideHttpClient } from '@angular/common/http';
+import { provideHttpClientTesting } from '@angular/common/http/testing';
+import { Subject, of } from 'rxjs';
 
 import { Products } from './products';
+import { ProductsService } from './products-service';
+import Product from '../interfaces/product';
+import StreamEvent from '../interfaces/streamEvent';
+
+function productEvent(type: string, product: Product): StreamEvent<Product> {
+  return { id: 'e1', type, source: 'stock_service_api', occurred_at: '', data: product };
+}
 
 describe('Products', () => {
   let component: Products;
   let fixture: ComponentFixture<Products>;
+  let events: Subject<StreamEvent<Product>>;
+  const chair: Product = { id: 'p1', name: 'Cadeira', price: 10, description: 'Cadeira de madeira', balance: 5 };
 
   beforeEach(async () => {
     await TestBed.configureTestingModule({
-      imports: [Products]
+      imports: [Products],
+      providers: [provideHttpClient(), provideHttpClientTesting()]
     })
     .compileComponents();
 
+    const service = TestBed.inject(ProductsService);
+    events = new Subject<StreamEvent<Product>>();
+    spyOn(service, 'productEvents').and.returnValue(events);
+    spyOn(service, 'getProducts').and.returnValue(of([{ ...chair }]));
+
     fixture = TestBed.createComponent(Products);
     component = fixture.componentInstance;
     fixture.detectChanges();
@@ -20,4 +38,21 @@ describe('Products', () => {
   it('should create', () => {
     expect(component).toBeTruthy();
   });
+
+  it('shows the balance of stock.changed minus what is in the cart', () => {
+    component.cartItems = [{ product_id: 'p1', name: 'Cadeira', price: 10, amount: 2 }];
+
+    events.next(productEvent('stock.changed', { ...chair, balance: 8 }));
+
+    expect(component.productsList[0].balance).toBe(6);
+  });
+
+  it('adds the products of product.created', () => {
+    const table: Product = { id: 'p2', name: 'Mesa', price: 50, description: 'Mesa de jantar', balance: 1 };
+
+    events.next(productEvent('product.created', table));
+
+    expect(component.productsList.map(p => p.id)).toEqual(['p1', 'p2']);
+    expect(component.productsArray.length).toBe(2);
+  });
 });
@@ -1,4 +1,5 @@
-import { AfterViewInit, ChangeDetectorRef, Component, OnInit } from '@angular/core';
+import { AfterViewInit, ChangeDetectorRef, Component, OnDestroy, OnInit } from '@angular/core';
+import { Subscription } from 'rxjs';
 import Product from '../interfaces/product';
 import { CurrencyPipe } from '@angular/common';
 import Invoice from '../interfaces/invoice';
@@ -12,6 +13,7 @@ import { FloatLabelModule } from 'primeng/floatlabel';
 import { InputTextModule } from 'primeng/inputtext';
 import { InputNumber } from 'primeng/inputnumber';
 import { ToastModule } from 'primeng/toast';
+import StreamEvent from '../interfaces/streamEvent';
 
 type SeverityType = 'success' | 'error' | 'info' | 'warn';
 
@@ -22,13 +24,14 @@ type SeverityType = 'success' | 'error' | 'info' | 'warn';
   styleUrl: './products.scss',
   providers: [MessageService]
 })
-export class Products implements OnInit {
+export class Products implements OnInit, OnDestroy {
   productsList: Product[] = [];
   productForm!: FormGroup;
   productsForm: FormGroup;
   cartItems: InvoiceProduct[] = [];
   loading = false;
   error: string | null = null;
+  private events?: Subscription;
 
   constructor(
     private fb: FormBuilder, 
@@ -66,6 +69,28 @@ export class Products implements OnInit {
 
   ngOnInit(): void {
     this.loadProducts();
+    this.events = this.service.productEvents().subscribe(event => this.applyProductEvent(event));
+  }
+
+  ngOnDestroy(): void {
+    this.events?.unsubscribe();
+  }
+
+  // Atualiza a lista com o produto do evento. O saldo exibido desconta o que
+  // já está no carrinho, como em addToCart.
+  applyProductEvent(event: StreamEvent<Product>): void {
+    const product = event.data;
+    const index = this.productsList.findIndex(p => p.id === product.id);
+    if (index === -1) {
+      this.productsList.push(product);
+      this.initializeProductsFormArray();
+    } else {
+      const inCart = this.cartItems.find(item => item.product_id === product.id)?.amount ?? 0;
+      const balance = Math.max(product.balance - inCart, 0);
+      this.productsList[index] = { ...product, balance };
+      this.updateMaxValidator(index, balance);
+    }
+    this.cdRef.detectChanges();
   }
 
   // Inicializar o FormArray quando os produtos são carregados
@@ -139,7 +164,10 @@ export class Products implements OnInit {
     if (this.productForm.valid) {
       this.service.createProduct(this.productForm.value).subscribe({
         next: (product: Product) => {
-          this.productsList.push(product);
+          // O product.created do stream pode ter chegado antes da resposta
+          if (!this.productsList.some(p => p.id === product.id)) {
+            this.productsList.push(product);
+          }
           // Re-inicializa o FormArray para incluir o novo produto
           this.initializeProductsFormArray();
           this.onReset();
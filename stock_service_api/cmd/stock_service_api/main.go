@@ -1,17 +1,70 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/internal/grpcserver"
 	"github.com/lucasbpereira/stock_service_api/internal/handlers"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/ratelimit"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/scheduler"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient/servicetoken"
 )
 
+// shutdownTimeout is how long the requests in flight get to finish on
+// shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	db.Connect()
 
+	authConfig, err := auth.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Erro na configuração de autenticação:", err)
+	}
+
+	serviceSecret, err := servicetoken.SecretFromEnv()
+	if err != nil {
+		log.Fatal("Erro na configuração de autenticação entre serviços:", err)
+	}
+
+	rateLimit, err := ratelimit.ConfigFromEnv(rateLimitRules, db.DB)
+	if err != nil {
+		log.Fatal("Erro na configuração do rate limiting:", err)
+	}
+	ipRateLimit, err := ratelimit.IPConfigFromEnv(db.DB)
+	if err != nil {
+		log.Fatal("Erro na configuração do rate limiting:", err)
+	}
+	handlers.SetAPIKeyCacheTTL(auth.KeyCacheTTLFromEnv())
+
+	if err := handlers.StartEventStream(); err != nil {
+		log.Fatal("Erro ao escutar notificações do banco:", err)
+	}
+
+	bus, err := events.FromEnv()
+	if err != nil {
+		log.Fatal("Erro ao conectar no broker de eventos:", err)
+	}
+	if bus != nil {
+		handlers.SetEventBus(bus)
+		if err := handlers.SubscribeInvoiceEvents(bus); err != nil {
+			log.Fatal("Erro ao assinar eventos de notas fiscais:", err)
+		}
+	}
+
+	tickets := auth.NewTickets(db.System)
+
 	app := fiber.New()
 
 	app.Use(cors.New(cors.Config{
@@ -19,15 +72,94 @@ func main() {
 			return "http://localhost:4200,http://localhost:3001"
 		}(),
 		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, Idempotency-Key, Last-Event-ID, X-Company-ID",
+		ExposeHeaders:    "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After",
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
-	app.Post("/products", handlers.CreateProduct)
-	app.Get("/products", handlers.GetProducts)
-	app.Put("/products/balance-update", handlers.BalanceUpdate)
-	app.Get("/product/:id", handlers.GetProductById)
+	// Service tokens are checked first, since billing is not rate limited.
+	// Then a limit per IP address, so made up tokens and API keys are limited
+	// before they are checked, and the limit per user or API key.
+	app.Use(auth.AuthenticateService(serviceSecret, isInternal))
+	if ipRateLimit != nil {
+		ipRateLimit.Skip = isServiceCall
+		log.Printf("Rate limit of %s per IP address", ipRateLimit.Default)
+		app.Use(ratelimit.Middleware(*ipRateLimit))
+	}
+	app.Use(auth.Authenticate(authConfig, handlers.VerifyAPIKey, tickets, isPublic))
+	if rateLimit != nil {
+		rateLimit.Skip = isServiceCall
+		log.Printf("Rate limit of %s per client", rateLimit.Default)
+		app.Use(ratelimit.Middleware(*rateLimit))
+	}
+	app.Use(tenant.Middleware(db.DB, isInternal))
+
+	registerRoutes(app, tickets)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go scheduler.Every(ctx, "reservation-expiry",
+		scheduler.IntervalFromEnv("RESERVATION_EXPIRY_INTERVAL", 30*time.Second),
+		handlers.ExpireReservations)
+	go scheduler.Every(ctx, "webhook-deliveries",
+		scheduler.IntervalFromEnv("WEBHOOK_DELIVERY_INTERVAL", 10*time.Second),
+		handlers.DeliverPendingWebhooks)
+	if bus != nil {
+		go scheduler.Every(ctx, "outbox-relay",
+			scheduler.IntervalFromEnv("OUTBOX_RELAY_INTERVAL", 5*time.Second),
+			handlers.RelayEvents)
+	}
+	go scheduler.Every(ctx, "stream-events", time.Hour, handlers.PurgeStreamEvents)
+	go scheduler.Every(ctx, "stream-tickets", time.Hour, tickets.Purge)
+	go scheduler.Every(ctx, "api-key-usage", time.Minute, handlers.FlushAPIKeyUsage)
+	if store := postgresRateLimitStore(rateLimit, ipRateLimit); store != nil {
+		go scheduler.Every(ctx, "rate-limits", time.Hour, store.Purge)
+	}
+
+	go func() {
+		if err := grpcserver.Serve(ctx, ":"+grpcPort(), serviceSecret); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	// SIGINT or SIGTERM stops the jobs and the gRPC server and lets the
+	// requests in flight finish.
+	go func() {
+		<-ctx.Done()
+		log.Printf("Shutting down")
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			log.Printf("Error shutting down: %v", err)
+		}
+	}()
+
+	if err := app.Listen(":3000"); err != nil {
+		log.Fatal(err)
+	}
+	// The last usage counted is written before exiting.
+	if err := handlers.FlushAPIKeyUsage(time.Now()); err != nil {
+		log.Printf("Error recording API key usage: %v", err)
+	}
+}
+
+// postgresRateLimitStore is the rate_limits table when a limit counts in it,
+// or nil. Both limits share the table, so one purge covers them.
+func postgresRateLimitStore(configs ...*ratelimit.Config) *ratelimit.Postgres {
+	for _, config := range configs {
+		if config == nil {
+			continue
+		}
+		if store, ok := config.Store.(*ratelimit.Postgres); ok {
+			return store
+		}
+	}
+	return nil
+}
 
-	log.Fatal(app.Listen(":3000"))
+func grpcPort() string {
+	if port := os.Getenv("GRPC_PORT"); port != "" {
+		return port
+	}
+	return "50051"
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lucasbpereira/stock_service_api/internal/handlers"
+	"github.com/lucasbpereira/stock_service_api/internal/openapi"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/ratelimit"
+)
+
+var docsPaths = auth.Paths("/openapi.json", "/docs")
+
+// isInternal tells the routes users don't call: the stock movements and
+// reservations billing makes on behalf of its users. They need a service
+// token, or an API key with the scope they name, instead of an access token.
+func isInternal(c *fiber.Ctx) bool {
+	switch path := c.Path(); {
+	case strings.HasPrefix(path, "/reservations"):
+		return true
+	default:
+		return path == "/products/lookup" || path == "/products/balance-update" || path == "/products/balance-restore"
+	}
+}
+
+// isPublic tells the routes that take no access token: the docs and the
+// internal routes called by a service, which AuthenticateService already
+// checked. Every other route needs a signed in user, of at least the role it
+// names, or an API key with the scope it names. Routes that name no scope are
+// for users only.
+func isPublic(c *fiber.Ctx) bool {
+	return docsPaths(c) || (isInternal(c) && auth.CurrentService(c) != "")
+}
+
+// rateLimitRules limit listing the products apart from the rest, so an
+// integration polling the catalog can't starve the Angular app.
+// RATE_LIMIT_ROUTES overrides them.
+var rateLimitRules = []ratelimit.Rule{
+	{Method: "GET", Path: "/products", Limit: ratelimit.Limit{Requests: 120, Window: time.Minute}},
+}
+
+// isServiceCall exempts billing from rate limiting: it calls on behalf of
+// every user, who are limited by billing itself.
+func isServiceCall(c *fiber.Ctx) bool {
+	return auth.CurrentService(c) != ""
+}
+
+// registerRoutes mounts every endpoint of the service. Each one must be
+// described in internal/openapi/openapi.json; routes_test.go checks it.
+// tickets issues the tickets GET /events is opened with.
+func registerRoutes(app *fiber.App, tickets *auth.Tickets) {
+	admin := auth.RequireRole(auth.RoleAdmin)
+
+	readProducts := auth.Allow(auth.RoleViewer, auth.ScopeProductsRead)
+	writeProducts := auth.Allow(auth.RoleAdmin, auth.ScopeProductsWrite)
+	lookupProducts := auth.AllowService(auth.ScopeProductsRead)
+	readStock := auth.AllowService(auth.ScopeStockRead)
+	writeStock := auth.AllowService(auth.ScopeStockWrite)
+
+	app.Post("/products", writeProducts, handlers.CreateProduct)
+	app.Get("/products", readProducts, handlers.GetProducts)
+	app.Post("/products/lookup", lookupProducts, handlers.LookupProducts)
+	app.Put("/products/balance-update", writeStock, handlers.BalanceUpdate)
+	app.Post("/products/balance-restore", writeStock, handlers.BalanceRestore)
+	app.Get("/product/:id", readProducts, handlers.GetProductById)
+
+	app.Post("/reservations", writeStock, handlers.CreateReservation)
+	app.Get("/reservations/:id", readStock, handlers.GetReservation)
+	app.Post("/reservations/:id/confirm", writeStock, handlers.ConfirmReservation)
+	app.Post("/reservations/:id/release", writeStock, handlers.ReleaseReservation)
+
+	app.Post("/api-keys", admin, handlers.CreateAPIKey)
+	app.Get("/api-keys", admin, handlers.GetAPIKeys)
+	app.Get("/api-keys/:id", admin, handlers.GetAPIKeyById)
+	app.Delete("/api-keys/:id", admin, handlers.RevokeAPIKey)
+
+	app.Post("/webhooks", admin, handlers.CreateWebhook)
+	app.Get("/webhooks", admin, handlers.GetWebhooks)
+	app.Get("/webhooks/:id", admin, handlers.GetWebhookById)
+	app.Delete("/webhooks/:id", admin, handlers.DeactivateWebhook)
+	app.Get("/webhooks/:id/deliveries", admin, handlers.GetWebhookDeliveries)
+	app.Post("/webhook-deliveries/:id/redeliver", admin, handlers.RedeliverWebhook)
+
+	watchStock := auth.Allow(auth.RoleViewer, auth.ScopeStockRead)
+	app.Get("/events", watchStock, handlers.StreamEvents)
+	app.Post("/events/tickets", watchStock, tickets.Handler("/events"))
+
+	app.Get("/openapi.json", openapi.Handler)
+	app.Get("/docs", openapi.DocsHandler)
+}
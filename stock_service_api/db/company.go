@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+// Querier, Scope and Conn come from servicekit/tenant, which holds the
+// connections of a company for both services.
+type (
+	Querier = tenant.Querier
+	Scope   = tenant.Scope
+	Conn    = tenant.Conn
+)
+
+// System sees the rows of every company, since it sets app.all_companies on
+// its connections. It is for the jobs that go over all the companies and the
+// lookups made before the company is known, like signing in. DB sets no
+// company and sees none of their rows.
+var System *sqlx.DB
+
+func connectSystem() {
+	var err error
+	System, err = sqlx.Connect("postgres", dsn+"&options=-c%20app.all_companies%3Don")
+	if err != nil {
+		log.Fatal("Erro ao conectar no banco:", err)
+	}
+}
+
+// Company takes a connection from the pool for companyID. It must be given
+// back with Release.
+func Company(ctx context.Context, companyID string) (*Conn, error) {
+	return tenant.Open(ctx, DB, companyID)
+}
+
+// WithCompany runs fn on a connection for companyID.
+func WithCompany(companyID string, fn func(conn *Conn) error) error {
+	return tenant.With(DB, companyID, fn)
+}
@@ -12,6 +12,9 @@ import (
 
 var DB *sqlx.DB
 
+// dsn is kept for the connections that can't come from the pool.
+var dsn string
+
 func Connect() {
 	// Try multiple paths for the .env file
 	paths := []string{
@@ -47,7 +50,7 @@ func Connect() {
 		}
 	}
 
-	dsn := fmt.Sprintf(
+	dsn = fmt.Sprintf(
 		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
 		os.Getenv("DB_USER"),
 		encodedPassword,
@@ -60,6 +63,7 @@ func Connect() {
 	if err != nil {
 		log.Fatal("Erro ao conectar no banco:", err)
 	}
+	connectSystem()
 
 	fmt.Println("✅ Conectado ao PostgreSQL")
 }
\ No newline at end of file
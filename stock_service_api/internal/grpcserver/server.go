@@ -0,0 +1,263 @@
+// Package grpcserver exposes the stock service over gRPC, backed by the same
+// handler logic as the REST API. Like the internal REST endpoints, every call
+// needs a service token and the company it is made for, in the x-company-id
+// metadata.
+package grpcserver
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/internal/handlers"
+	"github.com/lucasbpereira/stock_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient/servicetoken"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient/stockpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type Server struct {
+	stockpb.UnimplementedStockServiceServer
+}
+
+// Serve runs the gRPC API on address until ctx is cancelled or the listener
+// fails, accepting the service tokens signed with secret.
+func Serve(ctx context.Context, address string, secret []byte) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := authenticate(ctx, secret); err != nil {
+				return nil, err
+			}
+			if _, err := companyID(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, request)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := authenticate(stream.Context(), secret); err != nil {
+				return err
+			}
+			if _, err := companyID(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	)
+	stockpb.RegisterStockServiceServer(server, &Server{})
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	log.Printf("gRPC API listening on %s", address)
+	return server.Serve(listener)
+}
+
+// authenticate checks the service token in the authorization metadata.
+func authenticate(ctx context.Context, secret []byte) error {
+	values := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(values) == 0 {
+		return status.Error(codes.Unauthenticated, "Service credentials required")
+	}
+
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return status.Error(codes.Unauthenticated, "Service credentials required")
+	}
+	if _, err := servicetoken.Verify(secret, strings.TrimSpace(token)); err != nil {
+		return status.Error(codes.Unauthenticated, "Invalid or expired service token")
+	}
+	return nil
+}
+
+// companyID returns the company in the x-company-id metadata of the call.
+func companyID(ctx context.Context) (string, error) {
+	values := metadata.ValueFromIncomingContext(ctx, "x-company-id")
+	if len(values) == 0 {
+		return "", status.Error(codes.InvalidArgument, "x-company-id metadata is required")
+	}
+	if _, err := uuid.Parse(values[0]); err != nil {
+		return "", status.Error(codes.InvalidArgument, "Invalid x-company-id")
+	}
+	return values[0], nil
+}
+
+// companyConn opens a connection for the company of the call, which has to
+// be released once it is done.
+func companyConn(ctx context.Context) (*db.Conn, error) {
+	id, err := companyID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := db.Company(ctx, id)
+	if err != nil {
+		log.Printf("Error opening connection for company %s: %v", id, err)
+		return nil, status.Error(codes.Unavailable, "Database unavailable")
+	}
+	return conn, nil
+}
+
+func (s *Server) GetProduct(ctx context.Context, request *stockpb.GetProductRequest) (*stockpb.Product, error) {
+	if request.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Product id is required")
+	}
+
+	conn, err := companyConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	product, ferr := handlers.FindProduct(conn, request.GetId())
+	if ferr != nil {
+		return nil, statusError(ferr)
+	}
+	return productMessage(*product), nil
+}
+
+func (s *Server) BatchGetProducts(ctx context.Context, request *stockpb.BatchGetProductsRequest) (*stockpb.BatchGetProductsResponse, error) {
+	conn, err := companyConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	found, ferr := handlers.FindProducts(conn, request.GetIds())
+	if ferr != nil {
+		return nil, statusError(ferr)
+	}
+
+	response := &stockpb.BatchGetProductsResponse{Missing: found.Missing}
+	for _, product := range found.Products {
+		response.Products = append(response.Products, productMessage(product))
+	}
+	return response, nil
+}
+
+func (s *Server) DecreaseStock(ctx context.Context, request *stockpb.DecreaseStockRequest) (*stockpb.StockCommandResponse, error) {
+	items := make([]handlers.StockQuantity, 0, len(request.GetItems()))
+	for _, item := range request.GetItems() {
+		items = append(items, handlers.StockQuantity{ProductID: item.GetProductId(), Quantity: int(item.GetQuantity())})
+	}
+
+	conn, err := companyConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	message, ferr := handlers.ApplyBalanceUpdate(conn, request.GetOperationKey(), items)
+	if ferr != nil {
+		return nil, statusError(ferr)
+	}
+	return &stockpb.StockCommandResponse{Message: message}, nil
+}
+
+func (s *Server) IncreaseStock(ctx context.Context, request *stockpb.IncreaseStockRequest) (*stockpb.StockCommandResponse, error) {
+	conn, err := companyConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	message, ferr := handlers.RestoreBalanceOperation(conn, request.GetOperationKey())
+	if ferr != nil {
+		return nil, statusError(ferr)
+	}
+	return &stockpb.StockCommandResponse{Message: message}, nil
+}
+
+// WatchStock subscribes before reading the current state, so a change made in
+// between is sent rather than lost. The stream holds no connection: the
+// current state is read on one released right after.
+func (s *Server) WatchStock(request *stockpb.WatchStockRequest, stream stockpb.StockService_WatchStockServer) error {
+	company, err := companyID(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	changes, stop := handlers.WatchStock(company, request.GetProductIds())
+	defer stop()
+
+	if ids := request.GetProductIds(); len(ids) > 0 {
+		conn, err := companyConn(stream.Context())
+		if err != nil {
+			return err
+		}
+		current, ferr := handlers.FindProducts(conn, ids)
+		conn.Release()
+		if ferr != nil {
+			return statusError(ferr)
+		}
+		for _, product := range current.Products {
+			if err := sendChange(stream, product); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case product := <-changes:
+			if err := sendChange(stream, product); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func sendChange(stream stockpb.StockService_WatchStockServer, product models.Product) error {
+	return stream.Send(&stockpb.StockChange{
+		Product:   productMessage(product),
+		ChangedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func productMessage(product models.Product) *stockpb.Product {
+	return &stockpb.Product{
+		Id:          product.ID.String(),
+		Name:        product.Name,
+		Description: product.Description,
+		Price:       product.Price,
+		Balance:     int32(product.Balance),
+		Reserved:    int32(product.Reserved),
+		Available:   int32(product.Available),
+		Unit:        product.Unit,
+		Ncm:         product.NCM,
+	}
+}
+
+// statusError maps the HTTP status the handlers answer to a gRPC code. Lack of
+// stock is answered 400 over REST; here it is a failed precondition, as
+// conflicts are.
+func statusError(err *fiber.Error) error {
+	code := codes.Internal
+	switch {
+	case strings.HasPrefix(err.Message, "Insufficient stock"):
+		code = codes.FailedPrecondition
+	case err.Code == fiber.StatusBadRequest || err.Code == fiber.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case err.Code == fiber.StatusNotFound:
+		code = codes.NotFound
+	case err.Code == fiber.StatusConflict:
+		code = codes.FailedPrecondition
+	}
+	return status.Error(code, err.Message)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/apikeys"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+// CreateAPIKeyRequest names a key for an integration and the scopes it gets.
+// A key without expires_at lasts until it is revoked.
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,max=255"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=products:read products:write stock:read stock:write"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKey issues a key for the company of the admin and answers with
+// it, which is not shown again.
+func CreateAPIKey(c *fiber.Ctx) error {
+	request := new(CreateAPIKeyRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid API key data", "details": err.Error()})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return validationErrorResponse(c, err)
+	}
+
+	apiKey, err := apikeys.New(request.Name, request.Scopes, request.ExpiresAt, time.Now())
+	if errors.Is(err, apikeys.ErrPastExpiry) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error generating API key"})
+	}
+
+	if err := apikeys.Insert(tenant.DB(c), apiKey); err != nil {
+		log.Printf("Error creating API key: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error creating API key"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(apiKey)
+}
+
+func GetAPIKeys(c *fiber.Ctx) error {
+	keys, err := apikeys.List(tenant.DB(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error getting API keys"})
+	}
+	return c.JSON(keys)
+}
+
+func GetAPIKeyById(c *fiber.Ctx) error {
+	apiKey, err := apikeys.Find(tenant.DB(c), c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+	return c.JSON(apiKey)
+}
+
+// RevokeAPIKey refuses the key from now on, and within API_KEY_CACHE_TTL on
+// the other instances. Its usage is kept.
+func RevokeAPIKey(c *fiber.Ctx) error {
+	conn := tenant.DB(c)
+	apiKey, err := apikeys.Find(conn, c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+	if apiKey.RevokedAt != nil {
+		return c.JSON(apiKey)
+	}
+
+	if err := apikeys.Revoke(conn, apiKey, time.Now()); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error revoking API key"})
+	}
+	apiKeyCache.Forget(apiKey.ID.String())
+
+	return c.JSON(apiKey)
+}
+
+var (
+	// apiKeyCache keeps the lookups of VerifyAPIKey, set by SetAPIKeyCacheTTL.
+	apiKeyCache = auth.NewKeyCache(lookupAPIKey, 0)
+	// apiKeyUsage holds the requests counted by this instance until
+	// FlushAPIKeyUsage writes them.
+	apiKeyUsage apikeys.Usage
+)
+
+// SetAPIKeyCacheTTL caches the API key lookups for ttl.
+func SetAPIKeyCacheTTL(ttl time.Duration) {
+	apiKeyCache = auth.NewKeyCache(lookupAPIKey, ttl)
+}
+
+// VerifyAPIKey is the auth.KeyVerifier of the service. Each use is counted
+// for FlushAPIKeyUsage, cached or not.
+func VerifyAPIKey(key string) (*auth.Claims, error) {
+	claims, err := apiKeyCache.Verify(key)
+	if err != nil {
+		return nil, err
+	}
+	if id, err := uuid.Parse(claims.Subject); err == nil {
+		apiKeyUsage.Count(id, time.Now())
+	}
+	return claims, nil
+}
+
+// lookupAPIKey finds the key through db.System, since it names its company.
+func lookupAPIKey(key string) (*auth.Claims, error) {
+	return apikeys.Lookup(db.System, key)
+}
+
+// FlushAPIKeyUsage is the scheduler job adding the requests counted by this
+// instance to request_count and moving last_used_at forward.
+func FlushAPIKeyUsage(_ time.Time) error {
+	return apiKeyUsage.Flush(db.System)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/webhooks"
+)
+
+// forEachCompany runs a periodic job once for every company that query, a
+// SELECT of company IDs run through db.System, returns. Each run gets a
+// connection of its company, so the job only sees and changes its rows, and a
+// company that fails does not stop the others. Stock keeps no list of
+// companies: they are the ones its rows belong to.
+func forEachCompany(job func(conn *db.Conn) error, query string, args ...interface{}) error {
+	return forEachCompanyID(func(companyID string) error {
+		return db.WithCompany(companyID, job)
+	}, query, args...)
+}
+
+// forEachCompanyID runs job once for every company that query, a SELECT of
+// company IDs run through db.System, returns, for jobs that take a connection
+// of the company only when they need one. A company that fails does not stop
+// the others.
+func forEachCompanyID(job func(companyID string) error, query string, args ...interface{}) error {
+	var companyIDs []string
+	if err := db.System.Select(&companyIDs, query, args...); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, companyID := range companyIDs {
+		if err := job(companyID); err != nil {
+			errs = append(errs, fmt.Errorf("company %s: %v", companyID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// companyTx runs each function it is given in a transaction of companyID, on
+// a connection taken for that transaction only.
+func companyTx(companyID string) webhooks.InTx {
+	return func(fn func(tx *sqlx.Tx) error) error {
+		return db.WithCompany(companyID, func(conn *db.Conn) error {
+			tx, err := conn.Beginx()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return tx.Commit()
+		})
+	}
+}
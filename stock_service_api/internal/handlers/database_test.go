@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/stock_service_api/db"
+)
+
+// useTestDatabase points db.DB and db.System at TEST_DATABASE_URL, a database
+// set up by init-scripts/init-stock-db.sql and reached as stock_user, since
+// row-level security does not bind superusers. The tests needing it are
+// skipped when it is not set.
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	conn, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	system, err := sqlx.Connect("postgres", url+separator+"options=-c%20app.all_companies%3Don")
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+
+	previousDB, previousSystem := db.DB, db.System
+	db.DB, db.System = conn, system
+	t.Cleanup(func() {
+		db.DB, db.System = previousDB, previousSystem
+		conn.Close()
+		system.Close()
+	})
+}
+
+// createTestProduct inserts a product of companyID with balance in stock and
+// returns its ID.
+func createTestProduct(t *testing.T, companyID string, balance int) string {
+	t.Helper()
+
+	var id string
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		return conn.Get(&id, "INSERT INTO product (name, price, balance) VALUES ('Test product', 10, $1) RETURNING id", balance)
+	})
+	if err != nil {
+		t.Fatalf("creating product: %v", err)
+	}
+	return id
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+)
+
+// eventSource names stock in the events it publishes, and eventGroup is the
+// consumer group of its subscriptions.
+const (
+	eventSource = "stock_service_api"
+	eventGroup  = "stock_service_api"
+)
+
+// eventPublishTimeout bounds how long a change waits for the broker before
+// its stock.changed or product.created is given up.
+const eventPublishTimeout = 5 * time.Second
+
+var (
+	eventBusMu sync.Mutex
+	eventBus   events.Bus
+)
+
+// SetEventBus makes the handlers publish stock.changed and product.created.
+func SetEventBus(bus events.Bus) {
+	eventBusMu.Lock()
+	defer eventBusMu.Unlock()
+
+	eventBus = bus
+}
+
+func currentEventBus() events.Bus {
+	eventBusMu.Lock()
+	defer eventBusMu.Unlock()
+
+	return eventBus
+}
+
+// SubscribeInvoiceEvents consumes the invoice events billing publishes and
+// publishes through bus the answer to each invoice.closed.
+func SubscribeInvoiceEvents(bus events.Bus) error {
+	if err := bus.Subscribe(events.InvoiceClosed, eventGroup, func(ctx context.Context, event events.Event) error {
+		return handleInvoiceClosed(ctx, bus, event)
+	}); err != nil {
+		return err
+	}
+	return bus.Subscribe(events.InvoiceCancelled, eventGroup, handleInvoiceCancelled)
+}
+
+// handleInvoiceClosed deducts the stock of a closed invoice, confirming its
+// reservation when it has one. The event ID is the key of the stock operation,
+// so a redelivered event is applied once and can be compensated through
+// /products/balance-restore. A refused deduction registers the key as
+// cancelled, so redeliveries keep being refused even if stock arrived since.
+func handleInvoiceClosed(ctx context.Context, bus events.Bus, event events.Event) error {
+	var invoice events.InvoiceData
+	if err := json.Unmarshal(event.Data, &invoice); err != nil {
+		log.Printf("Discarding malformed %s event %s: %v", event.Type, event.ID, err)
+		return nil
+	}
+
+	if event.CompanyID == "" {
+		log.Printf("Discarding %s event %s with no company", event.Type, event.ID)
+		return nil
+	}
+
+	result := events.StockResultData{InvoiceCode: invoice.Code, EventID: event.ID}
+	resultType := events.StockDeducted
+	err := db.WithCompany(event.CompanyID, func(conn *db.Conn) error {
+		var ferr *fiber.Error
+		if invoice.ReservationID != nil {
+			_, _, ferr = confirmReservation(conn, *invoice.ReservationID, event.ID)
+		} else {
+			items := make([]StockQuantity, 0, len(invoice.Items))
+			for _, item := range invoice.Items {
+				items = append(items, StockQuantity{ProductID: item.ProductID, Quantity: item.Quantity})
+			}
+			_, ferr = ApplyBalanceUpdate(conn, event.ID, items)
+		}
+		if ferr == nil {
+			return nil
+		}
+		if ferr.Code >= fiber.StatusInternalServerError {
+			return ferr
+		}
+
+		status, err := cancelStockOperation(conn, event.ID)
+		if err != nil {
+			return err
+		}
+		if status != models.OperacaoAplicada {
+			resultType, result.Reason = events.StockRejected, ferr.Message
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	reply, err := events.New(eventSource, resultType, result)
+	if err != nil {
+		return err
+	}
+	reply.CompanyID = event.CompanyID
+	// Answering a redelivery again publishes the same ID, which the broker
+	// drops as a duplicate.
+	reply.ID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(resultType+"/"+event.ID)).String()
+	return bus.Publish(ctx, reply)
+}
+
+// handleInvoiceCancelled releases the reservation of a cancelled invoice.
+// Releasing is a no-op once done, which makes redeliveries harmless.
+func handleInvoiceCancelled(ctx context.Context, event events.Event) error {
+	var invoice events.InvoiceData
+	if err := json.Unmarshal(event.Data, &invoice); err != nil {
+		log.Printf("Discarding malformed %s event %s: %v", event.Type, event.ID, err)
+		return nil
+	}
+	if invoice.ReservationID == nil {
+		return nil
+	}
+	if event.CompanyID == "" {
+		log.Printf("Discarding %s event %s with no company", event.Type, event.ID)
+		return nil
+	}
+
+	return db.WithCompany(event.CompanyID, func(conn *db.Conn) error {
+		if _, _, ferr := releaseReservation(conn, *invoice.ReservationID); ferr != nil {
+			if ferr.Code >= fiber.StatusInternalServerError {
+				return ferr
+			}
+			log.Printf("Reservation %s of cancelled invoice %s not released: %s", *invoice.ReservationID, invoice.Code, ferr.Message)
+		}
+		return nil
+	})
+}
+
+// cancelStockOperation registers key as cancelled unless it is registered
+// already, and returns its status.
+func cancelStockOperation(conn db.Scope, key string) (models.StatusOperacao, error) {
+	tx, err := conn.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, status, err := claimStockOperation(tx, key, models.OperacaoCancelada, []byte("[]"), nil)
+	if err != nil {
+		return "", err
+	}
+	return status, tx.Commit()
+}
+
+// recordEvent writes a stock.changed or product.created event in tx, the
+// transaction of the change it reports: it queues its webhooks, appends it to
+// GET /events and, when a bus is set, queues it in the outbox RelayEvents
+// publishes from. A crash after the commit loses none of them.
+func recordEvent(tx *sqlx.Tx, eventType string, product models.Product) error {
+	event, err := events.New(eventSource, eventType, product)
+	if err != nil {
+		return err
+	}
+	event.CompanyID = product.CompanyID.String()
+
+	if err := enqueueWebhooks(tx, event); err != nil {
+		return fmt.Errorf("error queueing %s webhooks: %v", eventType, err)
+	}
+	if err := appendStreamEvent(tx, event, product.ID.String()); err != nil {
+		return fmt.Errorf("error appending %s to the event stream: %v", eventType, err)
+	}
+	if currentEventBus() == nil {
+		return nil
+	}
+	return enqueueOutboxEvent(tx, event)
+}
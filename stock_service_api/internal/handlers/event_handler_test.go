@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+)
+
+func TestInvoiceClosedSaga(t *testing.T) {
+	useTestDatabase(t)
+
+	companyID := uuid.NewString()
+	productID := createTestProduct(t, companyID, 5)
+
+	bus := events.NewMemory()
+	if err := SubscribeInvoiceEvents(bus); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	deducted := invoiceClosedEvent(t, companyID, productID, 2)
+	bus.Publish(ctx, deducted)
+	if got := stockResults(t, bus, deducted.ID); len(got) != 1 || got[0].Type != events.StockDeducted {
+		t.Fatalf("invoice.closed answered %+v, want one stock.deducted", got)
+	}
+	if balance := productBalance(t, companyID, productID); balance != 3 {
+		t.Fatalf("balance is %d after the deduction, want 3", balance)
+	}
+
+	// A redelivery is applied once, and answered again with the same ID.
+	bus.Publish(ctx, deducted)
+	results := stockResults(t, bus, deducted.ID)
+	if len(results) != 2 || results[1].Type != events.StockDeducted || results[1].ID != results[0].ID {
+		t.Errorf("redelivered invoice.closed answered %+v, want stock.deducted with the ID of the first answer", results)
+	}
+	if balance := productBalance(t, companyID, productID); balance != 3 {
+		t.Errorf("balance is %d after the redelivery, want 3", balance)
+	}
+
+	rejected := invoiceClosedEvent(t, companyID, productID, 10)
+	bus.Publish(ctx, rejected)
+	if got := stockResults(t, bus, rejected.ID); len(got) != 1 || got[0].Type != events.StockRejected {
+		t.Fatalf("invoice.closed over the balance answered %+v, want one stock.rejected", got)
+	}
+
+	// Once refused, a redelivery stays refused even with stock available.
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		_, err := conn.Exec("UPDATE product SET balance = 20 WHERE id = $1", productID)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(ctx, rejected)
+	if got := stockResults(t, bus, rejected.ID); len(got) != 2 || got[1].Type != events.StockRejected {
+		t.Errorf("redelivered refused invoice.closed answered %+v, want stock.rejected", got)
+	}
+	if balance := productBalance(t, companyID, productID); balance != 20 {
+		t.Errorf("balance is %d after the refused redelivery, want 20", balance)
+	}
+}
+
+func invoiceClosedEvent(t *testing.T, companyID, productID string, quantity int) events.Event {
+	t.Helper()
+
+	event, err := events.New("billing_service_api", events.InvoiceClosed, events.InvoiceData{
+		Code:   "NF-" + uuid.NewString(),
+		Status: "FECHANDO",
+		Items:  []events.Item{{ProductID: productID, Quantity: quantity}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.CompanyID = companyID
+	return event
+}
+
+// stockResults returns the stock.deducted and stock.rejected events bus
+// published in answer to the event eventID, oldest first.
+func stockResults(t *testing.T, bus *events.Memory, eventID string) []events.Event {
+	t.Helper()
+
+	var results []events.Event
+	for _, event := range bus.Published() {
+		if event.Type != events.StockDeducted && event.Type != events.StockRejected {
+			continue
+		}
+		var data events.StockResultData
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if data.EventID == eventID {
+			results = append(results, event)
+		}
+	}
+	return results
+}
+
+func productBalance(t *testing.T, companyID, productID string) int {
+	t.Helper()
+
+	var balance int
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		return conn.Get(&balance, "SELECT balance FROM product WHERE id = $1", productID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
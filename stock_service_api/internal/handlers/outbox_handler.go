@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+)
+
+// enqueueOutboxEvent writes the event inside tx, so it is published exactly
+// when the change it reports is committed.
+func enqueueOutboxEvent(tx *sqlx.Tx, event events.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO event_outbox (id, event_type, payload, created_at) VALUES ($1, $2, $3, $4)`,
+		event.ID, event.Type, payload, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("error writing outbox event: %v", err)
+	}
+	return nil
+}
+
+// RelayEvents publishes the events waiting in the outbox to the bus, oldest
+// first and company by company. It runs periodically from main. Each event is
+// locked with SKIP LOCKED and deleted in the transaction that publishes it; a
+// crash in between publishes it again with the same ID, which the broker
+// drops as a duplicate. A company whose event fails waits for the next run,
+// so its events keep their order.
+func RelayEvents(_ time.Time) error {
+	bus := currentEventBus()
+	if bus == nil {
+		return nil
+	}
+
+	return forEachCompany(func(conn *db.Conn) error {
+		for {
+			done, err := relayNextEvent(conn, bus)
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		}
+	}, "SELECT DISTINCT company_id FROM event_outbox")
+}
+
+// relayNextEvent publishes the oldest event of conn and returns true when
+// there was none.
+func relayNextEvent(conn db.Scope, bus events.Bus) (bool, error) {
+	tx, err := conn.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var message models.OutboxEvent
+	err = tx.Get(&message, "SELECT * FROM event_outbox ORDER BY seq LIMIT 1 FOR UPDATE SKIP LOCKED")
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil
+		}
+		return false, fmt.Errorf("error loading outbox: %v", err)
+	}
+
+	var event events.Event
+	publishErr := json.Unmarshal(message.Payload, &event)
+	if publishErr == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+		publishErr = bus.Publish(ctx, event)
+		cancel()
+	}
+
+	if publishErr == nil {
+		_, err = tx.Exec("DELETE FROM event_outbox WHERE id = $1", message.ID)
+	} else {
+		message.Attempts++
+		_, err = tx.Exec("UPDATE event_outbox SET attempts = $1, last_error = $2 WHERE id = $3",
+			message.Attempts, publishErr.Error(), message.ID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error updating outbox event %s: %v", message.ID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	if publishErr != nil {
+		return false, fmt.Errorf("error publishing %s event %s (attempt %d): %v", message.EventType, message.ID, message.Attempts, publishErr)
+	}
+	return false, nil
+}
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/lucasbpereira/stock_service_api/db"
 	"github.com/lucasbpereira/stock_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
 )
 
 type ErrorResponse struct {
@@ -15,6 +22,22 @@ type ErrorResponse struct {
 	Value       string `json:"value"`
 }
 
+// validationErrorResponse answers 422 with the fields validator rejected.
+func validationErrorResponse(c *fiber.Ctx, err error) error {
+	if validationErrors, ok := err.(validator.ValidationErrors); ok {
+		var errors []ErrorResponse
+		for _, err := range validationErrors {
+			var el ErrorResponse
+			el.FailedField = err.StructNamespace()
+			el.Tag = err.Tag()
+			el.Value = err.Param()
+			errors = append(errors, el)
+		}
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(errors)
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Validation failed"})
+}
+
 func CreateProduct(c *fiber.Ctx) error {
 	product := new(models.Product)
 
@@ -27,24 +50,13 @@ func CreateProduct(c *fiber.Ctx) error {
 
 	// Validate the product
 	if err := validate.Struct(product); err != nil {
-		if validationErrors, ok := err.(validator.ValidationErrors); ok {
-			var errors []ErrorResponse
-			for _, err := range validationErrors {
-				var el ErrorResponse
-				el.FailedField = err.StructNamespace()
-				el.Tag = err.Tag()
-				el.Value = err.Param()
-				errors = append(errors, el)
-			}
-			return c.Status(fiber.StatusUnprocessableEntity).JSON(errors)
-		}
-
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Validation failed"})
+		return validationErrorResponse(c, err)
 	}
 
+	conn := tenant.DB(c)
 	var existingCount int
 	checkQuery := `SELECT COUNT(*) FROM product WHERE name = $1`
-	err := db.DB.QueryRow(checkQuery, product.Name).Scan(&existingCount)
+	err := conn.QueryRow(checkQuery, product.Name).Scan(&existingCount)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Error checking product existence"})
 	}
@@ -55,30 +67,135 @@ func CreateProduct(c *fiber.Ctx) error {
 		})
 	}
 
-	query := `INSERT INTO product (name, description, price, balance) VALUES ($1, $2, $3, $4) RETURNING id`
-	err = db.DB.QueryRow(query, product.Name, product.Description, product.Price, product.Balance).Scan(&product.ID)
+	if product.Unit == "" {
+		product.Unit = "UN"
+	}
+
+	tx, err := conn.Beginx()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO product (name, description, price, balance, unit, ncm) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, company_id`
+	err = tx.QueryRow(query, product.Name, product.Description, product.Price, product.Balance, product.Unit, product.NCM).Scan(&product.ID, &product.CompanyID)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Error creating product"})
 	}
+	product.Reserved = 0
+	product.Available = product.Balance
+
+	if err := recordEvent(tx, events.ProductCreated, *product); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error recording product event"})
+	}
+	change, err := recordStockChange(tx, product.ID.String())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error recording stock change"})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+	change.publish()
 
 	return c.Status(fiber.StatusCreated).JSON(product)
 }
 
 func GetProducts(c *fiber.Ctx) error {
 	var product []models.Product
-	err := db.DB.Select(&product, "SELECT * FROM product ORDER BY name ASC")
+	err := tenant.DB(c).Select(&product, "SELECT * FROM product ORDER BY name ASC")
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Error getting products"})
 	}
 	return c.JSON(product)
 }
 
-func BalanceUpdate(c *fiber.Ctx) error {
-	var requests []struct {
-		ProductID string `json:"product_id" validate:"required"`
-		Quantity  int    `json:"quantity" validate:"required,min=1"`
+// maxLookupIDs bounds a single lookup so one request cannot scan the table.
+const maxLookupIDs = 1000
+
+type ProductLookupRequest struct {
+	IDs []string `json:"ids" validate:"required,min=1,max=1000"`
+}
+
+type ProductLookupResponse struct {
+	Products []models.Product `json:"products"`
+	Missing  []string         `json:"missing"`
+}
+
+// LookupProducts returns every requested product in one query. IDs that do not
+// exist, including malformed ones, are listed in missing instead of failing
+// the whole request.
+func LookupProducts(c *fiber.Ctx) error {
+	var request ProductLookupRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid lookup data", "details": err.Error()})
+	}
+
+	response, err := FindProducts(tenant.DB(c), request.IDs)
+	if err != nil {
+		return c.Status(err.Code).JSON(fiber.Map{"error": err.Message})
 	}
 
+	return c.JSON(response)
+}
+
+// FindProducts backs LookupProducts and the gRPC BatchGetProducts. It finds
+// the products of the company of q only.
+func FindProducts(q db.Querier, productIDs []string) (*ProductLookupResponse, *fiber.Error) {
+	validate := validator.New()
+	if err := validate.Struct(ProductLookupRequest{IDs: productIDs}); err != nil {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, fmt.Sprintf("Between 1 and %d ids are required", maxLookupIDs))
+	}
+
+	// ids maps each canonical UUID to the spelling the caller sent.
+	ids := make(map[string]string, len(productIDs))
+	canonical := make([]string, 0, len(productIDs))
+	seen := make(map[string]bool)
+	response := &ProductLookupResponse{Products: []models.Product{}, Missing: []string{}}
+	for _, id := range productIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			response.Missing = append(response.Missing, id)
+			continue
+		}
+		if _, ok := ids[parsed.String()]; !ok {
+			ids[parsed.String()] = id
+			canonical = append(canonical, parsed.String())
+		}
+	}
+
+	if len(canonical) > 0 {
+		err := q.Select(&response.Products, "SELECT * FROM product WHERE id = ANY($1::uuid[]) ORDER BY name ASC", pq.Array(canonical))
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusInternalServerError, "Error getting products")
+		}
+	}
+
+	found := make(map[string]bool, len(response.Products))
+	for _, product := range response.Products {
+		found[product.ID.String()] = true
+	}
+	for _, id := range canonical {
+		if !found[id] {
+			response.Missing = append(response.Missing, ids[id])
+		}
+	}
+
+	return response, nil
+}
+
+type StockQuantity struct {
+	ProductID string `json:"product_id" validate:"required"`
+	Quantity  int    `json:"quantity" validate:"required,min=1"`
+}
+
+func BalanceUpdate(c *fiber.Ctx) error {
+	var requests []StockQuantity
+
 	if err := c.BodyParser(&requests); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
@@ -86,82 +203,241 @@ func BalanceUpdate(c *fiber.Ctx) error {
 		})
 	}
 
+	message, err := ApplyBalanceUpdate(tenant.DB(c), c.Get("Idempotency-Key"), requests)
+	if err != nil {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Message,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": message,
+	})
+}
+
+// ApplyBalanceUpdate deducts the items in one transaction on conn. It backs
+// BalanceUpdate and the gRPC DecreaseStock.
+func ApplyBalanceUpdate(conn db.Scope, key string, requests []StockQuantity) (string, *fiber.Error) {
 	// Validar dados
 	validate := validator.New()
 	for _, req := range requests {
 		if err := validate.Struct(req); err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success": false,
-				"error":   "Validation failed",
-			})
+			return "", fiber.NewError(fiber.StatusBadRequest, "Validation failed")
 		}
 	}
 
 	// Atualizar estoque em transação
-	tx, err := db.DB.Beginx()
+	tx, err := conn.Beginx()
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"error":   "Error starting transaction",
-		})
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Error starting transaction")
 	}
+	defer tx.Rollback()
 
-	for _, req := range requests {
-		// Verificar se há estoque suficiente
-		var currentBalance int
-		err := tx.Get(&currentBalance, "SELECT balance FROM product WHERE id = $1", req.ProductID)
+	// Com Idempotency-Key a baixa é registrada como operação e aplicada uma
+	// única vez, mesmo que o faturamento reenvie o comando.
+	if key != "" {
+		items, _ := json.Marshal(requests)
+		applied, status, err := claimStockOperation(tx, key, models.OperacaoAplicada, items, nil)
 		if err != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"success": false,
-				"error":   fmt.Sprintf("Product not found: %s", req.ProductID),
-			})
+			return "", fiber.NewError(fiber.StatusInternalServerError, "Error registering stock operation")
 		}
-
-		if currentBalance < req.Quantity {
-			tx.Rollback()
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success": false,
-				"error":   fmt.Sprintf("Insufficient stock for product: %s", req.ProductID),
-			})
+		if !applied {
+			if status != models.OperacaoAplicada {
+				return "", fiber.NewError(fiber.StatusConflict, fmt.Sprintf("Stock operation %s was cancelled", key))
+			}
+			return fmt.Sprintf("Stock operation %s already applied", key), nil
 		}
+	}
 
-		// Atualizar estoque
-		_, err = tx.Exec("UPDATE product SET balance = balance - $1 WHERE id = $2",
-			req.Quantity, req.ProductID)
-		if err != nil {
-			tx.Rollback()
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success": false,
-				"error":   "Error updating stock",
-			})
-		}
+	if err := deductAvailable(tx, requests); err != nil {
+		return "", err
+	}
+
+	change, err := recordStockChange(tx, quantityProductIDs(requests)...)
+	if err != nil {
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Error recording stock change")
 	}
 
 	if err := tx.Commit(); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Error committing transaction")
+	}
+	change.publish()
+
+	return fmt.Sprintf("Stock updated for %d products", len(requests)), nil
+}
+
+// BalanceRestore compensates a balance update made with an Idempotency-Key,
+// returning its quantities to stock. When the update never arrived the key is
+// marked as cancelled, so a late delivery of it is refused instead of applied.
+func BalanceRestore(c *fiber.Ctx) error {
+	var request struct {
+		OperationKey string `json:"operation_key" validate:"required"`
+	}
+
+	if err := c.BodyParser(&request); err != nil || request.OperationKey == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
-			"error":   "Error committing transaction",
+			"error":   "operation_key is required",
+		})
+	}
+
+	message, err := RestoreBalanceOperation(tenant.DB(c), request.OperationKey)
+	if err != nil {
+		return c.Status(err.Code).JSON(fiber.Map{
+			"success": false,
+			"error":   err.Message,
 		})
 	}
 
 	return c.JSON(fiber.Map{
 		"success": true,
-		"message": fmt.Sprintf("Stock updated for %d products", len(requests)),
+		"message": message,
 	})
 }
 
+// RestoreBalanceOperation backs BalanceRestore and the gRPC IncreaseStock.
+func RestoreBalanceOperation(conn db.Scope, key string) (string, *fiber.Error) {
+	if key == "" {
+		return "", fiber.NewError(fiber.StatusBadRequest, "operation_key is required")
+	}
+
+	tx, err := conn.Beginx()
+	if err != nil {
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Error starting transaction")
+	}
+	defer tx.Rollback()
+
+	cancelled, _, err := claimStockOperation(tx, key, models.OperacaoCancelada, []byte("[]"), nil)
+	if err != nil {
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Error registering stock operation")
+	}
+
+	message := fmt.Sprintf("Stock operation %s cancelled", key)
+	var restored []StockQuantity
+	if !cancelled {
+		var operation models.StockOperation
+		if err := tx.Get(&operation, "SELECT * FROM stock_operations WHERE key = $1 FOR UPDATE", key); err != nil {
+			return "", fiber.NewError(fiber.StatusInternalServerError, "Error loading stock operation")
+		}
+
+		message = fmt.Sprintf("Stock operation %s already %s", key, strings.ToLower(string(operation.Status)))
+		if operation.Status == models.OperacaoAplicada {
+			if err := json.Unmarshal(operation.Items, &restored); err != nil {
+				return "", fiber.NewError(fiber.StatusInternalServerError, "Invalid stock operation items")
+			}
+
+			for _, item := range restored {
+				if _, err := tx.Exec("UPDATE product SET balance = balance + $1 WHERE id = $2", item.Quantity, item.ProductID); err != nil {
+					return "", fiber.NewError(fiber.StatusInternalServerError, "Error updating stock")
+				}
+			}
+
+			_, err := tx.Exec("UPDATE stock_operations SET status = $1, reversed_at = CURRENT_TIMESTAMP WHERE key = $2",
+				models.OperacaoEstornada, key)
+			if err != nil {
+				return "", fiber.NewError(fiber.StatusInternalServerError, "Error updating stock operation")
+			}
+			// A compensated confirmation frees its reservation: closing the
+			// invoice again has to take the stock from what is available.
+			if operation.ReservationID != nil {
+				_, err := tx.Exec("UPDATE reservations SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+					models.ReservaLiberada, operation.ReservationID)
+				if err != nil {
+					return "", fiber.NewError(fiber.StatusInternalServerError, "Error updating reservation")
+				}
+			}
+			message = fmt.Sprintf("Stock restored for %d products", len(restored))
+		}
+	}
+
+	change, err := recordStockChange(tx, quantityProductIDs(restored)...)
+	if err != nil {
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Error recording stock change")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Error committing transaction")
+	}
+	change.publish()
+
+	return message, nil
+}
+
+// deductAvailable takes the quantities out of the on-hand balance inside tx.
+// Only available stock can be used; quantities reserved for others are kept.
+func deductAvailable(tx *sqlx.Tx, items []StockQuantity) *fiber.Error {
+	for _, item := range items {
+		// Verificar se há estoque disponível
+		var available int
+		err := tx.Get(&available, "SELECT balance - reserved FROM product WHERE id = $1 FOR UPDATE", item.ProductID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Product not found: %s", item.ProductID))
+		}
+
+		if available < item.Quantity {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Insufficient stock for product: %s", item.ProductID))
+		}
+
+		// Atualizar estoque
+		_, err = tx.Exec("UPDATE product SET balance = balance - $1 WHERE id = $2", item.Quantity, item.ProductID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Error updating stock")
+		}
+	}
+
+	return nil
+}
+
+// claimStockOperation registers key with status inside tx. It returns false
+// and the status already recorded when the key was used before.
+func claimStockOperation(tx *sqlx.Tx, key string, status models.StatusOperacao, items []byte, reservationID *uuid.UUID) (bool, models.StatusOperacao, error) {
+	result, err := tx.Exec(`INSERT INTO stock_operations (key, status, items, reservation_id) VALUES ($1, $2, $3, $4) ON CONFLICT (company_id, key) DO NOTHING`,
+		key, status, items, reservationID)
+	if err != nil {
+		return false, "", err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return false, "", err
+	} else if affected == 1 {
+		return true, status, nil
+	}
+
+	var existing models.StatusOperacao
+	if err := tx.Get(&existing, "SELECT status FROM stock_operations WHERE key = $1", key); err != nil {
+		return false, "", err
+	}
+	return false, existing, nil
+}
+
 func GetProductById(c *fiber.Ctx) error {
 	productId := c.Params("id")
 	if productId == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invoice code is required"})
 	}
 
-	var product models.Product
-	err := db.DB.Get(&product, "SELECT * FROM product WHERE id = $1", productId)
+	product, err := FindProduct(tenant.DB(c), productId)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Product not found"})
+		return c.Status(err.Code).JSON(fiber.Map{"error": err.Message})
 	}
 
 	return c.JSON(product)
 }
+
+// FindProduct backs GetProductById and the gRPC GetProduct.
+func FindProduct(q db.Querier, productID string) (*models.Product, *fiber.Error) {
+	var product models.Product
+	if err := q.Get(&product, "SELECT * FROM product WHERE id = $1", productID); err != nil {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Product not found")
+	}
+	return &product, nil
+}
+
+func quantityProductIDs(items []StockQuantity) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
+	}
+	return ids
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+func TestCompaniesDoNotSeeEachOthersProducts(t *testing.T) {
+	useTestDatabase(t)
+
+	owner, other := uuid.NewString(), uuid.NewString()
+	productID := createTestProduct(t, owner, 10)
+
+	app := fiber.New()
+	app.Use(tenant.Middleware(db.DB, func(*fiber.Ctx) bool { return true }))
+	app.Get("/products", GetProducts)
+	app.Get("/product/:id", GetProductById)
+	app.Put("/products/balance-update", BalanceUpdate)
+
+	send := func(method, path, body string) *http.Response {
+		t.Helper()
+
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(tenant.Header, other)
+		response, err := app.Test(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	if status := send("GET", "/product/"+productID, "").StatusCode; status != fiber.StatusNotFound {
+		t.Errorf("GET of another company's product answered %d, want 404", status)
+	}
+
+	var listed []models.Product
+	json.NewDecoder(send("GET", "/products", "").Body).Decode(&listed)
+	for _, product := range listed {
+		if product.ID.String() == productID {
+			t.Error("another company's product was listed")
+		}
+	}
+
+	body := `[{"product_id":"` + productID + `","quantity":3}]`
+	if status := send("PUT", "/products/balance-update", body).StatusCode; status != fiber.StatusNotFound {
+		t.Errorf("balance update of another company's product answered %d, want 404", status)
+	}
+
+	err := db.WithCompany(other, func(conn *db.Conn) error {
+		result, err := conn.Exec("UPDATE product SET balance = 0 WHERE id = $1", productID)
+		if err != nil {
+			return err
+		}
+		if affected, _ := result.RowsAffected(); affected != 0 {
+			t.Errorf("another company updated %d of the owner's products", affected)
+		}
+
+		if _, err := conn.Exec("INSERT INTO product (company_id, name) VALUES ($1, 'Planted')", owner); err == nil {
+			t.Error("another company inserted a product for the owner")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var visible int
+	if err := db.DB.Get(&visible, "SELECT COUNT(*) FROM product WHERE id = $1", productID); err != nil {
+		t.Fatal(err)
+	}
+	if visible != 0 {
+		t.Error("a connection with no company saw the product")
+	}
+
+	var balance int
+	err = db.WithCompany(owner, func(conn *db.Conn) error {
+		return conn.Get(&balance, "SELECT balance FROM product WHERE id = $1", productID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 10 {
+		t.Errorf("owner's product has balance %d, want 10 untouched", balance)
+	}
+}
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+type CreateReservationRequest struct {
+	Reference  string          `json:"reference" validate:"required,max=255"`
+	TTLSeconds int             `json:"ttl_seconds" validate:"omitempty,min=1"`
+	Items      []StockQuantity `json:"items" validate:"required,min=1,dive"`
+}
+
+// CreateReservation holds available quantities for a reference until the
+// reservation is confirmed, released or expires (ttl_seconds, default
+// RESERVATION_TTL). Asking again for a reference with an active reservation
+// of the same items returns that reservation; other items are refused with
+// 409, since confirming it would deduct the wrong quantities.
+func CreateReservation(c *fiber.Ctx) error {
+	request := new(CreateReservationRequest)
+	if err := c.BodyParser(request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid reservation data"})
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(request); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "Validation failed", "details": err.Error()})
+	}
+
+	ttl := reservationTTL()
+	if request.TTLSeconds > 0 {
+		ttl = time.Duration(request.TTLSeconds) * time.Second
+	}
+
+	// Somar quantidades repetidas do mesmo produto
+	quantities := make(map[string]int)
+	var order []string
+	for _, item := range request.Items {
+		if _, ok := quantities[item.ProductID]; !ok {
+			order = append(order, item.ProductID)
+		}
+		quantities[item.ProductID] += item.Quantity
+	}
+
+	conn := tenant.DB(c)
+	tx, err := conn.Beginx()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error starting transaction"})
+	}
+	defer tx.Rollback()
+
+	var existing models.Reservation
+	err = tx.Get(&existing, "SELECT * FROM reservations WHERE reference = $1 AND status = $2 AND expires_at > NOW()", request.Reference, models.ReservaAtiva)
+	if err == nil {
+		if err := loadReservationItems(tx, &existing); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error getting reservation items"})
+		}
+		if !reservesQuantities(existing, quantities) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Reference already has an active reservation of other items"})
+		}
+		return c.JSON(existing)
+	}
+
+	now := time.Now().UTC()
+	reservation := models.Reservation{
+		ID:        uuid.New(),
+		Reference: request.Reference,
+		Status:    models.ReservaAtiva,
+		ExpiresAt: now.Add(ttl).Format(time.RFC3339),
+		CreatedAt: now.Format(time.RFC3339),
+		UpdatedAt: now.Format(time.RFC3339),
+	}
+
+	// Uma reserva ativa vencida ainda não recolhida libera a referência
+	expired, err := expireReservations(tx, "reference = $1", request.Reference)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error expiring reservations"})
+	}
+
+	_, err = tx.Exec(`INSERT INTO reservations (id, reference, status, expires_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		reservation.ID, reservation.Reference, reservation.Status, reservation.ExpiresAt, reservation.CreatedAt, reservation.UpdatedAt)
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Reference already has an active reservation"})
+	}
+
+	for _, productID := range order {
+		quantity := quantities[productID]
+
+		var available int
+		if err := tx.Get(&available, "SELECT balance - reserved FROM product WHERE id = $1 FOR UPDATE", productID); err != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": fmt.Sprintf("Product not found: %s", productID)})
+		}
+		if available < quantity {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":      fmt.Sprintf("Insufficient stock for product: %s", productID),
+				"product_id": productID,
+				"available":  available,
+				"requested":  quantity,
+			})
+		}
+
+		if _, err := tx.Exec("UPDATE product SET reserved = reserved + $1 WHERE id = $2", quantity, productID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error reserving stock"})
+		}
+
+		item := models.ReservationItem{ID: uuid.New(), ReservationID: reservation.ID, Quantity: quantity}
+		item.ProductID, _ = uuid.Parse(productID)
+		_, err := tx.Exec(`INSERT INTO reservation_items (id, reservation_id, product_id, quantity) VALUES ($1, $2, $3, $4)`,
+			item.ID, item.ReservationID, item.ProductID, item.Quantity)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error reserving stock"})
+		}
+		reservation.Items = append(reservation.Items, item)
+	}
+
+	change, err := recordStockChange(tx, append(order, expired...)...)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error recording stock change"})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error committing transaction"})
+	}
+	change.publish()
+
+	return c.Status(fiber.StatusCreated).JSON(reservation)
+}
+
+// reservesQuantities reports whether reservation holds exactly quantities,
+// keyed by product ID as requested.
+func reservesQuantities(reservation models.Reservation, quantities map[string]int) bool {
+	if len(reservation.Items) != len(quantities) {
+		return false
+	}
+
+	reserved := make(map[uuid.UUID]int, len(reservation.Items))
+	for _, item := range reservation.Items {
+		reserved[item.ProductID] += item.Quantity
+	}
+	for productID, quantity := range quantities {
+		id, err := uuid.Parse(productID)
+		if err != nil || reserved[id] != quantity {
+			return false
+		}
+	}
+	return true
+}
+
+func GetReservation(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := uuid.Parse(id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Reservation not found"})
+	}
+
+	var reservation models.Reservation
+	conn := tenant.DB(c)
+	if err := conn.Get(&reservation, "SELECT * FROM reservations WHERE id = $1", id); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Reservation not found"})
+	}
+	if err := loadReservationItems(conn, &reservation); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error getting reservation items"})
+	}
+
+	return c.JSON(reservation)
+}
+
+// ConfirmReservation deducts the reserved quantities from the on-hand balance.
+// A reservation that expired or was released is confirmed from available
+// stock instead, so a late confirmation still works while there is stock. An
+// Idempotency-Key registers the deduction so it can be compensated through
+// /products/balance-restore.
+func ConfirmReservation(c *fiber.Ctx) error {
+	reservation, message, ferr := confirmReservation(tenant.DB(c), c.Params("id"), c.Get("Idempotency-Key"))
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"success": false, "error": ferr.Message})
+	}
+
+	return c.JSON(fiber.Map{"success": true, "message": message, "reservation": reservation})
+}
+
+// confirmReservation backs ConfirmReservation and the invoice.closed consumer,
+// which passes the event ID as key.
+func confirmReservation(conn db.Scope, id, key string) (*models.Reservation, string, *fiber.Error) {
+	tx, reservation, ferr := lockReservation(conn, id)
+	if ferr != nil {
+		return nil, "", ferr
+	}
+	defer tx.Rollback()
+
+	if reservation.Status == models.ReservaConfirmada {
+		return reservation, "Reservation already confirmed", nil
+	}
+
+	items := make([]StockQuantity, 0, len(reservation.Items))
+	for _, item := range reservation.Items {
+		items = append(items, StockQuantity{ProductID: item.ProductID.String(), Quantity: item.Quantity})
+	}
+
+	if key != "" {
+		encoded, _ := json.Marshal(items)
+		applied, status, err := claimStockOperation(tx, key, models.OperacaoAplicada, encoded, &reservation.ID)
+		if err != nil {
+			return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error registering stock operation")
+		}
+		if !applied && status != models.OperacaoAplicada {
+			return nil, "", fiber.NewError(fiber.StatusConflict, fmt.Sprintf("Stock operation %s was cancelled", key))
+		}
+	}
+
+	if reservation.Status == models.ReservaAtiva && !reservationExpired(reservation) {
+		_, err := tx.Exec(`UPDATE product p SET balance = p.balance - i.quantity, reserved = p.reserved - i.quantity
+			FROM reservation_items i WHERE i.reservation_id = $1 AND p.id = i.product_id`, reservation.ID)
+		if err != nil {
+			return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error updating stock")
+		}
+	} else {
+		if reservation.Status == models.ReservaAtiva {
+			if _, err := releaseReserved(tx, reservation.ID); err != nil {
+				return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error updating stock")
+			}
+		}
+		if err := deductAvailable(tx, items); err != nil {
+			return nil, "", err
+		}
+	}
+
+	reservation.Status = models.ReservaConfirmada
+	if err := setReservationStatus(tx, reservation); err != nil {
+		return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error updating reservation")
+	}
+
+	change, err := recordStockChange(tx, quantityProductIDs(items)...)
+	if err != nil {
+		return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error recording stock change")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error committing transaction")
+	}
+	change.publish()
+
+	return reservation, "Reservation confirmed", nil
+}
+
+// ReleaseReservation gives the reserved quantities back to available stock.
+// Releasing a reservation that is no longer active is a no-op; a confirmed one
+// has to be compensated through /products/balance-restore instead.
+func ReleaseReservation(c *fiber.Ctx) error {
+	reservation, message, ferr := releaseReservation(tenant.DB(c), c.Params("id"))
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"success": false, "error": ferr.Message})
+	}
+
+	return c.JSON(fiber.Map{"success": true, "message": message, "reservation": reservation})
+}
+
+// releaseReservation backs ReleaseReservation and the invoice.cancelled
+// consumer. Being a no-op once the reservation is released, it needs no key.
+func releaseReservation(conn db.Scope, id string) (*models.Reservation, string, *fiber.Error) {
+	tx, reservation, ferr := lockReservation(conn, id)
+	if ferr != nil {
+		return nil, "", ferr
+	}
+	defer tx.Rollback()
+
+	switch reservation.Status {
+	case models.ReservaConfirmada:
+		return nil, "", fiber.NewError(fiber.StatusConflict, "Reservation is already confirmed")
+	case models.ReservaLiberada, models.ReservaExpirada:
+		return reservation, "Reservation is no longer active", nil
+	}
+
+	released, err := releaseReserved(tx, reservation.ID)
+	if err != nil {
+		return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error updating stock")
+	}
+
+	reservation.Status = models.ReservaLiberada
+	if err := setReservationStatus(tx, reservation); err != nil {
+		return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error updating reservation")
+	}
+
+	change, err := recordStockChange(tx, released...)
+	if err != nil {
+		return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error recording stock change")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", fiber.NewError(fiber.StatusInternalServerError, "Error committing transaction")
+	}
+	change.publish()
+
+	return reservation, "Reservation released", nil
+}
+
+// ExpireReservations returns the stock of every active reservation past its
+// expiry to available, company by company. It runs periodically from main.
+func ExpireReservations(now time.Time) error {
+	return forEachCompany(func(conn *db.Conn) error {
+		return expireDueReservations(conn, now)
+	}, "SELECT DISTINCT company_id FROM reservations WHERE status = $1 AND expires_at <= $2", models.ReservaAtiva, now)
+}
+
+func expireDueReservations(conn *db.Conn, now time.Time) error {
+	tx, err := conn.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	released, err := expireReservations(tx, "expires_at <= $1", now)
+	if err != nil {
+		return err
+	}
+
+	change, err := recordStockChange(tx, released...)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	change.publish()
+	return nil
+}
+
+// expireReservations marks the active, past-due reservations matching filter
+// as EXPIRADA and releases their quantities. It returns the products whose
+// stock was released.
+func expireReservations(tx *sqlx.Tx, filter string, arg interface{}) ([]string, error) {
+	var ids []uuid.UUID
+	query := fmt.Sprintf(`SELECT id FROM reservations WHERE status = 'ATIVA' AND expires_at <= NOW() AND %s FOR UPDATE SKIP LOCKED`, filter)
+	if err := tx.Select(&ids, query, arg); err != nil {
+		return nil, err
+	}
+
+	var released []string
+	for _, id := range ids {
+		products, err := releaseReserved(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, products...)
+		if _, err := tx.Exec("UPDATE reservations SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", models.ReservaExpirada, id); err != nil {
+			return nil, err
+		}
+		log.Printf("Reservation %s expired", id)
+	}
+
+	return released, nil
+}
+
+func lockReservation(conn db.Scope, id string) (*sqlx.Tx, *models.Reservation, *fiber.Error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, nil, fiber.NewError(fiber.StatusNotFound, "Reservation not found")
+	}
+
+	tx, err := conn.Beginx()
+	if err != nil {
+		return nil, nil, fiber.NewError(fiber.StatusInternalServerError, "Error starting transaction")
+	}
+
+	var reservation models.Reservation
+	if err := tx.Get(&reservation, "SELECT * FROM reservations WHERE id = $1 FOR UPDATE", id); err != nil {
+		tx.Rollback()
+		return nil, nil, fiber.NewError(fiber.StatusNotFound, "Reservation not found")
+	}
+	if err := loadReservationItems(tx, &reservation); err != nil {
+		tx.Rollback()
+		return nil, nil, fiber.NewError(fiber.StatusInternalServerError, "Error getting reservation items")
+	}
+
+	return tx, &reservation, nil
+}
+
+func loadReservationItems(q sqlx.Queryer, reservation *models.Reservation) error {
+	return sqlx.Select(q, &reservation.Items, "SELECT * FROM reservation_items WHERE reservation_id = $1 ORDER BY product_id", reservation.ID)
+}
+
+// releaseReserved returns the products whose reserved quantity went down.
+func releaseReserved(tx *sqlx.Tx, reservationID uuid.UUID) ([]string, error) {
+	var products []string
+	err := tx.Select(&products, `UPDATE product p SET reserved = p.reserved - i.quantity
+		FROM reservation_items i WHERE i.reservation_id = $1 AND p.id = i.product_id RETURNING p.id`, reservationID)
+	return products, err
+}
+
+func setReservationStatus(tx *sqlx.Tx, reservation *models.Reservation) error {
+	reservation.UpdatedAt = time.Now().Format(time.RFC3339)
+	_, err := tx.Exec("UPDATE reservations SET status = $1, updated_at = $2 WHERE id = $3", reservation.Status, reservation.UpdatedAt, reservation.ID)
+	return err
+}
+
+func reservationExpired(reservation *models.Reservation) bool {
+	expiresAt, err := time.Parse(time.RFC3339, reservation.ExpiresAt)
+	return err == nil && !expiresAt.After(time.Now())
+}
+
+func reservationTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("RESERVATION_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 24 * time.Hour
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+func TestReservesQuantities(t *testing.T) {
+	first, second := uuid.New(), uuid.New()
+	reservation := models.Reservation{Items: []models.ReservationItem{
+		{ProductID: first, Quantity: 2},
+		{ProductID: second, Quantity: 5},
+	}}
+
+	tests := []struct {
+		name       string
+		quantities map[string]int
+		want       bool
+	}{
+		{"same items", map[string]int{first.String(): 2, second.String(): 5}, true},
+		{"same items, upper case IDs", map[string]int{strings.ToUpper(first.String()): 2, strings.ToUpper(second.String()): 5}, true},
+		{"other quantity", map[string]int{first.String(): 2, second.String(): 4}, false},
+		{"missing item", map[string]int{first.String(): 2}, false},
+		{"extra item", map[string]int{first.String(): 2, second.String(): 5, uuid.NewString(): 1}, false},
+		{"other product", map[string]int{first.String(): 2, uuid.NewString(): 5}, false},
+		{"invalid product ID", map[string]int{first.String(): 2, "not-a-uuid": 5}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reservesQuantities(reservation, tt.quantities); got != tt.want {
+				t.Errorf("reservesQuantities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateReservationReplay(t *testing.T) {
+	useTestDatabase(t)
+
+	companyID := uuid.NewString()
+	productID := createTestProduct(t, companyID, 10)
+
+	app := fiber.New()
+	app.Use(tenant.Middleware(db.DB, func(*fiber.Ctx) bool { return true }))
+	app.Post("/reservations", CreateReservation)
+
+	reference := "NF-" + uuid.NewString()
+	reserve := func(quantity int) (int, models.Reservation) {
+		t.Helper()
+
+		body, _ := json.Marshal(CreateReservationRequest{
+			Reference: reference,
+			Items:     []StockQuantity{{ProductID: productID, Quantity: quantity}},
+		})
+		request := httptest.NewRequest("POST", "/reservations", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(tenant.Header, companyID)
+		response, err := app.Test(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var reservation models.Reservation
+		json.NewDecoder(response.Body).Decode(&reservation)
+		return response.StatusCode, reservation
+	}
+
+	status, created := reserve(2)
+	if status != fiber.StatusCreated {
+		t.Fatalf("first reservation answered %d, want 201", status)
+	}
+
+	status, replayed := reserve(2)
+	if status != fiber.StatusOK || replayed.ID != created.ID {
+		t.Errorf("same items answered %d with reservation %s, want 200 with %s", status, replayed.ID, created.ID)
+	}
+
+	if status, _ := reserve(3); status != fiber.StatusConflict {
+		t.Errorf("other items answered %d, want 409", status)
+	}
+
+	var reserved int
+	err := db.WithCompany(companyID, func(conn *db.Conn) error {
+		return conn.Get(&reserved, "SELECT reserved FROM product WHERE id = $1", productID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserved != 2 {
+		t.Errorf("product has %d reserved, want 2", reserved)
+	}
+}
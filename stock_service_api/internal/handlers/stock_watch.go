@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/lucasbpereira/stock_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+)
+
+// stockWatcherBuffer is how many changes a watcher may fall behind before new
+// ones are dropped for it. Every change carries the whole product, so the
+// next one it receives is current again.
+const stockWatcherBuffer = 64
+
+type stockWatcher struct {
+	company  uuid.UUID
+	products map[uuid.UUID]bool // nil watches every product of the company
+	changes  chan models.Product
+}
+
+var (
+	stockWatchersMu sync.Mutex
+	stockWatchers   = make(map[*stockWatcher]bool)
+)
+
+// WatchStock subscribes to the products of companyID whose stock changes from
+// now on, or to every product of the company when productIDs is empty. stop
+// has to be called to unsubscribe; it closes the channel.
+func WatchStock(companyID string, productIDs []string) (changes <-chan models.Product, stop func()) {
+	watcher := &stockWatcher{changes: make(chan models.Product, stockWatcherBuffer)}
+	watcher.company, _ = uuid.Parse(companyID)
+	if len(productIDs) > 0 {
+		watcher.products = make(map[uuid.UUID]bool, len(productIDs))
+		for _, id := range productIDs {
+			if parsed, err := uuid.Parse(id); err == nil {
+				watcher.products[parsed] = true
+			}
+		}
+	}
+
+	stockWatchersMu.Lock()
+	stockWatchers[watcher] = true
+	stockWatchersMu.Unlock()
+
+	var once sync.Once
+	return watcher.changes, func() {
+		once.Do(func() {
+			stockWatchersMu.Lock()
+			delete(stockWatchers, watcher)
+			stockWatchersMu.Unlock()
+			close(watcher.changes)
+		})
+	}
+}
+
+// stockChange is the products a transaction changed, as it left them.
+type stockChange []models.Product
+
+// recordStockChange loads the products as tx leaves them and records their
+// stock.changed events in tx, so the events exist exactly when the change is
+// committed. The change is published once it is.
+func recordStockChange(tx *sqlx.Tx, productIDs ...string) (stockChange, error) {
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+
+	var products []models.Product
+	if err := tx.Select(&products, "SELECT * FROM product WHERE id = ANY($1::uuid[])", pq.Array(productIDs)); err != nil {
+		return nil, fmt.Errorf("error loading changed products: %v", err)
+	}
+
+	for _, product := range products {
+		if err := recordEvent(tx, events.StockChanged, product); err != nil {
+			return nil, err
+		}
+	}
+	return products, nil
+}
+
+// publish sends the committed change to the watchers interested in its
+// products.
+func (change stockChange) publish() {
+	stockWatchersMu.Lock()
+	defer stockWatchersMu.Unlock()
+
+	for watcher := range stockWatchers {
+		for _, product := range change {
+			if product.CompanyID != watcher.company || (watcher.products != nil && !watcher.products[product.ID]) {
+				continue
+			}
+			select {
+			case watcher.changes <- product:
+			default:
+				log.Printf("Stock watcher is behind, dropping change of product %s", product.ID)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/lucasbpereira/stock_service_api/db"
+	"github.com/lucasbpereira/stock_service_api/internal/models"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/events"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/stream"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/tenant"
+)
+
+// stockStreamTypes are the events of GET /events.
+var stockStreamTypes = map[string]bool{
+	events.StockChanged:   true,
+	events.ProductCreated: true,
+}
+
+var streamHub *stream.Hub
+
+// StartEventStream listens for the stream_events notifications, so GET
+// /events streams the changes made through every instance.
+func StartEventStream() error {
+	hub, err := stream.Listen(db.NewListener())
+	if err != nil {
+		return err
+	}
+
+	streamHub = hub
+	return nil
+}
+
+// StreamEvents is GET /events, a Server-Sent Events stream of stock.changed,
+// with the balance of a product after every change, and product.created.
+// types and product_id take comma-separated filters. A stream starts with the
+// next event, or after the one in Last-Event-ID, also accepted as
+// last_event_id since the first request of a browser can't set it.
+func StreamEvents(c *fiber.Ctx) error {
+	if streamHub == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Event stream not available"})
+	}
+
+	types := stream.ParseList(c.Query("types"))
+	for _, eventType := range types {
+		if !stockStreamTypes[eventType] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid event type", "details": eventType})
+		}
+	}
+	productIDs := stream.ParseList(c.Query("product_id"))
+
+	after, ferr := streamStartID(c)
+	if ferr != nil {
+		return c.Status(ferr.Code).JSON(fiber.Map{"error": ferr.Message})
+	}
+
+	companyID := tenant.CompanyID(c)
+	wake, stop := streamHub.Subscribe()
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer stop()
+		stream.Serve(w, wake, after, fetchStreamEvents(companyID, types, productIDs), stream.PingInterval())
+	})
+	return nil
+}
+
+// streamStartID is the ID a stream reads after: the Last-Event-ID given, or
+// the latest event.
+func streamStartID(c *fiber.Ctx) (int64, *fiber.Error) {
+	last := c.Get("Last-Event-ID")
+	if last == "" {
+		last = c.Query("last_event_id")
+	}
+
+	if last == "" {
+		var latest int64
+		if err := tenant.DB(c).Get(&latest, "SELECT COALESCE(MAX(position), 0) FROM stream_events"); err != nil {
+			return 0, fiber.NewError(fiber.StatusInternalServerError, "Error reading event stream")
+		}
+		return latest, nil
+	}
+
+	id, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "Invalid Last-Event-ID")
+	}
+	return id, nil
+}
+
+// fetchStreamEvents reads the events of companyID matching the filters.
+// Empty filters are passed as NULL and match everything. The stream outlives
+// the request and its connection, so it reads through db.System and filters
+// the company itself.
+func fetchStreamEvents(companyID string, types, subjects []string) stream.Fetch {
+	return func(after int64, limit int) ([]stream.Message, error) {
+		var rows []models.StreamEvent
+		err := db.System.Select(&rows, `SELECT * FROM stream_events
+			WHERE company_id = $1 AND position > $2
+			AND ($3::text[] IS NULL OR event_type = ANY($3))
+			AND ($4::text[] IS NULL OR subject = ANY($4))
+			ORDER BY position LIMIT $5`,
+			companyID, after, pq.Array(types), pq.Array(subjects), limit)
+		if err != nil {
+			return nil, err
+		}
+
+		messages := make([]stream.Message, 0, len(rows))
+		for _, row := range rows {
+			messages = append(messages, stream.Message{ID: row.Position, Event: row.EventType, Data: row.Payload})
+		}
+		return messages, nil
+	}
+}
+
+// appendStreamEvent adds the event to stream_events. Its trigger gives it
+// its position and notifies the instances on commit.
+func appendStreamEvent(tx *sqlx.Tx, event events.Event, subject string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO stream_events (event_type, subject, payload) VALUES ($1, $2, $3)", event.Type, subject, payload)
+	return err
+}
+
+// PurgeStreamEvents deletes the events older than STREAM_RETENTION, which
+// clients can no longer resume from.
+func PurgeStreamEvents(now time.Time) error {
+	_, err := db.System.Exec("DELETE FROM stream_events WHERE created_at < $1", now.Add(-stream.Retention()))
+	return err
+}
@@ -0,0 +1,16 @@
+package models
+
+import "encoding/json"
+
+// OutboxEvent is an event waiting in the outbox to be published to the
+// broker. Payload is the whole envelope; the row is deleted once published.
+type OutboxEvent struct {
+	ID        string          `json:"id" db:"id"`
+	Seq       int64           `json:"seq" db:"seq"`
+	CompanyID string          `json:"company_id" db:"company_id"`
+	EventType string          `json:"event_type" db:"event_type"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	Attempts  int             `json:"attempts" db:"attempts"`
+	LastError *string         `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt string          `json:"created_at" db:"created_at"`
+}
@@ -7,8 +7,13 @@ import (
 
 type Product struct {
 	ID          uuid.UUID `db:"id" json:"id"`
+	CompanyID   uuid.UUID `db:"company_id" json:"company_id"`
 	Name        string    `db:"name" json:"name" validate:"required"`
 	Description string    `db:"description" json:"description"`
 	Price       float64   `db:"price" json:"price" validate:"gte=0"`
-	Balance     int       `db:"balance" json:"balance" validate:"gte=0"`
+	Balance     int       `db:"balance" json:"balance" validate:"gte=0"` // em mãos
+	Reserved    int       `db:"reserved" json:"reserved"`
+	Available   int       `db:"available" json:"available"`
+	Unit        string    `db:"unit" json:"unit" validate:"omitempty,max=6"`
+	NCM         string    `db:"ncm" json:"ncm" validate:"omitempty,len=8,numeric"`
 }
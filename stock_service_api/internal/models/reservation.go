@@ -0,0 +1,35 @@
+package models
+
+import (
+	"github.com/google/uuid"
+)
+
+type StatusReserva string
+
+const (
+	ReservaAtiva      StatusReserva = "ATIVA"
+	ReservaConfirmada StatusReserva = "CONFIRMADA"
+	ReservaLiberada   StatusReserva = "LIBERADA"
+	ReservaExpirada   StatusReserva = "EXPIRADA"
+)
+
+// Reservation holds quantities for a reference (an invoice code, for billing)
+// until it is confirmed, which deducts them, released or expired.
+type Reservation struct {
+	ID        uuid.UUID         `db:"id" json:"id"`
+	CompanyID uuid.UUID         `db:"company_id" json:"company_id"`
+	Reference string            `db:"reference" json:"reference"`
+	Status    StatusReserva     `db:"status" json:"status"`
+	ExpiresAt string            `db:"expires_at" json:"expires_at"`
+	CreatedAt string            `db:"created_at" json:"created_at"`
+	UpdatedAt string            `db:"updated_at" json:"updated_at"`
+	Items     []ReservationItem `json:"items"`
+}
+
+type ReservationItem struct {
+	ID            uuid.UUID `db:"id" json:"id"`
+	CompanyID     uuid.UUID `db:"company_id" json:"company_id"`
+	ReservationID uuid.UUID `db:"reservation_id" json:"reservation_id"`
+	ProductID     uuid.UUID `db:"product_id" json:"product_id"`
+	Quantity      int       `db:"quantity" json:"quantity"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type StatusOperacao string
+
+const (
+	OperacaoAplicada  StatusOperacao = "APLICADA"
+	OperacaoEstornada StatusOperacao = "ESTORNADA"
+	OperacaoCancelada StatusOperacao = "CANCELADA"
+)
+
+// StockOperation records a balance update sent with an Idempotency-Key so it
+// is applied once and can be compensated later.
+type StockOperation struct {
+	CompanyID     uuid.UUID       `db:"company_id" json:"company_id"`
+	Key           string          `db:"key" json:"key"`
+	Status        StatusOperacao  `db:"status" json:"status"`
+	Items         json.RawMessage `db:"items" json:"items"`
+	ReservationID *uuid.UUID      `db:"reservation_id" json:"reservation_id,omitempty"`
+	CreatedAt     string          `db:"created_at" json:"created_at"`
+	ReversedAt    *string         `db:"reversed_at" json:"reversed_at,omitempty"`
+}
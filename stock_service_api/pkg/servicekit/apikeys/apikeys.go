@@ -0,0 +1,197 @@
+// Package apikeys keeps the API keys integrations call both services with, in
+// the api_keys table of each, and counts their use. The handlers of each
+// service validate the scopes it offers and call it.
+package apikeys
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+)
+
+// ErrPastExpiry is returned by New for keys that would be expired already.
+var ErrPastExpiry = errors.New("expires_at must be in the future")
+
+// Key lets a machine client call the API with Scopes instead of a user role.
+// Only the SHA-256 of the key is stored; Key is set once, in the answer that
+// creates it. Prefix identifies the key in listings. RequestCount and
+// LastUsedAt are updated a minute or so behind.
+type Key struct {
+	ID           uuid.UUID      `json:"id" db:"id"`
+	CompanyID    uuid.UUID      `json:"company_id" db:"company_id"`
+	Name         string         `json:"name" db:"name"`
+	Prefix       string         `json:"prefix" db:"prefix"`
+	KeyHash      string         `json:"-" db:"key_hash"`
+	Key          string         `json:"key,omitempty" db:"-"`
+	Scopes       pq.StringArray `json:"scopes" db:"scopes"`
+	ExpiresAt    *string        `json:"expires_at" db:"expires_at"`
+	RevokedAt    *string        `json:"revoked_at" db:"revoked_at"`
+	LastUsedAt   *string        `json:"last_used_at" db:"last_used_at"`
+	RequestCount int64          `json:"request_count" db:"request_count"`
+	CreatedAt    string         `json:"created_at" db:"created_at"`
+}
+
+// New generates a key named name with scopes, lasting until expiresAt or,
+// when it is nil, until it is revoked.
+func New(name string, scopes []string, expiresAt *time.Time, now time.Time) (*Key, error) {
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, ErrPastExpiry
+	}
+
+	secret, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		ID:        uuid.New(),
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Key:       secret,
+		Scopes:    scopes,
+		CreatedAt: now.Format(time.RFC3339),
+	}
+	if expiresAt != nil {
+		expires := expiresAt.Format(time.RFC3339)
+		key.ExpiresAt = &expires
+	}
+	return key, nil
+}
+
+// Insert stores key for the company q works for, and sets its CompanyID.
+func Insert(q sqlx.Ext, key *Key) error {
+	_, err := q.Exec(`INSERT INTO api_keys (id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		key.ID, key.Name, key.Prefix, key.KeyHash, key.Scopes, key.ExpiresAt, key.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return sqlx.Get(q, &key.CompanyID, "SELECT company_id FROM api_keys WHERE id = $1", key.ID)
+}
+
+// List returns the keys q sees, newest first.
+func List(q sqlx.Queryer) ([]Key, error) {
+	keys := []Key{}
+	if err := sqlx.Select(q, &keys, "SELECT * FROM api_keys ORDER BY created_at DESC"); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Find returns the key with ID id.
+func Find(q sqlx.Queryer, id string) (*Key, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, err
+	}
+
+	var key Key
+	if err := sqlx.Get(q, &key, "SELECT * FROM api_keys WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Revoke refuses key from now on. Its usage is kept.
+func Revoke(q sqlx.Execer, key *Key, now time.Time) error {
+	revokedAt := now.Format(time.RFC3339)
+	if _, err := q.Exec("UPDATE api_keys SET revoked_at = $1 WHERE id = $2", revokedAt, key.ID); err != nil {
+		return err
+	}
+	key.RevokedAt = &revokedAt
+	return nil
+}
+
+// Lookup returns the claims of secret, a key that is neither revoked nor
+// expired: its ID as the subject, its company, its scopes and its prefix,
+// with its expiry as ExpiresAt. The key names its company, so q must see
+// those of every company. Unknown keys fail with sql.ErrNoRows.
+func Lookup(q sqlx.Queryer, secret string) (*auth.Claims, error) {
+	var key Key
+	err := sqlx.Get(q, &key, `SELECT * FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`,
+		auth.HashAPIKey(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &auth.Claims{Company: key.CompanyID.String(), KeyPrefix: key.Prefix}
+	claims.Subject = key.ID.String()
+	if key.ExpiresAt != nil {
+		if expiresAt, err := time.Parse(time.RFC3339Nano, *key.ExpiresAt); err == nil {
+			claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
+		}
+	}
+	for _, scope := range key.Scopes {
+		claims.Scopes = append(claims.Scopes, auth.Scope(scope))
+	}
+	return claims, nil
+}
+
+type use struct {
+	requests   int64
+	lastUsedAt time.Time
+}
+
+func (a use) merge(b use) use {
+	a.requests += b.requests
+	if b.lastUsedAt.After(a.lastUsedAt) {
+		a.lastUsedAt = b.lastUsedAt
+	}
+	return a
+}
+
+// Usage counts the requests of each key in memory, so a request costs no
+// write, until Flush adds them to the table.
+type Usage struct {
+	mu      sync.Mutex
+	pending map[uuid.UUID]use
+}
+
+// Count adds a request made at now to the usage of the key with ID id.
+func (u *Usage) Count(id uuid.UUID, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.pending == nil {
+		u.pending = make(map[uuid.UUID]use)
+	}
+	u.pending[id] = u.pending[id].merge(use{requests: 1, lastUsedAt: now})
+}
+
+// Flush adds the requests counted to request_count and moves last_used_at
+// forward. Usage that could not be written is kept for the next Flush. q
+// must see the keys of every company.
+func (u *Usage) Flush(q sqlx.Execer) error {
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = nil
+	u.mu.Unlock()
+
+	var firstErr error
+	for id, counted := range pending {
+		_, err := q.Exec(`UPDATE api_keys SET request_count = request_count + $1,
+			last_used_at = GREATEST(COALESCE(last_used_at, $2), $2) WHERE id = $3`,
+			counted.requests, counted.lastUsedAt, id)
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		u.mu.Lock()
+		if u.pending == nil {
+			u.pending = make(map[uuid.UUID]use)
+		}
+		u.pending[id] = u.pending[id].merge(counted)
+		u.mu.Unlock()
+	}
+	return firstErr
+}
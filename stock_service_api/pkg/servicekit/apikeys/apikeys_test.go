@@ -0,0 +1,100 @@
+package apikeys
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+)
+
+func TestNew(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+
+	key, err := New("erp", []string{"stock:read"}, &expiresAt, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key.Key, key.Prefix+"_") || !auth.IsAPIKey(key.Key) {
+		t.Errorf("key %q does not start with its prefix %q", key.Key, key.Prefix)
+	}
+	if key.KeyHash != auth.HashAPIKey(key.Key) {
+		t.Error("the hash is not that of the key")
+	}
+	if key.ExpiresAt == nil || *key.ExpiresAt != expiresAt.Format(time.RFC3339) {
+		t.Errorf("expires_at = %v, want %s", key.ExpiresAt, expiresAt.Format(time.RFC3339))
+	}
+
+	if _, err := New("erp", []string{"stock:read"}, &now, now); !errors.Is(err, ErrPastExpiry) {
+		t.Errorf("New() with expires_at now = %v, want ErrPastExpiry", err)
+	}
+}
+
+// recordingExecer records the usage updates, failing those of fail.
+type recordingExecer struct {
+	fail    map[uuid.UUID]bool
+	updates map[uuid.UUID][]interface{}
+}
+
+func (e *recordingExecer) Exec(_ string, args ...interface{}) (sql.Result, error) {
+	id := args[2].(uuid.UUID)
+	if e.fail[id] {
+		return nil, errors.New("database is down")
+	}
+	if e.updates == nil {
+		e.updates = make(map[uuid.UUID][]interface{})
+	}
+	e.updates[id] = args
+	return nil, nil
+}
+
+func TestUsageFlush(t *testing.T) {
+	first, second := uuid.New(), uuid.New()
+	earlier := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Minute)
+
+	var usage Usage
+	usage.Count(first, later)
+	usage.Count(first, earlier)
+	usage.Count(second, earlier)
+
+	db := &recordingExecer{}
+	if err := usage.Flush(db); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.updates[first]; got[0] != int64(2) || got[1] != later {
+		t.Errorf("first key flushed as %v, want 2 requests last used at %s", got, later)
+	}
+	if got := db.updates[second]; got[0] != int64(1) {
+		t.Errorf("second key flushed as %v, want 1 request", got)
+	}
+
+	db = &recordingExecer{}
+	if err := usage.Flush(db); err != nil || len(db.updates) != 0 {
+		t.Errorf("second Flush wrote %v, %v, want nothing", db.updates, err)
+	}
+}
+
+func TestUsageFlushKeepsWhatFailed(t *testing.T) {
+	id := uuid.New()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var usage Usage
+	usage.Count(id, now)
+	if err := usage.Flush(&recordingExecer{fail: map[uuid.UUID]bool{id: true}}); err == nil {
+		t.Fatal("Flush() = nil with the database down")
+	}
+
+	usage.Count(id, now)
+	db := &recordingExecer{}
+	if err := usage.Flush(db); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.updates[id]; got[0] != int64(2) {
+		t.Errorf("flushed %v, want the request that failed and the new one", got)
+	}
+}
@@ -0,0 +1,237 @@
+// Package auth issues and checks the JWT access tokens of the users. Billing,
+// which keeps the users, issues them and both services accept them, signed
+// with the shared JWT_SECRET. Machine clients use API keys instead, which each
+// service issues and checks itself, and billing calls stock with service
+// tokens.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Role is what a user may do. Each role includes the ones before it: viewer
+// reads, operator also creates invoices and the documents leading to them,
+// fiscal also closes and cancels invoices and admin also manages products,
+// users and webhooks.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleFiscal   Role = "fiscal"
+	RoleAdmin    Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleFiscal:   3,
+	RoleAdmin:    4,
+}
+
+func (r Role) Valid() bool {
+	return roleRank[r] > 0
+}
+
+// Allows reports whether r includes the permissions of required.
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && roleRank[r] >= roleRank[required]
+}
+
+// Claims are the claims of an access token. The subject is the user ID and
+// Company the ID of the company the user works for. Requests made with an
+// API key get claims too, with the key ID as the subject and its Scopes and
+// KeyPrefix instead of an email and a role.
+type Claims struct {
+	Email     string  `json:"email"`
+	Role      Role    `json:"role"`
+	Company   string  `json:"company"`
+	Scopes    []Scope `json:"-"`
+	KeyPrefix string  `json:"-"`
+	jwt.RegisteredClaims
+}
+
+// Config signs and checks access tokens. RefreshTTL is how long the refresh
+// tokens billing hands out with them last.
+type Config struct {
+	Secret     []byte
+	Issuer     string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// ConfigFromEnv reads JWT_SECRET, which is required and has to be the same
+// in both services, JWT_ACCESS_TTL (default 15m) and JWT_REFRESH_TTL
+// (default 720h).
+func ConfigFromEnv() (Config, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if len(secret) < 32 {
+		return Config{}, fmt.Errorf("JWT_SECRET must have at least 32 characters")
+	}
+
+	return Config{
+		Secret:     []byte(secret),
+		Issuer:     "nota_fiscal",
+		AccessTTL:  durationFromEnv("JWT_ACCESS_TTL", 15*time.Minute),
+		RefreshTTL: durationFromEnv("JWT_REFRESH_TTL", 30*24*time.Hour),
+	}, nil
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(name)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+// IssueAccessToken signs a token for the user valid for AccessTTL from now.
+func (c Config) IssueAccessToken(userID, email, companyID string, role Role, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(c.AccessTTL)
+	claims := Claims{
+		Email:   email,
+		Role:    role,
+		Company: companyID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			Issuer:    c.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(c.Secret)
+	return token, expiresAt, err
+}
+
+// ParseAccessToken checks the signature, issuer and expiry of a token, and
+// that it names a company.
+func (c Config) ParseAccessToken(token string) (*Claims, error) {
+	claims := new(Claims)
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return c.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(c.Issuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if !claims.Role.Valid() {
+		return nil, errors.New("token has an unknown role")
+	}
+	if claims.Company == "" {
+		return nil, errors.New("token has no company")
+	}
+	return claims, nil
+}
+
+const userLocal = "auth.user"
+
+// Authenticate rejects the requests without a valid access token or API key
+// in the Authorization header, except those public reports true for, and
+// keeps the claims for CurrentUser. API keys are checked by verifyKey.
+// EventSource can't set headers, so event streams are opened with a ticket
+// from tickets instead, passed as ?ticket=.
+func Authenticate(config Config, verifyKey KeyVerifier, tickets *Tickets, public func(*fiber.Ctx) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if public(c) {
+			return c.Next()
+		}
+
+		token := bearerToken(c)
+		if token == "" {
+			if ticket := streamTicket(c); ticket != "" && tickets != nil {
+				claims, err := tickets.Redeem(ticket, c.Path(), time.Now())
+				if err != nil {
+					return unauthorized(c, "Invalid, used or expired stream ticket")
+				}
+				c.Locals(userLocal, claims)
+				return c.Next()
+			}
+			return unauthorized(c, "Authentication required")
+		}
+
+		if IsAPIKey(token) {
+			claims, err := verifyKey(token)
+			if err != nil {
+				return unauthorized(c, "Invalid, expired or revoked API key")
+			}
+			c.Locals(userLocal, claims)
+			return c.Next()
+		}
+
+		claims, err := config.ParseAccessToken(token)
+		if err != nil {
+			return unauthorized(c, "Invalid or expired access token")
+		}
+
+		c.Locals(userLocal, claims)
+		return c.Next()
+	}
+}
+
+func bearerToken(c *fiber.Ctx) string {
+	if header := c.Get(fiber.HeaderAuthorization); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// streamTicket is the ticket of a GET asking for an event stream.
+func streamTicket(c *fiber.Ctx) string {
+	if c.Method() != fiber.MethodGet || !strings.Contains(c.Get(fiber.HeaderAccept), "text/event-stream") {
+		return ""
+	}
+	return c.Query("ticket")
+}
+
+func unauthorized(c *fiber.Ctx, message string) error {
+	c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="nota_fiscal"`)
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": message})
+}
+
+// RequireRole lets through the users whose role includes role. API keys are
+// refused; the routes they may use go through Allow instead. It runs after
+// Authenticate.
+func RequireRole(role Role) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := CurrentUser(c)
+		if user == nil {
+			return unauthorized(c, "Authentication required")
+		}
+		if user.IsAPIKey() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "API keys can't use this route"})
+		}
+		if !user.Role.Allows(role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Requires the " + string(role) + " role"})
+		}
+		return c.Next()
+	}
+}
+
+// CurrentUser returns the claims of the authenticated user or API key, or
+// nil on a public route.
+func CurrentUser(c *fiber.Ctx) *Claims {
+	claims, _ := c.Locals(userLocal).(*Claims)
+	return claims
+}
+
+// Paths returns a public matcher for Authenticate that matches the given
+// paths exactly.
+func Paths(paths ...string) func(*fiber.Ctx) bool {
+	public := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		public[path] = true
+	}
+	return func(c *fiber.Ctx) bool {
+		return public[c.Path()]
+	}
+}
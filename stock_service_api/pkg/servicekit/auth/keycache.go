@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// keyCacheSize bounds the keys a KeyCache holds. Past it, the expired
+// entries are dropped, and all of them when that is not enough, so a flood
+// of made up keys can't grow it without end.
+const keyCacheSize = 10000
+
+type cachedKey struct {
+	claims  *Claims
+	err     error
+	expires time.Time
+}
+
+// KeyCache remembers for TTL what a KeyVerifier answered, so a client
+// sending the same API key on every request costs one lookup per TTL. Keys
+// that don't exist are remembered as well, so requests with a wrong key
+// don't each reach the database; other errors, such as a database that is
+// down, are not. Revoking a key takes up to TTL to reach the other
+// instances; Forget drops it at once from this one.
+type KeyCache struct {
+	verify KeyVerifier
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	keys map[string]cachedKey
+}
+
+// NewKeyCache caches what verify answers for ttl. A ttl of zero or less
+// turns the cache off.
+func NewKeyCache(verify KeyVerifier, ttl time.Duration) *KeyCache {
+	return &KeyCache{verify: verify, ttl: ttl, now: time.Now, keys: make(map[string]cachedKey)}
+}
+
+// KeyCacheTTLFromEnv reads API_KEY_CACHE_TTL, how long API key lookups are
+// cached, 30s when unset or invalid; 0 turns the cache off.
+func KeyCacheTTLFromEnv() time.Duration {
+	if os.Getenv("API_KEY_CACHE_TTL") == "0" {
+		return 0
+	}
+	return durationFromEnv("API_KEY_CACHE_TTL", 30*time.Second)
+}
+
+// Verify is a KeyVerifier answering from the cache while it can. Claims
+// with an ExpiresAt are not kept past it.
+func (k *KeyCache) Verify(key string) (*Claims, error) {
+	if k.ttl <= 0 {
+		return k.verify(key)
+	}
+
+	hash := HashAPIKey(key)
+	now := k.now()
+	k.mu.Lock()
+	cached, ok := k.keys[hash]
+	k.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.result()
+	}
+
+	claims, err := k.verify(key)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	cached = cachedKey{claims: claims, err: err, expires: now.Add(k.ttl)}
+	if claims != nil && claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(cached.expires) {
+		cached.expires = claims.ExpiresAt.Time
+	}
+
+	k.mu.Lock()
+	if len(k.keys) >= keyCacheSize {
+		k.sweep(now)
+	}
+	k.keys[hash] = cached
+	k.mu.Unlock()
+	return cached.result()
+}
+
+// Forget drops the key with ID subject, so this instance stops accepting it
+// right after it is revoked.
+func (k *KeyCache) Forget(subject string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for hash, cached := range k.keys {
+		if cached.claims != nil && cached.claims.Subject == subject {
+			delete(k.keys, hash)
+		}
+	}
+}
+
+// sweep drops the expired entries, or every entry when none has expired.
+// It is called holding mu.
+func (k *KeyCache) sweep(now time.Time) {
+	for hash, cached := range k.keys {
+		if !now.Before(cached.expires) {
+			delete(k.keys, hash)
+		}
+	}
+	if len(k.keys) >= keyCacheSize {
+		clear(k.keys)
+	}
+}
+
+// result hands out a copy of the claims, so no request changes those of
+// another.
+func (c cachedKey) result() (*Claims, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	claims := *c.claims
+	claims.Scopes = append([]Scope(nil), c.claims.Scopes...)
+	return &claims, nil
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// countingVerifier answers with the claims of key, or sql.ErrNoRows for
+// keys it doesn't know, counting the lookups.
+type countingVerifier struct {
+	keys    map[string]*Claims
+	err     error
+	lookups int
+}
+
+func (v *countingVerifier) verify(key string) (*Claims, error) {
+	v.lookups++
+	if v.err != nil {
+		return nil, v.err
+	}
+	claims, ok := v.keys[key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *claims
+	return &copied, nil
+}
+
+// testKeyCache caches verifier for a minute on a clock the test moves.
+func testKeyCache(verifier *countingVerifier) (*KeyCache, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewKeyCache(verifier.verify, time.Minute)
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+func testClaims(subject string) *Claims {
+	claims := &Claims{Company: "company", KeyPrefix: "nfk_test", Scopes: []Scope{ScopeStockRead}}
+	claims.Subject = subject
+	return claims
+}
+
+func TestKeyCacheKeepsLookups(t *testing.T) {
+	verifier := &countingVerifier{keys: map[string]*Claims{"nfk_good": testClaims("key-1")}}
+	cache, now := testKeyCache(verifier)
+
+	for i := 0; i < 3; i++ {
+		claims, err := cache.Verify("nfk_good")
+		if err != nil || claims.Subject != "key-1" {
+			t.Fatalf("Verify() = %+v, %v", claims, err)
+		}
+	}
+	if verifier.lookups != 1 {
+		t.Errorf("%d lookups within the TTL, want 1", verifier.lookups)
+	}
+
+	*now = now.Add(time.Minute)
+	if _, err := cache.Verify("nfk_good"); err != nil {
+		t.Fatal(err)
+	}
+	if verifier.lookups != 2 {
+		t.Errorf("%d lookups after the TTL, want 2", verifier.lookups)
+	}
+}
+
+func TestKeyCacheKeepsUnknownKeys(t *testing.T) {
+	verifier := &countingVerifier{}
+	cache, _ := testKeyCache(verifier)
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Verify("nfk_made_up"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("Verify() error = %v, want sql.ErrNoRows", err)
+		}
+	}
+	if verifier.lookups != 1 {
+		t.Errorf("%d lookups of an unknown key, want 1", verifier.lookups)
+	}
+}
+
+func TestKeyCacheDoesNotKeepFailures(t *testing.T) {
+	verifier := &countingVerifier{err: errors.New("database is down")}
+	cache, _ := testKeyCache(verifier)
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Verify("nfk_good"); err == nil {
+			t.Fatal("Verify() succeeded with the database down")
+		}
+	}
+	if verifier.lookups != 2 {
+		t.Errorf("%d lookups, want 2: failures must not be cached", verifier.lookups)
+	}
+}
+
+func TestKeyCacheStopsAtKeyExpiry(t *testing.T) {
+	claims := testClaims("key-1")
+	verifier := &countingVerifier{keys: map[string]*Claims{"nfk_good": claims}}
+	cache, now := testKeyCache(verifier)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(10 * time.Second))
+
+	cache.Verify("nfk_good")
+	*now = now.Add(10 * time.Second)
+	cache.Verify("nfk_good")
+	if verifier.lookups != 2 {
+		t.Errorf("%d lookups, want 2: the key expired before the TTL", verifier.lookups)
+	}
+}
+
+func TestKeyCacheForget(t *testing.T) {
+	verifier := &countingVerifier{keys: map[string]*Claims{"nfk_good": testClaims("key-1")}}
+	cache, _ := testKeyCache(verifier)
+
+	cache.Verify("nfk_good")
+	delete(verifier.keys, "nfk_good")
+	cache.Forget("key-1")
+	if _, err := cache.Verify("nfk_good"); err == nil {
+		t.Error("Verify() accepted a key revoked and forgotten")
+	}
+}
+
+func TestKeyCacheHandsOutCopies(t *testing.T) {
+	verifier := &countingVerifier{keys: map[string]*Claims{"nfk_good": testClaims("key-1")}}
+	cache, _ := testKeyCache(verifier)
+
+	first, _ := cache.Verify("nfk_good")
+	first.Scopes[0] = ScopeStockWrite
+	first.Company = "other"
+
+	second, _ := cache.Verify("nfk_good")
+	if second.Company != "company" || second.Scopes[0] != ScopeStockRead {
+		t.Errorf("cached claims were changed through a copy: %+v", second)
+	}
+}
+
+func TestKeyCacheOff(t *testing.T) {
+	verifier := &countingVerifier{keys: map[string]*Claims{"nfk_good": testClaims("key-1")}}
+	cache := NewKeyCache(verifier.verify, 0)
+
+	cache.Verify("nfk_good")
+	cache.Verify("nfk_good")
+	if verifier.lookups != 2 {
+		t.Errorf("%d lookups with the cache off, want 2", verifier.lookups)
+	}
+}
+
+func TestKeyCacheStaysBounded(t *testing.T) {
+	verifier := &countingVerifier{}
+	cache, _ := testKeyCache(verifier)
+
+	for i := 0; i < keyCacheSize+10; i++ {
+		cache.Verify(APIKeyPrefix + string(rune('a'+i%26)) + time.Duration(i).String())
+	}
+	if len(cache.keys) > keyCacheSize {
+		t.Errorf("%d keys cached, want at most %d", len(cache.keys), keyCacheSize)
+	}
+}
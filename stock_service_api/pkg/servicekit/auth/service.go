@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient/servicetoken"
+)
+
+const serviceLocal = "auth.service"
+
+// AuthenticateService rejects the requests internal reports true for unless
+// they carry a valid service token in the Authorization header, and keeps the
+// calling service for CurrentService. Requests with an API key are left to
+// Authenticate, and other requests pass untouched.
+func AuthenticateService(secret []byte, internal func(*fiber.Ctx) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !internal(c) {
+			return c.Next()
+		}
+
+		token := bearerToken(c)
+		if token == "" {
+			return unauthorized(c, "Service credentials required")
+		}
+		if IsAPIKey(token) {
+			return c.Next()
+		}
+
+		service, err := servicetoken.Verify(secret, token)
+		if err != nil {
+			return unauthorized(c, "Invalid or expired service token")
+		}
+
+		c.Locals(serviceLocal, service)
+		return c.Next()
+	}
+}
+
+// AllowService lets through the services authenticated by
+// AuthenticateService and the API keys granted scope.
+func AllowService(scope Scope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if CurrentService(c) != "" {
+			return c.Next()
+		}
+
+		user := CurrentUser(c)
+		if user == nil || !user.IsAPIKey() {
+			return unauthorized(c, "Service credentials required")
+		}
+		if !user.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Requires the " + string(scope) + " scope"})
+		}
+		return c.Next()
+	}
+}
+
+// CurrentService returns the service that called, or "" when the request was
+// not authenticated by AuthenticateService.
+func CurrentService(c *fiber.Ctx) string {
+	service, _ := c.Locals(serviceLocal).(string)
+	return service
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// TicketTTL is how long a stream ticket can be redeemed after it is issued.
+const TicketTTL = 30 * time.Second
+
+// ErrInvalidTicket is returned for a stream ticket that is unknown, already
+// used, expired or issued for another path.
+var ErrInvalidTicket = errors.New("invalid, used or expired stream ticket")
+
+// Tickets issues and redeems the tickets event streams are opened with, since
+// EventSource can't send the Authorization header. A ticket stands for the
+// claims of whoever asked for it, for one path. It is kept hashed in the
+// stream_tickets table and redeemed once within TicketTTL, so the URL it ends
+// up in, and the access logs and proxies that record it, hold nothing that
+// can be used again.
+type Tickets struct {
+	db *sqlx.DB
+}
+
+// NewTickets keeps the tickets in database, which has to see the rows of
+// every company: tickets are redeemed before the company is known.
+func NewTickets(database *sqlx.DB) *Tickets {
+	return &Tickets{db: database}
+}
+
+type ticketRow struct {
+	TicketHash string         `db:"ticket_hash"`
+	CompanyID  string         `db:"company_id"`
+	Path       string         `db:"path"`
+	Subject    string         `db:"subject"`
+	Email      string         `db:"email"`
+	Role       string         `db:"role"`
+	Scopes     pq.StringArray `db:"scopes"`
+	KeyPrefix  string         `db:"key_prefix"`
+	ExpiresAt  time.Time      `db:"expires_at"`
+	CreatedAt  time.Time      `db:"created_at"`
+}
+
+// Issue stores a ticket standing for claims on path and returns it with when
+// it expires.
+func (t *Tickets) Issue(claims *Claims, path string, now time.Time) (string, time.Time, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", time.Time{}, err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(secret)
+
+	scopes := make([]string, len(claims.Scopes))
+	for i, scope := range claims.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	expiresAt := now.Add(TicketTTL)
+	_, err := t.db.Exec(`INSERT INTO stream_tickets (ticket_hash, company_id, path, subject, email, role, scopes, key_prefix, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		HashAPIKey(ticket), claims.Company, path, claims.Subject, claims.Email, string(claims.Role), pq.Array(scopes), claims.KeyPrefix, expiresAt, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return ticket, expiresAt, nil
+}
+
+// Redeem consumes the ticket and returns the claims it stands for. Deleting
+// it is what redeems it, so two requests racing with the same ticket can't
+// both succeed.
+func (t *Tickets) Redeem(ticket, path string, now time.Time) (*Claims, error) {
+	var row ticketRow
+	err := t.db.Get(&row, "DELETE FROM stream_tickets WHERE ticket_hash = $1 RETURNING *", HashAPIKey(ticket))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidTicket
+		}
+		return nil, err
+	}
+	if row.Path != path || !row.ExpiresAt.After(now) {
+		return nil, ErrInvalidTicket
+	}
+
+	claims := &Claims{Email: row.Email, Role: Role(row.Role), Company: row.CompanyID, KeyPrefix: row.KeyPrefix}
+	claims.Subject = row.Subject
+	for _, scope := range row.Scopes {
+		claims.Scopes = append(claims.Scopes, Scope(scope))
+	}
+	return claims, nil
+}
+
+// Purge deletes the tickets that expired before now without being redeemed.
+func (t *Tickets) Purge(now time.Time) error {
+	_, err := t.db.Exec("DELETE FROM stream_tickets WHERE expires_at < $1", now)
+	return err
+}
+
+// Handler answers with a ticket for path to the authenticated caller. It is
+// mounted behind the same permission as path itself.
+func (t *Tickets) Handler(path string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user := CurrentUser(c)
+		if user == nil {
+			return unauthorized(c, "Authentication required")
+		}
+
+		ticket, expiresAt, err := t.Issue(user, path, time.Now())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Error issuing stream ticket"})
+		}
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"ticket":     ticket,
+			"expires_at": expiresAt.UTC().Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,7 @@
+// Package servicekit holds the packages billing and stock share: the
+// authentication of users, API keys and services, the connections held for
+// one company under row-level security, the storage and usage of API keys,
+// the domain events they exchange, webhook delivery, the GET /events stream,
+// rate limiting and the scheduler of background jobs. It is a module of its
+// own, referenced by replace like the stock client.
+package servicekit
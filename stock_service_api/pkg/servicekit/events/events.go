@@ -0,0 +1,111 @@
+// Package events carries the domain events billing and stock exchange over a
+// message broker.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types. Billing publishes the invoice events; stock publishes the
+// others and answers every invoice.closed with stock.deducted or
+// stock.rejected.
+const (
+	InvoiceCreated   = "invoice.created"
+	InvoiceClosed    = "invoice.closed"
+	InvoiceCancelled = "invoice.cancelled"
+	StockChanged     = "stock.changed"
+	StockDeducted    = "stock.deducted"
+	StockRejected    = "stock.rejected"
+	ProductCreated   = "product.created"
+)
+
+// Event is the envelope of every message. Its type is also the subject it is
+// published on, and its ID is what consumers deduplicate redeliveries by.
+// CompanyID is the company the event happened in, which consumers act for.
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Source     string          `json:"source"`
+	CompanyID  string          `json:"company_id,omitempty"`
+	OccurredAt string          `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// InvoiceData is the data of the invoice events. ReservationID is the stock
+// reservation the invoice holds, confirmed when it is closed and released
+// when it is cancelled. AccessKey is set once the invoice is FECHADA.
+type InvoiceData struct {
+	Code          string  `json:"code"`
+	Status        string  `json:"status"`
+	CustomerID    *string `json:"customer_id,omitempty"`
+	TotalValue    float64 `json:"total_value"`
+	ReservationID *string `json:"reservation_id,omitempty"`
+	AccessKey     *string `json:"access_key,omitempty"`
+	Items         []Item  `json:"items"`
+}
+
+type Item struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// StockResultData is the data of stock.deducted and stock.rejected. EventID is
+// the invoice.closed event answered, which is also the key of the stock
+// operation, so it can be compensated through /products/balance-restore.
+type StockResultData struct {
+	InvoiceCode string `json:"invoice_code"`
+	EventID     string `json:"event_id"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// New builds an event with a fresh ID.
+func New(source, eventType string, data interface{}) (Event, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		ID:         uuid.New().String(),
+		Type:       eventType,
+		Source:     source,
+		OccurredAt: time.Now().UTC().Format(time.RFC3339),
+		Data:       encoded,
+	}, nil
+}
+
+// Handler processes one delivered event. Returning an error has the event
+// delivered again later, so handlers must be idempotent.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus publishes events and delivers them to subscribers. Each group receives
+// every event of the types it subscribed to once, whichever of its instances
+// handles it.
+type Bus interface {
+	Publish(ctx context.Context, event Event) error
+	Subscribe(eventType, group string, handler Handler) error
+	Close() error
+}
+
+// FromEnv connects the bus chosen by EVENT_BROKER: nats, using NATS_URL, or
+// none when it is empty, in which case it returns nil.
+func FromEnv() (Bus, error) {
+	switch broker := os.Getenv("EVENT_BROKER"); broker {
+	case "":
+		return nil, nil
+	case "nats":
+		url := os.Getenv("NATS_URL")
+		if url == "" {
+			url = "nats://nats:4222"
+		}
+		return NewNATS(url)
+	default:
+		return nil, fmt.Errorf("unknown EVENT_BROKER %q", broker)
+	}
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// memoryMaxDeliveries is how many times Memory hands an event to a handler
+// that keeps failing before dropping it.
+const memoryMaxDeliveries = 5
+
+// Memory is a Bus without a broker, for tests. Publish delivers the event to
+// the handler of every subscribed group before it returns, retrying a failing
+// handler right away, and keeps it for Published.
+type Memory struct {
+	mu        sync.Mutex
+	handlers  map[string]map[string]Handler // event type, then group
+	published []Event
+}
+
+func NewMemory() *Memory {
+	return &Memory{handlers: make(map[string]map[string]Handler)}
+}
+
+func (m *Memory) Publish(ctx context.Context, event Event) error {
+	m.mu.Lock()
+	m.published = append(m.published, event)
+	handlers := make([]Handler, 0, len(m.handlers[event.Type]))
+	for _, handler := range m.handlers[event.Type] {
+		handlers = append(handlers, handler)
+	}
+	m.mu.Unlock()
+
+	for _, handler := range handlers {
+		for delivery := 1; ; delivery++ {
+			err := handler(ctx, event)
+			if err == nil {
+				break
+			}
+			log.Printf("Error handling %s event %s (delivery %d): %v", event.Type, event.ID, delivery, err)
+			if delivery == memoryMaxDeliveries {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Subscribe replaces the handler a group had for eventType, as there is only
+// one instance of each group in a test.
+func (m *Memory) Subscribe(eventType, group string, handler Handler) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.handlers[eventType] == nil {
+		m.handlers[eventType] = make(map[string]Handler)
+	}
+	m.handlers[eventType][group] = handler
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Published returns the events published so far, oldest first.
+func (m *Memory) Published() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Event(nil), m.published...)
+}
@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryDeliversToEverySubscribedGroup(t *testing.T) {
+	bus := NewMemory()
+
+	delivered := make(map[string][]string)
+	subscribe := func(eventType, group string) {
+		t.Helper()
+		err := bus.Subscribe(eventType, group, func(ctx context.Context, event Event) error {
+			delivered[group] = append(delivered[group], event.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	subscribe(InvoiceClosed, "stock")
+	subscribe(InvoiceClosed, "reports")
+	subscribe(InvoiceCancelled, "audit")
+
+	event, err := New("billing", InvoiceClosed, InvoiceData{Code: "NF-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, group := range []string{"stock", "reports"} {
+		if len(delivered[group]) != 1 || delivered[group][0] != event.ID {
+			t.Errorf("group %s got %v, want [%s]", group, delivered[group], event.ID)
+		}
+	}
+	if len(delivered["audit"]) != 0 {
+		t.Errorf("group subscribed to another type got %v", delivered["audit"])
+	}
+
+	published := bus.Published()
+	if len(published) != 1 || published[0].ID != event.ID || published[0].Type != InvoiceClosed {
+		t.Errorf("Published() = %+v, want the invoice.closed event", published)
+	}
+}
+
+func TestMemorySubscribeReplacesTheHandlerOfAGroup(t *testing.T) {
+	bus := NewMemory()
+
+	var first, second int
+	bus.Subscribe(StockChanged, "billing", func(ctx context.Context, event Event) error {
+		first++
+		return nil
+	})
+	bus.Subscribe(StockChanged, "billing", func(ctx context.Context, event Event) error {
+		second++
+		return nil
+	})
+
+	event, _ := New("stock", StockChanged, nil)
+	bus.Publish(context.Background(), event)
+
+	if first != 0 || second != 1 {
+		t.Errorf("first handler ran %d times and second %d, want 0 and 1", first, second)
+	}
+}
+
+func TestMemoryRedeliversUntilTheHandlerSucceeds(t *testing.T) {
+	bus := NewMemory()
+
+	var deliveries []string
+	bus.Subscribe(InvoiceClosed, "stock", func(ctx context.Context, event Event) error {
+		deliveries = append(deliveries, event.ID)
+		if len(deliveries) < 3 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+
+	event, _ := New("billing", InvoiceClosed, InvoiceData{Code: "NF-1"})
+	bus.Publish(context.Background(), event)
+
+	if len(deliveries) != 3 {
+		t.Fatalf("handler ran %d times, want 3", len(deliveries))
+	}
+	for _, id := range deliveries {
+		if id != event.ID {
+			t.Errorf("redelivery has ID %s, want the same ID %s", id, event.ID)
+		}
+	}
+}
+
+func TestMemoryGivesUpOnAHandlerThatKeepsFailing(t *testing.T) {
+	bus := NewMemory()
+
+	deliveries := 0
+	bus.Subscribe(InvoiceClosed, "stock", func(ctx context.Context, event Event) error {
+		deliveries++
+		return errors.New("permanent failure")
+	})
+
+	event, _ := New("billing", InvoiceClosed, InvoiceData{Code: "NF-1"})
+	if err := bus.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish() = %v, want nil", err)
+	}
+	if deliveries != memoryMaxDeliveries {
+		t.Errorf("handler ran %d times, want %d", deliveries, memoryMaxDeliveries)
+	}
+}
@@ -0,0 +1,146 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// streamName is the JetStream stream holding every event. Both services
+// declare it with the same config, so either may start first.
+const streamName = "EVENTS"
+
+var streamSubjects = []string{"invoice.>", "stock.>", "product.>"}
+
+// Redeliveries of an event its handler failed wait natsRetryBase, doubling on
+// every further failure up to natsRetryMax.
+const (
+	natsRetryBase = time.Second
+	natsRetryMax  = 5 * time.Minute
+)
+
+// NATS is a Bus on NATS JetStream. Published events are stored in the stream
+// until every durable consumer acknowledged them, so a service that is down
+// receives them when it comes back.
+type NATS struct {
+	conn   *nats.Conn
+	stream jetstream.Stream
+	js     jetstream.JetStream
+
+	mu       sync.Mutex
+	consumes []jetstream.ConsumeContext
+}
+
+func NewNATS(url string) (*NATS, error) {
+	conn, err := nats.Connect(url, nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to NATS: %v", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error opening JetStream: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       streamName,
+		Subjects:   streamSubjects,
+		Storage:    jetstream.FileStorage,
+		MaxAge:     7 * 24 * time.Hour,
+		Duplicates: 10 * time.Minute,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error creating stream %s: %v", streamName, err)
+	}
+
+	return &NATS{conn: conn, stream: stream, js: js}, nil
+}
+
+// Publish returns once the stream stored the event. The event ID is the
+// message ID, so publishing it again within the duplicate window is a no-op.
+func (n *NATS) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := n.js.Publish(ctx, event.Type, data, jetstream.WithMsgID(event.ID)); err != nil {
+		return fmt.Errorf("error publishing %s event %s: %v", event.Type, event.ID, err)
+	}
+	return nil
+}
+
+// Subscribe creates, or resumes, the durable consumer of group for eventType.
+func (n *NATS) Subscribe(eventType, group string, handler Handler) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	consumer, err := n.stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       group + "_" + strings.ReplaceAll(eventType, ".", "_"),
+		FilterSubject: eventType,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       time.Minute,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating consumer for %s: %v", eventType, err)
+	}
+
+	consume, err := consumer.Consume(func(msg jetstream.Msg) {
+		var event Event
+		if err := json.Unmarshal(msg.Data(), &event); err != nil {
+			log.Printf("Discarding malformed %s event: %v", eventType, err)
+			msg.Term()
+			return
+		}
+
+		if err := handler(context.Background(), event); err != nil {
+			delivered := uint64(1)
+			if metadata, err := msg.Metadata(); err == nil {
+				delivered = metadata.NumDelivered
+			}
+			log.Printf("Error handling %s event %s (delivery %d): %v", event.Type, event.ID, delivered, err)
+			msg.NakWithDelay(retryDelay(delivered))
+			return
+		}
+		msg.Ack()
+	})
+	if err != nil {
+		return fmt.Errorf("error consuming %s: %v", eventType, err)
+	}
+
+	n.mu.Lock()
+	n.consumes = append(n.consumes, consume)
+	n.mu.Unlock()
+	return nil
+}
+
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	for _, consume := range n.consumes {
+		consume.Stop()
+	}
+	n.consumes = nil
+	n.mu.Unlock()
+
+	return n.conn.Drain()
+}
+
+func retryDelay(delivered uint64) time.Duration {
+	delay := natsRetryBase << (delivered - 1)
+	if delivered > 32 || delay <= 0 || delay > natsRetryMax {
+		return natsRetryMax
+	}
+	return delay
+}
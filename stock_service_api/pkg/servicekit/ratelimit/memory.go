@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often the memory store drops the windows that
+// are over.
+const memorySweepInterval = time.Minute
+
+type memoryWindow struct {
+	start time.Time
+	ends  time.Time
+	count int
+}
+
+// Memory keeps the counters in the process. Each instance counts apart, so
+// it only suits a single instance.
+type Memory struct {
+	mu        sync.Mutex
+	windows   map[string]*memoryWindow
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{windows: make(map[string]*memoryWindow)}
+}
+
+func (m *Memory) Increment(_ context.Context, key string, start time.Time, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now := time.Now(); now.Sub(m.lastSweep) >= memorySweepInterval {
+		for k, w := range m.windows {
+			if !w.ends.After(now) {
+				delete(m.windows, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	w, ok := m.windows[key]
+	if !ok || !w.start.Equal(start) {
+		w = &memoryWindow{start: start, ends: start.Add(window)}
+		m.windows[key] = w
+	}
+	w.count++
+	return w.count, nil
+}
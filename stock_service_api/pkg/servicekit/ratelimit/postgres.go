@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Postgres keeps the counters in the rate_limits table, so every instance
+// using the database shares them. Purge deletes the windows that are over.
+type Postgres struct {
+	db *sqlx.DB
+}
+
+func NewPostgres(db *sqlx.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) Increment(ctx context.Context, key string, start time.Time, window time.Duration) (int, error) {
+	var count int
+	err := p.db.GetContext(ctx, &count, `INSERT INTO rate_limits (key, window_start, expires_at, count) VALUES ($1, $2, $3, 1)
+		ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limits.count + 1
+		RETURNING count`,
+		key, start, start.Add(window))
+	return count, err
+}
+
+// Purge deletes the windows that ended before now.
+func (p *Postgres) Purge(now time.Time) error {
+	_, err := p.db.Exec("DELETE FROM rate_limits WHERE expires_at < $1", now)
+	return err
+}
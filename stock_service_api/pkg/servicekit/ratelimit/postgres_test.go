@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// TestPostgres counts in the rate_limits table of TEST_DATABASE_URL, a
+// database set up by either init script. It is skipped when it is not set.
+func TestPostgres(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	database, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+	defer database.Close()
+
+	store := NewPostgres(database)
+	ctx := context.Background()
+	key := "test|" + uuid.NewString()
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+	for want := 1; want <= 3; want++ {
+		got, err := store.Increment(ctx, key, start, time.Minute)
+		if err != nil || got != want {
+			t.Fatalf("Increment() = %d, %v, want %d", got, err, want)
+		}
+	}
+	if got, err := store.Increment(ctx, key, start.Add(time.Minute), time.Minute); err != nil || got != 1 {
+		t.Fatalf("Increment() in the next window = %d, %v, want 1", got, err)
+	}
+
+	if err := store.Purge(time.Now()); err != nil {
+		t.Fatalf("Purge(): %v", err)
+	}
+	var left int
+	if err := database.Get(&left, "SELECT COUNT(*) FROM rate_limits WHERE key = $1", key); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d windows left after Purge, want 0", left)
+	}
+}
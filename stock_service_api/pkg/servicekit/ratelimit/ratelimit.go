@@ -0,0 +1,259 @@
+// Package ratelimit limits how many requests each client makes in a window:
+// an API key, a signed in user or, for anonymous requests, an IP address.
+// Routes can have limits of their own, and every limited response carries the
+// RateLimit-* headers. The counters live in a Store, in memory for a single
+// instance or in Postgres when several replicas share them. A second, looser
+// limit per IP address runs before authentication, so requests with made up
+// tokens or API keys are limited before they are checked.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+)
+
+// Limit allows Requests per Window.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// ParseLimit reads a limit written as "<requests>/<window>", such as
+// "120/1m".
+func ParseLimit(value string) (Limit, error) {
+	requests, window, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected <requests>/<window>", value)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid request count in rate limit %q", value)
+	}
+	duration, err := time.ParseDuration(window)
+	if err != nil || duration < time.Second {
+		return Limit{}, fmt.Errorf("invalid window in rate limit %q, at least 1s", value)
+	}
+	return Limit{Requests: n, Window: duration}, nil
+}
+
+// String writes the limit the way ParseLimit reads it, such as "120/1m".
+func (l Limit) String() string {
+	window := l.Window.String()
+	if strings.HasSuffix(window, "m0s") {
+		window = strings.TrimSuffix(window, "0s")
+	}
+	if strings.HasSuffix(window, "h0m") {
+		window = strings.TrimSuffix(window, "0m")
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, window)
+}
+
+// Rule gives the requests of Method to Path a limit of their own, counted
+// apart from the default one. A Path ending in "*" matches every path
+// starting with what comes before it.
+type Rule struct {
+	Method string
+	Path   string
+	Limit  Limit
+}
+
+func (r Rule) matches(c *fiber.Ctx) bool {
+	if r.Method != c.Method() {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+		return strings.HasPrefix(c.Path(), prefix)
+	}
+	return c.Path() == r.Path
+}
+
+func (r Rule) name() string {
+	return r.Method + " " + r.Path
+}
+
+// Store counts the requests of each key in fixed windows.
+type Store interface {
+	// Increment counts a request in the window of key starting at start,
+	// lasting window, and returns how many it has now.
+	Increment(ctx context.Context, key string, start time.Time, window time.Duration) (int, error)
+}
+
+// Config is what Middleware limits. Skip, when set, exempts the requests it
+// reports true for. Key names who a request counts for, the API key, user or
+// IP address when unset. Name keeps the counters of the config apart from
+// those of another sharing the store.
+type Config struct {
+	Name    string
+	Default Limit
+	Rules   []Rule
+	Store   Store
+	Skip    func(*fiber.Ctx) bool
+	Key     func(*fiber.Ctx) string
+}
+
+// ConfigFromEnv reads RATE_LIMIT, the default limit (600/1m when unset, or
+// off to disable limiting, in which case it returns nil), RATE_LIMIT_ROUTES,
+// a comma-separated list of "<METHOD> <path>=<limit>" overriding or adding to
+// rules, and RATE_LIMIT_STORE: memory, the default, or postgres, which counts
+// in the rate_limits table of database so replicas share the counters.
+func ConfigFromEnv(rules []Rule, database *sqlx.DB) (*Config, error) {
+	value := os.Getenv("RATE_LIMIT")
+	if value == "off" {
+		return nil, nil
+	}
+	if value == "" {
+		value = "600/1m"
+	}
+
+	config := &Config{Rules: append([]Rule(nil), rules...)}
+	var err error
+	if config.Default, err = ParseLimit(value); err != nil {
+		return nil, err
+	}
+
+	if routes := os.Getenv("RATE_LIMIT_ROUTES"); routes != "" {
+		for _, entry := range strings.Split(routes, ",") {
+			route, limit, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			method, path, hasPath := strings.Cut(strings.TrimSpace(route), " ")
+			if !ok || !hasPath {
+				return nil, fmt.Errorf("invalid RATE_LIMIT_ROUTES entry %q, expected <METHOD> <path>=<limit>", entry)
+			}
+			rule := Rule{Method: strings.ToUpper(method), Path: strings.TrimSpace(path)}
+			if rule.Limit, err = ParseLimit(limit); err != nil {
+				return nil, err
+			}
+			config.setRule(rule)
+		}
+	}
+
+	if config.Store, err = storeFromEnv(database); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// IPConfigFromEnv reads RATE_LIMIT_IP, the limit per IP address checked
+// before authentication (1200/1m when unset, or off to disable it, in which
+// case it returns nil). It counts in the store RATE_LIMIT_STORE names.
+func IPConfigFromEnv(database *sqlx.DB) (*Config, error) {
+	value := os.Getenv("RATE_LIMIT_IP")
+	if value == "off" {
+		return nil, nil
+	}
+	if value == "" {
+		value = "1200/1m"
+	}
+
+	config := &Config{Name: "ip", Key: IPKey}
+	var err error
+	if config.Default, err = ParseLimit(value); err != nil {
+		return nil, err
+	}
+	if config.Store, err = storeFromEnv(database); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// storeFromEnv reads RATE_LIMIT_STORE: memory, the default, or postgres.
+func storeFromEnv(database *sqlx.DB) (Store, error) {
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+		return NewMemory(), nil
+	case "postgres":
+		return NewPostgres(database), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", store)
+	}
+}
+
+// setRule replaces the rule for the same method and path, or adds it.
+func (c *Config) setRule(rule Rule) {
+	for i := range c.Rules {
+		if c.Rules[i].name() == rule.name() {
+			c.Rules[i] = rule
+			return
+		}
+	}
+	c.Rules = append(c.Rules, rule)
+}
+
+// Middleware answers 429 to the clients over their limit: that of the first
+// rule matching the request, or the default one. Without a Key it runs after
+// auth.Authenticate, so API keys and users are told apart from anonymous
+// clients. A store that fails lets the request through, since refusing every
+// request would be worse than not limiting them for a while.
+func Middleware(config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if config.Skip != nil && config.Skip(c) {
+			return c.Next()
+		}
+
+		bucket, limit := "default", config.Default
+		for _, rule := range config.Rules {
+			if rule.matches(c) {
+				bucket, limit = rule.name(), rule.Limit
+				break
+			}
+		}
+
+		key := clientKey
+		if config.Key != nil {
+			key = config.Key
+		}
+		if config.Name != "" {
+			bucket = config.Name + "|" + bucket
+		}
+
+		now := time.Now()
+		start := now.Truncate(limit.Window)
+		count, err := config.Store.Increment(c.UserContext(), bucket+"|"+key(c), start, limit.Window)
+		if err != nil {
+			log.Printf("Error counting request for rate limit: %v", err)
+			return c.Next()
+		}
+
+		reset := int(start.Add(limit.Window).Sub(now).Seconds() + 0.999)
+		remaining := limit.Requests - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		c.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Set("RateLimit-Reset", strconv.Itoa(reset))
+		c.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Window.Seconds())))
+
+		if count > limit.Requests {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(reset))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many requests, retry in " + strconv.Itoa(reset) + "s"})
+		}
+		return c.Next()
+	}
+}
+
+// clientKey names who the request counts for: the API key, the user, or the
+// IP address when there is neither.
+func clientKey(c *fiber.Ctx) string {
+	if user := auth.CurrentUser(c); user != nil {
+		if user.IsAPIKey() {
+			return "key:" + user.Subject
+		}
+		return "user:" + user.Subject
+	}
+	return IPKey(c)
+}
+
+// IPKey counts requests per IP address.
+func IPKey(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
@@ -0,0 +1,362 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Limit
+		err   bool
+	}{
+		{value: "120/1m", want: Limit{Requests: 120, Window: time.Minute}},
+		{value: " 10/30s ", want: Limit{Requests: 10, Window: 30 * time.Second}},
+		{value: "5000/1h", want: Limit{Requests: 5000, Window: time.Hour}},
+		{value: "120", err: true},
+		{value: "0/1m", err: true},
+		{value: "-1/1m", err: true},
+		{value: "many/1m", err: true},
+		{value: "10/soon", err: true},
+		{value: "10/500ms", err: true},
+	}
+	for _, test := range tests {
+		got, err := ParseLimit(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseLimit(%q) = %v, want an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ParseLimit(%q) = %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestLimitString(t *testing.T) {
+	tests := []struct {
+		limit Limit
+		want  string
+	}{
+		{Limit{Requests: 120, Window: time.Minute}, "120/1m"},
+		{Limit{Requests: 10, Window: 30 * time.Second}, "10/30s"},
+		{Limit{Requests: 5000, Window: time.Hour}, "5000/1h"},
+		{Limit{Requests: 7, Window: 90 * time.Second}, "7/1m30s"},
+	}
+	for _, test := range tests {
+		if got := test.limit.String(); got != test.want {
+			t.Errorf("%#v.String() = %q, want %q", test.limit, got, test.want)
+		}
+		if parsed, err := ParseLimit(test.want); err != nil || parsed != test.limit {
+			t.Errorf("ParseLimit(%q) = %v, %v, want %v", test.want, parsed, err, test.limit)
+		}
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	login := Rule{Method: "POST", Path: "/auth/login", Limit: Limit{Requests: 10, Window: time.Minute}}
+
+	tests := []struct {
+		name   string
+		env    map[string]string
+		want   *Config
+		memory bool
+		err    bool
+	}{
+		{
+			name:   "defaults",
+			want:   &Config{Default: Limit{Requests: 600, Window: time.Minute}, Rules: []Rule{login}},
+			memory: true,
+		},
+		{
+			name: "off",
+			env:  map[string]string{"RATE_LIMIT": "off"},
+		},
+		{
+			name: "routes override and add rules",
+			env: map[string]string{
+				"RATE_LIMIT":        "100/10s",
+				"RATE_LIMIT_ROUTES": "POST /auth/login=3/1m, get /products/*=50/1m",
+				"RATE_LIMIT_STORE":  "memory",
+			},
+			want: &Config{Default: Limit{Requests: 100, Window: 10 * time.Second}, Rules: []Rule{
+				{Method: "POST", Path: "/auth/login", Limit: Limit{Requests: 3, Window: time.Minute}},
+				{Method: "GET", Path: "/products/*", Limit: Limit{Requests: 50, Window: time.Minute}},
+			}},
+			memory: true,
+		},
+		{
+			name: "invalid default",
+			env:  map[string]string{"RATE_LIMIT": "lots"},
+			err:  true,
+		},
+		{
+			name: "route without a method",
+			env:  map[string]string{"RATE_LIMIT_ROUTES": "/auth/login=3/1m"},
+			err:  true,
+		},
+		{
+			name: "route without a limit",
+			env:  map[string]string{"RATE_LIMIT_ROUTES": "POST /auth/login"},
+			err:  true,
+		},
+		{
+			name: "unknown store",
+			env:  map[string]string{"RATE_LIMIT_STORE": "redis"},
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"RATE_LIMIT", "RATE_LIMIT_ROUTES", "RATE_LIMIT_STORE"} {
+				t.Setenv(name, test.env[name])
+			}
+
+			rules := []Rule{login}
+			config, err := ConfigFromEnv(rules, nil)
+			if test.err {
+				if err == nil {
+					t.Fatalf("ConfigFromEnv() = %+v, want an error", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConfigFromEnv(): %v", err)
+			}
+			if test.want == nil {
+				if config != nil {
+					t.Fatalf("ConfigFromEnv() = %+v, want nil", config)
+				}
+				return
+			}
+
+			if _, ok := config.Store.(*Memory); ok != test.memory {
+				t.Errorf("store = %T", config.Store)
+			}
+			if config.Default != test.want.Default {
+				t.Errorf("default = %v, want %v", config.Default, test.want.Default)
+			}
+			if !reflect.DeepEqual(config.Rules, test.want.Rules) {
+				t.Errorf("rules = %v, want %v", config.Rules, test.want.Rules)
+			}
+			if rules[0] != login {
+				t.Errorf("the rules passed in were changed to %v", rules[0])
+			}
+		})
+	}
+}
+
+func TestConfigFromEnvPostgresStore(t *testing.T) {
+	t.Setenv("RATE_LIMIT", "")
+	t.Setenv("RATE_LIMIT_ROUTES", "")
+	t.Setenv("RATE_LIMIT_STORE", "postgres")
+
+	config, err := ConfigFromEnv(nil, nil)
+	if err != nil {
+		t.Fatalf("ConfigFromEnv(): %v", err)
+	}
+	if _, ok := config.Store.(*Postgres); !ok {
+		t.Errorf("store = %T, want *Postgres", config.Store)
+	}
+}
+
+func TestIPConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Limit
+		off   bool
+		err   bool
+	}{
+		{value: "", want: Limit{Requests: 1200, Window: time.Minute}},
+		{value: "300/10s", want: Limit{Requests: 300, Window: 10 * time.Second}},
+		{value: "off", off: true},
+		{value: "fast", err: true},
+	}
+	for _, test := range tests {
+		t.Setenv("RATE_LIMIT_IP", test.value)
+		t.Setenv("RATE_LIMIT_STORE", "")
+
+		config, err := IPConfigFromEnv(nil)
+		switch {
+		case test.err:
+			if err == nil {
+				t.Errorf("IPConfigFromEnv() with %q = %+v, want an error", test.value, config)
+			}
+		case err != nil:
+			t.Errorf("IPConfigFromEnv() with %q: %v", test.value, err)
+		case test.off:
+			if config != nil {
+				t.Errorf("IPConfigFromEnv() with %q = %+v, want nil", test.value, config)
+			}
+		case config.Default != test.want || config.Name != "ip" || config.Key == nil:
+			t.Errorf("IPConfigFromEnv() with %q = %+v", test.value, config)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule   Rule
+		method string
+		path   string
+		want   bool
+	}{
+		{Rule{Method: "GET", Path: "/products"}, "GET", "/products", true},
+		{Rule{Method: "GET", Path: "/products"}, "POST", "/products", false},
+		{Rule{Method: "GET", Path: "/products"}, "GET", "/products/1", false},
+		{Rule{Method: "GET", Path: "/products/*"}, "GET", "/products/1", true},
+		{Rule{Method: "GET", Path: "/products/*"}, "GET", "/product/1", false},
+	}
+	for _, test := range tests {
+		app := fiber.New()
+		var got bool
+		app.Use(func(c *fiber.Ctx) error {
+			got = test.rule.matches(c)
+			return nil
+		})
+		if _, err := app.Test(httptest.NewRequest(test.method, test.path, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%v matches %s %s = %v, want %v", test.rule, test.method, test.path, got, test.want)
+		}
+	}
+}
+
+// limitedApp answers 204 to every request that gets through config.
+func limitedApp(config Config) *fiber.App {
+	app := fiber.New()
+	app.Use(Middleware(config))
+	app.Use(func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	return app
+}
+
+func TestMiddleware(t *testing.T) {
+	app := limitedApp(Config{
+		Default: Limit{Requests: 2, Window: time.Hour},
+		Rules:   []Rule{{Method: "POST", Path: "/auth/login", Limit: Limit{Requests: 1, Window: time.Hour}}},
+		Store:   NewMemory(),
+	})
+
+	tests := []struct {
+		method    string
+		path      string
+		status    int
+		limit     string
+		remaining string
+	}{
+		{"GET", "/products", fiber.StatusNoContent, "2", "1"},
+		{"POST", "/auth/login", fiber.StatusNoContent, "1", "0"},
+		{"GET", "/invoices", fiber.StatusNoContent, "2", "0"},
+		{"POST", "/auth/login", fiber.StatusTooManyRequests, "1", "0"},
+		{"GET", "/products", fiber.StatusTooManyRequests, "2", "0"},
+	}
+	for i, test := range tests {
+		response, err := app.Test(httptest.NewRequest(test.method, test.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != test.status {
+			t.Errorf("request %d, %s %s: status %d, want %d", i, test.method, test.path, response.StatusCode, test.status)
+		}
+		if got := response.Header.Get("RateLimit-Limit"); got != test.limit {
+			t.Errorf("request %d: RateLimit-Limit %q, want %q", i, got, test.limit)
+		}
+		if got := response.Header.Get("RateLimit-Remaining"); got != test.remaining {
+			t.Errorf("request %d: RateLimit-Remaining %q, want %q", i, got, test.remaining)
+		}
+		if response.Header.Get("RateLimit-Reset") == "" || response.Header.Get("RateLimit-Policy") == "" {
+			t.Errorf("request %d: missing RateLimit-Reset or RateLimit-Policy", i)
+		}
+		if retryAfter := response.Header.Get(fiber.HeaderRetryAfter); (retryAfter != "") != (test.status == fiber.StatusTooManyRequests) {
+			t.Errorf("request %d: Retry-After %q with status %d", i, retryAfter, response.StatusCode)
+		}
+	}
+}
+
+func TestMiddlewareSkip(t *testing.T) {
+	app := limitedApp(Config{
+		Default: Limit{Requests: 1, Window: time.Hour},
+		Store:   NewMemory(),
+		Skip:    func(c *fiber.Ctx) bool { return c.Get("X-Service") != "" },
+	})
+
+	for i := 0; i < 3; i++ {
+		request := httptest.NewRequest("GET", "/products", nil)
+		request.Header.Set("X-Service", "billing")
+		response, err := app.Test(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != fiber.StatusNoContent || response.Header.Get("RateLimit-Limit") != "" {
+			t.Fatalf("skipped request %d: status %d, RateLimit-Limit %q", i, response.StatusCode, response.Header.Get("RateLimit-Limit"))
+		}
+	}
+}
+
+func TestMiddlewareNamesKeepCountersApart(t *testing.T) {
+	store := NewMemory()
+	limit := Limit{Requests: 1, Window: time.Hour}
+	app := fiber.New()
+	app.Use(Middleware(Config{Name: "ip", Default: limit, Store: store, Key: IPKey}))
+	app.Use(Middleware(Config{Default: limit, Store: store}))
+	app.Use(func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	response, err := app.Test(httptest.NewRequest("GET", "/products", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != fiber.StatusNoContent {
+		t.Fatalf("status %d: the anonymous limit counted the request of the IP limit", response.StatusCode)
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Increment(context.Context, string, time.Time, time.Duration) (int, error) {
+	return 0, errors.New("database is down")
+}
+
+func TestMiddlewareLetsRequestsThroughWhenTheStoreFails(t *testing.T) {
+	app := limitedApp(Config{Default: Limit{Requests: 1, Window: time.Hour}, Store: failingStore{}})
+
+	for i := 0; i < 3; i++ {
+		response, err := app.Test(httptest.NewRequest("GET", "/products", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != fiber.StatusNoContent {
+			t.Fatalf("request %d: status %d, want 204", i, response.StatusCode)
+		}
+	}
+}
+
+func TestMemoryStartsAFreshWindow(t *testing.T) {
+	store := NewMemory()
+	ctx := context.Background()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for want := 1; want <= 3; want++ {
+		if got, _ := store.Increment(ctx, "k", start, time.Minute); got != want {
+			t.Fatalf("count %d, want %d", got, want)
+		}
+	}
+	if got, _ := store.Increment(ctx, "other", start, time.Minute); got != 1 {
+		t.Errorf("another key counted %d, want 1", got)
+	}
+	if got, _ := store.Increment(ctx, "k", start.Add(time.Minute), time.Minute); got != 1 {
+		t.Errorf("next window counted %d, want 1", got)
+	}
+}
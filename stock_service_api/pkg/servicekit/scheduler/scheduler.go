@@ -0,0 +1,71 @@
+// Package scheduler runs the background jobs of both services on an
+// interval, until the context they are started with is cancelled.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// Job is one pass of background work. It receives the tick time so jobs can
+// be reasoned about, and replayed, without reading the clock themselves.
+type Job func(now time.Time) error
+
+// Every runs job once immediately and then on every interval until ctx is
+// cancelled. Errors are logged and the job is simply retried on the next tick,
+// so jobs must be idempotent. A job that panics is handled the same way,
+// instead of taking the service down with it.
+func Every(ctx context.Context, name string, interval time.Duration, job Job) {
+	log.Printf("Scheduler %s started, running every %s", name, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	run := func(now time.Time) {
+		if err := runJob(job, now); err != nil {
+			log.Printf("Scheduler %s failed: %v", name, err)
+		}
+	}
+
+	run(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Scheduler %s stopped", name)
+			return
+		case now := <-ticker.C:
+			run(now)
+		}
+	}
+}
+
+// runJob runs job, turning a panic into an error.
+func runJob(job Job, now time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return job(now)
+}
+
+// IntervalFromEnv reads a time.Duration such as "30s" or "5m" from the
+// environment, falling back when the variable is unset or invalid.
+func IntervalFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+
+	return interval
+}
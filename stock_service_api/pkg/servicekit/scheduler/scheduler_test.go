@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// runs counts the runs of a job, failing or panicking as it is told.
+type runs struct {
+	ch    chan time.Time
+	fail  bool
+	panic bool
+}
+
+func (r *runs) job(now time.Time) error {
+	r.ch <- now
+	if r.panic {
+		panic("boom")
+	}
+	if r.fail {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (r *runs) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.ch:
+		case <-time.After(time.Second):
+			t.Fatalf("run %d of %d did not happen", i+1, n)
+		}
+	}
+}
+
+func TestEveryRunsAtOnceAndOnEveryTick(t *testing.T) {
+	r := &runs{ch: make(chan time.Time, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go Every(ctx, "test", 10*time.Millisecond, r.job)
+	r.wait(t, 3)
+}
+
+func TestEveryKeepsGoingAfterFailuresAndPanics(t *testing.T) {
+	for _, r := range []*runs{
+		{ch: make(chan time.Time, 10), fail: true},
+		{ch: make(chan time.Time, 10), panic: true},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		go Every(ctx, "test", 10*time.Millisecond, r.job)
+		r.wait(t, 3)
+		cancel()
+	}
+}
+
+func TestEveryStopsWhenCancelled(t *testing.T) {
+	r := &runs{ch: make(chan time.Time, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stopped := make(chan struct{})
+	go func() {
+		Every(ctx, "test", time.Hour, r.job)
+		close(stopped)
+	}()
+	r.wait(t, 1)
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Every did not return after the context was cancelled")
+	}
+}
+
+func TestRunJobTurnsPanicsIntoErrors(t *testing.T) {
+	err := runJob(func(time.Time) error { panic("boom") }, time.Now())
+	if err == nil {
+		t.Fatal("runJob() = nil after a panic")
+	}
+}
+
+func TestIntervalFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", time.Minute},
+		{"30s", 30 * time.Second},
+		{"5m", 5 * time.Minute},
+		{"soon", time.Minute},
+		{"0s", time.Minute},
+		{"-1m", time.Minute},
+	}
+	for _, test := range tests {
+		t.Setenv("TEST_INTERVAL", test.value)
+		if got := IntervalFromEnv("TEST_INTERVAL", time.Minute); got != test.want {
+			t.Errorf("IntervalFromEnv with %q = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
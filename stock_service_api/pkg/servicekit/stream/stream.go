@@ -0,0 +1,176 @@
+// Package stream serves Server-Sent Events read from the stream_events table.
+// Streams are woken by Postgres notifications, so every instance streams the
+// events written through any of them. Both services serve their GET /events
+// with it.
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the channel the stream_events trigger notifies.
+const Channel = "stream_events"
+
+// PageSize is how many events a stream reads at a time.
+const PageSize = 100
+
+// RetryDelay is how long browsers wait before reconnecting a dropped stream.
+const RetryDelay = 3 * time.Second
+
+// Message is one event of the stream. Its ID is the Last-Event-ID a client
+// resumes after.
+type Message struct {
+	ID    int64
+	Event string
+	Data  []byte
+}
+
+// Fetch reads up to limit messages after the ID given, in ID order.
+type Fetch func(after int64, limit int) ([]Message, error)
+
+// Hub wakes the streams of this instance whenever stream_events is notified.
+type Hub struct {
+	mu      sync.Mutex
+	streams map[chan struct{}]bool
+}
+
+// Listen subscribes listener to Channel and returns the hub it wakes.
+func Listen(listener *pq.Listener) (*Hub, error) {
+	if err := listener.Listen(Channel); err != nil {
+		return nil, err
+	}
+
+	hub := &Hub{streams: make(map[chan struct{}]bool)}
+	go hub.run(listener)
+	return hub, nil
+}
+
+// run wakes every stream on each notification. A nil one follows a
+// reconnection, when notifications may have been lost; streams read from
+// their last ID either way, so nothing is skipped.
+func (h *Hub) run(listener *pq.Listener) {
+	for {
+		select {
+		case _, ok := <-listener.Notify:
+			if !ok {
+				return
+			}
+			h.wake()
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
+
+func (h *Hub) wake() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for stream := range h.streams {
+		select {
+		case stream <- struct{}{}:
+		default: // already due to read
+		}
+	}
+}
+
+// Subscribe returns a channel signalled when new events may have been
+// written. stop has to be called to unsubscribe.
+func (h *Hub) Subscribe() (wake <-chan struct{}, stop func()) {
+	stream := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	h.streams[stream] = true
+	h.mu.Unlock()
+
+	return stream, func() {
+		h.mu.Lock()
+		delete(h.streams, stream)
+		h.mu.Unlock()
+	}
+}
+
+// Serve writes the messages after the ID given and then the new ones as wake
+// signals them, pinging the client while idle. It returns once the client
+// is gone or reading fails; the client then reconnects with the last ID it
+// got.
+func Serve(w *bufio.Writer, wake <-chan struct{}, after int64, fetch Fetch, ping time.Duration) {
+	ticker := time.NewTicker(ping)
+	defer ticker.Stop()
+
+	fmt.Fprintf(w, "retry: %d\n\n", RetryDelay.Milliseconds())
+	for {
+		for {
+			messages, err := fetch(after, PageSize)
+			if err != nil {
+				log.Printf("Error reading the event stream: %v", err)
+				return
+			}
+			for _, message := range messages {
+				writeMessage(w, message)
+				after = message.ID
+			}
+			if len(messages) < PageSize {
+				break
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		// A ping also reads again, in case a notification was missed.
+		select {
+		case <-wake:
+		case <-ticker.C:
+			w.WriteString(": ping\n\n")
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeMessage(w *bufio.Writer, message Message) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\n", message.ID, message.Event)
+	for _, line := range bytes.Split(message.Data, []byte("\n")) {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	w.WriteString("\n")
+}
+
+// PingInterval is STREAM_PING_INTERVAL, 15s by default.
+func PingInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("STREAM_PING_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return 15 * time.Second
+}
+
+// Retention is STREAM_RETENTION, how long events are kept for clients to
+// resume from; 24h by default.
+func Retention() time.Duration {
+	if retention, err := time.ParseDuration(os.Getenv("STREAM_RETENTION")); err == nil && retention > 0 {
+		return retention
+	}
+	return 24 * time.Hour
+}
+
+// ParseList splits a comma-separated filter, dropping empty entries.
+func ParseList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,116 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Querier runs queries: the pool, a company's Conn or a transaction of
+// either.
+type Querier interface {
+	sqlx.Ext
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Scope is a Querier that can also start transactions.
+type Scope interface {
+	Querier
+	Beginx() (*sqlx.Tx, error)
+}
+
+// Conn is a connection of the pool held for one company: app.company_id is
+// set on it, so row-level security shows and accepts only the rows of
+// CompanyID, and rows inserted without company_id go to it. Its queries run
+// in the context it was opened with, so they stop with the request or the
+// call that holds it.
+type Conn struct {
+	CompanyID string
+	ctx       context.Context
+	pool      *sqlx.DB
+	conn      *sqlx.Conn
+}
+
+// Open takes a connection from pool for companyID, for queries made in ctx.
+// It must be given back with Release.
+func Open(ctx context.Context, pool *sqlx.DB, companyID string) (*Conn, error) {
+	conn, err := pool.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT set_config('app.company_id', $1, false)", companyID); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{CompanyID: companyID, ctx: ctx, pool: pool, conn: conn}, nil
+}
+
+// With runs fn on a connection of pool for companyID.
+func With(pool *sqlx.DB, companyID string, fn func(conn *Conn) error) error {
+	conn, err := Open(context.Background(), pool, companyID)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	return fn(conn)
+}
+
+// Release clears the company and returns the connection to the pool, even
+// once the context of the connection is done. A connection whose company
+// can't be cleared is closed instead, so it never serves another company.
+func (c *Conn) Release() {
+	if _, err := c.conn.ExecContext(context.Background(), "RESET app.company_id"); err != nil {
+		c.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	c.conn.Close()
+}
+
+func (c *Conn) Beginx() (*sqlx.Tx, error) {
+	return c.conn.BeginTxx(c.ctx, nil)
+}
+
+func (c *Conn) Get(dest interface{}, query string, args ...interface{}) error {
+	return c.conn.GetContext(c.ctx, dest, query, args...)
+}
+
+func (c *Conn) Select(dest interface{}, query string, args ...interface{}) error {
+	return c.conn.SelectContext(c.ctx, dest, query, args...)
+}
+
+func (c *Conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c *Conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c *Conn) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	return c.conn.QueryxContext(c.ctx, query, args...)
+}
+
+func (c *Conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c *Conn) QueryRowx(query string, args ...interface{}) *sqlx.Row {
+	return c.conn.QueryRowxContext(c.ctx, query, args...)
+}
+
+func (c *Conn) DriverName() string {
+	return c.pool.DriverName()
+}
+
+func (c *Conn) Rebind(query string) string {
+	return c.conn.Rebind(query)
+}
+
+func (c *Conn) BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	return c.pool.BindNamed(query, arg)
+}
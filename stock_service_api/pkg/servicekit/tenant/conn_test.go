@@ -0,0 +1,58 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+// TestConnStopsWithItsContext runs on TEST_DATABASE_URL, a database set up by
+// either init script. It is skipped when it is not set.
+func TestConnStopsWithItsContext(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	pool, err := sqlx.Connect("postgres", url)
+	if err != nil {
+		t.Fatalf("connecting to TEST_DATABASE_URL: %v", err)
+	}
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	companyID := uuid.NewString()
+	conn, err := Open(ctx, pool, companyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = conn.Exec("SELECT pg_sleep(5)")
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("Exec() = %v, want it stopped by the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Exec() took %s after its context was done", elapsed)
+	}
+
+	var company string
+	if err := conn.Get(&company, "SELECT current_setting('app.company_id', true)"); err == nil {
+		t.Error("Get() ran after the context of the connection was done")
+	}
+	conn.Release()
+
+	err = With(pool, companyID, func(conn *Conn) error {
+		return conn.Get(&company, "SELECT current_setting('app.company_id', true)")
+	})
+	if err != nil || company != companyID {
+		t.Errorf("company of a new connection = %q, %v, want %s", company, err, companyID)
+	}
+}
@@ -0,0 +1,83 @@
+// Package tenant picks the company a request works for and holds a database
+// connection set to it, so row-level security keeps the queries of the
+// request to that company's rows. Both services use it, each with its pool.
+package tenant
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+)
+
+// Header names the company of a request made by another service, or one a
+// user picks explicitly, which must then be theirs.
+const Header = "X-Company-ID"
+
+const (
+	connLocal = "tenant.conn"
+	poolLocal = "tenant.pool"
+)
+
+// poolRef keeps the pool in the locals of a request. fasthttp closes the
+// locals that are an io.Closer once the request is done, as *sqlx.DB is.
+type poolRef struct {
+	pool *sqlx.DB
+}
+
+// Middleware sets the company of the request: the one of the signed in user
+// or, on the routes fromHeader reports true for, the one in the Header. It
+// runs after authentication and takes the connection from pool. Requests
+// with no company, such as those of the public routes, go on without a
+// connection and DB gives them pool, which sees no company's rows.
+func Middleware(pool *sqlx.DB, fromHeader func(*fiber.Ctx) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals(poolLocal, poolRef{pool})
+
+		companyID := c.Get(Header)
+		if user := auth.CurrentUser(c); user != nil {
+			if companyID != "" && companyID != user.Company {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User does not belong to this company"})
+			}
+			companyID = user.Company
+		} else if fromHeader == nil || !fromHeader(c) {
+			return c.Next()
+		} else if companyID == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": Header + " header is required"})
+		}
+
+		if _, err := uuid.Parse(companyID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid " + Header})
+		}
+
+		conn, err := Open(c.UserContext(), pool, companyID)
+		if err != nil {
+			log.Printf("Error opening connection for company %s: %v", companyID, err)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Database unavailable"})
+		}
+		defer conn.Release()
+
+		c.Locals(connLocal, conn)
+		return c.Next()
+	}
+}
+
+// DB returns the connection of the company of the request, or the pool when
+// the request has none.
+func DB(c *fiber.Ctx) Scope {
+	if conn, ok := c.Locals(connLocal).(*Conn); ok {
+		return conn
+	}
+	ref, _ := c.Locals(poolLocal).(poolRef)
+	return ref.pool
+}
+
+// CompanyID returns the company of the request, or "" when it has none.
+func CompanyID(c *fiber.Ctx) string {
+	if conn, ok := c.Locals(connLocal).(*Conn); ok {
+		return conn.CompanyID
+	}
+	return ""
+}
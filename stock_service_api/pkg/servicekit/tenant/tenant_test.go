@@ -0,0 +1,68 @@
+package tenant
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lucasbpereira/stock_service_api/pkg/servicekit/auth"
+)
+
+// The requests below are refused, or go on without a company, before a
+// connection is taken, so the pool is never reached.
+func TestMiddlewareRefusesBeforeOpening(t *testing.T) {
+	config := auth.Config{Secret: []byte(strings.Repeat("s", 32)), Issuer: "nota_fiscal", AccessTTL: time.Hour}
+	pool := sqlx.NewDb(&sql.DB{}, "postgres")
+
+	app := fiber.New()
+	app.Use(auth.Authenticate(config, nil, nil, auth.Paths("/internal", "/public")))
+	app.Use(Middleware(pool, func(c *fiber.Ctx) bool { return c.Path() == "/internal" }))
+	app.Get("/*", func(c *fiber.Ctx) error {
+		if CompanyID(c) != "" || DB(c) != Scope(pool) {
+			t.Errorf("%s got company %q", c.Path(), CompanyID(c))
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	token, _, err := config.IssueAccessToken(uuid.NewString(), "user@example.com", uuid.NewString(), auth.RoleViewer, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		token   string
+		company string
+		want    int
+	}{
+		{"user picking another company", "/products", token, uuid.NewString(), fiber.StatusForbidden},
+		{"service call without company", "/internal", "", "", fiber.StatusBadRequest},
+		{"service call with invalid company", "/internal", "", "not-a-uuid", fiber.StatusBadRequest},
+		{"public route", "/public", "", "", fiber.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(fiber.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+			}
+			if tt.company != "" {
+				request.Header.Set(Header, tt.company)
+			}
+
+			response, err := app.Test(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", response.StatusCode, tt.want)
+			}
+		})
+	}
+}
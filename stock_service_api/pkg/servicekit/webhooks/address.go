@@ -0,0 +1,101 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned for a webhook URL that reaches a loopback,
+// private, link-local or otherwise internal address: the other containers of
+// the deployment, the database or a cloud metadata service.
+var ErrForbiddenAddress = errors.New("webhook URL must reach a public address")
+
+// reservedNetworks are not internet destinations, on top of those net.IP
+// classifies already.
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("240.0.0.0/4"),
+	mustParseCIDR("64:ff9b::/96"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// CheckURL refuses a subscription URL that is not http or https or whose host
+// resolves to an internal address. Deliveries check the address again when
+// they connect, since what a name resolves to can change.
+func CheckURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("webhook URL must be http or https")
+	}
+	if parsed.Hostname() == "" {
+		return fmt.Errorf("webhook URL has no host")
+	}
+	if allowPrivate() {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("resolving %s: %v", parsed.Hostname(), err)
+	}
+	for _, addr := range addrs {
+		if !publicAddress(addr.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+func publicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDial is the Control of the delivery dialer. It runs on the address
+// being connected to, after resolution and for every redirect, so a name
+// resolving to an internal address by then is refused too.
+func checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// allowPrivate lets deliveries reach internal addresses when
+// WEBHOOK_ALLOW_PRIVATE is true, for subscribers running next to the
+// services in development.
+func allowPrivate() bool {
+	allow, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE"))
+	return allow
+}
@@ -0,0 +1,104 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.18.0.5", false},
+		{"192.168.1.10", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, test := range tests {
+		if got := publicAddress(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("publicAddress(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://93.184.216.34/hooks", false},
+		{"http://127.0.0.1:3000/hooks", true},
+		{"http://localhost/hooks", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://10.0.0.5/hooks", true},
+		{"http://[::1]/hooks", true},
+		{"ftp://93.184.216.34/hooks", true},
+		{"http:///hooks", true},
+	}
+	for _, test := range tests {
+		err := CheckURL(context.Background(), test.url)
+		if (err != nil) != test.wantErr {
+			t.Errorf("CheckURL(%s) = %v, want error %v", test.url, err, test.wantErr)
+		}
+	}
+}
+
+func TestCheckURLAllowsPrivateWhenConfigured(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE", "true")
+
+	if err := CheckURL(context.Background(), "http://127.0.0.1:3000/hooks"); err != nil {
+		t.Errorf("CheckURL with WEBHOOK_ALLOW_PRIVATE: %v", err)
+	}
+}
+
+func TestClientRefusesInternalAddresses(t *testing.T) {
+	_, server := newReceiver(t)
+
+	_, err := Send(context.Background(), NewClient(), testDelivery(server.URL, "delivery-1"))
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Send to %s = %v, want %v", server.URL, err, ErrForbiddenAddress)
+	}
+}
+
+func TestClientRefusesRedirectsToInternalAddresses(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the redirect to an internal address was followed")
+	}))
+	defer internal.Close()
+
+	// The test client reaches the redirecting server itself, then has to go
+	// through the checking dialer for where it is sent.
+	redirecting := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusTemporaryRedirect))
+	defer redirecting.Close()
+
+	client := NewClient()
+	transport := client.Transport.(*http.Transport)
+	checked := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if address == redirecting.Listener.Addr().String() {
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}
+		return checked(ctx, network, address)
+	}
+
+	_, err := Send(context.Background(), client, testDelivery(redirecting.URL, "delivery-1"))
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Send through a redirect = %v, want %v", err, ErrForbiddenAddress)
+	}
+}
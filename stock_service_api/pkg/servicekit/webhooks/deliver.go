@@ -0,0 +1,162 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Statuses of a delivery in webhook_deliveries, the same table in both
+// services.
+const (
+	StatusPending = "PENDENTE"
+	StatusSent    = "ENVIADO"
+	StatusFailed  = "FALHOU"
+)
+
+// leaseMargin is added to the client timeout to get how long a claimed
+// delivery is left to its sender before another run may claim it again.
+const leaseMargin = time.Minute
+
+var errInactive = errors.New("webhook is not active")
+
+// InTx runs fn in a transaction on a connection of the company whose
+// deliveries are sent, committing it when fn succeeds. The connection is only
+// held for that transaction.
+type InTx func(fn func(tx *sqlx.Tx) error) error
+
+// claimedDelivery is a due delivery with the subscription it goes to.
+type claimedDelivery struct {
+	ID        string `db:"id"`
+	EventID   string `db:"event_id"`
+	EventType string `db:"event_type"`
+	Payload   []byte `db:"payload"`
+	Attempts  int    `db:"attempts"`
+	URL       string `db:"url"`
+	Secret    string `db:"secret"`
+	Active    bool   `db:"active"`
+}
+
+// DeliverDue sends every delivery due at now, one at a time.
+func DeliverDue(ctx context.Context, inTx InTx, client *http.Client, now time.Time, maxAttempts int) error {
+	for {
+		done, err := DeliverNext(ctx, inTx, client, now, maxAttempts)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// DeliverNext sends the oldest delivery due at now and returns true when
+// there was none. The delivery is claimed in a transaction of its own, which
+// moves its next attempt a lease ahead so no other run sends it meanwhile,
+// and its result is recorded in another: no transaction or connection is
+// held while the subscriber answers. A sender dying in between leaves the
+// delivery due again once the lease is over.
+func DeliverNext(ctx context.Context, inTx InTx, client *http.Client, now time.Time, maxAttempts int) (bool, error) {
+	var delivery *claimedDelivery
+	err := inTx(func(tx *sqlx.Tx) error {
+		var err error
+		delivery, err = claim(tx, now, client.Timeout+leaseMargin)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if delivery == nil {
+		return true, nil
+	}
+
+	responseStatus, sendErr := 0, errInactive
+	if delivery.Active {
+		responseStatus, sendErr = Send(ctx, client, Delivery{
+			ID:        delivery.ID,
+			URL:       delivery.URL,
+			Secret:    delivery.Secret,
+			EventID:   delivery.EventID,
+			EventType: delivery.EventType,
+			Body:      delivery.Payload,
+		})
+	}
+	attempts := delivery.Attempts + 1
+	status, nextAttempt := outcome(attempts, maxAttempts, sendErr, now)
+
+	switch {
+	case status == StatusFailed:
+		log.Printf("Webhook delivery %s to %s failed: %v", delivery.ID, delivery.URL, sendErr)
+	case sendErr != nil:
+		log.Printf("Error sending webhook delivery %s to %s (attempt %d): %v", delivery.ID, delivery.URL, attempts, sendErr)
+	}
+
+	err = inTx(func(tx *sqlx.Tx) error {
+		return record(tx, delivery.ID, status, attempts, responseStatus, sendErr, nextAttempt)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error updating webhook delivery %s: %v", delivery.ID, err)
+	}
+	return false, nil
+}
+
+// claim locks the oldest delivery due at now, skipping those other runs hold,
+// and moves its next attempt lease ahead.
+func claim(tx *sqlx.Tx, now time.Time, lease time.Duration) (*claimedDelivery, error) {
+	var delivery claimedDelivery
+	err := tx.Get(&delivery, `SELECT d.id, d.event_id, d.event_type, d.payload, d.attempts, s.url, s.secret, s.active
+		FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.status = $1 AND d.next_attempt_at <= $2
+		ORDER BY d.next_attempt_at
+		LIMIT 1 FOR UPDATE OF d SKIP LOCKED`, StatusPending, now.Format(time.RFC3339))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error loading pending webhooks: %v", err)
+	}
+
+	_, err = tx.Exec("UPDATE webhook_deliveries SET next_attempt_at = $1 WHERE id = $2",
+		now.Add(lease).Format(time.RFC3339), delivery.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook delivery %s: %v", delivery.ID, err)
+	}
+	return &delivery, nil
+}
+
+// outcome is the status of a delivery after attempts, the last of which
+// ended with sendErr, and when it is tried next if it is still pending.
+func outcome(attempts, maxAttempts int, sendErr error, now time.Time) (string, time.Time) {
+	switch {
+	case sendErr == nil:
+		return StatusSent, time.Time{}
+	case errors.Is(sendErr, errInactive) || attempts >= maxAttempts:
+		return StatusFailed, time.Time{}
+	default:
+		return StatusPending, now.Add(Backoff(attempts))
+	}
+}
+
+func record(tx *sqlx.Tx, id, status string, attempts, responseStatus int, sendErr error, nextAttempt time.Time) error {
+	var statusCode *int
+	if responseStatus != 0 {
+		statusCode = &responseStatus
+	}
+
+	var err error
+	switch status {
+	case StatusSent:
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET status = $1, attempts = $2, response_status = $3, last_error = NULL, delivered_at = $4 WHERE id = $5`,
+			status, attempts, statusCode, time.Now().Format(time.RFC3339), id)
+	case StatusFailed:
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET status = $1, attempts = $2, response_status = $3, last_error = $4 WHERE id = $5`,
+			status, attempts, statusCode, sendErr.Error(), id)
+	default:
+		_, err = tx.Exec(`UPDATE webhook_deliveries SET attempts = $1, response_status = $2, last_error = $3, next_attempt_at = $4 WHERE id = $5`,
+			attempts, statusCode, sendErr.Error(), nextAttempt.Format(time.RFC3339), id)
+	}
+	return err
+}
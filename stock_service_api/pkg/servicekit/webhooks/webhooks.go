@@ -0,0 +1,133 @@
+// Package webhooks signs and sends webhook deliveries for both services.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Headers of a delivery. SignatureHeader carries "sha256=" and the hex
+// HMAC-SHA256 of the body keyed with the subscription secret; receivers
+// should compare it with Verify, or in constant time, before trusting the
+// body. EventIDHeader is the same for redeliveries of an event.
+const (
+	SignatureHeader  = "X-Signature"
+	EventTypeHeader  = "X-Event-Type"
+	EventIDHeader    = "X-Event-ID"
+	DeliveryIDHeader = "X-Delivery-ID"
+)
+
+// retryBase is the wait after the first failed attempt; it doubles on every
+// further failure up to retryMax.
+const (
+	retryBase = 30 * time.Second
+	retryMax  = 6 * time.Hour
+)
+
+// Delivery is one POST of an event to a subscriber.
+type Delivery struct {
+	ID        string
+	URL       string
+	Secret    string
+	EventID   string
+	EventType string
+	Body      []byte
+}
+
+// NewSecret returns a random secret for a new subscription.
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Send posts the delivery and returns the response status, or 0 when there was
+// no response. Any status outside 2xx is an error.
+func Send(ctx context.Context, client *http.Client, delivery Delivery) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(SignatureHeader, Sign(delivery.Secret, delivery.Body))
+	request.Header.Set(EventTypeHeader, delivery.EventType)
+	request.Header.Set(EventIDHeader, delivery.EventID)
+	request.Header.Set(DeliveryIDHeader, delivery.ID)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// Backoff is the wait before the next attempt after attempts failed ones.
+func Backoff(attempts int) time.Duration {
+	backoff := retryBase << uint(attempts-1)
+	if attempts < 1 || backoff <= 0 || backoff > retryMax {
+		return retryMax
+	}
+	return backoff
+}
+
+// NewClient returns the client deliveries are sent with, timing out after
+// WEBHOOK_TIMEOUT (default 10s). It connects directly, never through a
+// proxy, and only to public addresses unless WEBHOOK_ALLOW_PRIVATE is set.
+func NewClient() *http.Client {
+	timeout, err := time.ParseDuration(os.Getenv("WEBHOOK_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate() {
+		dialer.Control = checkDial
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// MaxAttempts is how many times a delivery is tried before it is FALHOU,
+// from WEBHOOK_MAX_ATTEMPTS (default 8).
+func MaxAttempts() int {
+	if value, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); err == nil && value > 0 {
+		return value
+	}
+	return 8
+}
@@ -0,0 +1,168 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a subscriber answering with the statuses it is given in turn,
+// then 204, and recording what it was sent.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, received{header: request.Header.Clone(), body: body})
+
+	status := http.StatusNoContent
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	t.Helper()
+	r := &receiver{statuses: statuses}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func testDelivery(url, id string) Delivery {
+	return Delivery{
+		ID:        id,
+		URL:       url,
+		Secret:    "secret",
+		EventID:   "event-1",
+		EventType: "stock.changed",
+		Body:      []byte(`{"id":"event-1","type":"stock.changed"}`),
+	}
+}
+
+func TestSendSignsTheBody(t *testing.T) {
+	r, server := newReceiver(t)
+
+	status, err := Send(context.Background(), server.Client(), testDelivery(server.URL, "delivery-1"))
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Send = %d, %v, want 204, nil", status, err)
+	}
+
+	request := r.requests[0]
+	signature := request.header.Get(SignatureHeader)
+	if !Verify("secret", request.body, signature) {
+		t.Errorf("%s %q does not verify the body with the secret", SignatureHeader, signature)
+	}
+	if Verify("other secret", request.body, signature) {
+		t.Errorf("%s %q verifies with another secret", SignatureHeader, signature)
+	}
+	if Verify("secret", append(request.body, ' '), signature) {
+		t.Errorf("%s %q verifies a changed body", SignatureHeader, signature)
+	}
+	if got := request.header.Get(EventTypeHeader); got != "stock.changed" {
+		t.Errorf("%s = %q, want stock.changed", EventTypeHeader, got)
+	}
+	if got := request.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+}
+
+func TestRedeliveryKeepsTheEventID(t *testing.T) {
+	r, server := newReceiver(t, http.StatusInternalServerError)
+	client := server.Client()
+
+	status, err := Send(context.Background(), client, testDelivery(server.URL, "delivery-1"))
+	if err == nil || status != http.StatusInternalServerError {
+		t.Fatalf("Send = %d, %v, want 500 and an error", status, err)
+	}
+	if _, err := Send(context.Background(), client, testDelivery(server.URL, "delivery-2")); err != nil {
+		t.Fatalf("redelivery: %v", err)
+	}
+
+	first, second := r.requests[0], r.requests[1]
+	if first.header.Get(EventIDHeader) != "event-1" || second.header.Get(EventIDHeader) != "event-1" {
+		t.Errorf("%s = %q then %q, want event-1 both times", EventIDHeader, first.header.Get(EventIDHeader), second.header.Get(EventIDHeader))
+	}
+	if first.header.Get(DeliveryIDHeader) == second.header.Get(DeliveryIDHeader) {
+		t.Errorf("%s is %q for both deliveries", DeliveryIDHeader, first.header.Get(DeliveryIDHeader))
+	}
+	if first.header.Get(SignatureHeader) != second.header.Get(SignatureHeader) {
+		t.Errorf("redelivery of the same body has another signature")
+	}
+}
+
+func TestSendFailsOutside2xx(t *testing.T) {
+	for _, status := range []int{http.StatusMovedPermanently, http.StatusBadRequest, http.StatusGone, http.StatusServiceUnavailable} {
+		_, server := newReceiver(t, status)
+		client := server.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+		got, err := Send(context.Background(), client, testDelivery(server.URL, "delivery-1"))
+		if err == nil || got != status {
+			t.Errorf("answer %d: Send = %d, %v, want %d and an error", status, got, err, status)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+		{0, 6 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := Backoff(test.attempts); got != test.want {
+			t.Errorf("Backoff(%d) = %s, want %s", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	answered := errors.New("webhook answered 500 Internal Server Error")
+
+	tests := []struct {
+		name       string
+		attempts   int
+		err        error
+		wantStatus string
+		wantNext   time.Time
+	}{
+		{"sent", 1, nil, StatusSent, time.Time{}},
+		{"sent on a retry", 4, nil, StatusSent, time.Time{}},
+		{"first failure backs off", 1, answered, StatusPending, now.Add(30 * time.Second)},
+		{"later failure backs off longer", 3, answered, StatusPending, now.Add(2 * time.Minute)},
+		{"last attempt fails", 8, answered, StatusFailed, time.Time{}},
+		{"inactive subscription fails", 1, errInactive, StatusFailed, time.Time{}},
+	}
+	for _, test := range tests {
+		status, next := outcome(test.attempts, 8, test.err, now)
+		if status != test.wantStatus || !next.Equal(test.wantNext) {
+			t.Errorf("%s: outcome = %s, %s, want %s, %s", test.name, status, next, test.wantStatus, test.wantNext)
+		}
+	}
+}
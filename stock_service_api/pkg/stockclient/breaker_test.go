@@ -0,0 +1,171 @@
+package stockclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testBreaker opens after 3 failures for a minute, on a clock the test moves.
+func testBreaker() (*breaker, *time.Time) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newBreaker(3, time.Minute)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b, _ := testBreaker()
+
+	for i := 0; i < 2; i++ {
+		if !b.allow() {
+			t.Fatalf("call %d refused before the threshold", i+1)
+		}
+		b.failure()
+	}
+	if b.current() != breakerClosed {
+		t.Fatalf("state = %s after 2 failures, want closed", b.current())
+	}
+
+	b.allow()
+	b.failure()
+	if b.current() != breakerOpen {
+		t.Fatalf("state = %s after 3 failures, want open", b.current())
+	}
+	if b.allow() {
+		t.Error("open breaker let a call through")
+	}
+}
+
+func TestBreakerCountsConsecutiveFailures(t *testing.T) {
+	b, _ := testBreaker()
+
+	b.failure()
+	b.failure()
+	b.success()
+	b.failure()
+	b.failure()
+	if b.current() != breakerClosed {
+		t.Errorf("state = %s, want closed: a success resets the count", b.current())
+	}
+}
+
+func TestBreakerHalfOpenLetsOneProbeThrough(t *testing.T) {
+	b, now := testBreaker()
+	for i := 0; i < 3; i++ {
+		b.failure()
+	}
+
+	*now = now.Add(59 * time.Second)
+	if b.allow() {
+		t.Fatal("breaker let a call through before the cooldown")
+	}
+
+	*now = now.Add(time.Second)
+	if b.current() != breakerHalfOpen {
+		t.Fatalf("state = %s after the cooldown, want half-open", b.current())
+	}
+	if !b.allow() {
+		t.Fatal("half-open breaker refused the probe")
+	}
+	if b.allow() {
+		t.Error("half-open breaker let a second call through during the probe")
+	}
+}
+
+func TestBreakerProbeSuccessCloses(t *testing.T) {
+	b, now := testBreaker()
+	for i := 0; i < 3; i++ {
+		b.failure()
+	}
+	*now = now.Add(time.Minute)
+
+	b.allow()
+	b.success()
+	if b.current() != breakerClosed {
+		t.Fatalf("state = %s after a good probe, want closed", b.current())
+	}
+	if !b.allow() || !b.allow() {
+		t.Error("closed breaker refused calls")
+	}
+}
+
+func TestBreakerProbeFailureReopens(t *testing.T) {
+	b, now := testBreaker()
+	for i := 0; i < 3; i++ {
+		b.failure()
+	}
+	*now = now.Add(time.Minute)
+
+	b.allow()
+	b.failure()
+	if b.current() != breakerOpen {
+		t.Fatalf("state = %s after a failed probe, want open", b.current())
+	}
+	if b.allow() {
+		t.Error("reopened breaker let a call through")
+	}
+
+	*now = now.Add(time.Minute)
+	if !b.allow() {
+		t.Error("breaker sent no probe after a second cooldown")
+	}
+}
+
+func TestBreakerAbortedProbeAllowsAnother(t *testing.T) {
+	b, now := testBreaker()
+	for i := 0; i < 3; i++ {
+		b.failure()
+	}
+	*now = now.Add(time.Minute)
+
+	b.allow()
+	b.abort()
+	if !b.allow() {
+		t.Error("half-open breaker refused a probe after the first was aborted")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(0, time.Minute)
+
+	for i := 0; i < 10; i++ {
+		b.failure()
+	}
+	if !b.allow() || b.current() != breakerClosed {
+		t.Errorf("disabled breaker opened: %s", b.current())
+	}
+}
+
+func TestClientFailsFastWhileBreakerIsOpen(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := New(Config{
+		BaseURL:          server.URL,
+		Transport:        TransportHTTP,
+		Timeout:          time.Second,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ListProducts(context.Background()); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: error = %v, want the service error", i+1, err)
+		}
+	}
+	if _, err := client.ListProducts(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("error = %v, want ErrCircuitOpen", err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("stock service got %d requests, want 2: the open breaker must fail fast", requests.Load())
+	}
+}
@@ -0,0 +1,453 @@
+// Package stockclient is the Go client for the stock service API: its wire
+// types, a typed method per endpoint and errors decoded from its responses.
+// Reads are retried with jittered backoff and every call goes through a
+// circuit breaker that fails fast while the service is down, over REST or,
+// for the calls the gRPC API offers, over gRPC. With a ServiceSecret every
+// call carries a service token, which the internal endpoints require, and
+// the company set on its context by WithCompany.
+package stockclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient/servicetoken"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient/stockpb"
+	"google.golang.org/grpc"
+)
+
+// MaxLookupIDs is the most IDs the service accepts in one lookup; larger
+// lookups are split.
+const MaxLookupIDs = 1000
+
+type Config struct {
+	BaseURL string
+	// Transport is TransportHTTP, the default, or TransportGRPC.
+	Transport string
+	GRPCAddr  string
+	// Timeout bounds each attempt; the caller's context bounds the whole call.
+	Timeout    time.Duration
+	MaxRetries int
+	RetryBase  time.Duration
+	RetryMax   time.Duration
+	// BreakerThreshold consecutive failures open the breaker for
+	// BreakerCooldown. Zero disables it.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// ServiceName and ServiceSecret sign the service tokens sent with every
+	// call. Without a secret no token is sent.
+	ServiceName   string
+	ServiceSecret []byte
+}
+
+// ConfigFromEnv reads the STOCK_* settings, defaulting to the docker-compose
+// hostname of the stock service, and SERVICE_TOKEN_SECRET.
+func ConfigFromEnv() Config {
+	config := Config{
+		BaseURL:          os.Getenv("STOCK_SERVICE_URL"),
+		Transport:        os.Getenv("STOCK_CLIENT_TRANSPORT"),
+		GRPCAddr:         os.Getenv("STOCK_GRPC_ADDR"),
+		Timeout:          durationFromEnv("STOCK_CLIENT_TIMEOUT", 10*time.Second),
+		MaxRetries:       intFromEnv("STOCK_CLIENT_RETRIES", 3),
+		RetryBase:        durationFromEnv("STOCK_CLIENT_RETRY_BASE", 100*time.Millisecond),
+		RetryMax:         2 * time.Second,
+		BreakerThreshold: intFromEnv("STOCK_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:  durationFromEnv("STOCK_BREAKER_COOLDOWN", 30*time.Second),
+		ServiceName:      os.Getenv("SERVICE_NAME"),
+		ServiceSecret:    []byte(os.Getenv("SERVICE_TOKEN_SECRET")),
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = "http://stock_service_api:3000"
+	}
+	if config.Transport == "" {
+		config.Transport = TransportHTTP
+	}
+	if config.GRPCAddr == "" {
+		config.GRPCAddr = "stock_service_api:50051"
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = "billing_service_api"
+	}
+	return config
+}
+
+type Client struct {
+	config     Config
+	httpClient *http.Client
+	breaker    *breaker
+	metrics    *metrics
+	signer     *servicetoken.Signer
+
+	grpcMu   sync.Mutex
+	grpcConn *grpc.ClientConn
+	grpc     stockpb.StockServiceClient
+}
+
+func New(config Config) *Client {
+	client := &Client{
+		config:     config,
+		httpClient: &http.Client{},
+		breaker:    newBreaker(config.BreakerThreshold, config.BreakerCooldown),
+		metrics:    newMetrics(),
+	}
+	if len(config.ServiceSecret) > 0 {
+		client.signer = servicetoken.NewSigner(config.ServiceSecret, config.ServiceName)
+	}
+	return client
+}
+
+func (c *Client) CreateProduct(ctx context.Context, request CreateProductRequest) (*Product, error) {
+	var product Product
+	err := c.do(ctx, call{operation: "create_product", method: "POST", path: "/products", payload: request}, &product)
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (c *Client) ListProducts(ctx context.Context) ([]Product, error) {
+	var products []Product
+	if err := c.do(ctx, call{operation: "list_products", method: "GET", path: "/products", read: true}, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (c *Client) GetProduct(ctx context.Context, productID string) (*Product, error) {
+	var product Product
+	op := call{operation: "get_product", method: "GET", path: "/product/" + url.PathEscape(productID), read: true}
+	if c.useGRPC() {
+		op.rpc = func(rpc stockpb.StockServiceClient, ctx context.Context) error {
+			message, err := rpc.GetProduct(ctx, &stockpb.GetProductRequest{Id: productID})
+			if err == nil {
+				product = productFromMessage(message)
+			}
+			return err
+		}
+	}
+	if err := c.do(ctx, op, &product); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// LookupProducts fetches many products at once. Found products are keyed by
+// the ID as it was requested; unknown or malformed IDs are returned in missing.
+func (c *Client) LookupProducts(ctx context.Context, productIDs []string) (map[string]*Product, []string, error) {
+	products := make(map[string]*Product, len(productIDs))
+	var missing []string
+
+	for start := 0; start < len(productIDs); start += MaxLookupIDs {
+		end := start + MaxLookupIDs
+		if end > len(productIDs) {
+			end = len(productIDs)
+		}
+		batch := productIDs[start:end]
+
+		// The lookup is a POST only to carry the IDs; it reads, so it is retried.
+		var response LookupResponse
+		op := call{operation: "lookup_products", method: "POST", path: "/products/lookup", payload: LookupRequest{IDs: batch}, read: true}
+		if c.useGRPC() {
+			op.rpc = func(rpc stockpb.StockServiceClient, ctx context.Context) error {
+				message, err := rpc.BatchGetProducts(ctx, &stockpb.BatchGetProductsRequest{Ids: batch})
+				if err != nil {
+					return err
+				}
+				response = LookupResponse{Missing: message.GetMissing()}
+				for _, product := range message.GetProducts() {
+					response.Products = append(response.Products, productFromMessage(product))
+				}
+				return nil
+			}
+		}
+		if err := c.do(ctx, op, &response); err != nil {
+			return nil, nil, err
+		}
+
+		byID := make(map[uuid.UUID]*Product, len(response.Products))
+		for i := range response.Products {
+			byID[response.Products[i].ID] = &response.Products[i]
+		}
+		for _, id := range batch {
+			if parsed, err := uuid.Parse(id); err == nil {
+				if product, ok := byID[parsed]; ok {
+					products[id] = product
+				}
+			}
+		}
+		missing = append(missing, response.Missing...)
+	}
+
+	return products, missing, nil
+}
+
+// UpdateBalance deducts the items from stock. operationKey, sent as
+// Idempotency-Key, lets the service apply a redelivered command once.
+func (c *Client) UpdateBalance(ctx context.Context, items []Item, operationKey string) error {
+	response := BalanceUpdateResponse{Success: true}
+	op := call{operation: "update_balance", method: "PUT", path: "/products/balance-update", payload: items, operationKey: operationKey}
+	if c.useGRPC() {
+		op.rpc = func(rpc stockpb.StockServiceClient, ctx context.Context) error {
+			_, err := rpc.DecreaseStock(ctx, &stockpb.DecreaseStockRequest{Items: itemMessages(items), OperationKey: operationKey})
+			return err
+		}
+	}
+	if err := c.do(ctx, op, &response); err != nil {
+		return err
+	}
+	if !response.Success {
+		return fmt.Errorf("stock service reported failure: %s", response.Message)
+	}
+	return nil
+}
+
+// RestoreBalance compensates the deduction sent with operationKey. The service
+// also accepts it when the deduction never arrived, and then refuses that
+// deduction if it shows up later.
+func (c *Client) RestoreBalance(ctx context.Context, operationKey string) error {
+	op := call{operation: "restore_balance", method: "POST", path: "/products/balance-restore", payload: BalanceRestoreRequest{OperationKey: operationKey}}
+	if c.useGRPC() {
+		op.rpc = func(rpc stockpb.StockServiceClient, ctx context.Context) error {
+			_, err := rpc.IncreaseStock(ctx, &stockpb.IncreaseStockRequest{OperationKey: operationKey})
+			return err
+		}
+	}
+	return c.do(ctx, op, nil)
+}
+
+// CreateReservation holds the items for the request reference. Asking again
+// for a reference with an active reservation returns that reservation.
+func (c *Client) CreateReservation(ctx context.Context, request CreateReservationRequest) (*Reservation, error) {
+	var reservation Reservation
+	err := c.do(ctx, call{operation: "create_reservation", method: "POST", path: "/reservations", payload: request}, &reservation)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+func (c *Client) GetReservation(ctx context.Context, reservationID uuid.UUID) (*Reservation, error) {
+	var reservation Reservation
+	err := c.do(ctx, call{operation: "get_reservation", method: "GET", path: "/reservations/" + reservationID.String(), read: true}, &reservation)
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// ConfirmReservation deducts a reservation from stock. operationKey plays the
+// same role as in UpdateBalance.
+func (c *Client) ConfirmReservation(ctx context.Context, reservationID uuid.UUID, operationKey string) error {
+	return c.do(ctx, call{operation: "confirm_reservation", method: "POST", path: "/reservations/" + reservationID.String() + "/confirm", operationKey: operationKey}, nil)
+}
+
+func (c *Client) ReleaseReservation(ctx context.Context, reservationID uuid.UUID) error {
+	return c.do(ctx, call{operation: "release_reservation", method: "POST", path: "/reservations/" + reservationID.String() + "/release"}, nil)
+}
+
+// call describes one request. Only reads are retried here: commands carry an
+// Idempotency-Key and their caller owns the redelivery schedule.
+type call struct {
+	operation    string
+	method       string
+	path         string
+	payload      interface{}
+	operationKey string
+	read         bool
+	// rpc, when set, makes the call over gRPC instead and fills the results
+	// itself.
+	rpc func(stockpb.StockServiceClient, context.Context) error
+}
+
+// do sends the call and decodes a 2xx answer into out, when not nil; any
+// other status becomes an *Error. gRPC calls leave out alone.
+func (c *Client) do(ctx context.Context, op call, out interface{}) error {
+	var payload []byte
+	if op.payload != nil {
+		data, err := json.Marshal(op.payload)
+		if err != nil {
+			return fmt.Errorf("error marshaling stock data: %v", err)
+		}
+		payload = data
+	}
+
+	attempts := 1
+	if op.read {
+		attempts += c.config.MaxRetries
+	}
+
+	var body []byte
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			c.metrics.retried(op.operation)
+			if waitErr := sleep(ctx, c.backoff(attempt)); waitErr != nil {
+				return err
+			}
+		}
+
+		var result outcome
+		body, result, err = c.attempt(ctx, op, payload)
+		if !result.retryable() {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if out != nil && op.rpc == nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("error decoding stock service response: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) attempt(ctx context.Context, op call, payload []byte) ([]byte, outcome, error) {
+	if !c.breaker.allow() {
+		c.metrics.record(op.operation, outcomeCircuitOpen, 0)
+		return nil, outcomeCircuitOpen, ErrCircuitOpen
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	var body []byte
+	var result outcome
+	var err error
+	start := time.Now()
+	if op.rpc != nil {
+		rpc, rpcErr := c.grpcClient()
+		if rpcErr != nil {
+			c.breaker.abort()
+			return nil, outcomeNetworkError, rpcErr
+		}
+		result, err = grpcOutcome(op.rpc(rpc, outgoingCompany(attemptCtx)))
+	} else {
+		req, reqErr := c.request(attemptCtx, op, payload)
+		if reqErr != nil {
+			c.breaker.abort()
+			return nil, outcomeNetworkError, reqErr
+		}
+		body, result, err = c.send(req)
+	}
+	elapsed := time.Since(start)
+
+	// A call the caller gave up on says nothing about the stock service.
+	if result == outcomeNetworkError && ctx.Err() != nil {
+		result = outcomeCancelled
+		err = fmt.Errorf("stock service call cancelled: %w", ctx.Err())
+	}
+
+	switch result {
+	case outcomeSuccess, outcomeClientError:
+		c.breaker.success()
+	case outcomeCancelled:
+		c.breaker.abort()
+	default:
+		c.breaker.failure()
+	}
+	c.metrics.record(op.operation, result, elapsed)
+
+	return body, result, err
+}
+
+func (c *Client) request(ctx context.Context, op call, payload []byte) (*http.Request, error) {
+	var requestBody io.Reader
+	if payload != nil {
+		requestBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, op.method, c.config.BaseURL+op.path, requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if op.operationKey != "" {
+		req.Header.Set("Idempotency-Key", op.operationKey)
+	}
+	if companyID := CompanyFromContext(ctx); companyID != "" {
+		req.Header.Set(CompanyHeader, companyID)
+	}
+	if c.signer != nil {
+		token, err := c.signer.Token()
+		if err != nil {
+			return nil, fmt.Errorf("error signing service token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+func (c *Client) send(req *http.Request) ([]byte, outcome, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, outcomeNetworkError, fmt.Errorf("error calling stock service: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, outcomeNetworkError, fmt.Errorf("error reading response body: %v", err)
+	}
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return body, outcomeSuccess, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, outcomeServerError, responseError(resp.StatusCode, body)
+	default:
+		return nil, outcomeClientError, responseError(resp.StatusCode, body)
+	}
+}
+
+// backoff doubles from RetryBase up to RetryMax, with jitter over the upper
+// half so concurrent callers do not retry in lockstep.
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.config.RetryBase << uint(attempt-1)
+	if wait <= 0 || (c.config.RetryMax > 0 && wait > c.config.RetryMax) {
+		wait = c.config.RetryMax
+	}
+	if wait <= 0 {
+		return 0
+	}
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(wait-half)+1))
+}
+
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func intFromEnv(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value >= 0 {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,33 @@
+package stockclient
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// CompanyHeader carries the company a call is made for, over REST as a
+// header and over gRPC as metadata. The stock service keeps the products and
+// stock of each company apart and requires it on its internal endpoints.
+const CompanyHeader = "X-Company-ID"
+
+type companyKey struct{}
+
+// WithCompany returns a context whose calls are made for companyID.
+func WithCompany(ctx context.Context, companyID string) context.Context {
+	return context.WithValue(ctx, companyKey{}, companyID)
+}
+
+// CompanyFromContext returns the company set by WithCompany, or "".
+func CompanyFromContext(ctx context.Context) string {
+	companyID, _ := ctx.Value(companyKey{}).(string)
+	return companyID
+}
+
+// outgoingCompany adds the company of ctx to the metadata of a gRPC call.
+func outgoingCompany(ctx context.Context) context.Context {
+	if companyID := CompanyFromContext(ctx); companyID != "" {
+		return metadata.AppendToOutgoingContext(ctx, "x-company-id", companyID)
+	}
+	return ctx
+}
@@ -0,0 +1,89 @@
+package stockclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Kinds of refusal, matched with errors.Is against an *Error.
+var (
+	ErrNotFound          = errors.New("stock: not found")
+	ErrInsufficientStock = errors.New("stock: insufficient stock")
+	ErrValidation        = errors.New("stock: validation failed")
+	ErrConflict          = errors.New("stock: conflict")
+)
+
+// ErrCircuitOpen is returned without calling the stock service while the
+// circuit breaker considers it down.
+var ErrCircuitOpen = errors.New("stock service unavailable: circuit breaker is open")
+
+// FieldError is one entry of the validation error list the service answers
+// for invalid products.
+type FieldError struct {
+	FailedField string `json:"failedField"`
+	Tag         string `json:"tag"`
+	Value       string `json:"value"`
+}
+
+// Error is a response in which the stock service refused a call. Client
+// errors are permanent: retrying the same command cannot succeed.
+type Error struct {
+	StatusCode int
+	Message    string
+	Fields     []FieldError
+	// Set when a reservation is refused for lack of stock.
+	ProductID string
+	Available int
+	Requested int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("stock service returned error: %d - %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusTooManyRequests
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrInsufficientStock:
+		return strings.HasPrefix(e.Message, "Insufficient stock")
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrValidation:
+		return e.StatusCode == http.StatusUnprocessableEntity || len(e.Fields) > 0 || e.Message == "Validation failed"
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	}
+	return false
+}
+
+// responseError decodes the service's error bodies: {"error": ...} with
+// optional stock details, or a list of field errors.
+func responseError(statusCode int, body []byte) error {
+	var fields []FieldError
+	if err := json.Unmarshal(body, &fields); err == nil && len(fields) > 0 {
+		return &Error{StatusCode: statusCode, Message: "Validation failed", Fields: fields}
+	}
+
+	var errorResp struct {
+		Error     string `json:"error"`
+		ProductID string `json:"product_id"`
+		Available int    `json:"available"`
+		Requested int    `json:"requested"`
+	}
+	if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error != "" {
+		return &Error{
+			StatusCode: statusCode,
+			Message:    errorResp.Error,
+			ProductID:  errorResp.ProductID,
+			Available:  errorResp.Available,
+			Requested:  errorResp.Requested,
+		}
+	}
+	return &Error{StatusCode: statusCode, Message: string(body)}
+}
@@ -0,0 +1,182 @@
+package stockclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient/servicetoken"
+	"github.com/lucasbpereira/stock_service_api/pkg/stockclient/stockpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Transports for Config.Transport. Over gRPC, product reads and balance
+// updates use the gRPC API; reservations and product creation, which it does
+// not offer, stay on REST.
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+// StockChange is one message of WatchStock.
+type StockChange struct {
+	Product   Product `json:"product"`
+	ChangedAt string  `json:"changed_at"`
+}
+
+// WatchStock calls fn with the current state of the products and then with
+// each change to their stock, or to any product when productIDs is empty. It
+// always uses the gRPC API and returns when ctx is done, the stream fails or
+// fn returns an error.
+func (c *Client) WatchStock(ctx context.Context, productIDs []string, fn func(StockChange) error) error {
+	rpc, err := c.grpcClient()
+	if err != nil {
+		return err
+	}
+
+	stream, err := rpc.WatchStock(outgoingCompany(ctx), &stockpb.WatchStockRequest{ProductIds: productIDs})
+	if err != nil {
+		return grpcError(err)
+	}
+	for {
+		change, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return grpcError(err)
+		}
+		if err := fn(StockChange{Product: productFromMessage(change.GetProduct()), ChangedAt: change.GetChangedAt()}); err != nil {
+			return err
+		}
+	}
+}
+
+// Close releases the gRPC connection, if one was opened.
+func (c *Client) Close() error {
+	c.grpcMu.Lock()
+	defer c.grpcMu.Unlock()
+
+	if c.grpcConn == nil {
+		return nil
+	}
+	err := c.grpcConn.Close()
+	c.grpcConn, c.grpc = nil, nil
+	return err
+}
+
+// grpcClient opens the connection on first use. It does not dial: gRPC
+// connects, and reconnects, when calls are made.
+func (c *Client) grpcClient() (stockpb.StockServiceClient, error) {
+	c.grpcMu.Lock()
+	defer c.grpcMu.Unlock()
+
+	if c.grpc == nil {
+		options := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+		if c.signer != nil {
+			options = append(options, grpc.WithPerRPCCredentials(serviceCredentials{c.signer}))
+		}
+		conn, err := grpc.NewClient(c.config.GRPCAddr, options...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating gRPC client: %v", err)
+		}
+		c.grpcConn = conn
+		c.grpc = stockpb.NewStockServiceClient(conn)
+	}
+	return c.grpc, nil
+}
+
+// serviceCredentials sends the service token as authorization metadata.
+type serviceCredentials struct {
+	signer *servicetoken.Signer
+}
+
+func (s serviceCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	token, err := s.signer.Token()
+	if err != nil {
+		return nil, fmt.Errorf("error signing service token: %v", err)
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity is false: the gRPC API is plaintext inside the
+// docker network, like the REST one.
+func (serviceCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+func (c *Client) useGRPC() bool {
+	return c.config.Transport == TransportGRPC
+}
+
+// grpcOutcome classifies the result of a gRPC call like send does HTTP
+// responses. Calls that did not get an answer are network errors.
+func grpcOutcome(err error) (outcome, error) {
+	if err == nil {
+		return outcomeSuccess, nil
+	}
+
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.Unavailable:
+		return outcomeNetworkError, fmt.Errorf("error calling stock service: %v", err)
+	}
+
+	stockErr := grpcError(err)
+	if stockErr.(*Error).Permanent() {
+		return outcomeClientError, stockErr
+	}
+	return outcomeServerError, stockErr
+}
+
+// grpcError turns a gRPC status into an *Error carrying the HTTP status the
+// REST API answers in the same case, so errors.Is and Permanent work the
+// same on both transports.
+func grpcError(err error) error {
+	st := status.Convert(err)
+
+	statusCode := http.StatusInternalServerError
+	switch st.Code() {
+	case codes.InvalidArgument:
+		statusCode = http.StatusBadRequest
+	case codes.NotFound:
+		statusCode = http.StatusNotFound
+	case codes.FailedPrecondition, codes.AlreadyExists, codes.Aborted:
+		statusCode = http.StatusConflict
+	case codes.ResourceExhausted:
+		statusCode = http.StatusTooManyRequests
+	case codes.Unimplemented:
+		statusCode = http.StatusNotImplemented
+	case codes.Unavailable:
+		statusCode = http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		statusCode = http.StatusGatewayTimeout
+	}
+	return &Error{StatusCode: statusCode, Message: st.Message()}
+}
+
+func productFromMessage(message *stockpb.Product) Product {
+	id, _ := uuid.Parse(message.GetId())
+	return Product{
+		ID:          id,
+		Name:        message.GetName(),
+		Description: message.GetDescription(),
+		Price:       message.GetPrice(),
+		Balance:     int(message.GetBalance()),
+		Reserved:    int(message.GetReserved()),
+		Available:   int(message.GetAvailable()),
+		Unit:        message.GetUnit(),
+		NCM:         message.GetNcm(),
+	}
+}
+
+func itemMessages(items []Item) []*stockpb.StockItem {
+	messages := make([]*stockpb.StockItem, 0, len(items))
+	for _, item := range items {
+		messages = append(messages, &stockpb.StockItem{ProductId: item.ProductID, Quantity: int32(item.Quantity)})
+	}
+	return messages
+}
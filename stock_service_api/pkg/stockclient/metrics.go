@@ -0,0 +1,104 @@
+package stockclient
+
+import (
+	"sync"
+	"time"
+)
+
+type outcome string
+
+const (
+	outcomeSuccess      outcome = "success"
+	outcomeClientError  outcome = "client_error"
+	outcomeServerError  outcome = "server_error"
+	outcomeNetworkError outcome = "network_error"
+	outcomeCancelled    outcome = "cancelled"
+	outcomeCircuitOpen  outcome = "circuit_open"
+)
+
+// retryable reports whether another attempt of a read may succeed.
+func (o outcome) retryable() bool {
+	return o == outcomeServerError || o == outcomeNetworkError
+}
+
+// OperationMetrics counts the attempts of one client operation by outcome.
+type OperationMetrics struct {
+	Success        int64   `json:"success"`
+	ClientError    int64   `json:"client_error"`
+	ServerError    int64   `json:"server_error"`
+	NetworkError   int64   `json:"network_error"`
+	Cancelled      int64   `json:"cancelled"`
+	CircuitOpen    int64   `json:"circuit_open"`
+	Retries        int64   `json:"retries"`
+	LatencyTotalMs float64 `json:"latency_total_ms"`
+}
+
+type Metrics struct {
+	Transport  string                      `json:"transport"`
+	Breaker    string                      `json:"breaker"`
+	Operations map[string]OperationMetrics `json:"operations"`
+}
+
+type metrics struct {
+	mu         sync.Mutex
+	operations map[string]*OperationMetrics
+}
+
+func newMetrics() *metrics {
+	return &metrics{operations: make(map[string]*OperationMetrics)}
+}
+
+func (m *metrics) operation(name string) *OperationMetrics {
+	op, ok := m.operations[name]
+	if !ok {
+		op = &OperationMetrics{}
+		m.operations[name] = op
+	}
+	return op
+}
+
+func (m *metrics) record(name string, result outcome, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op := m.operation(name)
+	switch result {
+	case outcomeSuccess:
+		op.Success++
+	case outcomeClientError:
+		op.ClientError++
+	case outcomeServerError:
+		op.ServerError++
+	case outcomeNetworkError:
+		op.NetworkError++
+	case outcomeCancelled:
+		op.Cancelled++
+	case outcomeCircuitOpen:
+		op.CircuitOpen++
+	}
+	op.LatencyTotalMs += float64(elapsed) / float64(time.Millisecond)
+}
+
+func (m *metrics) retried(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operation(name).Retries++
+}
+
+// Metrics returns the call counts since the client was created and the
+// current state of its circuit breaker.
+func (c *Client) Metrics() Metrics {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+
+	snapshot := Metrics{
+		Transport:  c.config.Transport,
+		Breaker:    string(c.breaker.current()),
+		Operations: make(map[string]OperationMetrics, len(c.metrics.operations)),
+	}
+	for name, op := range c.metrics.operations {
+		snapshot.Operations[name] = *op
+	}
+	return snapshot
+}
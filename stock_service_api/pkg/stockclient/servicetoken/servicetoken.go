@@ -0,0 +1,100 @@
+// Package servicetoken signs and checks the tokens services present to call
+// the internal endpoints of the stock service: the stock movements and
+// reservations billing makes on behalf of its users. They are JWTs signed
+// with SERVICE_TOKEN_SECRET, which only the services know, naming the calling
+// service and valid for a minute or so.
+package servicetoken
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audience is the audience of every service token, so an access token of a
+// user is never taken for one.
+const Audience = "stock_service_api"
+
+// TTL is how long a token lasts, and MaxTTL the longest lifetime Verify
+// accepts.
+const (
+	TTL    = time.Minute
+	MaxTTL = 5 * time.Minute
+)
+
+// SecretFromEnv reads SERVICE_TOKEN_SECRET, which has to be the same in the
+// stock service and its callers and should differ from JWT_SECRET.
+func SecretFromEnv() ([]byte, error) {
+	secret := os.Getenv("SERVICE_TOKEN_SECRET")
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("SERVICE_TOKEN_SECRET must have at least 32 characters")
+	}
+	return []byte(secret), nil
+}
+
+// Signer hands out the tokens of one service, reusing each until half its
+// lifetime is gone.
+type Signer struct {
+	secret  []byte
+	service string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func NewSigner(secret []byte, service string) *Signer {
+	return &Signer{secret: secret, service: service}
+}
+
+// Token returns a token valid for at least TTL/2.
+func (s *Signer) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.token != "" && now.Add(TTL/2).Before(s.expiresAt) {
+		return s.token, nil
+	}
+
+	expiresAt := now.Add(TTL)
+	claims := jwt.RegisteredClaims{
+		Subject:   s.service,
+		Issuer:    s.service,
+		Audience:  jwt.ClaimStrings{Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	if err != nil {
+		return "", err
+	}
+
+	s.token, s.expiresAt = token, expiresAt
+	return token, nil
+}
+
+// Verify checks the signature, audience and expiry of a token and returns
+// the service it names. Tokens meant to last longer than MaxTTL are refused.
+func Verify(secret []byte, token string) (string, error) {
+	claims := new(jwt.RegisteredClaims)
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(Audience),
+		jwt.WithExpirationRequired(), jwt.WithIssuedAt(), jwt.WithLeeway(5*time.Second))
+	if err != nil {
+		return "", err
+	}
+
+	if claims.IssuedAt == nil || claims.ExpiresAt.Sub(claims.IssuedAt.Time) > MaxTTL {
+		return "", errors.New("service token lasts too long")
+	}
+	if claims.Subject == "" {
+		return "", errors.New("service token names no service")
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,131 @@
+package servicetoken
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var testSecret = []byte(strings.Repeat("s", 32))
+
+// sign signs claims with secret the way a Signer would, so each test can
+// change the one thing it checks.
+func sign(t *testing.T, secret []byte, claims jwt.Claims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func validClaims(now time.Time) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   "billing_service_api",
+		Issuer:    "billing_service_api",
+		Audience:  jwt.ClaimStrings{Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(TTL)),
+	}
+}
+
+func TestVerifyAcceptsSignerTokens(t *testing.T) {
+	token, err := NewSigner(testSecret, "billing_service_api").Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := Verify(testSecret, token)
+	if err != nil || service != "billing_service_api" {
+		t.Errorf("Verify() = %q, %v, want billing_service_api", service, err)
+	}
+}
+
+func TestVerifyRefusesOtherAudiences(t *testing.T) {
+	claims := validClaims(time.Now())
+	claims.Audience = jwt.ClaimStrings{"billing_service_api"}
+
+	if _, err := Verify(testSecret, sign(t, testSecret, claims)); err == nil {
+		t.Error("Verify() accepted a token for another audience")
+	}
+}
+
+func TestVerifyRefusesExpiredTokens(t *testing.T) {
+	claims := validClaims(time.Now().Add(-2 * TTL))
+
+	if _, err := Verify(testSecret, sign(t, testSecret, claims)); err == nil {
+		t.Error("Verify() accepted an expired token")
+	}
+}
+
+func TestVerifyRefusesTokensWithoutExpiry(t *testing.T) {
+	claims := validClaims(time.Now())
+	claims.ExpiresAt = nil
+
+	if _, err := Verify(testSecret, sign(t, testSecret, claims)); err == nil {
+		t.Error("Verify() accepted a token that never expires")
+	}
+}
+
+func TestVerifyRefusesLongLivedTokens(t *testing.T) {
+	now := time.Now()
+	claims := validClaims(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(MaxTTL + time.Minute))
+
+	if _, err := Verify(testSecret, sign(t, testSecret, claims)); err == nil {
+		t.Error("Verify() accepted a token lasting longer than MaxTTL")
+	}
+}
+
+func TestVerifyRefusesAnotherSecret(t *testing.T) {
+	token, err := NewSigner([]byte(strings.Repeat("x", 32)), "billing_service_api").Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(testSecret, token); err == nil {
+		t.Error("Verify() accepted a token signed with another secret")
+	}
+}
+
+func TestVerifyRefusesOtherAlgorithms(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, validClaims(time.Now())).SignedString(testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(testSecret, token); err == nil {
+		t.Error("Verify() accepted a token signed with HS512")
+	}
+}
+
+// A user's access token carries no audience, so it is refused even when
+// JWT_SECRET and SERVICE_TOKEN_SECRET were set to the same value.
+func TestVerifyRefusesUserAccessTokens(t *testing.T) {
+	now := time.Now()
+	token := sign(t, testSecret, jwt.MapClaims{
+		"sub":     "5f0c9a8e-1d2b-4c3d-8e9f-0a1b2c3d4e5f",
+		"email":   "user@example.com",
+		"role":    "admin",
+		"company": "7a6b5c4d-3e2f-4a1b-9c8d-7e6f5a4b3c2d",
+		"iss":     "nota_fiscal",
+		"iat":     now.Unix(),
+		"exp":     now.Add(TTL).Unix(),
+	})
+
+	if _, err := Verify(testSecret, token); err == nil {
+		t.Error("Verify() accepted a user access token")
+	}
+}
+
+func TestVerifyRefusesTokensNamingNoService(t *testing.T) {
+	claims := validClaims(time.Now())
+	claims.Subject = ""
+
+	if _, err := Verify(testSecret, sign(t, testSecret, claims)); err == nil {
+		t.Error("Verify() accepted a token naming no service")
+	}
+}
@@ -0,0 +1,5 @@
+// Package stockpb holds the protobuf messages and gRPC stubs generated from
+// stock.proto.
+package stockpb
+
+//go:generate protoc --proto_path=.. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative stockpb/stock.proto
@@ -0,0 +1,86 @@
+package stockclient
+
+import (
+	"github.com/google/uuid"
+)
+
+// Product is a product as the stock service returns it. Available is the
+// balance not held by active reservations.
+type Product struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Price       float64   `json:"price"`
+	Balance     int       `json:"balance"`
+	Reserved    int       `json:"reserved"`
+	Available   int       `json:"available"`
+	Unit        string    `json:"unit"`
+	NCM         string    `json:"ncm"`
+}
+
+type CreateProductRequest struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	Balance     int     `json:"balance"`
+	Unit        string  `json:"unit"`
+	NCM         string  `json:"ncm"`
+}
+
+// Item is a quantity of one product, as sent to balance updates and
+// reservations.
+type Item struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+type LookupRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type LookupResponse struct {
+	Products []Product `json:"products"`
+	Missing  []string  `json:"missing"`
+}
+
+type BalanceUpdateResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type BalanceRestoreRequest struct {
+	OperationKey string `json:"operation_key"`
+}
+
+type ReservationStatus string
+
+const (
+	ReservationActive    ReservationStatus = "ATIVA"
+	ReservationConfirmed ReservationStatus = "CONFIRMADA"
+	ReservationReleased  ReservationStatus = "LIBERADA"
+	ReservationExpired   ReservationStatus = "EXPIRADA"
+)
+
+type CreateReservationRequest struct {
+	Reference  string `json:"reference"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+	Items      []Item `json:"items"`
+}
+
+type Reservation struct {
+	ID        uuid.UUID         `json:"id"`
+	Reference string            `json:"reference"`
+	Status    ReservationStatus `json:"status"`
+	ExpiresAt string            `json:"expires_at"`
+	CreatedAt string            `json:"created_at"`
+	UpdatedAt string            `json:"updated_at"`
+	Items     []ReservationItem `json:"items"`
+}
+
+type ReservationItem struct {
+	ID            uuid.UUID `json:"id"`
+	ReservationID uuid.UUID `json:"reservation_id"`
+	ProductID     uuid.UUID `json:"product_id"`
+	Quantity      int       `json:"quantity"`
+}